@@ -0,0 +1,131 @@
+package acme
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	acmeclient "golang.org/x/crypto/acme"
+)
+
+const http01Prefix = "/.well-known/acme-challenge/"
+
+// solver fulfils challenges of one type, so the CA can validate control over domains.
+type solver interface {
+	Type() string
+	Present(ctx context.Context, client *acmeclient.Client, domain string, challenge *acmeclient.Challenge) error
+	CleanUp(ctx context.Context, client *acmeclient.Client, domain string, challenge *acmeclient.Challenge) error
+}
+
+// http01Solver serves key authorizations through the HTTP listener, which has to be reachable on port 80 of all
+// domains.
+type http01Solver struct {
+	mutex     sync.RWMutex
+	responses map[string]string
+}
+
+func newHTTP01Solver() *http01Solver {
+	return &http01Solver{responses: make(map[string]string)}
+}
+
+func (s *http01Solver) Type() string {
+	return "http-01"
+}
+
+func (s *http01Solver) Present(
+	_ context.Context, client *acmeclient.Client, _ string, challenge *acmeclient.Challenge,
+) error {
+	response, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	s.mutex.Lock()
+	s.responses[challenge.Token] = response
+	s.mutex.Unlock()
+
+	return nil
+}
+
+func (s *http01Solver) CleanUp(
+	_ context.Context, _ *acmeclient.Client, _ string, challenge *acmeclient.Challenge,
+) error {
+	s.mutex.Lock()
+	delete(s.responses, challenge.Token)
+	s.mutex.Unlock()
+
+	return nil
+}
+
+func (s *http01Solver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, http01Prefix)
+
+	s.mutex.RLock()
+	response, ok := s.responses[token]
+	s.mutex.RUnlock()
+
+	if !ok {
+		log.Debugw(http01Prefix, log.Fields{"path": r.URL.Path, "status": strconv.Itoa(http.StatusNotFound)})
+		http.NotFound(w, r)
+
+		return
+	}
+
+	log.Debugw(http01Prefix, log.Fields{"path": r.URL.Path, "status": strconv.Itoa(http.StatusOK)})
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(response))
+}
+
+// dns01Solver publishes key authorization digests as TXT records with DNS provider.
+type dns01Solver struct {
+	Provider         DNSProvider
+	PropagationDelay time.Duration
+}
+
+func (s *dns01Solver) Type() string {
+	return "dns-01"
+}
+
+func (s *dns01Solver) Present(
+	ctx context.Context, client *acmeclient.Client, domain string, challenge *acmeclient.Challenge,
+) error {
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if err = s.Provider.Present(ctx, dns01Record(domain), value); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	timer := time.NewTimer(s.PropagationDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck
+	}
+}
+
+func (s *dns01Solver) CleanUp(
+	ctx context.Context, client *acmeclient.Client, domain string, challenge *acmeclient.Challenge,
+) error {
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	return s.Provider.CleanUp(ctx, dns01Record(domain), value) //nolint:wrapcheck
+}
+
+// dns01Record returns fully qualified name of the TXT record, wildcard domains are validated on their base domain.
+func dns01Record(domain string) string {
+	return "_acme-challenge." + strings.TrimPrefix(domain, "*.") + "."
+}
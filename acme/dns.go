@@ -0,0 +1,50 @@
+package acme
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/ajgon/mailbowl/config"
+)
+
+// DNSProvider creates and removes TXT records for DNS-01 challenges. Record name is fully qualified (with trailing
+// dot). Implement it to support another DNS service.
+type DNSProvider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+func NewDNSProvider(conf config.ACMEDNS) (DNSProvider, error) { //nolint:ireturn
+	switch conf.Provider {
+	case config.DNSProviderExec:
+		return &ExecProvider{Command: conf.Command}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %d", config.ErrInvalidACMEDNSProvider, conf.Provider)
+}
+
+// ExecProvider runs Command to manage records, with `present` or `cleanup`, record name and value as arguments, so
+// any DNS service can be used with a small script.
+type ExecProvider struct {
+	Command string
+}
+
+func (p *ExecProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p *ExecProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+func (p *ExecProvider) run(ctx context.Context, action, fqdn, value string) error {
+	//nolint:gosec
+	output, err := exec.CommandContext(ctx, p.Command, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("`%s %s` failed: %w: %s", p.Command, action, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
@@ -0,0 +1,377 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+	acmeclient "golang.org/x/crypto/acme"
+)
+
+const (
+	cacheDirectoryMode = 0o700
+	certificateMode    = 0o644
+	keyMode            = 0o600
+)
+
+var (
+	ErrInvalidCACertificate = errors.New("no certificates found in acme.ca_certificate")
+	ErrInvalidCertificate   = errors.New("invalid certificate")
+	ErrInvalidKey           = errors.New("invalid key")
+	ErrChallengeNotOffered  = errors.New("challenge not offered by CA")
+)
+
+// Manager obtains certificate for all configured domains from ACME CA, and writes it with its key to the cache
+// directory, where it's picked up by listeners. Account key is kept there as well, it's generated on first use.
+type Manager struct {
+	Config config.ACME
+
+	solver solver
+	http01 *http01Solver
+}
+
+func NewManager(conf config.ACME) (*Manager, error) {
+	manager := &Manager{Config: conf}
+
+	switch conf.Challenge {
+	case config.ChallengeHTTP01:
+		manager.http01 = newHTTP01Solver()
+		manager.solver = manager.http01
+	case config.ChallengeDNS01:
+		provider, err := NewDNSProvider(conf.DNS)
+		if err != nil {
+			return nil, err
+		}
+
+		manager.solver = &dns01Solver{Provider: provider, PropagationDelay: conf.DNS.PropagationDelay}
+	}
+
+	// listeners watch the directory for the certificate, so it has to exist before it's obtained
+	if err := os.MkdirAll(conf.CacheDirectory, cacheDirectoryMode); err != nil {
+		return nil, fmt.Errorf("error creating acme cache directory: %w", err)
+	}
+
+	return manager, nil
+}
+
+// HTTPHandler responds to HTTP-01 challenges, it's nil when they are not used.
+func (m *Manager) HTTPHandler() http.Handler {
+	if m.http01 == nil {
+		return nil
+	}
+
+	return m.http01
+}
+
+// NeedsRenewal checks if the cached certificate is missing, expires within renew_before, or doesn't cover all
+// configured domains.
+func (m *Manager) NeedsRenewal(now time.Time) bool {
+	certificate, err := m.cachedCertificate()
+	if err != nil {
+		return true
+	}
+
+	if now.Add(m.Config.RenewBefore).After(certificate.NotAfter) {
+		return true
+	}
+
+	for _, domain := range m.Config.Domains {
+		if certificate.VerifyHostname(domain) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Obtain requests a new certificate, fulfilling challenges for all domains.
+func (m *Manager) Obtain(ctx context.Context) error {
+	client, err := m.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acmeclient.DomainIDs(m.Config.Domains...))
+	if err != nil {
+		return fmt.Errorf("error creating acme order: %w", err)
+	}
+
+	for _, url := range order.AuthzURLs {
+		if err = m.authorize(ctx, client, url); err != nil {
+			return err
+		}
+	}
+
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return fmt.Errorf("error waiting for acme order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.Config.Domains[0]},
+		DNSNames: m.Config.Domains,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("error finalizing acme order: %w", err)
+	}
+
+	return m.store(chain, key)
+}
+
+func (m *Manager) authorize(ctx context.Context, client *acmeclient.Client, url string) error {
+	authorization, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("error fetching acme authorization: %w", err)
+	}
+
+	if authorization.Status == acmeclient.StatusValid {
+		return nil
+	}
+
+	domain := authorization.Identifier.Value
+
+	var challenge *acmeclient.Challenge
+
+	for _, offered := range authorization.Challenges {
+		if offered.Type == m.solver.Type() {
+			challenge = offered
+
+			break
+		}
+	}
+
+	if challenge == nil {
+		return fmt.Errorf("%w: %s for %s", ErrChallengeNotOffered, m.solver.Type(), domain)
+	}
+
+	if err = m.solver.Present(ctx, client, domain, challenge); err != nil {
+		return fmt.Errorf("error presenting %s challenge for %s: %w", challenge.Type, domain, err)
+	}
+
+	defer func() {
+		if err := m.solver.CleanUp(ctx, client, domain, challenge); err != nil {
+			log.Warnw("error cleaning up acme challenge", log.Fields{"domain": domain, "error": err.Error()})
+		}
+	}()
+
+	if _, err = client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("error accepting %s challenge for %s: %w", challenge.Type, domain, err)
+	}
+
+	if _, err = client.WaitAuthorization(ctx, authorization.URI); err != nil {
+		return fmt.Errorf("authorization for %s failed: %w", domain, err)
+	}
+
+	log.Debugw("acme authorization valid", log.Fields{"domain": domain})
+
+	return nil
+}
+
+// client registers the account (or finds the existing one) on the CA.
+func (m *Manager) client(ctx context.Context) (*acmeclient.Client, error) {
+	key, err := m.accountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newHTTPClient(m.Config.CACertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &acmeclient.Client{
+		Key:          key,
+		DirectoryURL: m.Config.Directory,
+		HTTPClient:   httpClient,
+		UserAgent:    "mailbowl",
+	}
+
+	account := &acmeclient.Account{}
+	if m.Config.Email != "" {
+		account.Contact = []string{"mailto:" + m.Config.Email}
+	}
+
+	if _, err = client.Register(ctx, account, acmeclient.AcceptTOS); err != nil &&
+		!errors.Is(err, acmeclient.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("error registering acme account: %w", err)
+	}
+
+	return client, nil
+}
+
+// accountKey loads the account key, or generates a new one, when it's not cached yet.
+func (m *Manager) accountKey() (crypto.Signer, error) {
+	path := m.Config.AccountKeyFile()
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return parseKey(data)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading acme account key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	encoded, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = writeFile(path, encoded, keyMode); err != nil {
+		return nil, fmt.Errorf("error writing acme account key: %w", err)
+	}
+
+	log.Infow("acme account key generated", log.Fields{"file": path})
+
+	return key, nil
+}
+
+func (m *Manager) cachedCertificate() (*x509.Certificate, error) {
+	data, err := os.ReadFile(m.Config.CertificateFile())
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidCertificate
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return certificate, nil
+}
+
+// store writes key first, so the certificate never refers to a key which is not there yet. Both are replaced
+// atomically.
+func (m *Manager) store(chain [][]byte, key *ecdsa.PrivateKey) error {
+	encodedKey, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	encodedChain := make([]byte, 0)
+	for _, der := range chain {
+		encodedChain = append(encodedChain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	if err = writeFile(m.Config.KeyFile(), encodedKey, keyMode); err != nil {
+		return fmt.Errorf("error writing acme certificate key: %w", err)
+	}
+
+	if err = writeFile(m.Config.CertificateFile(), encodedChain, certificateMode); err != nil {
+		return fmt.Errorf("error writing acme certificate: %w", err)
+	}
+
+	return nil
+}
+
+func newHTTPClient(caCertificate string) (*http.Client, error) {
+	if caCertificate == "" {
+		return http.DefaultClient, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	data, err := os.ReadFile(caCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("error reading acme.ca_certificate: %w", err)
+	}
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, ErrInvalidCACertificate
+	}
+
+	transport, _ := http.DefaultTransport.(*http.Transport)
+	transport = transport.Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func parseKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidKey, err.Error())
+	}
+
+	return key, nil
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeFile replaces file atomically, so it's never read half-written.
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	temporary, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	defer os.Remove(temporary.Name())
+
+	if _, err = temporary.Write(data); err != nil {
+		temporary.Close()
+
+		return fmt.Errorf("%w", err)
+	}
+
+	if err = temporary.Close(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err = os.Chmod(temporary.Name(), mode); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err = os.Rename(temporary.Name(), path); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
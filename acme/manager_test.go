@@ -0,0 +1,422 @@
+package acme_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/acme"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener"
+	"github.com/stretchr/testify/assert"
+	acmeclient "golang.org/x/crypto/acme"
+)
+
+var errChallengeFailed = errors.New("challenge failed")
+
+// fakeCA implements just enough of RFC 8555 to issue certificates, requests are not authenticated.
+type fakeCA struct {
+	*httptest.Server
+
+	t             *testing.T
+	challengeType string
+	validate      func(token string) error
+
+	mutex       sync.Mutex
+	nonce       int
+	domains     []string
+	authorized  map[int]string
+	certificate []byte
+	key         *ecdsa.PrivateKey
+	root        *x509.Certificate
+}
+
+func newFakeCA(t *testing.T, challengeType string, validate func(token string) error) *fakeCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.NoError(t, err)
+
+	root, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	ca := &fakeCA{
+		t: t, challengeType: challengeType, validate: validate,
+		authorized: make(map[int]string), key: key, root: root,
+	}
+	ca.Server = httptest.NewServer(http.HandlerFunc(ca.handle))
+	t.Cleanup(ca.Close)
+
+	return ca
+}
+
+//nolint:cyclop
+func (ca *fakeCA) handle(w http.ResponseWriter, r *http.Request) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", "nonce-"+strconv.Itoa(ca.nonce))
+
+	payload := ca.payload(r)
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	switch path[0] {
+	case "directory":
+		ca.respond(w, http.StatusOK, map[string]string{
+			"newNonce": ca.URL + "/nonce", "newAccount": ca.URL + "/account", "newOrder": ca.URL + "/order",
+			"revokeCert": ca.URL + "/revoke", "keyChange": ca.URL + "/key-change",
+		})
+	case "nonce":
+		w.WriteHeader(http.StatusOK)
+	case "account":
+		w.Header().Set("Location", ca.URL+"/account/1")
+		ca.respond(w, http.StatusCreated, map[string]string{"status": "valid"})
+	case "order":
+		if len(path) == 1 {
+			ca.newOrder(payload)
+			w.Header().Set("Location", ca.URL+"/order/1")
+			ca.respond(w, http.StatusCreated, ca.order())
+
+			return
+		}
+
+		ca.respond(w, http.StatusOK, ca.order())
+	case "authz":
+		ca.respond(w, http.StatusOK, ca.authorization(ca.index(path)))
+	case "challenge":
+		index := ca.index(path)
+		ca.authorized[index] = "valid"
+
+		if err := ca.validate(ca.token(index)); err != nil {
+			ca.authorized[index] = "invalid"
+		}
+
+		ca.respond(w, http.StatusOK, ca.challenge(index))
+	case "finalize":
+		ca.finalize(payload)
+		w.Header().Set("Location", ca.URL+"/order/1")
+		ca.respond(w, http.StatusOK, ca.order())
+	case "certificate":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(ca.certificate)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ca *fakeCA) payload(r *http.Request) []byte {
+	var jws struct{ Payload string }
+
+	if r.Method != http.MethodPost {
+		return nil
+	}
+
+	assert.NoError(ca.t, json.NewDecoder(r.Body).Decode(&jws))
+
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	assert.NoError(ca.t, err)
+
+	return payload
+}
+
+func (ca *fakeCA) respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	assert.NoError(ca.t, json.NewEncoder(w).Encode(body))
+}
+
+func (ca *fakeCA) index(path []string) int {
+	index, err := strconv.Atoi(path[1])
+	assert.NoError(ca.t, err)
+
+	return index
+}
+
+func (ca *fakeCA) token(index int) string {
+	return "token-" + strconv.Itoa(index)
+}
+
+func (ca *fakeCA) newOrder(payload []byte) {
+	var request struct{ Identifiers []struct{ Value string } }
+
+	assert.NoError(ca.t, json.Unmarshal(payload, &request))
+
+	ca.domains, ca.authorized, ca.certificate = nil, make(map[int]string), nil
+
+	for index, identifier := range request.Identifiers {
+		ca.domains = append(ca.domains, identifier.Value)
+		ca.authorized[index] = "pending"
+	}
+}
+
+func (ca *fakeCA) order() map[string]interface{} {
+	status := "ready"
+	authorizations := make([]string, 0)
+
+	for index := range ca.domains {
+		authorizations = append(authorizations, fmt.Sprintf("%s/authz/%d", ca.URL, index))
+
+		if ca.authorized[index] != "valid" {
+			status = "pending"
+		}
+	}
+
+	order := map[string]interface{}{
+		"status": status, "authorizations": authorizations, "finalize": ca.URL + "/finalize",
+	}
+
+	if ca.certificate != nil {
+		order["status"], order["certificate"] = "valid", ca.URL+"/certificate"
+	}
+
+	return order
+}
+
+func (ca *fakeCA) authorization(index int) map[string]interface{} {
+	return map[string]interface{}{
+		"status":     ca.authorized[index],
+		"identifier": map[string]string{"type": "dns", "value": ca.domains[index]},
+		"challenges": []interface{}{ca.challenge(index)},
+	}
+}
+
+func (ca *fakeCA) challenge(index int) map[string]string {
+	return map[string]string{
+		"type":   ca.challengeType,
+		"url":    fmt.Sprintf("%s/challenge/%d", ca.URL, index),
+		"token":  ca.token(index),
+		"status": ca.authorized[index],
+	}
+}
+
+func (ca *fakeCA) finalize(payload []byte) {
+	var request struct{ CSR string }
+
+	assert.NoError(ca.t, json.Unmarshal(payload, &request))
+
+	der, err := base64.RawURLEncoding.DecodeString(request.CSR)
+	assert.NoError(ca.t, err)
+
+	csr, err := x509.ParseCertificateRequest(der)
+	assert.NoError(ca.t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	leaf, err := x509.CreateCertificate(rand.Reader, template, ca.root, csr.PublicKey, ca.key)
+	assert.NoError(ca.t, err)
+
+	ca.certificate = append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.root.Raw})...,
+	)
+}
+
+func keyAuthorization(t *testing.T, conf config.ACME, token string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(conf.AccountKeyFile())
+	assert.NoError(t, err)
+
+	block, _ := pem.Decode(data)
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	assert.NoError(t, err)
+
+	thumbprint, err := acmeclient.JWKThumbprint(key.Public())
+	assert.NoError(t, err)
+
+	return token + "." + thumbprint
+}
+
+func acmeConfig(t *testing.T, ca *fakeCA, challenge config.ACMEChallenge) config.ACME {
+	t.Helper()
+
+	return config.ACME{
+		Enabled:        true,
+		Directory:      ca.URL + "/directory",
+		Email:          "postmaster@example.local",
+		Domains:        []string{"mail.example.local", "smtp.example.local"},
+		CacheDirectory: filepath.Join(t.TempDir(), "acme"),
+		Challenge:      challenge,
+		RenewBefore:    30 * 24 * time.Hour,
+	}
+}
+
+func TestObtainWithHTTP01(t *testing.T) {
+	t.Parallel()
+
+	var (
+		conf   config.ACME
+		server *httptest.Server
+	)
+
+	ca := newFakeCA(t, "http-01", func(token string) error {
+		response, err := http.Get(server.URL + "/.well-known/acme-challenge/" + token) //nolint:noctx
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		defer response.Body.Close()
+
+		body, _ := io.ReadAll(response.Body)
+		if string(body) != keyAuthorization(t, conf, token) {
+			return errChallengeFailed
+		}
+
+		return nil
+	})
+
+	conf = acmeConfig(t, ca, config.ChallengeHTTP01)
+
+	manager, err := acme.NewManager(conf)
+	assert.NoError(t, err)
+
+	// challenges are served by the HTTP listener
+	server = httptest.NewServer((&listener.HTTP{ACME: manager.HTTPHandler()}).Handler())
+	defer server.Close()
+
+	assert.True(t, manager.NeedsRenewal(time.Now()))
+	assert.NoError(t, manager.Obtain(context.Background()))
+
+	pair, err := tls.LoadX509KeyPair(conf.CertificateFile(), conf.KeyFile())
+	assert.NoError(t, err)
+	assert.Len(t, pair.Certificate, 2)
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "mail.example.local", leaf.Subject.CommonName)
+	assert.Equal(t, []string{"mail.example.local", "smtp.example.local"}, leaf.DNSNames)
+
+	// challenge responses are removed
+	response, err := http.Get(server.URL + "/.well-known/acme-challenge/token-0") //nolint:noctx
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	assert.False(t, manager.NeedsRenewal(time.Now()))
+	assert.True(t, manager.NeedsRenewal(time.Now().Add(61*24*time.Hour)))
+
+	manager.Config.Domains = append(manager.Config.Domains, "mx.example.local")
+	assert.True(t, manager.NeedsRenewal(time.Now()))
+}
+
+func TestObtainWithDNS01(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	records := filepath.Join(directory, "records")
+	command := filepath.Join(directory, "dns-hook")
+
+	assert.NoError(t, os.WriteFile(command, []byte("#!/bin/sh\necho \"$1 $2 $3\" >> "+records+"\n"), 0o700))
+
+	var conf config.ACME
+
+	ca := newFakeCA(t, "dns-01", func(token string) error {
+		digest := sha256.Sum256([]byte(keyAuthorization(t, conf, token)))
+		value := base64.RawURLEncoding.EncodeToString(digest[:])
+
+		data, _ := os.ReadFile(records)
+		if !strings.Contains(string(data), "present _acme-challenge.mail.example.local. "+value) &&
+			!strings.Contains(string(data), "present _acme-challenge.smtp.example.local. "+value) {
+			return errChallengeFailed
+		}
+
+		return nil
+	})
+
+	conf = acmeConfig(t, ca, config.ChallengeDNS01)
+	conf.DNS = config.ACMEDNS{Provider: config.DNSProviderExec, Command: command}
+
+	manager, err := acme.NewManager(conf)
+	assert.NoError(t, err)
+	assert.Nil(t, manager.HTTPHandler())
+
+	assert.NoError(t, manager.Obtain(context.Background()))
+
+	_, err = tls.LoadX509KeyPair(conf.CertificateFile(), conf.KeyFile())
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(records)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "cleanup _acme-challenge.mail.example.local. ")
+	assert.Contains(t, string(data), "cleanup _acme-challenge.smtp.example.local. ")
+}
+
+func TestObtainFailedChallenge(t *testing.T) {
+	t.Parallel()
+
+	ca := newFakeCA(t, "http-01", func(string) error { return errChallengeFailed })
+	conf := acmeConfig(t, ca, config.ChallengeHTTP01)
+
+	manager, err := acme.NewManager(conf)
+	assert.NoError(t, err)
+
+	err = manager.Obtain(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "authorization for mail.example.local failed")
+
+	_, err = os.Stat(conf.CertificateFile())
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	// account key is kept, so the account is reused on retry
+	accountKey, err := os.ReadFile(conf.AccountKeyFile())
+	assert.NoError(t, err)
+
+	assert.Error(t, manager.Obtain(context.Background()))
+
+	reusedKey, err := os.ReadFile(conf.AccountKeyFile())
+	assert.NoError(t, err)
+	assert.Equal(t, accountKey, reusedKey)
+}
+
+func TestObtainWithUnofferedChallenge(t *testing.T) {
+	t.Parallel()
+
+	ca := newFakeCA(t, "tls-alpn-01", func(string) error { return nil })
+
+	manager, err := acme.NewManager(acmeConfig(t, ca, config.ChallengeHTTP01))
+	assert.NoError(t, err)
+
+	err = manager.Obtain(context.Background())
+	assert.True(t, errors.Is(err, acme.ErrChallengeNotOffered))
+}
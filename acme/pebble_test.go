@@ -0,0 +1,67 @@
+package acme_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/acme"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestObtainFromPebble requests certificate from Pebble (https://github.com/letsencrypt/pebble). It's skipped, unless
+// PEBBLE_DIRECTORY is set. Pebble validates HTTP-01 challenges on port 5002 by default, and has to resolve the test
+// domain to this host, e.g. with pebble-challtestsrv:
+//
+//	pebble-challtestsrv -defaultIPv4 127.0.0.1 &
+//	PEBBLE_VA_NOSLEEP=1 pebble -config test/config/pebble-config.json -dnsserver 127.0.0.1:8053 &
+//	PEBBLE_DIRECTORY=https://localhost:14000/dir PEBBLE_CA_CERTIFICATE=test/certs/pebble.minica.pem go test ./acme
+func TestObtainFromPebble(t *testing.T) {
+	directory := os.Getenv("PEBBLE_DIRECTORY")
+	if directory == "" {
+		t.Skip("PEBBLE_DIRECTORY not set")
+	}
+
+	address := os.Getenv("PEBBLE_HTTP_ADDRESS")
+	if address == "" {
+		address = ":5002"
+	}
+
+	conf := config.ACME{
+		Enabled:        true,
+		Directory:      directory,
+		Email:          "postmaster@example.com",
+		Domains:        []string{"mail.example.com"},
+		CacheDirectory: filepath.Join(t.TempDir(), "acme"),
+		CACertificate:  os.Getenv("PEBBLE_CA_CERTIFICATE"),
+		Challenge:      config.ChallengeHTTP01,
+		RenewBefore:    24 * time.Hour,
+	}
+
+	manager, err := acme.NewManager(conf)
+	assert.NoError(t, err)
+
+	socket, err := net.Listen("tcp", address)
+	assert.NoError(t, err)
+
+	server := &http.Server{Handler: (&listener.HTTP{ACME: manager.HTTPHandler()}).Handler()}
+
+	go func() { _ = server.Serve(socket) }()
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	assert.NoError(t, manager.Obtain(ctx))
+
+	_, err = tls.LoadX509KeyPair(conf.CertificateFile(), conf.KeyFile())
+	assert.NoError(t, err)
+	assert.False(t, manager.NeedsRenewal(time.Now()))
+}
@@ -0,0 +1,76 @@
+package acme
+
+import (
+	"context"
+	"time"
+
+	"github.com/Masterminds/log-go"
+)
+
+const (
+	defaultRenewalInterval = 12 * time.Hour
+	defaultRetryInterval   = 10 * time.Minute
+)
+
+// Renewal obtains the certificate when it's missing, and renews it ahead of expiry. Failed requests are retried
+// after RetryInterval. It runs alongside listeners, so it stops together with them.
+type Renewal struct {
+	Manager       *Manager
+	Interval      time.Duration
+	RetryInterval time.Duration
+}
+
+func NewRenewal(manager *Manager) *Renewal {
+	return &Renewal{Manager: manager, Interval: defaultRenewalInterval, RetryInterval: defaultRetryInterval}
+}
+
+func (r *Renewal) GetName() string {
+	return "ACME renewal"
+}
+
+func (r *Renewal) Serve(ctx context.Context) error {
+	for {
+		wait := r.Interval
+
+		if !r.renew(ctx) {
+			wait = r.RetryInterval
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+
+			return nil
+		}
+	}
+}
+
+// renew returns false, when the certificate was due, but couldn't be obtained.
+func (r *Renewal) renew(ctx context.Context) bool {
+	if !r.Manager.NeedsRenewal(time.Now()) {
+		return true
+	}
+
+	fields := log.Fields{"domains": r.Manager.Config.Domains, "directory": r.Manager.Config.Directory}
+
+	log.Infow("requesting acme certificate", fields)
+
+	if err := r.Manager.Obtain(ctx); err != nil {
+		// interrupted by reload or shutdown
+		if ctx.Err() != nil {
+			return true
+		}
+
+		fields["error"] = err.Error()
+		log.Errorw("acme certificate request failed", fields)
+
+		return false
+	}
+
+	log.Infow("acme certificate obtained", fields)
+
+	return true
+}
@@ -0,0 +1,337 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+)
+
+const (
+	directoryMode     = 0o750
+	fileMode          = 0o640
+	closedSegmentMode = 0o440
+
+	segmentPattern = "segment-*.jsonl"
+	segmentFormat  = "segment-%08d.jsonl"
+	lockFile       = ".lock"
+)
+
+var (
+	ErrArchiveDisabled  = errors.New("archive directory not configured")
+	ErrTampered         = errors.New("archive chain broken")
+	ErrIncompleteRecord = errors.New("incomplete archive record")
+)
+
+// Record is a single archived message with its envelope. Records are chained: each one includes the hash of
+// the previous one, so altering, removing or reordering any of them breaks the chain. Records removed from the end
+// don't break it, the archive just looks shorter: compare the last hash reported by `archive verify` with a copy
+// kept elsewhere to detect that.
+type Record struct {
+	Sequence   uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Sender     string    `json:"sender"`
+	Recipients []string  `json:"recipients"`
+	RemoteIP   string    `json:"remote_ip"`
+	Username   string    `json:"username,omitempty"`
+	Listener   string    `json:"listener"`
+	Message    []byte    `json:"message"`
+	Previous   string    `json:"prev"`
+	Hash       string    `json:"hash"`
+}
+
+// Archive appends records to segment files in Directory, one JSON record per line. When the segment grows over
+// SegmentSize, it is made read-only and the next one is started. Appends are serialized with a lock file in
+// Directory, so other processes (i.e. quarantine release command) can append at the same time.
+type Archive struct {
+	Directory   string
+	SegmentSize int64
+
+	mutex    sync.Mutex
+	loaded   bool
+	segment  int
+	size     int64
+	sequence uint64
+	last     string
+}
+
+func NewArchive(conf config.Archive) *Archive {
+	return &Archive{Directory: conf.Directory, SegmentSize: int64(conf.SegmentSize)}
+}
+
+// Resize changes the size at which segments are closed. The archive keeps its place in the chain, so it can be
+// reused when only the segment size changed.
+func (a *Archive) Resize(segmentSize int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.SegmentSize = int64(segmentSize)
+}
+
+func (a *Archive) Enabled() bool {
+	return a != nil && a.Directory != ""
+}
+
+// Append chains the record to the archive, filling its sequence number, time and hashes. Record is synced to
+// disk, before Append returns.
+func (a *Archive) Append(record *Record) error {
+	if !a.Enabled() {
+		return ErrArchiveDisabled
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err := os.MkdirAll(a.Directory, directoryMode); err != nil {
+		return fmt.Errorf("error creating archive directory: %w", err)
+	}
+
+	locked, err := lock(a.Directory)
+	if err != nil {
+		return err
+	}
+	defer locked.Close()
+
+	if err := a.load(); err != nil {
+		return err
+	}
+
+	record.Sequence = a.sequence + 1
+	record.Time = time.Now().UTC()
+	record.Previous = a.last
+	record.Hash = ""
+
+	hash, err := Sum(record)
+	if err != nil {
+		return err
+	}
+
+	record.Hash = hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding archive record: %w", err)
+	}
+
+	line = append(line, '\n')
+
+	if a.size > 0 && a.SegmentSize > 0 && a.size+int64(len(line)) > a.SegmentSize {
+		if err := os.Chmod(a.segmentPath(a.segment), closedSegmentMode); err != nil {
+			return fmt.Errorf("error closing archive segment: %w", err)
+		}
+
+		a.segment++
+		a.size = 0
+	}
+
+	if err := a.write(line); err != nil {
+		return err
+	}
+
+	a.size += int64(len(line))
+	a.sequence = record.Sequence
+	a.last = record.Hash
+
+	return nil
+}
+
+// Sum calculates hash of the record: SHA-256 of its JSON representation, without the hash itself.
+func Sum(record *Record) (string, error) {
+	unhashed := *record
+	unhashed.Hash = ""
+
+	data, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", fmt.Errorf("error encoding archive record: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Walk reads all records in order, verifying the chain on the way. It stops with ErrTampered at the first record
+// which doesn't match, so records passed to fn are always verified.
+func Walk(directory string, fn func(segment string, record *Record) error) error {
+	segments, err := filepath.Glob(filepath.Join(directory, segmentPattern))
+	if err != nil {
+		return fmt.Errorf("error listing archive segments: %w", err)
+	}
+
+	sort.Strings(segments)
+
+	var (
+		sequence uint64
+		last     string
+	)
+
+	for _, segment := range segments {
+		err := walkSegment(segment, func(line int, record *Record) error {
+			name := fmt.Sprintf("%s:%d", filepath.Base(segment), line)
+
+			switch hash, err := Sum(record); {
+			case err != nil:
+				return err
+			case record.Sequence != sequence+1:
+				return fmt.Errorf("%w: %s: expected record %d, got %d", ErrTampered, name, sequence+1, record.Sequence)
+			case record.Previous != last:
+				return fmt.Errorf("%w: %s: previous hash mismatch", ErrTampered, name)
+			case record.Hash != hash:
+				return fmt.Errorf("%w: %s: record hash mismatch", ErrTampered, name)
+			}
+
+			sequence, last = record.Sequence, record.Hash
+
+			return fn(filepath.Base(segment), record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkSegment(path string, fn func(line int, record *Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading archive segment: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				return fmt.Errorf("%w: %s:%d", ErrIncompleteRecord, filepath.Base(path), line)
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("error reading archive segment: %w", err)
+		}
+
+		record := &Record{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return fmt.Errorf("%w: %s:%d: %s", ErrTampered, filepath.Base(path), line, err.Error())
+		}
+
+		if err := fn(line, record); err != nil {
+			return err
+		}
+	}
+}
+
+// load finds the end of the chain: the last segment and its last record. Incomplete last record (i.e. left by
+// a crash) is an error, as appending after it would hide it. It is found again, when records were appended by
+// another process (i.e. quarantine release command).
+func (a *Archive) load() error {
+	if a.loaded && !a.changed() {
+		return nil
+	}
+
+	segments, err := filepath.Glob(filepath.Join(a.Directory, segmentPattern))
+	if err != nil {
+		return fmt.Errorf("error listing archive segments: %w", err)
+	}
+
+	a.segment = 1
+
+	if len(segments) > 0 {
+		sort.Strings(segments)
+
+		last := segments[len(segments)-1]
+		if _, err := fmt.Sscanf(filepath.Base(last), segmentFormat, &a.segment); err != nil {
+			return fmt.Errorf("invalid archive segment name `%s`: %w", last, err)
+		}
+
+		if err := a.loadSegment(last); err != nil {
+			return err
+		}
+	}
+
+	a.loaded = true
+
+	return nil
+}
+
+// changed checks whether the last segment grew, or the next one was started, since the archive was loaded.
+func (a *Archive) changed() bool {
+	info, err := os.Stat(a.segmentPath(a.segment))
+	if err != nil {
+		return !os.IsNotExist(err) || a.size > 0
+	}
+
+	if info.Size() != a.size {
+		return true
+	}
+
+	_, err = os.Stat(a.segmentPath(a.segment + 1))
+
+	return err == nil
+}
+
+func (a *Archive) loadSegment(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading archive segment: %w", err)
+	}
+
+	a.size = int64(len(data))
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if data[len(data)-1] != '\n' {
+		return fmt.Errorf("%w: at the end of %s", ErrIncompleteRecord, filepath.Base(path))
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	record := &Record{}
+
+	if err := json.Unmarshal(lines[len(lines)-1], record); err != nil {
+		return fmt.Errorf("%w: at the end of %s: %s", ErrTampered, filepath.Base(path), err.Error())
+	}
+
+	a.sequence, a.last = record.Sequence, record.Hash
+
+	return nil
+}
+
+func (a *Archive) write(line []byte) error {
+	file, err := os.OpenFile(a.segmentPath(a.segment), os.O_APPEND|os.O_CREATE|os.O_WRONLY, fileMode)
+	if err != nil {
+		return fmt.Errorf("error writing archive segment: %w", err)
+	}
+	defer file.Close()
+
+	if _, err = file.Write(line); err != nil {
+		return fmt.Errorf("error writing archive segment: %w", err)
+	}
+
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("error writing archive segment: %w", err)
+	}
+
+	return nil
+}
+
+func (a *Archive) segmentPath(segment int) string {
+	return filepath.Join(a.Directory, fmt.Sprintf(segmentFormat, segment))
+}
@@ -0,0 +1,248 @@
+package archive_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/archive"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const archiveTestMessage = "From: sender@example.local\r\nTo: rcpt@example.local\r\nSubject: hello\r\n\r\nbody\r\n"
+
+func appendRecords(t *testing.T, a *archive.Archive, senders ...string) {
+	t.Helper()
+
+	for _, sender := range senders {
+		assert.NoError(t, a.Append(&archive.Record{
+			Sender:     sender,
+			Recipients: []string{"rcpt@example.local", "hidden@example.local"},
+			RemoteIP:   "192.0.2.1",
+			Listener:   "smtp://0.0.0.0:25",
+			Message:    []byte(archiveTestMessage),
+		}))
+	}
+}
+
+func segments(t *testing.T, directory string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(directory, "segment-*.jsonl"))
+	assert.NoError(t, err)
+
+	return files
+}
+
+func TestArchiveChain(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	appendRecords(t, archive.NewArchive(config.Archive{Directory: directory}), "a@example.local", "b@example.local")
+
+	// reopened archive continues the chain
+	appendRecords(t, archive.NewArchive(config.Archive{Directory: directory}), "c@example.local")
+
+	records := make([]*archive.Record, 0)
+	assert.NoError(t, archive.Walk(directory, func(_ string, record *archive.Record) error {
+		records = append(records, record)
+
+		return nil
+	}))
+
+	assert.Len(t, records, 3)
+	assert.Equal(t, "", records[0].Previous)
+
+	for i, record := range records {
+		assert.Equal(t, uint64(i+1), record.Sequence)
+		assert.Equal(t, archiveTestMessage, string(record.Message))
+
+		if i > 0 {
+			assert.Equal(t, records[i-1].Hash, record.Previous)
+		}
+	}
+
+	summary, err := archive.Verify(directory)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Segments)
+	assert.Equal(t, 3, summary.Records)
+	assert.Equal(t, records[2].Hash, summary.Last)
+}
+
+func TestArchiveAppendedByAnotherProcess(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	running := archive.NewArchive(config.Archive{Directory: directory, SegmentSize: 1000})
+	appendRecords(t, running, "a@example.local")
+
+	// i.e. quarantine release command, appending while the server is running
+	appendRecords(t, archive.NewArchive(config.Archive{Directory: directory, SegmentSize: 1000}), "b@example.local")
+	appendRecords(t, running, "c@example.local")
+
+	summary, err := archive.Verify(directory)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, summary.Records)
+}
+
+func TestArchiveAppendedConcurrentlyByAnotherProcess(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	done := make(chan struct{})
+
+	// each archive stands for a separate process, sharing only the directory
+	for _, sender := range []string{"a@example.local", "b@example.local"} {
+		go func(sender string) {
+			store := archive.NewArchive(config.Archive{Directory: directory, SegmentSize: 2000})
+
+			for i := 0; i < 20; i++ {
+				appendRecords(t, store, sender)
+			}
+
+			done <- struct{}{}
+		}(sender)
+	}
+
+	<-done
+	<-done
+
+	summary, err := archive.Verify(directory)
+	assert.NoError(t, err)
+	assert.Equal(t, 40, summary.Records)
+}
+
+func TestArchiveSegments(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	appendRecords(t, archive.NewArchive(config.Archive{Directory: directory, SegmentSize: 100}),
+		"a@example.local", "b@example.local", "c@example.local")
+
+	files := segments(t, directory)
+	assert.Len(t, files, 3)
+
+	info, err := os.Stat(files[0])
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o440), info.Mode().Perm())
+
+	summary, err := archive.Verify(directory)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, summary.Segments)
+	assert.Equal(t, 3, summary.Records)
+}
+
+func TestArchiveResize(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	store := archive.NewArchive(config.Archive{Directory: directory})
+	appendRecords(t, store, "a@example.local", "b@example.local")
+
+	// same archive keeps the chain, new size applies to the next records
+	store.Resize(100)
+	appendRecords(t, store, "c@example.local", "d@example.local")
+
+	summary, err := archive.Verify(directory)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, summary.Segments)
+	assert.Equal(t, 4, summary.Records)
+}
+
+func TestArchiveVerifyDetectsTampering(t *testing.T) {
+	t.Parallel()
+
+	tamper := map[string]func(lines []string) []string{
+		"altered": func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "b@example.local", "x@example.local", 1)
+
+			return lines
+		},
+		"removed": func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		},
+		"reordered": func(lines []string) []string {
+			lines[0], lines[1] = lines[1], lines[0]
+
+			return lines
+		},
+	}
+
+	for name, fn := range tamper {
+		fn := fn
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			directory := t.TempDir()
+			appendRecords(t, archive.NewArchive(config.Archive{Directory: directory}),
+				"a@example.local", "b@example.local", "c@example.local")
+
+			path := segments(t, directory)[0]
+			data, err := os.ReadFile(path)
+			assert.NoError(t, err)
+
+			lines := fn(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+			assert.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+
+			_, err = archive.Verify(directory)
+			assert.ErrorIs(t, err, archive.ErrTampered)
+		})
+	}
+}
+
+func TestArchiveRefusesIncompleteRecord(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	appendRecords(t, archive.NewArchive(config.Archive{Directory: directory}), "a@example.local")
+
+	file, err := os.OpenFile(segments(t, directory)[0], os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = file.WriteString(`{"seq":2,`)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	err = archive.NewArchive(config.Archive{Directory: directory}).Append(&archive.Record{})
+	assert.ErrorIs(t, err, archive.ErrIncompleteRecord)
+
+	_, err = archive.Verify(directory)
+	assert.ErrorIs(t, err, archive.ErrIncompleteRecord)
+}
+
+func TestArchiveExport(t *testing.T) {
+	t.Parallel()
+
+	directory, output := t.TempDir(), t.TempDir()
+	appendRecords(t, archive.NewArchive(config.Archive{Directory: directory}), "a@example.local", "b@example.local")
+
+	exported, err := archive.Export(directory, output, archive.Criteria{Address: "B@example.local"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, exported)
+
+	data, err := os.ReadFile(filepath.Join(output, "00000002.eml"))
+	assert.NoError(t, err)
+	assert.Equal(t, archiveTestMessage, string(data))
+
+	envelope, err := os.ReadFile(filepath.Join(output, "00000002.json"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(envelope), `"sender": "b@example.local"`)
+	assert.Contains(t, string(envelope), `"message": null`)
+
+	// recipients match too, time range excludes everything
+	exported, err = archive.Export(directory, t.TempDir(), archive.Criteria{
+		Address: "hidden@example.local", Until: time.Now().Add(-time.Hour),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exported)
+}
+
+func TestArchiveDisabled(t *testing.T) {
+	t.Parallel()
+
+	err := archive.NewArchive(config.Archive{}).Append(&archive.Record{})
+	assert.ErrorIs(t, err, archive.ErrArchiveDisabled)
+}
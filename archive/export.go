@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Criteria selects exported records. Zero values match everything, Address matches sender or any of the
+// recipients.
+type Criteria struct {
+	Since   time.Time
+	Until   time.Time
+	Address string
+}
+
+// Summary describes the verified archive.
+type Summary struct {
+	Segments int
+	Records  int
+	Last     string
+}
+
+func (c Criteria) Match(record *Record) bool {
+	if !c.Since.IsZero() && record.Time.Before(c.Since) {
+		return false
+	}
+
+	if !c.Until.IsZero() && !record.Time.Before(c.Until) {
+		return false
+	}
+
+	if c.Address == "" || strings.EqualFold(record.Sender, c.Address) {
+		return true
+	}
+
+	for _, recipient := range record.Recipients {
+		if strings.EqualFold(recipient, c.Address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Verify checks the whole chain, returning ErrTampered when any of the records was altered, removed or reordered.
+func Verify(directory string) (*Summary, error) {
+	summary := &Summary{}
+	segment := ""
+
+	err := Walk(directory, func(name string, record *Record) error {
+		if name != segment {
+			segment = name
+			summary.Segments++
+		}
+
+		summary.Records++
+		summary.Last = record.Hash
+
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	return summary, nil
+}
+
+// Export writes messages matching criteria to output directory, as <seq>.eml files, with envelopes stored next to
+// them in <seq>.json. Chain is verified while exporting, so only untampered records are written. Returns the number
+// of exported messages.
+func Export(directory, output string, criteria Criteria) (int, error) {
+	if err := os.MkdirAll(output, directoryMode); err != nil {
+		return 0, fmt.Errorf("error creating export directory: %w", err)
+	}
+
+	exported := 0
+
+	err := Walk(directory, func(_ string, record *Record) error {
+		if !criteria.Match(record) {
+			return nil
+		}
+
+		name := filepath.Join(output, fmt.Sprintf("%08d", record.Sequence))
+
+		if err := os.WriteFile(name+".eml", record.Message, fileMode); err != nil {
+			return fmt.Errorf("error exporting message: %w", err)
+		}
+
+		envelope := *record
+		envelope.Message = nil
+
+		data, err := json.MarshalIndent(&envelope, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error exporting envelope: %w", err)
+		}
+
+		if err := os.WriteFile(name+".json", append(data, '\n'), fileMode); err != nil {
+			return fmt.Errorf("error exporting envelope: %w", err)
+		}
+
+		exported++
+
+		return nil
+	})
+
+	return exported, err
+}
@@ -0,0 +1,21 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lock only opens the lock file, there is no flock outside of unix systems, so the archive must not be appended
+// by two processes at once there.
+func lock(directory string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(directory, lockFile), os.O_CREATE|os.O_RDWR, fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("error locking archive: %w", err)
+	}
+
+	return file, nil
+}
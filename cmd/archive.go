@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ajgon/mailbowl/archive"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	archiveExportSince   string
+	archiveExportUntil   string
+	archiveExportAddress string
+	archiveExportOutput  string
+)
+
+// archiveCmd groups commands working with the tamper-evident message archive.
+var archiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Verify and export the message archive",
+}
+
+var archiveVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the hash chain of the whole archive",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		summary, err := archive.Verify(archiveDirectory())
+		if err != nil {
+			fmt.Printf("%d records verified before the chain broke\n", summary.Records)
+		}
+
+		cobra.CheckErr(err)
+
+		fmt.Printf("archive intact: %d records in %d segments, last hash %s\n",
+			summary.Records, summary.Segments, summary.Last)
+	},
+}
+
+var archiveExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Extract archived messages by date range or address",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		since, err := parseArchiveTime(archiveExportSince)
+		cobra.CheckErr(err)
+
+		until, err := parseArchiveTime(archiveExportUntil)
+		cobra.CheckErr(err)
+
+		exported, err := archive.Export(archiveDirectory(), archiveExportOutput, archive.Criteria{
+			Since: since, Until: until, Address: archiveExportAddress,
+		})
+		cobra.CheckErr(err)
+
+		fmt.Printf("%d messages exported to %s\n", exported, archiveExportOutput)
+	},
+}
+
+func archiveDirectory() string {
+	directory := config.Get().Archive.Directory
+	if directory == "" {
+		cobra.CheckErr(archive.ErrArchiveDisabled)
+	}
+
+	return directory
+}
+
+// parseArchiveTime accepts either a date (2006-01-02, UTC midnight) or a full RFC3339 timestamp.
+func parseArchiveTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if parsed, err := time.Parse("2006-01-02", value); err == nil {
+		return parsed, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time `%s`, expected 2006-01-02 or RFC3339: %w", value, err)
+	}
+
+	return parsed, nil
+}
+
+func init() {
+	archiveExportCmd.Flags().StringVar(&archiveExportSince, "since", "", "export messages archived at or after this time")
+	archiveExportCmd.Flags().StringVar(&archiveExportUntil, "until", "", "export messages archived before this time")
+	archiveExportCmd.Flags().StringVar(&archiveExportAddress, "address", "", "export messages from or to this address")
+	archiveExportCmd.Flags().StringVar(&archiveExportOutput, "output", "archive-export", "directory to export messages to")
+
+	archiveCmd.AddCommand(archiveVerifyCmd, archiveExportCmd)
+	rootCmd.AddCommand(archiveCmd)
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ajgon/mailbowl/acme"
+	"github.com/ajgon/mailbowl/archive"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/ajgon/mailbowl/journal"
+	"github.com/ajgon/mailbowl/listener"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/ajgon/mailbowl/quarantine"
+	"github.com/ajgon/mailbowl/relay"
+	"github.com/ajgon/mailbowl/watch"
+	"github.com/spf13/viper"
+)
+
+// listenerBuilder builds all listeners from the configuration, on start and on every reload. Filters are kept
+// between reloads when their configuration didn't change, so their state (like rate limits) survives. SMTP listen
+// sockets are kept as well, and handed over to the new listeners. Archive is kept as long as its directory stays
+// the same, so servers of the draining generation and the new one append to the same chain.
+type listenerBuilder struct {
+	filters    *filter.Chain
+	filterConf config.Filter
+	archive    *archive.Archive
+	sockets    *smtp.Sockets
+	reload     func()
+}
+
+func (b *listenerBuilder) build(conf config.Config) ([]listener.Listener, error) {
+	filters := b.filters
+
+	if filters == nil || !reflect.DeepEqual(b.filterConf, conf.Filter) {
+		var err error
+
+		if filters, err = filter.NewChain(conf.Filter); err != nil {
+			return nil, fmt.Errorf("problem configuring filters: %w", err)
+		}
+	}
+
+	store := b.archive
+
+	if store == nil || store.Directory != conf.Archive.Directory {
+		store = archive.NewArchive(conf.Archive)
+	}
+
+	httpServer, err := listener.NewHTTP(conf)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	listeners := []listener.Listener{httpServer}
+
+	// certificate manager has to be there first, as SMTP listeners watch its cache directory
+	if conf.ACME.Enabled {
+		manager, err := acme.NewManager(conf.ACME)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring acme: %w", err)
+		}
+
+		httpServer.ACME = manager.HTTPHandler()
+		listeners = append(listeners, acme.NewRenewal(manager))
+	}
+
+	if b.sockets == nil {
+		b.sockets = smtp.NewSockets()
+	}
+
+	smtpServer, err := smtp.NewSMTP(conf, conf.SMTP.ListenURIs(), filters, store, b.sockets)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	// quarantined messages are released through SMTP listeners, the way accepted messages are relayed
+	httpServer.Releaser = smtpServer
+	listeners = append(listeners, smtpServer)
+
+	if store := quarantine.NewStore(conf.Quarantine); store.Enabled() && store.Retention > 0 {
+		listeners = append(listeners, quarantine.NewExpiry(store))
+	}
+
+	if conf.Journal.Enabled {
+		relay, err := relay.NewRelay(conf.Relay)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring journal relay: %w", err)
+		}
+
+		listeners = append(listeners, journal.NewRetry(
+			journal.NewJournal(conf.Journal, conf.SMTP.Hostname, relay), conf.Journal.RetryInterval,
+		))
+	}
+
+	if conf.Watch.Enabled && b.reload != nil {
+		files := conf.WatchedFiles()
+
+		if configFile := viper.ConfigFileUsed(); configFile != "" {
+			files = append([]string{configFile}, files...)
+		}
+
+		listeners = append(listeners, watch.NewWatcher(files, conf.Watch.Debounce, b.reload))
+	}
+
+	b.apply(filters, store, conf)
+
+	return listeners, nil
+}
+
+// apply changes filters and archive used by running listeners. It's called once all listeners were built, so
+// a rejected reload leaves them as they were.
+func (b *listenerBuilder) apply(filters *filter.Chain, store *archive.Archive, conf config.Config) {
+	if filters == b.filters {
+		filters.Reload()
+	} else {
+		filters.Inherit(b.filters)
+	}
+
+	if store == b.archive {
+		store.Resize(conf.Archive.SegmentSize)
+	}
+
+	b.filters, b.filterConf, b.archive = filters, conf.Filter, store
+}
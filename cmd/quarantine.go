@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/ajgon/mailbowl/quarantine"
+	"github.com/spf13/cobra"
+)
+
+// quarantineCmd groups commands reviewing messages held in quarantine.
+var quarantineCmd = &cobra.Command{
+	Use:   "quarantine",
+	Short: "Review messages held in quarantine",
+}
+
+var quarantineListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List quarantined messages, oldest first",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := quarantineStore().List()
+		cobra.CheckErr(err)
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+		fmt.Fprintln(writer, "ID\tRECEIVED\tFROM\tTO\tFILTER\tREASON")
+
+		for _, entry := range entries {
+			fmt.Fprintf(
+				writer, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				entry.ID, entry.Received.Format(time.RFC3339), entry.Sender, strings.Join(entry.Recipients, ","),
+				entry.Filter, entry.Reason,
+			)
+		}
+
+		cobra.CheckErr(writer.Flush())
+	},
+}
+
+var quarantineShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show quarantined message together with the reason it was held",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		entry, data, err := quarantineStore().Get(args[0])
+		cobra.CheckErr(err)
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+		fmt.Fprintf(writer, "ID:\t%s\n", entry.ID)
+		fmt.Fprintf(writer, "Received:\t%s\n", entry.Received.Format(time.RFC3339))
+		fmt.Fprintf(writer, "From:\t%s\n", entry.Sender)
+		fmt.Fprintf(writer, "To:\t%s\n", strings.Join(entry.Recipients, ", "))
+		fmt.Fprintf(writer, "Remote IP:\t%s\n", entry.RemoteIP)
+		fmt.Fprintf(writer, "Username:\t%s\n", entry.Username)
+		fmt.Fprintf(writer, "Filter:\t%s\n", entry.Filter)
+		fmt.Fprintf(writer, "Rule:\t%s\n", entry.Rule)
+		fmt.Fprintf(writer, "Reason:\t%s\n", entry.Reason)
+		cobra.CheckErr(writer.Flush())
+
+		fmt.Printf("\n%s", data)
+	},
+}
+
+var quarantineReleaseCmd = &cobra.Command{
+	Use:   "release <id>...",
+	Short: "Relay quarantined messages to their original recipients and remove them from quarantine",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := config.Get()
+
+		// messages already passed the checks, only the footer is added on release
+		filters, err := filter.NewChain(config.Filter{Footer: conf.Filter.Footer})
+		cobra.CheckErr(err)
+
+		// listeners are not started, they are used to relay messages the same way they would
+		releaser, err := smtp.NewSMTP(conf, conf.SMTP.ListenURIs(), filters, nil, nil)
+		cobra.CheckErr(err)
+
+		store := quarantineStore()
+
+		for _, id := range args {
+			entry, err := store.Release(id, releaser)
+			cobra.CheckErr(err)
+
+			fmt.Printf("%s released to %s\n", id, strings.Join(entry.Recipients, ", "))
+		}
+	},
+}
+
+var quarantineDeleteCmd = &cobra.Command{
+	Use:   "delete <id>...",
+	Short: "Remove quarantined messages without relaying them",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store := quarantineStore()
+
+		for _, id := range args {
+			cobra.CheckErr(store.Delete(id))
+
+			fmt.Printf("%s deleted\n", id)
+		}
+	},
+}
+
+func quarantineStore() *quarantine.Store {
+	store := quarantine.NewStore(config.Get().Quarantine)
+	if !store.Enabled() {
+		cobra.CheckErr(quarantine.ErrQuarantineDisabled)
+	}
+
+	return store
+}
+
+func init() {
+	quarantineCmd.AddCommand(quarantineListCmd, quarantineShowCmd, quarantineReleaseCmd, quarantineDeleteCmd)
+	rootCmd.AddCommand(quarantineCmd)
+}
@@ -4,11 +4,8 @@ import (
 	"os"
 
 	"github.com/ajgon/mailbowl/config"
-	"github.com/ajgon/mailbowl/listener"
-	"github.com/ajgon/mailbowl/listener/smtp"
 	"github.com/ajgon/mailbowl/process"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var cfgFile string
@@ -26,13 +23,11 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
-		conf := config.Get()
-		httpServer := listener.NewHTTP()
-		smtpServer := smtp.NewSMTP(conf.SMTP, conf.Relay, viper.GetStringSlice("smtp.listen"))
+		builder := new(listenerBuilder)
+		manager := process.NewManager(builder.build)
+		builder.reload = manager.Reload
 
-		manager := process.NewManager()
-		manager.AddListener(httpServer)
-		manager.AddListener(smtpServer)
+		cobra.CheckErr(manager.Build(config.Get()))
 
 		manager.Start()
 	},
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+type (
+	ACMEChallenge   int
+	ACMEDNSProvider int
+)
+
+const (
+	ChallengeHTTP01 ACMEChallenge = iota
+	ChallengeDNS01
+)
+
+const (
+	DNSProviderExec ACMEDNSProvider = iota
+)
+
+var (
+	ErrInvalidACME            = errors.New("invalid acme configuration")
+	ErrInvalidACMEChallenge   = errors.New("invalid acme challenge")
+	ErrInvalidACMEDNSProvider = errors.New("invalid acme dns provider")
+)
+
+// ACMEDNS configures DNS-01 challenges. TXT records are created and removed by Provider, for exec it's done by
+// running Command with `present` or `cleanup`, record name and value as arguments. Challenge is accepted after
+// PropagationDelay, so the record can reach all authoritative servers.
+type ACMEDNS struct {
+	Provider         ACMEDNSProvider
+	Command          string
+	PropagationDelay time.Duration
+}
+
+// ACME configures certificates obtained from ACME CA at Directory, for all Domains. Certificate, its key and the
+// account key are kept in CacheDirectory, certificate is renewed RenewBefore its expiry. CACertificate is a PEM file
+// with additional root certificates, trusted when connecting to the CA (e.g. for step-ca or Pebble).
+type ACME struct {
+	Enabled        bool
+	Directory      string
+	Email          string
+	Domains        []string
+	CacheDirectory string
+	CACertificate  string
+	Challenge      ACMEChallenge
+	DNS            ACMEDNS
+	RenewBefore    time.Duration
+}
+
+// CertificateFile returns path of the obtained certificate (with its chain).
+func (a ACME) CertificateFile() string {
+	return filepath.Join(a.CacheDirectory, "certificate.pem")
+}
+
+// KeyFile returns path of the obtained certificate key.
+func (a ACME) KeyFile() string {
+	return filepath.Join(a.CacheDirectory, "key.pem")
+}
+
+// AccountKeyFile returns path of the ACME account key.
+func (a ACME) AccountKeyFile() string {
+	return filepath.Join(a.CacheDirectory, "account.key")
+}
+
+// ForTLS points TLS settings using ACME to the obtained certificate. When ACME is disabled, configured certificate is
+// used.
+func (a ACME) ForTLS(tls SMTPTLS) SMTPTLS {
+	if !tls.ACME {
+		return tls
+	}
+
+	if !a.Enabled {
+		tls.ACME = false
+
+		return tls
+	}
+
+	tls.Key, tls.Certificate = "", ""
+	tls.KeyFile, tls.CertificateFile = a.KeyFile(), a.CertificateFile()
+
+	return tls
+}
+
+//nolint:cyclop,funlen
+func ACMEHook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{}) (interface{}, error) {
+	var (
+		data                   map[string]interface{}
+		challenge, renewBefore string
+		ok                     bool
+		err                    error
+	)
+
+	if dataType.Kind() != reflect.Map {
+		return rawData, nil
+	}
+
+	if targetDataType != reflect.TypeOf(ACME{}) {
+		return rawData, nil
+	}
+
+	if data, ok = rawData.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	acme := ACME{}
+
+	if acme.Enabled, err = parseBool(data["enabled"]); err != nil {
+		return nil, err
+	}
+
+	if acme.Directory, ok = data["directory"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if acme.Email, ok = data["email"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if acme.Domains, err = parseStringList(data["domains"]); err != nil {
+		return nil, fmt.Errorf("invalid acme.domains: %w", err)
+	}
+
+	if acme.CacheDirectory, ok = data["cache_directory"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if acme.CACertificate, ok = data["ca_certificate"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if challenge, ok = data["challenge"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if acme.Challenge, err = buildACMEChallenge(challenge); err != nil {
+		return nil, fmt.Errorf("invalid acme.challenge: %w", err)
+	}
+
+	dns, err := buildACMEDNS(data["dns"])
+	if err != nil {
+		return nil, err
+	}
+
+	acme.DNS = *dns
+
+	if renewBefore, ok = data["renew_before"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if acme.RenewBefore, err = time.ParseDuration(renewBefore); err != nil {
+		return nil, fmt.Errorf("invalid acme.renew_before: `%s`: %w", renewBefore, err)
+	}
+
+	if !acme.Enabled {
+		return acme, nil
+	}
+
+	if err = validateACME(acme); err != nil {
+		return nil, err
+	}
+
+	return acme, nil
+}
+
+func validateACME(acme ACME) error {
+	if acme.Directory == "" {
+		return fmt.Errorf("%w: acme.directory is required", ErrInvalidACME)
+	}
+
+	if len(acme.Domains) == 0 {
+		return fmt.Errorf("%w: acme.domains are required", ErrInvalidACME)
+	}
+
+	// account key and certificates are never kept in memory only, so they are not requested again on restart
+	if acme.CacheDirectory == "" {
+		return fmt.Errorf("%w: acme.cache_directory is required", ErrInvalidACME)
+	}
+
+	if acme.Challenge == ChallengeDNS01 && acme.DNS.Provider == DNSProviderExec && acme.DNS.Command == "" {
+		return fmt.Errorf("%w: acme.dns.command is required for exec provider", ErrInvalidACME)
+	}
+
+	return nil
+}
+
+func buildACMEDNS(dnsInterface interface{}) (*ACMEDNS, error) {
+	var (
+		dns             map[string]interface{}
+		provider, delay string
+		ok              bool
+		err             error
+	)
+
+	if dns, ok = dnsInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	acmeDNS := &ACMEDNS{}
+
+	if provider, ok = dns["provider"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if acmeDNS.Provider, err = buildACMEDNSProvider(provider); err != nil {
+		return nil, fmt.Errorf("invalid acme.dns.provider: %w", err)
+	}
+
+	if acmeDNS.Command, ok = dns["command"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if delay, ok = dns["propagation_delay"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if acmeDNS.PropagationDelay, err = time.ParseDuration(delay); err != nil {
+		return nil, fmt.Errorf("invalid acme.dns.propagation_delay: `%s`: %w", delay, err)
+	}
+
+	return acmeDNS, nil
+}
+
+func buildACMEChallenge(challenge string) (ACMEChallenge, error) {
+	switch challenge {
+	case "http-01":
+		return ChallengeHTTP01, nil
+	case "dns-01":
+		return ChallengeDNS01, nil
+	}
+
+	return -1, fmt.Errorf("%w: `%s`", ErrInvalidACMEChallenge, challenge)
+}
+
+func buildACMEDNSProvider(provider string) (ACMEDNSProvider, error) {
+	if provider == "exec" {
+		return DNSProviderExec, nil
+	}
+
+	return -1, fmt.Errorf("%w: `%s`", ErrInvalidACMEDNSProvider, provider)
+}
@@ -0,0 +1,128 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidACMEMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+acme:
+  enabled: true
+  directory: https://ca.example.local/acme/acme/directory
+  email: postmaster@example.com
+  domains:
+    - mail.example.com
+    - smtp.example.com
+  cache_directory: /var/lib/mailbowl/acme
+  ca_certificate: /etc/ssl/step-ca.pem
+  challenge: dns-01
+  dns:
+    provider: exec
+    command: /usr/local/bin/update-dns
+    propagation_delay: 1m
+  renew_before: 240h
+smtp:
+  tls:
+    acme: true
+`
+
+	conf, err := InitConfig(viper.New(), yamlExample)
+	assert.NoError(t, err)
+
+	assert.True(t, conf.ACME.Enabled)
+	assert.Equal(t, "https://ca.example.local/acme/acme/directory", conf.ACME.Directory)
+	assert.Equal(t, "postmaster@example.com", conf.ACME.Email)
+	assert.Equal(t, []string{"mail.example.com", "smtp.example.com"}, conf.ACME.Domains)
+	assert.Equal(t, "/var/lib/mailbowl/acme", conf.ACME.CacheDirectory)
+	assert.Equal(t, "/etc/ssl/step-ca.pem", conf.ACME.CACertificate)
+	assert.Equal(t, config.ChallengeDNS01, conf.ACME.Challenge)
+	assert.Equal(t, config.DNSProviderExec, conf.ACME.DNS.Provider)
+	assert.Equal(t, "/usr/local/bin/update-dns", conf.ACME.DNS.Command)
+	assert.Equal(t, time.Minute, conf.ACME.DNS.PropagationDelay)
+	assert.Equal(t, 240*time.Hour, conf.ACME.RenewBefore)
+	assert.True(t, conf.SMTP.TLS.ACME)
+
+	assert.Equal(t, "/var/lib/mailbowl/acme/certificate.pem", conf.ACME.CertificateFile())
+	assert.Equal(t, "/var/lib/mailbowl/acme/key.pem", conf.ACME.KeyFile())
+	assert.Equal(t, "/var/lib/mailbowl/acme/account.key", conf.ACME.AccountKeyFile())
+}
+
+func TestValidACMEMarshalFromENV(t *testing.T) {
+	t.Setenv("ACME_ENABLED", "1")
+	t.Setenv("ACME_DOMAINS", "mail.example.com smtp.example.com")
+	t.Setenv("ACME_CACHE_DIRECTORY", "/tmp/acme")
+	t.Setenv("ACME_RENEW_BEFORE", "48h")
+	t.Setenv("SMTP_TLS_ACME", "true")
+
+	conf, err := InitConfig(viper.New())
+	assert.NoError(t, err)
+
+	assert.True(t, conf.ACME.Enabled)
+	assert.Equal(t, []string{"mail.example.com", "smtp.example.com"}, conf.ACME.Domains)
+	assert.Equal(t, "/tmp/acme", conf.ACME.CacheDirectory)
+	assert.Equal(t, config.ChallengeHTTP01, conf.ACME.Challenge)
+	assert.Equal(t, 48*time.Hour, conf.ACME.RenewBefore)
+	assert.True(t, conf.SMTP.TLS.ACME)
+}
+
+func TestInvalidACMEChallenge(t *testing.T) {
+	t.Parallel()
+
+	_, err := InitConfig(viper.New(), "---\nacme:\n  challenge: tls-alpn-01\n")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid acme.challenge: invalid acme challenge: `tls-alpn-01`")
+}
+
+func TestInvalidACMEDNSProvider(t *testing.T) {
+	t.Parallel()
+
+	_, err := InitConfig(viper.New(), "---\nacme:\n  dns:\n    provider: route53\n")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid acme.dns.provider: invalid acme dns provider: `route53`")
+}
+
+func TestInvalidACME(t *testing.T) {
+	t.Parallel()
+
+	for yamlExample, message := range map[string]string{
+		"---\nacme:\n  enabled: true\n  cache_directory: /tmp/acme\n":                     "acme.domains are required",
+		"---\nacme:\n  enabled: true\n  domains: [mail.example.com]\n":                    "acme.cache_directory is required",
+		"---\nacme:\n  enabled: true\n  domains: [mail.example.com]\n  directory: \"\"\n": "acme.directory is required",
+		"---\nacme:\n  enabled: true\n  domains: [mail.example.com]\n  cache_directory: /tmp/acme\n" +
+			"  challenge: dns-01\n": "acme.dns.command is required for exec provider",
+	} {
+		_, err := InitConfig(viper.New(), yamlExample)
+		assert.EqualError(
+			t, err, "error unmarshaling config: 1 error(s) decoding:\n\n* error decoding 'ACME': "+
+				"invalid acme configuration: "+message,
+		)
+	}
+}
+
+func TestACMEForTLS(t *testing.T) {
+	t.Parallel()
+
+	acme := config.ACME{Enabled: true, CacheDirectory: "/var/lib/mailbowl/acme"}
+	tls := config.SMTPTLS{Key: "key", Certificate: "certificate", KeyFile: "key.pem", CertificateFile: "cert.pem"}
+
+	assert.Equal(t, tls, acme.ForTLS(tls))
+
+	tls.ACME = true
+	assert.Equal(t, config.SMTPTLS{
+		KeyFile:         "/var/lib/mailbowl/acme/key.pem",
+		CertificateFile: "/var/lib/mailbowl/acme/certificate.pem",
+		ACME:            true,
+	}, acme.ForTLS(tls))
+
+	acme.Enabled = false
+	assert.Equal(t, config.SMTPTLS{
+		Key: "key", Certificate: "certificate", KeyFile: "key.pem", CertificateFile: "cert.pem",
+	}, acme.ForTLS(tls))
+}
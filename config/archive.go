@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Archive configures tamper-evident archive of relayed messages, kept in Directory as append-only segment files,
+// of at most SegmentSize bytes each. Empty Directory disables the archive.
+type Archive struct {
+	Directory   string
+	SegmentSize int
+}
+
+func ArchiveHook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{}) (interface{}, error) {
+	var (
+		data map[string]interface{}
+		ok   bool
+		err  error
+	)
+
+	if dataType.Kind() != reflect.Map {
+		return rawData, nil
+	}
+
+	if targetDataType != reflect.TypeOf(Archive{}) {
+		return rawData, nil
+	}
+
+	if data, ok = rawData.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	archive := Archive{}
+
+	if archive.Directory, ok = data["directory"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if archive.SegmentSize, err = parseInt(data["segment_size"]); err != nil {
+		return nil, fmt.Errorf("invalid archive.segment_size: %w", err)
+	}
+
+	return archive, nil
+}
@@ -0,0 +1,43 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidArchiveMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+archive:
+  directory: /var/lib/mailbowl/archive
+  segment_size: 1048576
+`
+
+	conf, err := InitConfig(viper.New(), yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/var/lib/mailbowl/archive", conf.Archive.Directory)
+	assert.Equal(t, 1048576, conf.Archive.SegmentSize)
+}
+
+func TestValidArchiveMarshalFromENV(t *testing.T) {
+	t.Setenv("ARCHIVE_DIRECTORY", "/tmp/archive")
+	t.Setenv("ARCHIVE_SEGMENT_SIZE", "4096")
+
+	conf, err := InitConfig(viper.New())
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/tmp/archive", conf.Archive.Directory)
+	assert.Equal(t, 4096, conf.Archive.SegmentSize)
+}
+
+func TestInvalidArchiveSegmentSize(t *testing.T) {
+	t.Parallel()
+
+	_, err := InitConfig(viper.New(), "---\narchive:\n  segment_size: big\n")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid archive.segment_size")
+}
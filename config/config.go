@@ -11,9 +11,17 @@ var (
 )
 
 type Config struct {
-	Log   Log
-	Relay Relay
-	SMTP  SMTP
+	ACME       ACME
+	Archive    Archive
+	Filter     Filter
+	HTTP       HTTP
+	Journal    Journal
+	Log        Log
+	Milter     Milter
+	Quarantine Quarantine
+	Relay      Relay
+	SMTP       SMTP
+	Watch      Watch
 }
 
 func Get() Config {
@@ -25,10 +33,38 @@ func Hook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{
 		return rawData, nil
 	}
 
+	if targetDataType == reflect.TypeOf(ACME{}) {
+		return ACMEHook(dataType, targetDataType, rawData)
+	}
+
+	if targetDataType == reflect.TypeOf(Archive{}) {
+		return ArchiveHook(dataType, targetDataType, rawData)
+	}
+
+	if targetDataType == reflect.TypeOf(Filter{}) {
+		return FilterHook(dataType, targetDataType, rawData)
+	}
+
+	if targetDataType == reflect.TypeOf(HTTP{}) {
+		return HTTPHook(dataType, targetDataType, rawData)
+	}
+
+	if targetDataType == reflect.TypeOf(Journal{}) {
+		return JournalHook(dataType, targetDataType, rawData)
+	}
+
 	if targetDataType == reflect.TypeOf(Log{}) {
 		return LogHook(dataType, targetDataType, rawData)
 	}
 
+	if targetDataType == reflect.TypeOf(Milter{}) {
+		return MilterHook(dataType, targetDataType, rawData)
+	}
+
+	if targetDataType == reflect.TypeOf(Quarantine{}) {
+		return QuarantineHook(dataType, targetDataType, rawData)
+	}
+
 	if targetDataType == reflect.TypeOf(Relay{}) {
 		return RelayHook(dataType, targetDataType, rawData)
 	}
@@ -37,6 +73,10 @@ func Hook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{
 		return SMTPHook(dataType, targetDataType, rawData)
 	}
 
+	if targetDataType == reflect.TypeOf(Watch{}) {
+		return WatchHook(dataType, targetDataType, rawData)
+	}
+
 	return rawData, nil
 }
 
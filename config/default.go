@@ -10,35 +10,119 @@ const (
 
 //nolint:gochecknoglobals
 var defaults = map[string]interface{}{
-	"log.color":                             false,
-	"log.format":                            "console",
-	"log.level":                             "warn",
-	"log.stacktrace_level":                  "error",
-	"relay.outgoing_server.address":         "",
-	"relay.outgoing_server.auth_method":     "plain",
-	"relay.outgoing_server.connection_type": "tls",
-	"relay.outgoing_server.from_email":      "",
-	"relay.outgoing_server.host":            "",
-	"relay.outgoing_server.password":        "",
-	"relay.outgoing_server.port":            0,
-	"relay.outgoing_server.username":        "",
-	"relay.outgoing_server.verify_tls":      true,
-	"smtp.auth.enabled":                     false,
-	"smtp.auth.users":                       []interface{}{},
-	"smtp.hostname":                         "",
-	"smtp.limit.connections":                defaultConnectionsLimit,
-	"smtp.limit.message_size":               defaultMessageSizeInBytes,
-	"smtp.limit.recipients":                 defaultRecipientsLimit,
-	"smtp.listen":                           []string{},
-	"smtp.timeout.read":                     "60s",
-	"smtp.timeout.write":                    "60s",
-	"smtp.timeout.data":                     "5m",
-	"smtp.tls.key":                          "",
-	"smtp.tls.certificate":                  "",
-	"smtp.tls.key_file":                     "",
-	"smtp.tls.certificate_file":             "",
-	"smtp.tls.force_for_starttls":           true,
-	"smtp.whitelist":                        []string{},
+	"acme.ca_certificate":                         "",
+	"acme.cache_directory":                        "",
+	"acme.challenge":                              "http-01",
+	"acme.directory":                              "https://acme-v02.api.letsencrypt.org/directory",
+	"acme.dns.command":                            "",
+	"acme.dns.propagation_delay":                  "30s",
+	"acme.dns.provider":                           "exec",
+	"acme.domains":                                []string{},
+	"acme.email":                                  "",
+	"acme.enabled":                                false,
+	"acme.renew_before":                           "720h",
+	"archive.directory":                           "",
+	"archive.segment_size":                        67108864,
+	"filter.attachment.action":                    "reject",
+	"filter.attachment.blocked_content_types":     []string{},
+	"filter.attachment.blocked_extensions":        []string{},
+	"filter.attachment.enabled":                   false,
+	"filter.attachment.inspect_archives":          true,
+	"filter.attachment.max_size":                  0,
+	"filter.attachment.max_total_size":            0,
+	"filter.clamd.action":                         "reject",
+	"filter.clamd.address":                        "",
+	"filter.clamd.enabled":                        false,
+	"filter.clamd.fail_open":                      false,
+	"filter.clamd.timeout":                        "30s",
+	"filter.dedupe.enabled":                       false,
+	"filter.dedupe.max_entries":                   100000,
+	"filter.dedupe.state_file":                    "",
+	"filter.dedupe.window":                        "24h",
+	"filter.footer.enabled":                       false,
+	"filter.footer.rules":                         []interface{}{},
+	"filter.policy.enabled":                       false,
+	"filter.policy.rules":                         []interface{}{},
+	"filter.policy.rules_file":                    "",
+	"filter.spam.address":                         "",
+	"filter.spam.enabled":                         false,
+	"filter.spam.engine":                          "spamd",
+	"filter.spam.fail_open":                       true,
+	"filter.spam.header_score":                    5.0,
+	"filter.spam.quarantine_score":                0.0,
+	"filter.spam.reject_score":                    15.0,
+	"filter.spam.timeout":                         "30s",
+	"http.admin_token":                            "",
+	"journal.address":                             "",
+	"journal.directory":                           "",
+	"journal.enabled":                             false,
+	"journal.retry_interval":                      "5m",
+	"journal.sender":                              "",
+	"journal.spool_directory":                     "",
+	"log.color":                                   false,
+	"log.format":                                  "console",
+	"log.level":                                   "warn",
+	"log.stacktrace_level":                        "error",
+	"milter.fail_open":                            false,
+	"milter.servers":                              []interface{}{},
+	"milter.timeout":                              "10s",
+	"quarantine.directory":                        "",
+	"quarantine.retention":                        "720h",
+	"relay.encryption.domains":                    []interface{}{},
+	"relay.encryption.keys":                       []interface{}{},
+	"relay.encryption.policy":                     "never",
+	"relay.outgoing_server.address":               "",
+	"relay.outgoing_server.auth_method":           "plain",
+	"relay.outgoing_server.connection_type":       "tls",
+	"relay.outgoing_server.from_email":            "",
+	"relay.outgoing_server.host":                  "",
+	"relay.outgoing_server.password":              "",
+	"relay.outgoing_server.port":                  0,
+	"relay.outgoing_server.tls_policy.dane":       false,
+	"relay.outgoing_server.tls_policy.dns_server": "",
+	"relay.outgoing_server.tls_policy.mta_sts":    false,
+	"relay.outgoing_server.tls_policy.timeout":    "10s",
+	"relay.outgoing_server.username":              "",
+	"relay.outgoing_server.verify_tls":            true,
+	"relay.routes":                                []interface{}{},
+	"smtp.auth.enabled":                           false,
+	"smtp.auth.users":                             []interface{}{},
+	"smtp.hostname":                               "",
+	"smtp.limit.connections":                      defaultConnectionsLimit,
+	"smtp.limit.message_size":                     defaultMessageSizeInBytes,
+	"smtp.limit.recipients":                       defaultRecipientsLimit,
+	"smtp.listen":                                 []string{},
+	"smtp.normalize.add_date":                     true,
+	"smtp.normalize.add_message_id":               true,
+	"smtp.normalize.fix_line_endings":             true,
+	"smtp.normalize.fold_headers":                 true,
+	"smtp.normalize.reject_malformed":             true,
+	"smtp.privacy.received":                       "full",
+	"smtp.privacy.remove_headers":                 []string{},
+	"smtp.privacy.strip_received":                 []string{},
+	"smtp.proxy.header_timeout":                   "5s",
+	"smtp.proxy.mode":                             "none",
+	"smtp.proxy.trusted_proxies":                  []string{},
+	"smtp.smtputf8":                               true,
+	"smtp.timeout.read":                           "60s",
+	"smtp.timeout.write":                          "60s",
+	"smtp.timeout.data":                           "5m",
+	"smtp.timeout.drain":                          "30s",
+	"smtp.tls.key":                                "",
+	"smtp.tls.certificate":                        "",
+	"smtp.tls.key_file":                           "",
+	"smtp.tls.certificate_file":                   "",
+	"smtp.tls.acme":                               false,
+	"smtp.tls.force_for_starttls":                 true,
+	"smtp.unix.allowed_gids":                      []string{},
+	"smtp.unix.allowed_uids":                      []string{},
+	"smtp.unix.group":                             "",
+	"smtp.unix.mode":                              "0660",
+	"smtp.unix.owner":                             "",
+	"smtp.whitelist":                              []string{},
+	"watch.debounce":                              "2s",
+	"watch.enabled":                               false,
+	"watch.files":                                 []string{},
 }
 
 func SetDefaults(force bool) {
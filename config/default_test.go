@@ -1,6 +1,7 @@
 package config_test
 
 import (
+	"os"
 	"testing"
 	"time"
 
@@ -17,10 +18,67 @@ func TestDefaults(t *testing.T) {
 	conf, err := InitConfig(viperConfig)
 	assert.NoError(t, err)
 
+	assert.False(t, conf.ACME.Enabled)
+	assert.Equal(t, "https://acme-v02.api.letsencrypt.org/directory", conf.ACME.Directory)
+	assert.Equal(t, "", conf.ACME.Email)
+	assert.Equal(t, []string{}, conf.ACME.Domains)
+	assert.Equal(t, "", conf.ACME.CacheDirectory)
+	assert.Equal(t, "", conf.ACME.CACertificate)
+	assert.Equal(t, config.ChallengeHTTP01, conf.ACME.Challenge)
+	assert.Equal(t, config.DNSProviderExec, conf.ACME.DNS.Provider)
+	assert.Equal(t, "", conf.ACME.DNS.Command)
+	assert.Equal(t, 30*time.Second, conf.ACME.DNS.PropagationDelay)
+	assert.Equal(t, 720*time.Hour, conf.ACME.RenewBefore)
+	assert.Equal(t, "", conf.Archive.Directory)
+	assert.Equal(t, 67108864, conf.Archive.SegmentSize)
+
+	assert.False(t, conf.Filter.Attachment.Enabled)
+	assert.Equal(t, config.FilterReject, conf.Filter.Attachment.Action)
+	assert.Equal(t, []string{}, conf.Filter.Attachment.BlockedContentTypes)
+	assert.Equal(t, []string{}, conf.Filter.Attachment.BlockedExtensions)
+	assert.True(t, conf.Filter.Attachment.InspectArchives)
+	assert.Equal(t, 0, conf.Filter.Attachment.MaxSize)
+	assert.Equal(t, 0, conf.Filter.Attachment.MaxTotalSize)
+	assert.False(t, conf.Filter.Clamd.Enabled)
+	assert.Equal(t, config.FilterReject, conf.Filter.Clamd.Action)
+	assert.Equal(t, "", conf.Filter.Clamd.Address)
+	assert.False(t, conf.Filter.Clamd.FailOpen)
+	assert.Equal(t, 30*time.Second, conf.Filter.Clamd.Timeout)
+	assert.False(t, conf.Filter.Dedupe.Enabled)
+	assert.Equal(t, 24*time.Hour, conf.Filter.Dedupe.Window)
+	assert.Equal(t, 100000, conf.Filter.Dedupe.MaxEntries)
+	assert.Equal(t, "", conf.Filter.Dedupe.StateFile)
+	assert.False(t, conf.Filter.Footer.Enabled)
+	assert.Equal(t, []config.FilterFooterRule{}, conf.Filter.Footer.Rules)
+	assert.False(t, conf.Filter.Policy.Enabled)
+	assert.Equal(t, "", conf.Filter.Policy.RulesFile)
+	assert.Equal(t, []config.FilterPolicyRule{}, conf.Filter.Policy.Rules)
+	assert.False(t, conf.Filter.Spam.Enabled)
+	assert.Equal(t, config.SpamEngineSpamd, conf.Filter.Spam.Engine)
+	assert.Equal(t, "tcp", conf.Filter.Spam.Network)
+	assert.Equal(t, "127.0.0.1:783", conf.Filter.Spam.Address)
+	assert.True(t, conf.Filter.Spam.FailOpen)
+	assert.Equal(t, 30*time.Second, conf.Filter.Spam.Timeout)
+	assert.Equal(t, 5.0, conf.Filter.Spam.HeaderScore)
+	assert.Equal(t, 0.0, conf.Filter.Spam.QuarantineScore)
+	assert.Equal(t, 15.0, conf.Filter.Spam.RejectScore)
 	assert.False(t, conf.Log.Color)
 	assert.Equal(t, config.Console, conf.Log.Format)
 	assert.Equal(t, zapcore.WarnLevel, conf.Log.Level)
 	assert.Equal(t, zapcore.ErrorLevel, conf.Log.StacktraceLevel)
+	assert.Equal(t, []config.MilterServer{}, conf.Milter.Servers)
+	assert.Equal(t, "", conf.HTTP.AdminToken)
+	assert.False(t, conf.Journal.Enabled)
+	assert.Equal(t, "", conf.Journal.Address)
+	assert.Equal(t, "", conf.Journal.Sender)
+	assert.Equal(t, "", conf.Journal.Directory)
+	assert.Equal(t, "", conf.Journal.SpoolDirectory)
+	assert.Equal(t, 5*time.Minute, conf.Journal.RetryInterval)
+	assert.Equal(t, "", conf.Quarantine.Directory)
+	assert.Equal(t, 720*time.Hour, conf.Quarantine.Retention)
+	assert.Equal(t, config.EncryptionNever, conf.Relay.Encryption.Policy)
+	assert.Equal(t, []config.RelayEncryptionDomain{}, conf.Relay.Encryption.Domains)
+	assert.Equal(t, []config.RelayEncryptionKey{}, conf.Relay.Encryption.Keys)
 	assert.Equal(t, "", conf.Relay.OutgoingServer.Host)
 	assert.Equal(t, 0, conf.Relay.OutgoingServer.Port)
 	assert.Equal(t, config.ConnectionTLS, conf.Relay.OutgoingServer.ConnectionType)
@@ -28,7 +86,12 @@ func TestDefaults(t *testing.T) {
 	assert.Equal(t, "", conf.Relay.OutgoingServer.FromEmail)
 	assert.Equal(t, "", conf.Relay.OutgoingServer.Password)
 	assert.Equal(t, "", conf.Relay.OutgoingServer.Username)
+	assert.Equal(t, map[string]config.RelayOutgoingServer{}, conf.Relay.Routes)
 	assert.True(t, conf.Relay.OutgoingServer.VerifyTLS)
+	assert.False(t, conf.Relay.OutgoingServer.TLSPolicy.MTASTS)
+	assert.False(t, conf.Relay.OutgoingServer.TLSPolicy.DANE)
+	assert.Equal(t, "", conf.Relay.OutgoingServer.TLSPolicy.DNSServer)
+	assert.Equal(t, 10*time.Second, conf.Relay.OutgoingServer.TLSPolicy.Timeout)
 	assert.False(t, conf.SMTP.Auth.Enabled)
 	assert.Equal(t, []config.SMTPAuthUser{}, conf.SMTP.Auth.Users)
 	assert.Equal(t, "", conf.SMTP.Hostname)
@@ -36,13 +99,35 @@ func TestDefaults(t *testing.T) {
 	assert.Equal(t, 26214400, conf.SMTP.Limit.MessageSize)
 	assert.Equal(t, 100, conf.SMTP.Limit.Recipients)
 	assert.Equal(t, []config.SMTPListen{}, conf.SMTP.Listen)
+	assert.True(t, conf.SMTP.Normalize.AddDate)
+	assert.True(t, conf.SMTP.Normalize.AddMessageID)
+	assert.True(t, conf.SMTP.Normalize.FixLineEndings)
+	assert.True(t, conf.SMTP.Normalize.FoldHeaders)
+	assert.True(t, conf.SMTP.Normalize.RejectMalformed)
+	assert.Equal(t, config.ReceivedFull, conf.SMTP.Privacy.Received)
+	assert.Equal(t, []string{}, conf.SMTP.Privacy.StripReceived)
+	assert.Equal(t, []string{}, conf.SMTP.Privacy.RemoveHeaders)
+	assert.Equal(t, config.ProxyNone, conf.SMTP.Proxy.Mode)
+	assert.Equal(t, []string{}, conf.SMTP.Proxy.TrustedProxies)
+	assert.Equal(t, 5*time.Second, conf.SMTP.Proxy.HeaderTimeout)
+	assert.True(t, conf.SMTP.SMTPUTF8)
 	assert.Equal(t, 60*time.Second, conf.SMTP.Timeout.Read)
 	assert.Equal(t, 60*time.Second, conf.SMTP.Timeout.Write)
 	assert.Equal(t, 5*time.Minute, conf.SMTP.Timeout.Data)
+	assert.Equal(t, 30*time.Second, conf.SMTP.Timeout.Drain)
 	assert.Equal(t, "", conf.SMTP.TLS.Key)
 	assert.Equal(t, "", conf.SMTP.TLS.Certificate)
 	assert.Equal(t, "", conf.SMTP.TLS.KeyFile)
 	assert.Equal(t, "", conf.SMTP.TLS.CertificateFile)
+	assert.False(t, conf.SMTP.TLS.ACME)
 	assert.True(t, conf.SMTP.TLS.ForceForStartTLS)
+	assert.Equal(t, os.FileMode(0o660), conf.SMTP.Unix.Mode)
+	assert.Equal(t, "", conf.SMTP.Unix.Owner)
+	assert.Equal(t, "", conf.SMTP.Unix.Group)
+	assert.Equal(t, []int{}, conf.SMTP.Unix.AllowedUIDs)
+	assert.Equal(t, []int{}, conf.SMTP.Unix.AllowedGIDs)
 	assert.Equal(t, []string{}, conf.SMTP.Whitelist)
+	assert.False(t, conf.Watch.Enabled)
+	assert.Equal(t, 2*time.Second, conf.Watch.Debounce)
+	assert.Equal(t, []string{}, conf.Watch.Files)
 }
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type (
+	RelayEncryptionPolicy  int
+	RelayEncryptionKeyType int
+)
+
+const (
+	EncryptionNever RelayEncryptionPolicy = iota
+	EncryptionOpportunistic
+	EncryptionRequired
+)
+
+const (
+	KeyPGP RelayEncryptionKeyType = iota
+	KeySMIME
+)
+
+var (
+	ErrInvalidEncryptionPolicy = errors.New("invalid encryption policy")
+	ErrInvalidEncryptionKey    = errors.New("invalid encryption key")
+)
+
+type RelayEncryptionDomain struct {
+	Domain string
+	Policy RelayEncryptionPolicy
+}
+
+type RelayEncryptionKey struct {
+	Email   string
+	Type    RelayEncryptionKeyType
+	Key     string
+	KeyFile string
+}
+
+type RelayEncryption struct {
+	Policy  RelayEncryptionPolicy
+	Domains []RelayEncryptionDomain
+	Keys    []RelayEncryptionKey
+}
+
+func buildRelayEncryption(encryptionInterface interface{}) (*RelayEncryption, error) {
+	var (
+		encryption map[string]interface{}
+		policy     string
+		ok         bool
+		err        error
+	)
+
+	if encryption, ok = encryptionInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if policy, ok = encryption["policy"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	relayEncryption := &RelayEncryption{}
+
+	relayEncryption.Policy, err = buildEncryptionPolicy(policy)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	switch domainsItem := encryption["domains"].(type) {
+	case []interface{}:
+		relayEncryption.Domains, err = buildEncryptionDomainsFromInterface(domainsItem)
+	case string:
+		relayEncryption.Domains, err = buildEncryptionDomainsFromString(domainsItem)
+	default:
+		return nil, ErrUnserializing
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error parsing relay.encryption.domains: %w", err)
+	}
+
+	switch keysItem := encryption["keys"].(type) {
+	case []interface{}:
+		relayEncryption.Keys, err = buildEncryptionKeysFromInterface(keysItem)
+	case string:
+		relayEncryption.Keys, err = buildEncryptionKeysFromString(keysItem)
+	default:
+		return nil, ErrUnserializing
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error parsing relay.encryption.keys: %w", err)
+	}
+
+	return relayEncryption, nil
+}
+
+func buildEncryptionDomainsFromInterface(domainsInterface []interface{}) ([]RelayEncryptionDomain, error) {
+	encryptionDomains := make([]RelayEncryptionDomain, 0)
+
+	for _, domainInterface := range domainsInterface {
+		var (
+			domainName, policyName string
+			domain                 map[interface{}]interface{}
+			ok                     bool
+		)
+
+		if domain, ok = domainInterface.(map[interface{}]interface{}); !ok {
+			continue
+		}
+
+		if domainName, ok = domain["domain"].(string); !ok {
+			return nil, ErrUnserializing
+		}
+
+		if policyName, ok = domain["policy"].(string); !ok {
+			return nil, ErrUnserializing
+		}
+
+		policy, err := buildEncryptionPolicy(policyName)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		encryptionDomains = append(encryptionDomains, RelayEncryptionDomain{
+			Domain: strings.ToLower(domainName), Policy: policy,
+		})
+	}
+
+	return encryptionDomains, nil
+}
+
+// buildEncryptionDomainsFromString parses space separated list of `domain:policy` pairs.
+func buildEncryptionDomainsFromString(domainsString string) ([]RelayEncryptionDomain, error) {
+	encryptionDomains := make([]RelayEncryptionDomain, 0)
+
+	for _, domain := range strings.Fields(domainsString) {
+		lastIndex := strings.LastIndex(domain, ":")
+		if lastIndex < 0 {
+			return nil, fmt.Errorf("%w: `%s`", ErrInvalidEncryptionPolicy, domain)
+		}
+
+		policy, err := buildEncryptionPolicy(domain[lastIndex+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		encryptionDomains = append(encryptionDomains, RelayEncryptionDomain{
+			Domain: strings.ToLower(domain[:lastIndex]), Policy: policy,
+		})
+	}
+
+	return encryptionDomains, nil
+}
+
+func buildEncryptionKeysFromInterface(keysInterface []interface{}) ([]RelayEncryptionKey, error) {
+	encryptionKeys := make([]RelayEncryptionKey, 0)
+
+	for _, keyInterface := range keysInterface {
+		var (
+			key                 map[interface{}]interface{}
+			email, keyType      string
+			keyContent, keyFile string
+			ok                  bool
+		)
+
+		if key, ok = keyInterface.(map[interface{}]interface{}); !ok {
+			continue
+		}
+
+		if email, ok = key["email"].(string); !ok {
+			return nil, ErrUnserializing
+		}
+
+		if keyType, ok = key["type"].(string); !ok {
+			return nil, ErrUnserializing
+		}
+
+		// key and key_file are optional, but one of them has to be present
+		keyContent, _ = key["key"].(string)
+		keyFile, _ = key["key_file"].(string)
+
+		encryptionKey, err := buildEncryptionKey(email, keyType, keyContent, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		encryptionKeys = append(encryptionKeys, *encryptionKey)
+	}
+
+	return encryptionKeys, nil
+}
+
+// buildEncryptionKeysFromString parses space separated list of `email:type:key_file` entries.
+func buildEncryptionKeysFromString(keysString string) ([]RelayEncryptionKey, error) {
+	encryptionKeys := make([]RelayEncryptionKey, 0)
+
+	for _, key := range strings.Fields(keysString) {
+		fields := strings.SplitN(key, ":", 3) //nolint:gomnd
+		if len(fields) != 3 {                 //nolint:gomnd
+			return nil, fmt.Errorf("%w: `%s`", ErrInvalidEncryptionKey, key)
+		}
+
+		encryptionKey, err := buildEncryptionKey(fields[0], fields[1], "", fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		encryptionKeys = append(encryptionKeys, *encryptionKey)
+	}
+
+	return encryptionKeys, nil
+}
+
+func buildEncryptionKey(email, keyType, key, keyFile string) (*RelayEncryptionKey, error) {
+	encryptionKey := &RelayEncryptionKey{Email: strings.ToLower(email), Key: key, KeyFile: keyFile}
+
+	switch keyType {
+	case "pgp":
+		encryptionKey.Type = KeyPGP
+	case "smime":
+		encryptionKey.Type = KeySMIME
+	default:
+		return nil, fmt.Errorf("%w: unknown type `%s` for `%s`", ErrInvalidEncryptionKey, keyType, email)
+	}
+
+	if email == "" || (key == "" && keyFile == "") {
+		return nil, fmt.Errorf("%w: email and key or key_file are required", ErrInvalidEncryptionKey)
+	}
+
+	return encryptionKey, nil
+}
+
+func buildEncryptionPolicy(policy string) (RelayEncryptionPolicy, error) {
+	switch policy {
+	case "never":
+		return EncryptionNever, nil
+	case "opportunistic":
+		return EncryptionOpportunistic, nil
+	case "required":
+		return EncryptionRequired, nil
+	}
+
+	return -1, fmt.Errorf("%w: `%s`", ErrInvalidEncryptionPolicy, policy)
+}
@@ -0,0 +1,114 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidEncryptionMarshalFromObjects(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("relay.encryption.policy", "opportunistic")
+	viperConfig.Set("relay.encryption.domains", []interface{}{
+		map[interface{}]interface{}{"domain": "Partner.local", "policy": "required"},
+	})
+	viperConfig.Set("relay.encryption.keys", []interface{}{
+		map[interface{}]interface{}{"email": "Alice@partner.local", "type": "pgp", "key": "armored key"},
+		map[interface{}]interface{}{"email": "bob@partner.local", "type": "smime", "key_file": "/etc/bob.pem"},
+	})
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.EncryptionOpportunistic, conf.Relay.Encryption.Policy)
+	assert.Equal(t, []config.RelayEncryptionDomain{
+		{Domain: "partner.local", Policy: config.EncryptionRequired},
+	}, conf.Relay.Encryption.Domains)
+	assert.Equal(t, []config.RelayEncryptionKey{
+		{Email: "alice@partner.local", Type: config.KeyPGP, Key: "armored key"},
+		{Email: "bob@partner.local", Type: config.KeySMIME, KeyFile: "/etc/bob.pem"},
+	}, conf.Relay.Encryption.Keys)
+}
+
+func TestValidEncryptionMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+relay:
+  encryption:
+    policy: never
+    domains:
+      - domain: partner.local
+        policy: opportunistic
+      - domain: secure.local
+        policy: required
+    keys:
+      - email: alice@partner.local
+        type: pgp
+        key_file: /etc/alice.asc
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.EncryptionNever, conf.Relay.Encryption.Policy)
+	assert.Equal(t, []config.RelayEncryptionDomain{
+		{Domain: "partner.local", Policy: config.EncryptionOpportunistic},
+		{Domain: "secure.local", Policy: config.EncryptionRequired},
+	}, conf.Relay.Encryption.Domains)
+	assert.Equal(t, []config.RelayEncryptionKey{
+		{Email: "alice@partner.local", Type: config.KeyPGP, KeyFile: "/etc/alice.asc"},
+	}, conf.Relay.Encryption.Keys)
+}
+
+func TestValidEncryptionMarshalFromENV(t *testing.T) {
+	t.Setenv("RELAY_ENCRYPTION_POLICY", "required")
+	t.Setenv("RELAY_ENCRYPTION_DOMAINS", "partner.local:opportunistic plain.local:never")
+	t.Setenv("RELAY_ENCRYPTION_KEYS", "alice@partner.local:pgp:/etc/alice.asc bob@partner.local:smime:/etc/bob.pem")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.EncryptionRequired, conf.Relay.Encryption.Policy)
+	assert.Equal(t, []config.RelayEncryptionDomain{
+		{Domain: "partner.local", Policy: config.EncryptionOpportunistic},
+		{Domain: "plain.local", Policy: config.EncryptionNever},
+	}, conf.Relay.Encryption.Domains)
+	assert.Equal(t, []config.RelayEncryptionKey{
+		{Email: "alice@partner.local", Type: config.KeyPGP, KeyFile: "/etc/alice.asc"},
+		{Email: "bob@partner.local", Type: config.KeySMIME, KeyFile: "/etc/bob.pem"},
+	}, conf.Relay.Encryption.Keys)
+}
+
+func TestInvalidEncryptionPolicy(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("relay.encryption.policy", "sometimes")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Relay': invalid encryption policy: `sometimes`",
+	)
+}
+
+func TestInvalidEncryptionKeyType(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("relay.encryption.keys", "alice@partner.local:gpg:/etc/alice.asc")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Relay': error parsing relay.encryption.keys: "+
+			"invalid encryption key: unknown type `gpg` for `alice@partner.local`",
+	)
+}
@@ -0,0 +1,523 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	FilterAction     int
+	FilterSpamEngine int
+)
+
+const (
+	FilterReject FilterAction = iota
+	FilterStrip
+	FilterQuarantine
+)
+
+const (
+	SpamEngineSpamd FilterSpamEngine = iota
+	SpamEngineRspamd
+)
+
+const (
+	defaultSpamdAddress  = "127.0.0.1:783"
+	defaultRspamdAddress = "http://127.0.0.1:11333"
+)
+
+var (
+	ErrInvalidFilterAction  = errors.New("invalid filter action")
+	ErrInvalidSocketAddress = errors.New("invalid socket address")
+	ErrInvalidSpamEngine    = errors.New("invalid spam engine")
+)
+
+type FilterAttachment struct {
+	Enabled             bool
+	Action              FilterAction
+	BlockedExtensions   []string
+	BlockedContentTypes []string
+	InspectArchives     bool
+	MaxSize             int
+	MaxTotalSize        int
+}
+
+// FilterClamd configures virus scanning with clamd. Network is either tcp or unix.
+type FilterClamd struct {
+	Enabled  bool
+	Network  string
+	Address  string
+	Action   FilterAction
+	FailOpen bool
+	Timeout  time.Duration
+}
+
+// FilterSpam configures spam scoring with spamd or rspamd. For spamd, Network is either tcp or unix, for rspamd
+// Address is the base URL of its HTTP interface. Thresholds set to 0 are disabled.
+type FilterSpam struct {
+	Enabled         bool
+	Engine          FilterSpamEngine
+	Network         string
+	Address         string
+	FailOpen        bool
+	Timeout         time.Duration
+	HeaderScore     float64
+	QuarantineScore float64
+	RejectScore     float64
+}
+
+// FilterDedupe configures suppression of messages submitted more than once within Window. At most MaxEntries
+// fingerprints are remembered, in StateFile when set, so they survive restarts.
+type FilterDedupe struct {
+	Enabled    bool
+	Window     time.Duration
+	MaxEntries int
+	StateFile  string
+}
+
+type Filter struct {
+	Attachment FilterAttachment
+	Clamd      FilterClamd
+	Dedupe     FilterDedupe
+	Footer     FilterFooter
+	Policy     FilterPolicy
+	Spam       FilterSpam
+}
+
+func FilterHook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{}) (interface{}, error) {
+	var (
+		data map[string]interface{}
+		ok   bool
+	)
+
+	if dataType.Kind() != reflect.Map {
+		return rawData, nil
+	}
+
+	if targetDataType != reflect.TypeOf(Filter{}) {
+		return rawData, nil
+	}
+
+	if data, ok = rawData.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterAttachment, err := buildFilterAttachment(data["attachment"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	filterClamd, err := buildFilterClamd(data["clamd"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	filterDedupe, err := buildFilterDedupe(data["dedupe"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	filterFooter, err := buildFilterFooter(data["footer"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	filterSpam, err := buildFilterSpam(data["spam"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	filterPolicy, err := buildFilterPolicy(data["policy"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return Filter{
+		Attachment: *filterAttachment,
+		Clamd:      *filterClamd,
+		Dedupe:     *filterDedupe,
+		Footer:     *filterFooter,
+		Policy:     *filterPolicy,
+		Spam:       *filterSpam,
+	}, nil
+}
+
+//nolint:cyclop
+func buildFilterAttachment(attachmentInterface interface{}) (*FilterAttachment, error) {
+	var (
+		attachment map[string]interface{}
+		action     string
+		ok         bool
+		err        error
+	)
+
+	if attachment, ok = attachmentInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterAttachment := &FilterAttachment{}
+
+	if filterAttachment.Enabled, err = parseBool(attachment["enabled"]); err != nil {
+		return nil, err
+	}
+
+	if action, ok = attachment["action"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterAttachment.Action, err = buildFilterAction(action, FilterReject, FilterStrip, FilterQuarantine)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter.attachment.action: %w", err)
+	}
+
+	if filterAttachment.BlockedExtensions, err = parseStringList(attachment["blocked_extensions"]); err != nil {
+		return nil, err
+	}
+
+	for i, extension := range filterAttachment.BlockedExtensions {
+		filterAttachment.BlockedExtensions[i] = strings.ToLower(strings.TrimPrefix(extension, "."))
+	}
+
+	if filterAttachment.BlockedContentTypes, err = parseStringList(attachment["blocked_content_types"]); err != nil {
+		return nil, err
+	}
+
+	if filterAttachment.InspectArchives, err = parseBool(attachment["inspect_archives"]); err != nil {
+		return nil, err
+	}
+
+	if filterAttachment.MaxSize, err = parseInt(attachment["max_size"]); err != nil {
+		return nil, fmt.Errorf("invalid filter.attachment.max_size: %w", err)
+	}
+
+	if filterAttachment.MaxTotalSize, err = parseInt(attachment["max_total_size"]); err != nil {
+		return nil, fmt.Errorf("invalid filter.attachment.max_total_size: %w", err)
+	}
+
+	return filterAttachment, nil
+}
+
+func buildFilterClamd(clamdInterface interface{}) (*FilterClamd, error) {
+	var (
+		clamd           map[string]interface{}
+		action, address string
+		timeout         string
+		ok              bool
+		err             error
+	)
+
+	if clamd, ok = clamdInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterClamd := &FilterClamd{}
+
+	if filterClamd.Enabled, err = parseBool(clamd["enabled"]); err != nil {
+		return nil, err
+	}
+
+	if address, ok = clamd["address"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if filterClamd.Enabled {
+		filterClamd.Network, filterClamd.Address, err = parseSocketAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter.clamd.address: %w", err)
+		}
+	}
+
+	if action, ok = clamd["action"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterClamd.Action, err = buildFilterAction(action, FilterReject, FilterQuarantine)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter.clamd.action: %w", err)
+	}
+
+	if filterClamd.FailOpen, err = parseBool(clamd["fail_open"]); err != nil {
+		return nil, err
+	}
+
+	if timeout, ok = clamd["timeout"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterClamd.Timeout, err = time.ParseDuration(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter.clamd.timeout: `%s`: %w", timeout, err)
+	}
+
+	return filterClamd, nil
+}
+
+func buildFilterDedupe(dedupeInterface interface{}) (*FilterDedupe, error) {
+	var (
+		dedupe map[string]interface{}
+		window string
+		ok     bool
+		err    error
+	)
+
+	if dedupe, ok = dedupeInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterDedupe := &FilterDedupe{}
+
+	if filterDedupe.Enabled, err = parseBool(dedupe["enabled"]); err != nil {
+		return nil, err
+	}
+
+	if window, ok = dedupe["window"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterDedupe.Window, err = time.ParseDuration(window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter.dedupe.window: `%s`: %w", window, err)
+	}
+
+	if filterDedupe.MaxEntries, err = parseInt(dedupe["max_entries"]); err != nil {
+		return nil, fmt.Errorf("invalid filter.dedupe.max_entries: %w", err)
+	}
+
+	if filterDedupe.StateFile, ok = dedupe["state_file"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	return filterDedupe, nil
+}
+
+//nolint:cyclop,funlen
+func buildFilterSpam(spamInterface interface{}) (*FilterSpam, error) {
+	var (
+		spam                     map[string]interface{}
+		engine, address, timeout string
+		ok                       bool
+		err                      error
+	)
+
+	if spam, ok = spamInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterSpam := &FilterSpam{}
+
+	if filterSpam.Enabled, err = parseBool(spam["enabled"]); err != nil {
+		return nil, err
+	}
+
+	if engine, ok = spam["engine"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if filterSpam.Engine, err = buildFilterSpamEngine(engine); err != nil {
+		return nil, fmt.Errorf("invalid filter.spam.engine: %w", err)
+	}
+
+	if address, ok = spam["address"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if filterSpam.Network, filterSpam.Address, err = buildFilterSpamAddress(filterSpam.Engine, address); err != nil {
+		return nil, fmt.Errorf("invalid filter.spam.address: %w", err)
+	}
+
+	if filterSpam.FailOpen, err = parseBool(spam["fail_open"]); err != nil {
+		return nil, err
+	}
+
+	if timeout, ok = spam["timeout"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterSpam.Timeout, err = time.ParseDuration(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter.spam.timeout: `%s`: %w", timeout, err)
+	}
+
+	if filterSpam.HeaderScore, err = parseFloat(spam["header_score"]); err != nil {
+		return nil, fmt.Errorf("invalid filter.spam.header_score: %w", err)
+	}
+
+	if filterSpam.QuarantineScore, err = parseFloat(spam["quarantine_score"]); err != nil {
+		return nil, fmt.Errorf("invalid filter.spam.quarantine_score: %w", err)
+	}
+
+	if filterSpam.RejectScore, err = parseFloat(spam["reject_score"]); err != nil {
+		return nil, fmt.Errorf("invalid filter.spam.reject_score: %w", err)
+	}
+
+	return filterSpam, nil
+}
+
+func buildFilterSpamEngine(engine string) (FilterSpamEngine, error) {
+	switch engine {
+	case "spamd":
+		return SpamEngineSpamd, nil
+	case "rspamd":
+		return SpamEngineRspamd, nil
+	}
+
+	return -1, fmt.Errorf("%w: `%s`", ErrInvalidSpamEngine, engine)
+}
+
+// buildFilterSpamAddress parses spamd socket address, or rspamd URL, falling back to engine defaults.
+func buildFilterSpamAddress(engine FilterSpamEngine, address string) (string, string, error) {
+	if engine == SpamEngineSpamd {
+		if address == "" {
+			address = defaultSpamdAddress
+		}
+
+		return parseSocketAddress(address)
+	}
+
+	if address == "" {
+		address = defaultRspamdAddress
+	}
+
+	parsed, err := url.Parse(address)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", "", fmt.Errorf("%w: `%s`", ErrInvalidSocketAddress, address)
+	}
+
+	return parsed.Scheme, strings.TrimSuffix(address, "/"), nil
+}
+
+// parseSocketAddress accepts tcp://host:port and unix:///path URLs, as well as bare host:port and absolute paths.
+func parseSocketAddress(address string) (string, string, error) {
+	if strings.HasPrefix(address, "/") {
+		return "unix", address, nil
+	}
+
+	if !strings.Contains(address, "://") {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("%w: `%s`", ErrInvalidSocketAddress, address)
+		}
+
+		return "tcp", address, nil
+	}
+
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: `%s`", ErrInvalidSocketAddress, address)
+	}
+
+	switch parsed.Scheme {
+	case "tcp":
+		if _, _, err = net.SplitHostPort(parsed.Host); err != nil {
+			return "", "", fmt.Errorf("%w: `%s`", ErrInvalidSocketAddress, address)
+		}
+
+		return "tcp", parsed.Host, nil
+	case "unix":
+		if parsed.Path == "" {
+			return "", "", fmt.Errorf("%w: `%s`", ErrInvalidSocketAddress, address)
+		}
+
+		return "unix", parsed.Path, nil
+	}
+
+	return "", "", fmt.Errorf("%w: `%s`", ErrInvalidSocketAddress, address)
+}
+
+// buildFilterAction parses action name, accepting only actions supported by given filter.
+func buildFilterAction(action string, allowed ...FilterAction) (FilterAction, error) {
+	var filterAction FilterAction
+
+	switch action {
+	case "reject":
+		filterAction = FilterReject
+	case "strip":
+		filterAction = FilterStrip
+	case "quarantine":
+		filterAction = FilterQuarantine
+	default:
+		return -1, fmt.Errorf("%w: `%s`", ErrInvalidFilterAction, action)
+	}
+
+	for _, allowedAction := range allowed {
+		if filterAction == allowedAction {
+			return filterAction, nil
+		}
+	}
+
+	return -1, fmt.Errorf("%w: `%s`", ErrInvalidFilterAction, action)
+}
+
+func parseBool(value interface{}) (bool, error) {
+	switch boolValue := value.(type) {
+	case bool:
+		return boolValue, nil
+	case string:
+		return parseBoolString(boolValue), nil
+	}
+
+	return false, ErrUnserializing
+}
+
+func parseInt(value interface{}) (int, error) {
+	switch intValue := value.(type) {
+	case int:
+		return intValue, nil
+	case string:
+		parsed, err := strconv.Atoi(intValue)
+		if err != nil {
+			return 0, fmt.Errorf("%w", err)
+		}
+
+		return parsed, nil
+	}
+
+	return 0, ErrUnserializing
+}
+
+func parseFloat(value interface{}) (float64, error) {
+	switch floatValue := value.(type) {
+	case float64:
+		return floatValue, nil
+	case int:
+		return float64(floatValue), nil
+	case string:
+		parsed, err := strconv.ParseFloat(floatValue, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w", err)
+		}
+
+		return parsed, nil
+	}
+
+	return 0, ErrUnserializing
+}
+
+func parseStringList(value interface{}) ([]string, error) {
+	list := make([]string, 0)
+
+	switch listValue := value.(type) {
+	case []string:
+		list = append(list, listValue...)
+	case []interface{}:
+		for _, itemValue := range listValue {
+			item, ok := itemValue.(string)
+			if !ok {
+				return nil, ErrUnserializing
+			}
+
+			list = append(list, item)
+		}
+	case string:
+		list = append(list, strings.Fields(listValue)...)
+	default:
+		return nil, ErrUnserializing
+	}
+
+	return list, nil
+}
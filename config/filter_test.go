@@ -0,0 +1,369 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidFilterMarshalFromObjects(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("filter.attachment.enabled", true)
+	viperConfig.Set("filter.attachment.action", "strip")
+	viperConfig.Set("filter.attachment.blocked_extensions", []string{".EXE", "js"})
+	viperConfig.Set("filter.attachment.blocked_content_types", []string{"application/x-msdownload"})
+	viperConfig.Set("filter.attachment.inspect_archives", false)
+	viperConfig.Set("filter.attachment.max_size", 1024)
+	viperConfig.Set("filter.attachment.max_total_size", 4096)
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.FilterAttachment{
+		Enabled:             true,
+		Action:              config.FilterStrip,
+		BlockedExtensions:   []string{"exe", "js"},
+		BlockedContentTypes: []string{"application/x-msdownload"},
+		InspectArchives:     false,
+		MaxSize:             1024,
+		MaxTotalSize:        4096,
+	}, conf.Filter.Attachment)
+}
+
+func TestValidFilterMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+filter:
+  attachment:
+    enabled: true
+    action: quarantine
+    blocked_extensions:
+      - exe
+      - .scr
+    blocked_content_types:
+      - application/x-executable
+      - application/x-msdownload
+    max_size: 10485760
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.FilterAttachment{
+		Enabled:             true,
+		Action:              config.FilterQuarantine,
+		BlockedExtensions:   []string{"exe", "scr"},
+		BlockedContentTypes: []string{"application/x-executable", "application/x-msdownload"},
+		InspectArchives:     true,
+		MaxSize:             10485760,
+		MaxTotalSize:        0,
+	}, conf.Filter.Attachment)
+}
+
+func TestValidFilterMarshalFromENV(t *testing.T) {
+	t.Setenv("FILTER_ATTACHMENT_ENABLED", "true")
+	t.Setenv("FILTER_ATTACHMENT_ACTION", "reject")
+	t.Setenv("FILTER_ATTACHMENT_BLOCKED_EXTENSIONS", "exe bat")
+	t.Setenv("FILTER_ATTACHMENT_BLOCKED_CONTENT_TYPES", "application/x-executable")
+	t.Setenv("FILTER_ATTACHMENT_INSPECT_ARCHIVES", "false")
+	t.Setenv("FILTER_ATTACHMENT_MAX_SIZE", "2048")
+	t.Setenv("FILTER_ATTACHMENT_MAX_TOTAL_SIZE", "8192")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.FilterAttachment{
+		Enabled:             true,
+		Action:              config.FilterReject,
+		BlockedExtensions:   []string{"exe", "bat"},
+		BlockedContentTypes: []string{"application/x-executable"},
+		InspectArchives:     false,
+		MaxSize:             2048,
+		MaxTotalSize:        8192,
+	}, conf.Filter.Attachment)
+}
+
+func TestInvalidFilterAttachmentAction(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("filter.attachment.action", "drop")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Filter': invalid filter.attachment.action: invalid filter action: `drop`",
+	)
+}
+
+func TestInvalidFilterAttachmentMaxSize(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("filter.attachment.max_size", "10MB")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Filter': invalid filter.attachment.max_size: "+
+			"strconv.Atoi: parsing \"10MB\": invalid syntax",
+	)
+}
+
+func TestValidFilterClamdMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+filter:
+  clamd:
+    enabled: true
+    address: unix:///var/run/clamav/clamd.ctl
+    action: quarantine
+    fail_open: true
+    timeout: 1m
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.FilterClamd{
+		Enabled:  true,
+		Network:  "unix",
+		Address:  "/var/run/clamav/clamd.ctl",
+		Action:   config.FilterQuarantine,
+		FailOpen: true,
+		Timeout:  time.Minute,
+	}, conf.Filter.Clamd)
+}
+
+func TestValidFilterClamdMarshalFromENV(t *testing.T) {
+	t.Setenv("FILTER_CLAMD_ENABLED", "true")
+	t.Setenv("FILTER_CLAMD_ADDRESS", "tcp://127.0.0.1:3310")
+	t.Setenv("FILTER_CLAMD_ACTION", "reject")
+	t.Setenv("FILTER_CLAMD_FAIL_OPEN", "false")
+	t.Setenv("FILTER_CLAMD_TIMEOUT", "10s")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.FilterClamd{
+		Enabled:  true,
+		Network:  "tcp",
+		Address:  "127.0.0.1:3310",
+		Action:   config.FilterReject,
+		FailOpen: false,
+		Timeout:  10 * time.Second,
+	}, conf.Filter.Clamd)
+}
+
+func TestFilterClamdBareAddresses(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("filter.clamd.enabled", true)
+	viperConfig.Set("filter.clamd.address", "/run/clamd.sock")
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "unix", conf.Filter.Clamd.Network)
+	assert.Equal(t, "/run/clamd.sock", conf.Filter.Clamd.Address)
+
+	viperConfig = viper.New()
+	viperConfig.Set("filter.clamd.enabled", true)
+	viperConfig.Set("filter.clamd.address", "clamav:3310")
+	conf, err = InitConfig(viperConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp", conf.Filter.Clamd.Network)
+	assert.Equal(t, "clamav:3310", conf.Filter.Clamd.Address)
+}
+
+func TestInvalidFilterClamdAddress(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("filter.clamd.enabled", true)
+	viperConfig.Set("filter.clamd.address", "udp://127.0.0.1:3310")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Filter': invalid filter.clamd.address: invalid socket address: `udp://127.0.0.1:3310`",
+	)
+}
+
+func TestInvalidFilterClamdAction(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("filter.clamd.action", "strip")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Filter': invalid filter.clamd.action: invalid filter action: `strip`",
+	)
+}
+
+func TestValidFilterSpamMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+filter:
+  spam:
+    enabled: true
+    engine: rspamd
+    address: http://rspamd:11333/
+    fail_open: false
+    timeout: 15s
+    header_score: 4
+    quarantine_score: 8.5
+    reject_score: 20
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.FilterSpam{
+		Enabled:         true,
+		Engine:          config.SpamEngineRspamd,
+		Network:         "http",
+		Address:         "http://rspamd:11333",
+		FailOpen:        false,
+		Timeout:         15 * time.Second,
+		HeaderScore:     4,
+		QuarantineScore: 8.5,
+		RejectScore:     20,
+	}, conf.Filter.Spam)
+}
+
+func TestValidFilterSpamMarshalFromENV(t *testing.T) {
+	t.Setenv("FILTER_SPAM_ENABLED", "true")
+	t.Setenv("FILTER_SPAM_ENGINE", "spamd")
+	t.Setenv("FILTER_SPAM_ADDRESS", "unix:///run/spamd.sock")
+	t.Setenv("FILTER_SPAM_FAIL_OPEN", "true")
+	t.Setenv("FILTER_SPAM_TIMEOUT", "5s")
+	t.Setenv("FILTER_SPAM_HEADER_SCORE", "3.5")
+	t.Setenv("FILTER_SPAM_QUARANTINE_SCORE", "0")
+	t.Setenv("FILTER_SPAM_REJECT_SCORE", "12")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.FilterSpam{
+		Enabled:         true,
+		Engine:          config.SpamEngineSpamd,
+		Network:         "unix",
+		Address:         "/run/spamd.sock",
+		FailOpen:        true,
+		Timeout:         5 * time.Second,
+		HeaderScore:     3.5,
+		QuarantineScore: 0,
+		RejectScore:     12,
+	}, conf.Filter.Spam)
+}
+
+func TestFilterSpamDefaultAddresses(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("filter.spam.engine", "rspamd")
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:11333", conf.Filter.Spam.Address)
+
+	viperConfig = viper.New()
+	conf, err = InitConfig(viperConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp", conf.Filter.Spam.Network)
+	assert.Equal(t, "127.0.0.1:783", conf.Filter.Spam.Address)
+}
+
+func TestInvalidFilterSpamEngine(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("filter.spam.engine", "spamassassin")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Filter': invalid filter.spam.engine: invalid spam engine: `spamassassin`",
+	)
+}
+
+func TestInvalidFilterSpamRspamdAddress(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("filter.spam.engine", "rspamd")
+	viperConfig.Set("filter.spam.address", "127.0.0.1:11333")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Filter': invalid filter.spam.address: invalid socket address: `127.0.0.1:11333`",
+	)
+}
+
+func TestValidFilterDedupeMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+filter:
+  dedupe:
+    enabled: true
+    window: 2h
+    max_entries: 500
+    state_file: /var/lib/mailbowl/dedupe
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.FilterDedupe{
+		Enabled: true, Window: 2 * time.Hour, MaxEntries: 500, StateFile: "/var/lib/mailbowl/dedupe",
+	}, conf.Filter.Dedupe)
+}
+
+func TestValidFilterDedupeMarshalFromENV(t *testing.T) {
+	t.Setenv("FILTER_DEDUPE_ENABLED", "true")
+	t.Setenv("FILTER_DEDUPE_WINDOW", "10m")
+	t.Setenv("FILTER_DEDUPE_MAX_ENTRIES", "50")
+	t.Setenv("FILTER_DEDUPE_STATE_FILE", "/tmp/dedupe")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.FilterDedupe{
+		Enabled: true, Window: 10 * time.Minute, MaxEntries: 50, StateFile: "/tmp/dedupe",
+	}, conf.Filter.Dedupe)
+}
+
+func TestInvalidFilterDedupeWindow(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("filter.dedupe.window", "day")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Filter': invalid filter.dedupe.window: `day`: time: invalid duration \"day\"",
+	)
+}
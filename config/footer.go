@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidFooterRule = errors.New("invalid footer rule")
+
+// FilterFooterRule selects the footer by sender domain or authenticated user. Rules without both lists match every
+// message. Text and HTML are templates, appended to text/plain and text/html body parts respectively.
+type FilterFooterRule struct {
+	Name          string
+	SenderDomains []string
+	Usernames     []string
+	Text          string
+	HTML          string
+}
+
+// FilterFooter configures footers (i.e. legal disclaimers) appended to outgoing messages. First matching rule wins.
+type FilterFooter struct {
+	Enabled bool
+	Rules   []FilterFooterRule
+}
+
+func buildFilterFooter(footerInterface interface{}) (*FilterFooter, error) {
+	var (
+		footer map[string]interface{}
+		ok     bool
+		err    error
+	)
+
+	if footer, ok = footerInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterFooter := &FilterFooter{}
+
+	if filterFooter.Enabled, err = parseBool(footer["enabled"]); err != nil {
+		return nil, err
+	}
+
+	switch rules := footer["rules"].(type) {
+	case []interface{}:
+		filterFooter.Rules, err = buildFooterRules(rules)
+	case string:
+		if strings.TrimSpace(rules) != "" {
+			return nil, fmt.Errorf("invalid filter.footer.rules: %w", ErrUnserializing)
+		}
+
+		filterFooter.Rules = []FilterFooterRule{}
+	default:
+		return nil, ErrUnserializing
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter.footer.rules: %w", err)
+	}
+
+	return filterFooter, nil
+}
+
+func buildFooterRules(rulesInterface []interface{}) ([]FilterFooterRule, error) {
+	rules := make([]FilterFooterRule, 0, len(rulesInterface))
+
+	for i, ruleInterface := range rulesInterface {
+		rule, err := buildFooterRule(ruleInterface)
+		if err != nil {
+			return nil, fmt.Errorf("rule #%d: %w", i+1, err)
+		}
+
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		rules = append(rules, *rule)
+	}
+
+	return rules, nil
+}
+
+func buildFooterRule(ruleInterface interface{}) (*FilterFooterRule, error) {
+	var (
+		rule map[interface{}]interface{}
+		ok   bool
+		err  error
+	)
+
+	if rule, ok = ruleInterface.(map[interface{}]interface{}); !ok {
+		return nil, fmt.Errorf("%w: rule must be a map", ErrInvalidFooterRule)
+	}
+
+	footerRule := &FilterFooterRule{SenderDomains: []string{}, Usernames: []string{}}
+
+	if name, ok := rule["name"]; ok {
+		footerRule.Name = fmt.Sprint(name)
+	}
+
+	if senderDomains, ok := rule["sender_domains"]; ok {
+		if footerRule.SenderDomains, err = parseStringList(senderDomains); err != nil {
+			return nil, fmt.Errorf("%w: invalid sender_domains", ErrInvalidFooterRule)
+		}
+	}
+
+	for i, domain := range footerRule.SenderDomains {
+		footerRule.SenderDomains[i] = strings.ToLower(domain)
+	}
+
+	if usernames, ok := rule["usernames"]; ok {
+		if footerRule.Usernames, err = parseStringList(usernames); err != nil {
+			return nil, fmt.Errorf("%w: invalid usernames", ErrInvalidFooterRule)
+		}
+	}
+
+	footerRule.Text, _ = rule["text"].(string)
+	footerRule.HTML, _ = rule["html"].(string)
+
+	if footerRule.Text == "" && footerRule.HTML == "" {
+		return nil, fmt.Errorf("%w: text or html footer is required", ErrInvalidFooterRule)
+	}
+
+	return footerRule, nil
+}
@@ -0,0 +1,65 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidFilterFooterMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+filter:
+  footer:
+    enabled: true
+    rules:
+      - name: legal
+        sender_domains:
+          - Example.com
+        usernames: app@example.com other@example.com
+        text: "-- {{.Sender}}"
+        html: "<p>{{.Sender}}</p>"
+      - text: catch all
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	assert.True(t, conf.Filter.Footer.Enabled)
+	assert.Equal(t, []config.FilterFooterRule{
+		{
+			Name:          "legal",
+			SenderDomains: []string{"example.com"},
+			Usernames:     []string{"app@example.com", "other@example.com"},
+			Text:          "-- {{.Sender}}",
+			HTML:          "<p>{{.Sender}}</p>",
+		},
+		{Name: "rule-2", SenderDomains: []string{}, Usernames: []string{}, Text: "catch all"},
+	}, conf.Filter.Footer.Rules)
+}
+
+func TestValidFilterFooterMarshalFromENV(t *testing.T) {
+	t.Setenv("FILTER_FOOTER_ENABLED", "true")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.True(t, conf.Filter.Footer.Enabled)
+	assert.Equal(t, []config.FilterFooterRule{}, conf.Filter.Footer.Rules)
+}
+
+func TestInvalidFilterFooterRules(t *testing.T) {
+	t.Parallel()
+
+	_, err := InitConfig(viper.New(), "---\nfilter:\n  footer:\n    rules:\n      - name: empty\n")
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n* error decoding 'Filter': "+
+			"invalid filter.footer.rules: rule #1: invalid footer rule: text or html footer is required",
+	)
+}
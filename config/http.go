@@ -0,0 +1,38 @@
+package config
+
+import (
+	"reflect"
+)
+
+// HTTP configures the HTTP listener. Admin endpoints are available only when AdminToken is set, and require it
+// as a bearer token.
+type HTTP struct {
+	AdminToken string
+}
+
+func HTTPHook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{}) (interface{}, error) {
+	var (
+		data map[string]interface{}
+		ok   bool
+	)
+
+	if dataType.Kind() != reflect.Map {
+		return rawData, nil
+	}
+
+	if targetDataType != reflect.TypeOf(HTTP{}) {
+		return rawData, nil
+	}
+
+	if data, ok = rawData.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	http := HTTP{}
+
+	if http.AdminToken, ok = data["admin_token"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	return http, nil
+}
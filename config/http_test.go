@@ -0,0 +1,34 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidHTTPMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+http:
+  admin_token: secret
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "secret", conf.HTTP.AdminToken)
+}
+
+func TestValidHTTPMarshalFromENV(t *testing.T) {
+	t.Setenv("HTTP_ADMIN_TOKEN", "env-secret")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "env-secret", conf.HTTP.AdminToken)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"regexp"
 	"strings"
 
@@ -13,6 +14,7 @@ import (
 
 //nolint:gochecknoglobals
 var redactedFields = []string{
+	"AdminToken",
 	"Key",
 	"Password",
 	"PasswordHash",
@@ -36,7 +38,9 @@ func Init(cfgFile string) {
 		log.Fatalf("error initializing config: %s", err.Error())
 	}
 
-	Reload()
+	if logErr := ConfigureLogger(config.Log, os.Stdout, os.Stderr); logErr != nil {
+		log.Errorf("problem configuring logger: %s", logErr.Error())
+	}
 
 	if err == nil {
 		log.Infof("Using config file: %s", viper.ConfigFileUsed())
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var ErrInvalidJournal = errors.New("invalid journal configuration")
+
+// Journal configures copies of all relayed messages, wrapped with envelope reports, sent to Address (through
+// the relay) and/or written to Directory. Reports are kept in SpoolDirectory until delivered, failed deliveries are
+// retried every RetryInterval.
+type Journal struct {
+	Enabled        bool
+	Address        string
+	Sender         string
+	Directory      string
+	SpoolDirectory string
+	RetryInterval  time.Duration
+}
+
+func JournalHook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{}) (interface{}, error) {
+	var (
+		data          map[string]interface{}
+		retryInterval string
+		ok            bool
+		err           error
+	)
+
+	if dataType.Kind() != reflect.Map {
+		return rawData, nil
+	}
+
+	if targetDataType != reflect.TypeOf(Journal{}) {
+		return rawData, nil
+	}
+
+	if data, ok = rawData.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	journal := Journal{}
+
+	if journal.Enabled, err = parseBool(data["enabled"]); err != nil {
+		return nil, err
+	}
+
+	if journal.Address, ok = data["address"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if journal.Sender, ok = data["sender"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if journal.Directory, ok = data["directory"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if journal.SpoolDirectory, ok = data["spool_directory"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if retryInterval, ok = data["retry_interval"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if journal.RetryInterval, err = time.ParseDuration(retryInterval); err != nil {
+		return nil, fmt.Errorf("invalid journal.retry_interval: `%s`: %w", retryInterval, err)
+	}
+
+	if !journal.Enabled {
+		return journal, nil
+	}
+
+	if journal.Address == "" && journal.Directory == "" {
+		return nil, fmt.Errorf("%w: journal.address or journal.directory is required", ErrInvalidJournal)
+	}
+
+	// reports are never kept in memory only, so they are not lost when delivery fails
+	if journal.SpoolDirectory == "" {
+		return nil, fmt.Errorf("%w: journal.spool_directory is required", ErrInvalidJournal)
+	}
+
+	return journal, nil
+}
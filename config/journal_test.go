@@ -0,0 +1,68 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidJournalMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+journal:
+  enabled: true
+  address: archive@example.local
+  sender: journal@example.local
+  directory: /var/lib/mailbowl/journal
+  spool_directory: /var/spool/mailbowl/journal
+  retry_interval: 1m
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	assert.True(t, conf.Journal.Enabled)
+	assert.Equal(t, "archive@example.local", conf.Journal.Address)
+	assert.Equal(t, "journal@example.local", conf.Journal.Sender)
+	assert.Equal(t, "/var/lib/mailbowl/journal", conf.Journal.Directory)
+	assert.Equal(t, "/var/spool/mailbowl/journal", conf.Journal.SpoolDirectory)
+	assert.Equal(t, time.Minute, conf.Journal.RetryInterval)
+}
+
+func TestValidJournalMarshalFromENV(t *testing.T) {
+	t.Setenv("JOURNAL_ENABLED", "1")
+	t.Setenv("JOURNAL_DIRECTORY", "/tmp/journal")
+	t.Setenv("JOURNAL_SPOOL_DIRECTORY", "/tmp/spool")
+	t.Setenv("JOURNAL_RETRY_INTERVAL", "30s")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.True(t, conf.Journal.Enabled)
+	assert.Equal(t, "", conf.Journal.Address)
+	assert.Equal(t, "/tmp/journal", conf.Journal.Directory)
+	assert.Equal(t, "/tmp/spool", conf.Journal.SpoolDirectory)
+	assert.Equal(t, 30*time.Second, conf.Journal.RetryInterval)
+}
+
+func TestInvalidJournal(t *testing.T) {
+	t.Parallel()
+
+	_, err := InitConfig(viper.New(), "---\njournal:\n  enabled: true\n  spool_directory: /tmp/spool\n")
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n* error decoding 'Journal': "+
+			"invalid journal configuration: journal.address or journal.directory is required",
+	)
+
+	_, err = InitConfig(viper.New(), "---\njournal:\n  enabled: true\n  address: archive@example.local\n")
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n* error decoding 'Journal': "+
+			"invalid journal configuration: journal.spool_directory is required",
+	)
+}
@@ -0,0 +1,289 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// SMTPListen is a single listener. Listeners given as plain URIs share settings of the smtp section, listeners given
+// as objects can override auth, whitelist, limit, privacy, proxy, timeout and tls, and send messages through one of
+// relay routes.
+type SMTPListen struct {
+	URI   string
+	Proto string
+	Host  string
+	Port  string
+	Path  string
+
+	Auth      SMTPAuth
+	Limit     SMTPLimit
+	Privacy   SMTPPrivacy
+	Proxy     SMTPProxy
+	Timeout   SMTPTimeout
+	TLS       SMTPTLS
+	Whitelist []string
+	Relay     string
+}
+
+// ForListener returns settings for given listen URI, with its overrides applied.
+func (s SMTP) ForListener(uri string) SMTP {
+	listen, ok := s.listener(uri)
+	if !ok {
+		return s
+	}
+
+	s.Auth, s.Limit, s.Timeout = listen.Auth, listen.Limit, listen.Timeout
+	s.Privacy, s.Proxy = listen.Privacy, listen.Proxy
+	s.TLS, s.Whitelist = listen.TLS, listen.Whitelist
+
+	return s
+}
+
+// RelayRoute returns name of the relay route used by given listen URI, empty for the default one.
+func (s SMTP) RelayRoute(uri string) string {
+	listen, _ := s.listener(uri)
+
+	return listen.Relay
+}
+
+// ListenURIs returns URIs of all configured listeners.
+func (s SMTP) ListenURIs() []string {
+	uris := make([]string, 0, len(s.Listen))
+
+	for _, listen := range s.Listen {
+		uris = append(uris, listen.URI)
+	}
+
+	return uris
+}
+
+func (s SMTP) listener(uri string) (SMTPListen, bool) {
+	key := listenerKey(uri)
+
+	for _, listen := range s.Listen {
+		if listenerKey(listen.URI) == key {
+			return listen, true
+		}
+	}
+
+	return SMTPListen{}, false
+}
+
+// buildSMTPListen parses listeners, either URIs or objects with `uri` and overrides. Overridden sections are merged
+// with the ones from smtp section, so only changed settings have to be given.
+func buildSMTPListen(data map[string]interface{}, defaults SMTP) ([]SMTPListen, error) {
+	var items []interface{}
+
+	switch listenDecoded := data["listen"].(type) {
+	case []string:
+		for _, uri := range listenDecoded {
+			items = append(items, uri)
+		}
+	case []interface{}:
+		items = listenDecoded
+	case string:
+		for _, uri := range strings.Split(listenDecoded, " ") {
+			items = append(items, uri)
+		}
+	default:
+		return nil, ErrUnserializing
+	}
+
+	smtpListen := make([]SMTPListen, 0)
+
+	for i, item := range items {
+		listen := SMTPListen{
+			Auth: defaults.Auth, Limit: defaults.Limit, Privacy: defaults.Privacy, Proxy: defaults.Proxy,
+			Timeout: defaults.Timeout, TLS: defaults.TLS, Whitelist: defaults.Whitelist,
+		}
+
+		var err error
+
+		switch itemDecoded := item.(type) {
+		case string:
+			listen.URI = itemDecoded
+		default:
+			err = buildListenOverrides(&listen, item, data)
+		}
+
+		if err == nil {
+			err = buildListenAddress(&listen)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid smtp.listen: listener #%d: %w", i+1, err)
+		}
+
+		smtpListen = append(smtpListen, listen)
+	}
+
+	return smtpListen, nil
+}
+
+//nolint:cyclop,funlen
+func buildListenOverrides(listen *SMTPListen, item interface{}, data map[string]interface{}) error {
+	listener, ok := stringMap(item)
+	if !ok {
+		return ErrUnserializing
+	}
+
+	if listen.URI, ok = listener["uri"].(string); !ok || listen.URI == "" {
+		return fmt.Errorf("missing uri: %w", ErrUnserializing)
+	}
+
+	if override, ok := listener["auth"]; ok {
+		auth, err := buildSMTPAuth(mergeSection(data["auth"], override))
+		if err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+
+		listen.Auth = *auth
+	}
+
+	if override, ok := listener["limit"]; ok {
+		limit, err := buildSMTPLimit(mergeSection(data["limit"], override))
+		if err != nil {
+			return fmt.Errorf("limit: %w", err)
+		}
+
+		listen.Limit = *limit
+	}
+
+	if override, ok := stringMap(listener["privacy"]); ok {
+		merged, _ := stringMap(mergeSection(data["privacy"], override))
+
+		privacy, err := buildPrivacySettings(merged)
+		if err != nil {
+			return fmt.Errorf("privacy: %w", err)
+		}
+
+		listen.Privacy = *privacy
+	}
+
+	if override, ok := stringMap(listener["proxy"]); ok {
+		merged, _ := stringMap(mergeSection(data["proxy"], override))
+
+		proxy, err := buildProxySettings(merged)
+		if err != nil {
+			return fmt.Errorf("proxy: %w", err)
+		}
+
+		listen.Proxy = *proxy
+	}
+
+	if override, ok := listener["timeout"]; ok {
+		timeout, err := buildSMTPTimeout(mergeSection(data["timeout"], override))
+		if err != nil {
+			return fmt.Errorf("timeout: %w", err)
+		}
+
+		listen.Timeout = *timeout
+	}
+
+	if override, ok := listener["tls"]; ok {
+		tls, err := buildSMTPTLS(mergeTLS(data["tls"], override))
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+
+		listen.TLS = *tls
+	}
+
+	if override, ok := listener["whitelist"]; ok {
+		whitelist, err := buildSMTPWhitelist(override)
+		if err != nil {
+			return fmt.Errorf("whitelist: %w", err)
+		}
+
+		listen.Whitelist = whitelist
+	}
+
+	if override, ok := listener["relay"]; ok {
+		if listen.Relay, ok = override.(string); !ok {
+			return fmt.Errorf("relay: %w", ErrUnserializing)
+		}
+	}
+
+	return nil
+}
+
+func buildListenAddress(listen *SMTPListen) error {
+	url, err := url.Parse(listen.URI)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	listen.Proto = url.Scheme
+
+	if url.Scheme == "unix" || (url.Scheme == "lmtp" && url.Host == "") {
+		listen.Path = url.Path
+
+		return nil
+	}
+
+	if listen.Host, listen.Port, err = net.SplitHostPort(url.Host); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// mergeTLS merges tls override like any other section, except the certificate source: inline key and certificate
+// take precedence over files, so inherited ones are dropped, when the override sets the other kind.
+func mergeTLS(defaults interface{}, override interface{}) interface{} {
+	merged, ok := stringMap(mergeSection(defaults, override))
+	if !ok {
+		return merged
+	}
+
+	overrideMap, _ := stringMap(override)
+
+	_, keyFile := overrideMap["key_file"]
+	_, certificateFile := overrideMap["certificate_file"]
+	_, key := overrideMap["key"]
+	_, certificate := overrideMap["certificate"]
+
+	if (keyFile || certificateFile) && !key && !certificate {
+		merged["key"], merged["certificate"] = "", ""
+	}
+
+	if (key || certificate) && !keyFile && !certificateFile {
+		merged["key_file"], merged["certificate_file"] = "", ""
+	}
+
+	return merged
+}
+
+// mergeSection applies overrides on top of the section defaults. Nested maps are merged as well, other values
+// (including lists) are replaced.
+func mergeSection(defaults interface{}, override interface{}) interface{} {
+	overrideMap, ok := stringMap(override)
+	if !ok {
+		return override
+	}
+
+	defaultsMap, ok := stringMap(defaults)
+	if !ok {
+		defaultsMap = map[string]interface{}{}
+	}
+
+	merged := make(map[string]interface{}, len(defaultsMap))
+
+	for key, value := range defaultsMap {
+		merged[key] = value
+	}
+
+	for key, value := range overrideMap {
+		if _, nested := stringMap(value); nested {
+			merged[key] = mergeSection(merged[key], value)
+
+			continue
+		}
+
+		merged[key] = value
+	}
+
+	return merged
+}
@@ -0,0 +1,160 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidSMTPListenObjectsFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+relay:
+  outgoing_server:
+    host: smtp.example.local
+    port: 465
+    connection_type: tls
+  routes:
+    - name: internal
+      outgoing_server:
+        host: internal.example.local
+        port: 25
+        connection_type: plain
+        auth_method: none
+smtp:
+  auth:
+    enabled: true
+    users:
+      - email: public@example.local
+        password_hash: hash
+  limit:
+    connections: 100
+  timeout:
+    read: 10s
+  whitelist:
+    - 0.0.0.0/0
+  listen:
+    - tls://0.0.0.0:465
+    - uri: plain://10.0.0.1:25
+      auth:
+        enabled: false
+      whitelist:
+        - 10.0.0.0/8
+      limit:
+        connections: 10
+      timeout:
+        data: 1m
+      tls:
+        force_for_starttls: false
+      relay: internal
+`
+
+	conf, err := InitConfig(viper.New(), yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"tls://0.0.0.0:465", "plain://10.0.0.1:25"}, conf.SMTP.ListenURIs())
+	assert.Equal(t, "plain", conf.SMTP.Listen[1].Proto)
+	assert.Equal(t, "10.0.0.1", conf.SMTP.Listen[1].Host)
+	assert.Equal(t, "25", conf.SMTP.Listen[1].Port)
+
+	public := conf.SMTP.ForListener("tls://0.0.0.0:465")
+	assert.True(t, public.Auth.Enabled)
+	assert.Len(t, public.Auth.Users, 1)
+	assert.Equal(t, []string{"0.0.0.0/0"}, public.Whitelist)
+	assert.Equal(t, 100, public.Limit.Connections)
+	assert.True(t, public.TLS.ForceForStartTLS)
+	assert.Equal(t, "", conf.SMTP.RelayRoute("tls://0.0.0.0:465"))
+
+	internal := conf.SMTP.ForListener("plain://10.0.0.1:25")
+	assert.False(t, internal.Auth.Enabled)
+	assert.Len(t, internal.Auth.Users, 1)
+	assert.Equal(t, []string{"10.0.0.0/8"}, internal.Whitelist)
+	assert.Equal(t, 10, internal.Limit.Connections)
+	assert.Equal(t, 10*time.Second, internal.Timeout.Read)
+	assert.Equal(t, time.Minute, internal.Timeout.Data)
+	assert.False(t, internal.TLS.ForceForStartTLS)
+	assert.Equal(t, "internal", conf.SMTP.RelayRoute("plain://10.0.0.1:25"))
+
+	relay, err := conf.Relay.ForRoute("internal")
+	assert.NoError(t, err)
+	assert.Equal(t, "internal.example.local", relay.OutgoingServer.Host)
+	assert.Equal(t, 25, relay.OutgoingServer.Port)
+	assert.Equal(t, config.ConnectionPlain, relay.OutgoingServer.ConnectionType)
+	assert.Equal(t, config.AuthNone, relay.OutgoingServer.AuthMethod)
+
+	relay, err = conf.Relay.ForRoute("")
+	assert.NoError(t, err)
+	assert.Equal(t, "smtp.example.local", relay.OutgoingServer.Host)
+
+	_, err = conf.Relay.ForRoute("missing")
+	assert.ErrorIs(t, err, config.ErrUnknownRoute)
+}
+
+func TestSMTPListenTLSOverrideReplacesCertificateSource(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+smtp:
+  tls:
+    key: global-key
+    certificate: global-certificate
+  listen:
+    - uri: tls://0.0.0.0:465
+      tls:
+        key_file: /etc/mailbowl/key.pem
+        certificate_file: /etc/mailbowl/certificate.pem
+`
+
+	conf, err := InitConfig(viper.New(), yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.SMTPTLS{
+		KeyFile: "/etc/mailbowl/key.pem", CertificateFile: "/etc/mailbowl/certificate.pem", ForceForStartTLS: true,
+	}, conf.SMTP.ForListener("tls://0.0.0.0:465").TLS)
+
+	yamlExample = `---
+smtp:
+  tls:
+    key_file: /etc/mailbowl/key.pem
+    certificate_file: /etc/mailbowl/certificate.pem
+  listen:
+    - uri: tls://0.0.0.0:465
+      tls:
+        key: listener-key
+        certificate: listener-certificate
+`
+
+	conf, err = InitConfig(viper.New(), yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.SMTPTLS{
+		Key: "listener-key", Certificate: "listener-certificate", ForceForStartTLS: true,
+	}, conf.SMTP.ForListener("tls://0.0.0.0:465").TLS)
+}
+
+func TestValidSMTPListenLMTPSocket(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := "---\nsmtp:\n  listen:\n    - uri: lmtp:///run/mailbowl/lmtp.sock\n      relay: internal\n"
+
+	conf, err := InitConfig(viper.New(), yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "lmtp", conf.SMTP.Listen[0].Proto)
+	assert.Equal(t, "/run/mailbowl/lmtp.sock", conf.SMTP.Listen[0].Path)
+	assert.Equal(t, "internal", conf.SMTP.RelayRoute("lmtp:///run/mailbowl/lmtp.sock"))
+}
+
+func TestInvalidSMTPListenObject(t *testing.T) {
+	t.Parallel()
+
+	_, err := InitConfig(viper.New(), "---\nsmtp:\n  listen:\n    - whitelist: [10.0.0.0/8]\n")
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n* error decoding 'SMTP': "+
+			"invalid smtp.listen: listener #1: missing uri: error unserializing conf",
+	)
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// MilterServer is a single milter, called by SMTP listener at each stage of the session. Network is either tcp
+// or unix.
+type MilterServer struct {
+	Name     string
+	Network  string
+	Address  string
+	Timeout  time.Duration
+	FailOpen bool
+}
+
+type Milter struct {
+	Servers []MilterServer
+}
+
+func MilterHook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{}) (interface{}, error) {
+	var (
+		data     map[string]interface{}
+		timeout  string
+		ok       bool
+		defaults MilterServer
+		err      error
+	)
+
+	if dataType.Kind() != reflect.Map {
+		return rawData, nil
+	}
+
+	if targetDataType != reflect.TypeOf(Milter{}) {
+		return rawData, nil
+	}
+
+	if data, ok = rawData.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if timeout, ok = data["timeout"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if defaults.Timeout, err = time.ParseDuration(timeout); err != nil {
+		return nil, fmt.Errorf("invalid milter.timeout: `%s`: %w", timeout, err)
+	}
+
+	if defaults.FailOpen, err = parseBool(data["fail_open"]); err != nil {
+		return nil, err
+	}
+
+	milter := Milter{}
+
+	switch serversItem := data["servers"].(type) {
+	case []interface{}:
+		milter.Servers, err = buildMilterServersFromInterface(serversItem, defaults)
+	case string:
+		milter.Servers, err = buildMilterServersFromString(serversItem, defaults)
+	default:
+		return nil, ErrUnserializing
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error parsing milter.servers: %w", err)
+	}
+
+	return milter, nil
+}
+
+// buildMilterServersFromInterface parses list of milters, missing timeout and fail_open are taken from defaults.
+func buildMilterServersFromInterface(serversInterface []interface{}, defaults MilterServer) ([]MilterServer, error) {
+	milterServers := make([]MilterServer, 0)
+
+	for _, serverInterface := range serversInterface {
+		var (
+			server        map[interface{}]interface{}
+			name, address string
+			ok            bool
+			err           error
+		)
+
+		if server, ok = serverInterface.(map[interface{}]interface{}); !ok {
+			continue
+		}
+
+		if address, ok = server["address"].(string); !ok {
+			return nil, ErrUnserializing
+		}
+
+		milterServer := defaults
+		if milterServer.Network, milterServer.Address, err = parseSocketAddress(address); err != nil {
+			return nil, err
+		}
+
+		milterServer.Name = address
+		if name, ok = server["name"].(string); ok && name != "" {
+			milterServer.Name = name
+		}
+
+		if timeout, ok := server["timeout"].(string); ok {
+			if milterServer.Timeout, err = time.ParseDuration(timeout); err != nil {
+				return nil, fmt.Errorf("invalid timeout: `%s`: %w", timeout, err)
+			}
+		}
+
+		if failOpen, ok := server["fail_open"]; ok {
+			if milterServer.FailOpen, err = parseBool(failOpen); err != nil {
+				return nil, err
+			}
+		}
+
+		milterServers = append(milterServers, milterServer)
+	}
+
+	return milterServers, nil
+}
+
+// buildMilterServersFromString parses space separated list of milter addresses.
+func buildMilterServersFromString(serversString string, defaults MilterServer) ([]MilterServer, error) {
+	milterServers := make([]MilterServer, 0)
+
+	for _, address := range strings.Fields(serversString) {
+		var err error
+
+		milterServer := defaults
+		milterServer.Name = address
+
+		if milterServer.Network, milterServer.Address, err = parseSocketAddress(address); err != nil {
+			return nil, err
+		}
+
+		milterServers = append(milterServers, milterServer)
+	}
+
+	return milterServers, nil
+}
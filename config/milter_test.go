@@ -0,0 +1,79 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidMilterMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+milter:
+  fail_open: true
+  timeout: 5s
+  servers:
+    - name: opendkim
+      address: unix:///run/opendkim/opendkim.sock
+    - address: 127.0.0.1:8893
+      timeout: 1s
+      fail_open: false
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []config.MilterServer{
+		{
+			Name: "opendkim", Network: "unix", Address: "/run/opendkim/opendkim.sock",
+			Timeout: 5 * time.Second, FailOpen: true,
+		},
+		{Name: "127.0.0.1:8893", Network: "tcp", Address: "127.0.0.1:8893", Timeout: time.Second, FailOpen: false},
+	}, conf.Milter.Servers)
+}
+
+func TestValidMilterMarshalFromENV(t *testing.T) {
+	t.Setenv("MILTER_SERVERS", "tcp://127.0.0.1:8891 /run/opendmarc.sock")
+	t.Setenv("MILTER_TIMEOUT", "2s")
+	t.Setenv("MILTER_FAIL_OPEN", "true")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []config.MilterServer{
+		{
+			Name: "tcp://127.0.0.1:8891", Network: "tcp", Address: "127.0.0.1:8891",
+			Timeout: 2 * time.Second, FailOpen: true,
+		},
+		{
+			Name: "/run/opendmarc.sock", Network: "unix", Address: "/run/opendmarc.sock",
+			Timeout: 2 * time.Second, FailOpen: true,
+		},
+	}, conf.Milter.Servers)
+}
+
+func TestInvalidMilterAddress(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+milter:
+  servers:
+    - address: udp://127.0.0.1:8891
+`
+
+	viperConfig := viper.New()
+
+	_, err := InitConfig(viperConfig, yamlExample)
+	assert.EqualError(
+		t, err,
+		"error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Milter': error parsing milter.servers: invalid socket address: `udp://127.0.0.1:8891`",
+	)
+}
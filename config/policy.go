@@ -0,0 +1,463 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	PolicyField      int
+	PolicyOperator   int
+	PolicyActionType int
+)
+
+const (
+	PolicySender PolicyField = iota
+	PolicyRecipient
+	PolicyHeader
+	PolicyBody
+	PolicySize
+	PolicyUsername
+	PolicyListener
+	PolicyRemoteIP
+	PolicyHelo
+)
+
+const (
+	PolicyEquals PolicyOperator = iota
+	PolicyContains
+	PolicyMatches
+	PolicyExists
+	PolicyGreaterThan
+	PolicyLessThan
+)
+
+const (
+	PolicyReject PolicyActionType = iota
+	PolicyTempFail
+	PolicyQuarantine
+	PolicyAddHeader
+	PolicyRemoveHeader
+	PolicyRedirect
+	PolicyTagSubject
+)
+
+const (
+	defaultPolicyRejectCode      = 550
+	defaultPolicyTempFailCode    = 451
+	defaultPolicyRejectMessage   = "message rejected by policy"
+	defaultPolicyTempFailMessage = "message temporarily rejected by policy, try again later"
+	policyHeaderFieldPrefix      = "header:"
+)
+
+var (
+	ErrInvalidPolicyRule     = errors.New("invalid policy rule")
+	ErrInvalidPolicyField    = errors.New("invalid policy field")
+	ErrInvalidPolicyOperator = errors.New("invalid policy operator")
+	ErrInvalidPolicyAction   = errors.New("invalid policy action")
+)
+
+// FilterPolicyCondition compares a single property of the message with Value. Fields with many values (recipients,
+// repeated headers) match when any of the values does. Negate inverts the result.
+type FilterPolicyCondition struct {
+	Field    PolicyField
+	Header   string
+	Operator PolicyOperator
+	Value    string
+	Number   int
+	Regexp   *regexp.Regexp
+	Negate   bool
+}
+
+// FilterPolicyAction is a single thing done to a message matching the rule. Reject, tempfail and quarantine end
+// the evaluation, all the others modify the message and let it through.
+type FilterPolicyAction struct {
+	Type       PolicyActionType
+	Code       int
+	Message    string
+	Name       string
+	Value      string
+	Recipients []string
+}
+
+// FilterPolicyRule runs its actions, when all (or, with MatchAny, any) of its conditions match. Stop prevents
+// evaluating further rules.
+type FilterPolicyRule struct {
+	Name       string
+	MatchAny   bool
+	Conditions []FilterPolicyCondition
+	Actions    []FilterPolicyAction
+	Stop       bool
+}
+
+// FilterPolicy configures the policy engine. Rules from RulesFile are evaluated after the inline ones, the file is
+// read again on reload.
+type FilterPolicy struct {
+	Enabled   bool
+	RulesFile string
+	Rules     []FilterPolicyRule
+}
+
+func buildFilterPolicy(policyInterface interface{}) (*FilterPolicy, error) {
+	var (
+		policy map[string]interface{}
+		ok     bool
+		err    error
+	)
+
+	if policy, ok = policyInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	filterPolicy := &FilterPolicy{}
+
+	if filterPolicy.Enabled, err = parseBool(policy["enabled"]); err != nil {
+		return nil, err
+	}
+
+	if filterPolicy.RulesFile, ok = policy["rules_file"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	switch rules := policy["rules"].(type) {
+	case []interface{}:
+		filterPolicy.Rules, err = BuildPolicyRules(rules)
+	case string:
+		if strings.TrimSpace(rules) != "" {
+			return nil, fmt.Errorf("invalid filter.policy.rules: %w", ErrUnserializing)
+		}
+
+		filterPolicy.Rules = []FilterPolicyRule{}
+	default:
+		return nil, ErrUnserializing
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter.policy.rules: %w", err)
+	}
+
+	return filterPolicy, nil
+}
+
+// LoadPolicyRules reads rules from YAML file, containing a list of rules in the same format as filter.policy.rules.
+func LoadPolicyRules(path string) ([]FilterPolicyRule, error) {
+	var rules []interface{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if err = yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing policy rules file `%s`: %w", path, err)
+	}
+
+	policyRules, err := BuildPolicyRules(rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy rules file `%s`: %w", path, err)
+	}
+
+	return policyRules, nil
+}
+
+// BuildPolicyRules parses list of rules, as read from YAML.
+func BuildPolicyRules(rulesInterface []interface{}) ([]FilterPolicyRule, error) {
+	rules := make([]FilterPolicyRule, 0, len(rulesInterface))
+
+	for i, ruleInterface := range rulesInterface {
+		rule, err := buildPolicyRule(ruleInterface)
+		if err != nil {
+			return nil, fmt.Errorf("rule #%d: %w", i+1, err)
+		}
+
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		rules = append(rules, *rule)
+	}
+
+	return rules, nil
+}
+
+//nolint:cyclop
+func buildPolicyRule(ruleInterface interface{}) (*FilterPolicyRule, error) {
+	var (
+		rule map[interface{}]interface{}
+		ok   bool
+		err  error
+	)
+
+	if rule, ok = ruleInterface.(map[interface{}]interface{}); !ok {
+		return nil, fmt.Errorf("%w: rule must be a map", ErrInvalidPolicyRule)
+	}
+
+	policyRule := &FilterPolicyRule{}
+
+	if name, ok := rule["name"]; ok {
+		policyRule.Name = fmt.Sprint(name)
+	}
+
+	switch match := rule["match"]; match {
+	case nil, "all":
+	case "any":
+		policyRule.MatchAny = true
+	default:
+		return nil, fmt.Errorf("%w: invalid match: `%v`", ErrInvalidPolicyRule, match)
+	}
+
+	if stop, ok := rule["stop"]; ok {
+		if policyRule.Stop, err = parseBool(stop); err != nil {
+			return nil, fmt.Errorf("%w: invalid stop: `%v`", ErrInvalidPolicyRule, stop)
+		}
+	}
+
+	conditions, _ := rule["conditions"].([]interface{})
+	for _, conditionInterface := range conditions {
+		condition, err := buildPolicyCondition(conditionInterface)
+		if err != nil {
+			return nil, err
+		}
+
+		policyRule.Conditions = append(policyRule.Conditions, *condition)
+	}
+
+	actions, _ := rule["actions"].([]interface{})
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("%w: at least one action is required", ErrInvalidPolicyRule)
+	}
+
+	for _, actionInterface := range actions {
+		action, err := buildPolicyAction(actionInterface)
+		if err != nil {
+			return nil, err
+		}
+
+		policyRule.Actions = append(policyRule.Actions, *action)
+	}
+
+	return policyRule, nil
+}
+
+//nolint:cyclop
+func buildPolicyCondition(conditionInterface interface{}) (*FilterPolicyCondition, error) {
+	var (
+		condition map[interface{}]interface{}
+		field     string
+		operator  string
+		ok        bool
+		err       error
+	)
+
+	if condition, ok = conditionInterface.(map[interface{}]interface{}); !ok {
+		return nil, fmt.Errorf("%w: condition must be a map", ErrInvalidPolicyRule)
+	}
+
+	if field, ok = condition["field"].(string); !ok {
+		return nil, fmt.Errorf("%w: condition field is required", ErrInvalidPolicyRule)
+	}
+
+	policyCondition := &FilterPolicyCondition{}
+
+	if policyCondition.Field, policyCondition.Header, err = buildPolicyField(field); err != nil {
+		return nil, err
+	}
+
+	if operator, ok = condition["operator"].(string); !ok {
+		return nil, fmt.Errorf("%w: condition operator is required", ErrInvalidPolicyRule)
+	}
+
+	if policyCondition.Operator, err = buildPolicyOperator(operator); err != nil {
+		return nil, err
+	}
+
+	if value, ok := condition["value"]; ok && value != nil {
+		policyCondition.Value = fmt.Sprint(value)
+	}
+
+	if negate, ok := condition["negate"]; ok {
+		if policyCondition.Negate, err = parseBool(negate); err != nil {
+			return nil, fmt.Errorf("%w: invalid negate: `%v`", ErrInvalidPolicyRule, negate)
+		}
+	}
+
+	switch policyCondition.Operator {
+	case PolicyMatches:
+		if policyCondition.Regexp, err = regexp.Compile(policyCondition.Value); err != nil {
+			return nil, fmt.Errorf("%w: invalid regular expression: `%s`", ErrInvalidPolicyRule, policyCondition.Value)
+		}
+	case PolicyGreaterThan, PolicyLessThan:
+		if policyCondition.Field != PolicySize {
+			return nil, fmt.Errorf("%w: `%s` can be used with size only", ErrInvalidPolicyOperator, operator)
+		}
+	case PolicyEquals, PolicyContains, PolicyExists:
+	}
+
+	if policyCondition.Field == PolicySize &&
+		(policyCondition.Operator == PolicyContains || policyCondition.Operator == PolicyMatches) {
+		return nil, fmt.Errorf("%w: `%s` can't be used with size", ErrInvalidPolicyOperator, operator)
+	}
+
+	if policyCondition.Field == PolicySize && policyCondition.Operator != PolicyExists {
+		if policyCondition.Number, err = strconv.Atoi(policyCondition.Value); err != nil {
+			return nil, fmt.Errorf("%w: size must be a number: `%s`", ErrInvalidPolicyRule, policyCondition.Value)
+		}
+	}
+
+	return policyCondition, nil
+}
+
+func buildPolicyField(field string) (PolicyField, string, error) {
+	if strings.HasPrefix(strings.ToLower(field), policyHeaderFieldPrefix) {
+		header := strings.TrimSpace(field[len(policyHeaderFieldPrefix):])
+		if header == "" {
+			return -1, "", fmt.Errorf("%w: `%s`", ErrInvalidPolicyField, field)
+		}
+
+		return PolicyHeader, header, nil
+	}
+
+	switch field {
+	case "sender":
+		return PolicySender, "", nil
+	case "recipient":
+		return PolicyRecipient, "", nil
+	case "body":
+		return PolicyBody, "", nil
+	case "size":
+		return PolicySize, "", nil
+	case "username":
+		return PolicyUsername, "", nil
+	case "listener":
+		return PolicyListener, "", nil
+	case "remote_ip":
+		return PolicyRemoteIP, "", nil
+	case "helo":
+		return PolicyHelo, "", nil
+	}
+
+	return -1, "", fmt.Errorf("%w: `%s`", ErrInvalidPolicyField, field)
+}
+
+func buildPolicyOperator(operator string) (PolicyOperator, error) {
+	switch operator {
+	case "equals":
+		return PolicyEquals, nil
+	case "contains":
+		return PolicyContains, nil
+	case "matches":
+		return PolicyMatches, nil
+	case "exists":
+		return PolicyExists, nil
+	case "gt":
+		return PolicyGreaterThan, nil
+	case "lt":
+		return PolicyLessThan, nil
+	}
+
+	return -1, fmt.Errorf("%w: `%s`", ErrInvalidPolicyOperator, operator)
+}
+
+//nolint:cyclop,funlen
+func buildPolicyAction(actionInterface interface{}) (*FilterPolicyAction, error) {
+	var (
+		action     map[interface{}]interface{}
+		actionType string
+		ok         bool
+		err        error
+	)
+
+	if action, ok = actionInterface.(map[interface{}]interface{}); !ok {
+		return nil, fmt.Errorf("%w: action must be a map", ErrInvalidPolicyRule)
+	}
+
+	if actionType, ok = action["action"].(string); !ok {
+		return nil, fmt.Errorf("%w: action is required", ErrInvalidPolicyRule)
+	}
+
+	policyAction := &FilterPolicyAction{}
+	policyAction.Message, _ = action["message"].(string)
+	policyAction.Name, _ = action["name"].(string)
+
+	if value, ok := action["value"]; ok && value != nil {
+		policyAction.Value = fmt.Sprint(value)
+	}
+
+	if code, ok := action["code"]; ok {
+		if policyAction.Code, err = parseInt(code); err != nil {
+			return nil, fmt.Errorf("%w: invalid code: `%v`", ErrInvalidPolicyAction, code)
+		}
+	}
+
+	switch actionType {
+	case "reject":
+		policyAction.Type = PolicyReject
+		err = policyReplyDefaults(policyAction, defaultPolicyRejectCode, defaultPolicyRejectMessage)
+	case "tempfail":
+		policyAction.Type = PolicyTempFail
+		err = policyReplyDefaults(policyAction, defaultPolicyTempFailCode, defaultPolicyTempFailMessage)
+	case "quarantine":
+		policyAction.Type = PolicyQuarantine
+	case "add_header", "remove_header":
+		policyAction.Type = PolicyAddHeader
+		if actionType == "remove_header" {
+			policyAction.Type = PolicyRemoveHeader
+		}
+
+		if policyAction.Name == "" {
+			err = fmt.Errorf("%w: %s requires header name", ErrInvalidPolicyAction, actionType)
+		}
+	case "redirect":
+		policyAction.Type = PolicyRedirect
+
+		policyAction.Recipients, err = parseStringList(action["recipients"])
+		if err != nil || len(policyAction.Recipients) == 0 {
+			return nil, fmt.Errorf("%w: redirect requires recipients", ErrInvalidPolicyAction)
+		}
+
+		for _, recipient := range policyAction.Recipients {
+			if _, err = mail.ParseAddress(recipient); err != nil {
+				return nil, fmt.Errorf("%w: invalid redirect recipient: `%s`", ErrInvalidPolicyAction, recipient)
+			}
+		}
+	case "tag_subject":
+		policyAction.Type = PolicyTagSubject
+
+		if policyAction.Value == "" {
+			err = fmt.Errorf("%w: tag_subject requires value", ErrInvalidPolicyAction)
+		}
+	default:
+		err = fmt.Errorf("%w: `%s`", ErrInvalidPolicyAction, actionType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return policyAction, nil
+}
+
+// policyReplyDefaults fills missing reply, and makes sure the code matches the action: 5xx for reject, 4xx for
+// tempfail.
+func policyReplyDefaults(action *FilterPolicyAction, code int, message string) error {
+	if action.Code == 0 {
+		action.Code = code
+	}
+
+	if action.Message == "" {
+		action.Message = message
+	}
+
+	if action.Code/100 != code/100 { //nolint:gomnd
+		return fmt.Errorf("%w: invalid code %d", ErrInvalidPolicyAction, action.Code)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidFilterPolicyMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+filter:
+  policy:
+    enabled: true
+    rules_file: /etc/mailbowl/policy.yml
+    rules:
+      - name: password-reset
+        conditions:
+          - field: header:Subject
+            operator: matches
+            value: (?i)password reset
+          - field: sender
+            operator: matches
+            value: ^noreply@
+            negate: true
+          - field: size
+            operator: gt
+            value: 1024
+        actions:
+          - action: reject
+            code: 550
+            message: 5.7.1 not allowed
+      - match: any
+        stop: true
+        actions:
+          - action: tempfail
+          - action: add_header
+            name: X-Policy
+            value: checked
+          - action: redirect
+            recipients: [review@example.local]
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	policy := conf.Filter.Policy
+	assert.True(t, policy.Enabled)
+	assert.Equal(t, "/etc/mailbowl/policy.yml", policy.RulesFile)
+	assert.Len(t, policy.Rules, 2)
+
+	rule := policy.Rules[0]
+	assert.Equal(t, "password-reset", rule.Name)
+	assert.False(t, rule.MatchAny)
+	assert.False(t, rule.Stop)
+	assert.Len(t, rule.Conditions, 3)
+	assert.Equal(t, config.PolicyHeader, rule.Conditions[0].Field)
+	assert.Equal(t, "Subject", rule.Conditions[0].Header)
+	assert.Equal(t, config.PolicyMatches, rule.Conditions[0].Operator)
+	assert.True(t, rule.Conditions[0].Regexp.MatchString("Your Password Reset"))
+	assert.Equal(t, config.PolicySender, rule.Conditions[1].Field)
+	assert.True(t, rule.Conditions[1].Negate)
+	assert.Equal(t, config.PolicyGreaterThan, rule.Conditions[2].Operator)
+	assert.Equal(t, 1024, rule.Conditions[2].Number)
+	assert.Equal(t, []config.FilterPolicyAction{
+		{Type: config.PolicyReject, Code: 550, Message: "5.7.1 not allowed"},
+	}, rule.Actions)
+
+	rule = policy.Rules[1]
+	assert.Equal(t, "rule-2", rule.Name)
+	assert.True(t, rule.MatchAny)
+	assert.True(t, rule.Stop)
+	assert.Equal(t, []config.FilterPolicyAction{
+		{Type: config.PolicyTempFail, Code: 451, Message: "message temporarily rejected by policy, try again later"},
+		{Type: config.PolicyAddHeader, Name: "X-Policy", Value: "checked"},
+		{Type: config.PolicyRedirect, Recipients: []string{"review@example.local"}},
+	}, rule.Actions)
+}
+
+func TestValidFilterPolicyMarshalFromENV(t *testing.T) {
+	t.Setenv("FILTER_POLICY_ENABLED", "true")
+	t.Setenv("FILTER_POLICY_RULES_FILE", "/tmp/policy.yml")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.True(t, conf.Filter.Policy.Enabled)
+	assert.Equal(t, "/tmp/policy.yml", conf.Filter.Policy.RulesFile)
+	assert.Equal(t, []config.FilterPolicyRule{}, conf.Filter.Policy.Rules)
+}
+
+func TestInvalidFilterPolicyRules(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"- actions: []":                                    "rule #1: invalid policy rule: at least one action is required",
+		"- actions: [{action: explode}]":                   "rule #1: invalid policy action: `explode`",
+		"- actions: [{action: reject, code: 451}]":         "rule #1: invalid policy action: invalid code 451",
+		"- actions: [{action: tempfail, code: 550}]":       "rule #1: invalid policy action: invalid code 550",
+		"- actions: [{action: add_header}]":                "rule #1: invalid policy action: add_header requires header name",
+		"- actions: [{action: redirect}]":                  "rule #1: invalid policy action: redirect requires recipients",
+		"- {match: some, actions: [{action: quarantine}]}": "rule #1: invalid policy rule: invalid match: `some`",
+		"- {conditions: [{field: cc, operator: exists}], actions: [{action: quarantine}]}": "rule #1: " +
+			"invalid policy field: `cc`",
+		"- {conditions: [{field: sender, operator: like}], actions: [{action: quarantine}]}": "rule #1: " +
+			"invalid policy operator: `like`",
+		"- {conditions: [{field: sender, operator: gt, value: 1}], actions: [{action: quarantine}]}": "rule #1: " +
+			"invalid policy operator: `gt` can be used with size only",
+		"- {conditions: [{field: size, operator: gt, value: big}], actions: [{action: quarantine}]}": "rule #1: " +
+			"invalid policy rule: size must be a number: `big`",
+		"- {conditions: [{field: body, operator: matches, value: '('}], actions: [{action: quarantine}]}": "rule #1: " +
+			"invalid policy rule: invalid regular expression: `(`",
+	}
+
+	for rules, expected := range cases {
+		yamlExample := "---\nfilter:\n  policy:\n    rules:\n    " + rules + "\n"
+
+		viperConfig := viper.New()
+		_, err := InitConfig(viperConfig, yamlExample)
+
+		assert.EqualError(
+			t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+				"* error decoding 'Filter': invalid filter.policy.rules: "+expected,
+			rules,
+		)
+	}
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+type SMTPReceived int
+
+const (
+	ReceivedFull SMTPReceived = iota
+	ReceivedAnonymize
+	ReceivedOmit
+)
+
+var ErrInvalidReceived = errors.New("invalid received mode")
+
+// SMTPPrivacy controls headers revealing our hosts and clients to recipients. Received selects how our own trace
+// field is added, StripReceived lists networks of internal hops, whose Received fields are removed, and
+// RemoveHeaders lists client identifying fields (like X-Originating-IP or User-Agent) to remove. Listeners can
+// override them in smtp.listen.
+type SMTPPrivacy struct {
+	Received      SMTPReceived
+	StripReceived []string
+	RemoveHeaders []string
+}
+
+func buildSMTPPrivacy(privacyInterface interface{}) (*SMTPPrivacy, error) {
+	var (
+		privacy map[string]interface{}
+		ok      bool
+	)
+
+	if privacy, ok = privacyInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	smtpPrivacy, err := buildPrivacySettings(privacy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp.privacy: %w", err)
+	}
+
+	return smtpPrivacy, nil
+}
+
+func buildPrivacySettings(data map[string]interface{}) (*SMTPPrivacy, error) {
+	var err error
+
+	settings := &SMTPPrivacy{}
+
+	if received, ok := data["received"]; ok {
+		if settings.Received, err = buildReceived(received); err != nil {
+			return nil, err
+		}
+	}
+
+	if stripReceived, ok := data["strip_received"]; ok {
+		if settings.StripReceived, err = parseStringList(stripReceived); err != nil {
+			return nil, err
+		}
+
+		for _, cidr := range settings.StripReceived {
+			if _, _, err = net.ParseCIDR(cidr); err != nil {
+				return nil, fmt.Errorf("strip_received: %w", err)
+			}
+		}
+	}
+
+	if removeHeaders, ok := data["remove_headers"]; ok {
+		if settings.RemoveHeaders, err = parseStringList(removeHeaders); err != nil {
+			return nil, err
+		}
+	}
+
+	return settings, nil
+}
+
+func buildReceived(receivedInterface interface{}) (SMTPReceived, error) {
+	received, ok := receivedInterface.(string)
+	if !ok {
+		return -1, ErrUnserializing
+	}
+
+	switch received {
+	case "full":
+		return ReceivedFull, nil
+	case "anonymize":
+		return ReceivedAnonymize, nil
+	case "omit":
+		return ReceivedOmit, nil
+	}
+
+	return -1, fmt.Errorf("%w: `%s`", ErrInvalidReceived, received)
+}
+
+// listenerKey normalizes listen URI, so it can be compared with the listener address.
+func listenerKey(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme == "" {
+		return uri
+	}
+
+	// unix sockets (unix:///path, lmtp:///path) are identified by the path
+	if parsed.Host == "" {
+		return fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Path)
+	}
+
+	return fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Host)
+}
+
+// stringMap converts map decoded from YAML list item, to the form used by viper for top level keys.
+func stringMap(value interface{}) (map[string]interface{}, bool) {
+	switch mapValue := value.(type) {
+	case map[string]interface{}:
+		return mapValue, true
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(mapValue))
+
+		for key, item := range mapValue {
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, false
+			}
+
+			converted[keyString] = item
+		}
+
+		return converted, true
+	}
+
+	return nil, false
+}
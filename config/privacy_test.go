@@ -0,0 +1,84 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidPrivacyMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+smtp:
+  listen:
+    - uri: plain://127.0.0.1:10025
+      privacy:
+        received: full
+        remove_headers: []
+    - uri: tls://0.0.0.0:10465
+      privacy:
+        received: omit
+    - starttls://0.0.0.0:10587
+  privacy:
+    received: anonymize
+    strip_received:
+      - 10.0.0.0/8
+    remove_headers:
+      - X-Originating-IP
+      - User-Agent
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	privacy := conf.SMTP.Privacy
+	assert.Equal(t, config.ReceivedAnonymize, privacy.Received)
+	assert.Equal(t, []string{"10.0.0.0/8"}, privacy.StripReceived)
+	assert.Equal(t, []string{"X-Originating-IP", "User-Agent"}, privacy.RemoveHeaders)
+
+	assert.Equal(t, config.SMTPPrivacy{
+		Received: config.ReceivedFull, StripReceived: []string{"10.0.0.0/8"}, RemoveHeaders: []string{},
+	}, conf.SMTP.ForListener("plain://127.0.0.1:10025").Privacy)
+	assert.Equal(t, config.SMTPPrivacy{
+		Received:      config.ReceivedOmit,
+		StripReceived: []string{"10.0.0.0/8"},
+		RemoveHeaders: []string{"X-Originating-IP", "User-Agent"},
+	}, conf.SMTP.ForListener("tls://0.0.0.0:10465").Privacy)
+	assert.Equal(t, privacy, conf.SMTP.ForListener("starttls://0.0.0.0:10587").Privacy)
+}
+
+func TestValidPrivacyMarshalFromENV(t *testing.T) {
+	t.Setenv("SMTP_PRIVACY_RECEIVED", "omit")
+	t.Setenv("SMTP_PRIVACY_STRIP_RECEIVED", "10.0.0.0/8 192.168.0.0/16")
+	t.Setenv("SMTP_PRIVACY_REMOVE_HEADERS", "X-Mailer User-Agent")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.ReceivedOmit, conf.SMTP.Privacy.Received)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, conf.SMTP.Privacy.StripReceived)
+	assert.Equal(t, []string{"X-Mailer", "User-Agent"}, conf.SMTP.Privacy.RemoveHeaders)
+}
+
+func TestInvalidPrivacy(t *testing.T) {
+	t.Parallel()
+
+	_, err := InitConfig(viper.New(), "---\nsmtp:\n  privacy:\n    received: hidden\n")
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n* error decoding 'SMTP': "+
+			"invalid smtp.privacy: invalid received mode: `hidden`",
+	)
+
+	_, err = InitConfig(viper.New(), "---\nsmtp:\n  listen:\n    - uri: plain://0.0.0.0:25\n      privacy:\n"+
+		"        received: hidden\n")
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n* error decoding 'SMTP': "+
+			"invalid smtp.listen: listener #1: privacy: invalid received mode: `hidden`",
+	)
+}
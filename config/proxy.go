@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+type SMTPProxyMode int
+
+const (
+	ProxyNone SMTPProxyMode = iota
+	ProxyAccept
+	ProxyRequire
+)
+
+var ErrInvalidProxyMode = errors.New("invalid proxy protocol mode")
+
+// SMTPProxy configures PROXY protocol (v1 and v2) headers, sent by load balancers in front of listeners. Headers are
+// read only from TrustedProxies, in Accept mode they are optional, in Require mode connections without them, or
+// from other sources, are refused. Listeners can override them in smtp.listen.
+type SMTPProxy struct {
+	Mode           SMTPProxyMode
+	TrustedProxies []string
+	HeaderTimeout  time.Duration
+}
+
+func buildSMTPProxy(proxyInterface interface{}) (*SMTPProxy, error) {
+	var (
+		proxy map[string]interface{}
+		ok    bool
+	)
+
+	if proxy, ok = proxyInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	smtpProxy, err := buildProxySettings(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp.proxy: %w", err)
+	}
+
+	return smtpProxy, nil
+}
+
+func buildProxySettings(data map[string]interface{}) (*SMTPProxy, error) {
+	var err error
+
+	settings := &SMTPProxy{}
+
+	if mode, ok := data["mode"]; ok {
+		if settings.Mode, err = buildProxyMode(mode); err != nil {
+			return nil, err
+		}
+	}
+
+	if trustedProxies, ok := data["trusted_proxies"]; ok {
+		if settings.TrustedProxies, err = parseStringList(trustedProxies); err != nil {
+			return nil, err
+		}
+
+		for _, cidr := range settings.TrustedProxies {
+			if _, _, err = net.ParseCIDR(cidr); err != nil {
+				return nil, fmt.Errorf("trusted_proxies: %w", err)
+			}
+		}
+	}
+
+	if headerTimeout, ok := data["header_timeout"]; ok {
+		timeout, ok := headerTimeout.(string)
+		if !ok {
+			return nil, ErrUnserializing
+		}
+
+		if settings.HeaderTimeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("header_timeout: %w", err)
+		}
+	}
+
+	if settings.Mode != ProxyNone && len(settings.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("%w: trusted_proxies are required, when proxy protocol is enabled", ErrInvalidProxyMode)
+	}
+
+	return settings, nil
+}
+
+func buildProxyMode(modeInterface interface{}) (SMTPProxyMode, error) {
+	mode, ok := modeInterface.(string)
+	if !ok {
+		return -1, ErrUnserializing
+	}
+
+	switch mode {
+	case "none":
+		return ProxyNone, nil
+	case "accept":
+		return ProxyAccept, nil
+	case "require":
+		return ProxyRequire, nil
+	}
+
+	return -1, fmt.Errorf("%w: `%s`", ErrInvalidProxyMode, mode)
+}
@@ -0,0 +1,86 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidSMTPProxyMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+smtp:
+  listen:
+    - uri: plain://0.0.0.0:10025
+      proxy:
+        mode: none
+    - uri: tls://0.0.0.0:10465
+      proxy:
+        mode: require
+        trusted_proxies:
+          - 192.168.1.10/32
+    - starttls://0.0.0.0:10587
+  proxy:
+    mode: accept
+    trusted_proxies:
+      - 10.0.0.0/8
+    header_timeout: 2s
+`
+
+	conf, err := InitConfig(viper.New(), yamlExample)
+	assert.NoError(t, err)
+
+	proxy := conf.SMTP.Proxy
+	assert.Equal(t, config.ProxyAccept, proxy.Mode)
+	assert.Equal(t, []string{"10.0.0.0/8"}, proxy.TrustedProxies)
+	assert.Equal(t, 2*time.Second, proxy.HeaderTimeout)
+
+	assert.Equal(t, config.SMTPProxy{
+		Mode: config.ProxyNone, TrustedProxies: []string{"10.0.0.0/8"}, HeaderTimeout: 2 * time.Second,
+	}, conf.SMTP.ForListener("plain://0.0.0.0:10025").Proxy)
+	assert.Equal(t, config.SMTPProxy{
+		Mode: config.ProxyRequire, TrustedProxies: []string{"192.168.1.10/32"}, HeaderTimeout: 2 * time.Second,
+	}, conf.SMTP.ForListener("tls://0.0.0.0:10465").Proxy)
+	assert.Equal(t, proxy, conf.SMTP.ForListener("starttls://0.0.0.0:10587").Proxy)
+}
+
+func TestValidSMTPProxyMarshalFromENV(t *testing.T) {
+	t.Setenv("SMTP_PROXY_MODE", "require")
+	t.Setenv("SMTP_PROXY_TRUSTED_PROXIES", "10.0.0.1/32 10.0.0.2/32")
+
+	conf, err := InitConfig(viper.New())
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.ProxyRequire, conf.SMTP.Proxy.Mode)
+	assert.Equal(t, []string{"10.0.0.1/32", "10.0.0.2/32"}, conf.SMTP.Proxy.TrustedProxies)
+	assert.Equal(t, 5*time.Second, conf.SMTP.Proxy.HeaderTimeout)
+}
+
+func TestInvalidSMTPProxy(t *testing.T) {
+	t.Parallel()
+
+	_, err := InitConfig(viper.New(), "---\nsmtp:\n  proxy:\n    mode: always\n")
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n* error decoding 'SMTP': "+
+			"invalid smtp.proxy: invalid proxy protocol mode: `always`",
+	)
+
+	_, err = InitConfig(viper.New(), "---\nsmtp:\n  proxy:\n    mode: require\n")
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n* error decoding 'SMTP': "+
+			"invalid smtp.proxy: invalid proxy protocol mode: trusted_proxies are required, "+
+			"when proxy protocol is enabled",
+	)
+
+	_, err = InitConfig(viper.New(), "---\nsmtp:\n  listen:\n    - uri: plain://0.0.0.0:25\n      proxy:\n"+
+		"        mode: accept\n")
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n* error decoding 'SMTP': "+
+			"invalid smtp.listen: listener #1: proxy: invalid proxy protocol mode: trusted_proxies are required, "+
+			"when proxy protocol is enabled",
+	)
+}
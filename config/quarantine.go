@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Quarantine configures where held messages are stored and for how long. Retention of 0 keeps them forever.
+type Quarantine struct {
+	Directory string
+	Retention time.Duration
+}
+
+func QuarantineHook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{}) (interface{}, error) {
+	var (
+		data      map[string]interface{}
+		retention string
+		ok        bool
+		err       error
+	)
+
+	if dataType.Kind() != reflect.Map {
+		return rawData, nil
+	}
+
+	if targetDataType != reflect.TypeOf(Quarantine{}) {
+		return rawData, nil
+	}
+
+	if data, ok = rawData.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	quarantine := Quarantine{}
+
+	if quarantine.Directory, ok = data["directory"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if retention, ok = data["retention"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if quarantine.Retention, err = time.ParseDuration(retention); err != nil {
+		return nil, fmt.Errorf("invalid quarantine.retention: `%s`: %w", retention, err)
+	}
+
+	return quarantine, nil
+}
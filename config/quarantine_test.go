@@ -0,0 +1,52 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidQuarantineMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+quarantine:
+  directory: /var/lib/mailbowl/quarantine
+  retention: 168h
+`
+
+	viperConfig := viper.New()
+
+	conf, err := InitConfig(viperConfig, yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/var/lib/mailbowl/quarantine", conf.Quarantine.Directory)
+	assert.Equal(t, 168*time.Hour, conf.Quarantine.Retention)
+}
+
+func TestValidQuarantineMarshalFromENV(t *testing.T) {
+	t.Setenv("QUARANTINE_DIRECTORY", "/tmp/quarantine")
+	t.Setenv("QUARANTINE_RETENTION", "0")
+
+	viperConfig := viper.New()
+	conf, err := InitConfig(viperConfig)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/tmp/quarantine", conf.Quarantine.Directory)
+	assert.Equal(t, time.Duration(0), conf.Quarantine.Retention)
+}
+
+func TestInvalidQuarantineRetention(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("quarantine.retention", "30d")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Quarantine': invalid quarantine.retention: `30d`: time: unknown unit \"d\" in duration \"30d\"",
+	)
+}
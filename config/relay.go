@@ -7,6 +7,8 @@ import (
 	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type (
@@ -29,6 +31,7 @@ const (
 var (
 	ErrInvalidAuthMethod     = errors.New("invalid auth method")
 	ErrInvalidConnectionType = errors.New("invalid address protocol")
+	ErrUnknownRoute          = errors.New("unknown relay route")
 )
 
 type RelayOutgoingServer struct {
@@ -40,10 +43,38 @@ type RelayOutgoingServer struct {
 	Port           int
 	Username       string
 	VerifyTLS      bool
+	TLSPolicy      RelayTLSPolicy
 }
 
+type RelayTLSPolicy struct {
+	MTASTS    bool
+	DANE      bool
+	DNSServer string
+	Timeout   time.Duration
+}
+
+// Relay configures the outgoing server. Routes are named alternative outgoing servers, which listeners can send
+// messages through instead.
 type Relay struct {
+	Encryption     RelayEncryption
 	OutgoingServer RelayOutgoingServer
+	Routes         map[string]RelayOutgoingServer
+}
+
+// ForRoute returns settings with outgoing server of given route, empty name is the default one.
+func (r Relay) ForRoute(name string) (Relay, error) {
+	if name == "" {
+		return r, nil
+	}
+
+	outgoingServer, ok := r.Routes[name]
+	if !ok {
+		return Relay{}, fmt.Errorf("%w: `%s`", ErrUnknownRoute, name)
+	}
+
+	r.OutgoingServer = outgoingServer
+
+	return r, nil
 }
 
 func RelayHook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{}) (interface{}, error) {
@@ -69,8 +100,20 @@ func RelayHook(dataType reflect.Type, targetDataType reflect.Type, rawData inter
 		return nil, fmt.Errorf("%w", err)
 	}
 
+	relayEncryption, err := buildRelayEncryption(data["encryption"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	relayRoutes, err := buildRelayRoutes(data["routes"], data["outgoing_server"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay.routes: %w", err)
+	}
+
 	relayConfig := Relay{
+		Encryption:     *relayEncryption,
 		OutgoingServer: *relayOutgoingServer,
+		Routes:         relayRoutes,
 	}
 
 	return relayConfig, nil
@@ -134,9 +177,64 @@ func buildOutgoingServer(outgoingServerMap interface{}) (relayOutgoingServer *Re
 		return nil, ErrUnserializing
 	}
 
+	tlsPolicy, err := buildTLSPolicy(outgoingServer["tls_policy"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	relayOutgoingServer.TLSPolicy = *tlsPolicy
+
 	return relayOutgoingServer, nil
 }
 
+func buildTLSPolicy(tlsPolicyInterface interface{}) (*RelayTLSPolicy, error) {
+	var (
+		tlsPolicy map[string]interface{}
+		timeout   string
+		ok        bool
+		err       error
+	)
+
+	if tlsPolicy, ok = tlsPolicyInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	relayTLSPolicy := &RelayTLSPolicy{}
+
+	switch mtaSTS := tlsPolicy["mta_sts"].(type) {
+	case bool:
+		relayTLSPolicy.MTASTS = mtaSTS
+	case string:
+		relayTLSPolicy.MTASTS = parseBoolString(mtaSTS)
+	default:
+		return nil, ErrUnserializing
+	}
+
+	switch dane := tlsPolicy["dane"].(type) {
+	case bool:
+		relayTLSPolicy.DANE = dane
+	case string:
+		relayTLSPolicy.DANE = parseBoolString(dane)
+	default:
+		return nil, ErrUnserializing
+	}
+
+	if relayTLSPolicy.DNSServer, ok = tlsPolicy["dns_server"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if timeout, ok = tlsPolicy["timeout"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	relayTLSPolicy.Timeout, err = time.ParseDuration(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tls_policy.timeout: `%s`: %w", timeout, err)
+	}
+
+	return relayTLSPolicy, nil
+}
+
 func buildAuthMethod(authMethod string) (RelayAuthMethod, error) {
 	switch authMethod {
 	case "none":
@@ -223,3 +321,38 @@ func buildProtoHostPortFromData(relayOutgoingServer *RelayOutgoingServer, data m
 
 	return nil
 }
+
+// buildRelayRoutes parses named outgoing servers, missing settings are taken from relay.outgoing_server.
+func buildRelayRoutes(routesInterface interface{}, defaults interface{}) (map[string]RelayOutgoingServer, error) {
+	routes := make(map[string]RelayOutgoingServer)
+
+	switch routesDecoded := routesInterface.(type) {
+	case []interface{}:
+		for i, routeInterface := range routesDecoded {
+			route, ok := stringMap(routeInterface)
+			if !ok {
+				return nil, fmt.Errorf("route #%d: %w", i+1, ErrUnserializing)
+			}
+
+			name, ok := route["name"].(string)
+			if !ok || name == "" {
+				return nil, fmt.Errorf("route #%d: missing name: %w", i+1, ErrUnserializing)
+			}
+
+			outgoingServer, err := buildOutgoingServer(mergeSection(defaults, route["outgoing_server"]))
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", name, err)
+			}
+
+			routes[name] = *outgoingServer
+		}
+	case string:
+		if strings.TrimSpace(routesDecoded) != "" {
+			return nil, ErrUnserializing
+		}
+	default:
+		return nil, ErrUnserializing
+	}
+
+	return routes, nil
+}
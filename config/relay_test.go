@@ -2,6 +2,7 @@ package config_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/ajgon/mailbowl/config"
 	"github.com/spf13/viper"
@@ -22,6 +23,10 @@ func TestValidRelayMarshalFromObjects(t *testing.T) {
 	viperConfig.Set("relay.outgoing_server.password", "secret")
 	viperConfig.Set("relay.outgoing_server.username", "user@example.local")
 	viperConfig.Set("relay.outgoing_server.verify_tls", true)
+	viperConfig.Set("relay.outgoing_server.tls_policy.mta_sts", true)
+	viperConfig.Set("relay.outgoing_server.tls_policy.dane", true)
+	viperConfig.Set("relay.outgoing_server.tls_policy.dns_server", "127.0.0.1:5353")
+	viperConfig.Set("relay.outgoing_server.tls_policy.timeout", "30s")
 	conf, err := InitConfig(viperConfig)
 	assert.NoError(t, err)
 
@@ -33,6 +38,10 @@ func TestValidRelayMarshalFromObjects(t *testing.T) {
 	assert.Equal(t, "secret", conf.Relay.OutgoingServer.Password)
 	assert.Equal(t, "user@example.local", conf.Relay.OutgoingServer.Username)
 	assert.True(t, conf.Relay.OutgoingServer.VerifyTLS)
+	assert.True(t, conf.Relay.OutgoingServer.TLSPolicy.MTASTS)
+	assert.True(t, conf.Relay.OutgoingServer.TLSPolicy.DANE)
+	assert.Equal(t, "127.0.0.1:5353", conf.Relay.OutgoingServer.TLSPolicy.DNSServer)
+	assert.Equal(t, 30*time.Second, conf.Relay.OutgoingServer.TLSPolicy.Timeout)
 }
 
 func TestValidRelayMarshalFromYAML(t *testing.T) {
@@ -50,6 +59,11 @@ relay:
     password: secretyaml
     username: useryaml@example.local
     verify_tls: true
+    tls_policy:
+      mta_sts: true
+      dane: false
+      dns_server: 10.0.0.53
+      timeout: 5s
 `
 
 	viperConfig := viper.New()
@@ -65,6 +79,10 @@ relay:
 	assert.Equal(t, "secretyaml", conf.Relay.OutgoingServer.Password)
 	assert.Equal(t, "useryaml@example.local", conf.Relay.OutgoingServer.Username)
 	assert.True(t, conf.Relay.OutgoingServer.VerifyTLS)
+	assert.True(t, conf.Relay.OutgoingServer.TLSPolicy.MTASTS)
+	assert.False(t, conf.Relay.OutgoingServer.TLSPolicy.DANE)
+	assert.Equal(t, "10.0.0.53", conf.Relay.OutgoingServer.TLSPolicy.DNSServer)
+	assert.Equal(t, 5*time.Second, conf.Relay.OutgoingServer.TLSPolicy.Timeout)
 }
 
 func TestValidRelayMarshalFromENV(t *testing.T) {
@@ -77,6 +95,10 @@ func TestValidRelayMarshalFromENV(t *testing.T) {
 	t.Setenv("RELAY_OUTGOING_SERVER_PASSWORD", "secretenv")
 	t.Setenv("RELAY_OUTGOING_SERVER_USERNAME", "userenv@example.local")
 	t.Setenv("RELAY_OUTGOING_SERVER_VERIFY_TLS", "true")
+	t.Setenv("RELAY_OUTGOING_SERVER_TLS_POLICY_MTA_STS", "false")
+	t.Setenv("RELAY_OUTGOING_SERVER_TLS_POLICY_DANE", "true")
+	t.Setenv("RELAY_OUTGOING_SERVER_TLS_POLICY_DNS_SERVER", "10.0.0.1")
+	t.Setenv("RELAY_OUTGOING_SERVER_TLS_POLICY_TIMEOUT", "1m")
 
 	viperConfig := viper.New()
 	conf, err := InitConfig(viperConfig)
@@ -90,6 +112,10 @@ func TestValidRelayMarshalFromENV(t *testing.T) {
 	assert.Equal(t, "secretenv", conf.Relay.OutgoingServer.Password)
 	assert.Equal(t, "userenv@example.local", conf.Relay.OutgoingServer.Username)
 	assert.True(t, conf.Relay.OutgoingServer.VerifyTLS)
+	assert.False(t, conf.Relay.OutgoingServer.TLSPolicy.MTASTS)
+	assert.True(t, conf.Relay.OutgoingServer.TLSPolicy.DANE)
+	assert.Equal(t, "10.0.0.1", conf.Relay.OutgoingServer.TLSPolicy.DNSServer)
+	assert.Equal(t, time.Minute, conf.Relay.OutgoingServer.TLSPolicy.Timeout)
 }
 
 func TestValidRelayHostPort(t *testing.T) {
@@ -132,3 +158,16 @@ func TestInvalidAddress(t *testing.T) {
 			"* error decoding 'Relay': invalid address: missing port in address",
 	)
 }
+
+func TestInvalidTLSPolicyTimeout(t *testing.T) {
+	t.Parallel()
+
+	viperConfig := viper.New()
+	viperConfig.Set("relay.outgoing_server.tls_policy.timeout", "wrong")
+	_, err := InitConfig(viperConfig)
+
+	assert.EqualError(
+		t, err, "error unmarshaling config: 1 error(s) decoding:\n\n"+
+			"* error decoding 'Relay': invalid tls_policy.timeout: `wrong`: time: invalid duration \"wrong\"",
+	)
+}
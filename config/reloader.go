@@ -1,20 +1,38 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
-	"github.com/Masterminds/log-go"
+	"github.com/spf13/viper"
 )
 
-func Reload() {
-	err := ConfigureLogger(Get().Log, os.Stdout, os.Stderr)
-	handleReloadError(err)
-}
+// Load reads the configuration file again and returns the new configuration, without using it yet, so everything
+// depending on it can be validated first. Current configuration is kept when the file is invalid.
+func Load() (Config, error) {
+	var notFound viper.ConfigFileNotFoundError
 
-func handleReloadError(err error) {
-	if err == nil {
-		return
+	if err := viper.ReadInConfig(); err != nil && !errors.As(err, &notFound) {
+		return Config{}, fmt.Errorf("problem reading config file `%s`: %w", viper.ConfigFileUsed(), err)
 	}
 
-	log.Errorf("problem reloading configuration: %s", err.Error())
+	loaded := Config{}
+
+	if err := viper.Unmarshal(&loaded, viper.DecodeHook(Hook)); err != nil {
+		return Config{}, fmt.Errorf("error loading config: %w", err)
+	}
+
+	if _, err := BuildLogger(loaded.Log, os.Stdout, os.Stderr); err != nil {
+		return Config{}, fmt.Errorf("error configuring logger: %w", err)
+	}
+
+	return loaded, nil
+}
+
+// Apply makes loaded configuration the current one, and reconfigures the logger.
+func Apply(conf Config) error {
+	config = conf
+
+	return ConfigureLogger(conf.Log, os.Stdout, os.Stderr)
 }
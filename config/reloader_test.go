@@ -0,0 +1,44 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAndApply(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "mailbowl.yml")
+	assert.NoError(t, os.WriteFile(configFile, []byte("---\nsmtp:\n  hostname: first.local\n"), 0o600))
+
+	config.CobraInitialize(configFile)
+	defer viper.Reset()
+
+	assert.Equal(t, "first.local", config.Get().SMTP.Hostname)
+
+	assert.NoError(t, os.WriteFile(configFile, []byte("---\nsmtp:\n  hostname: second.local\n"), 0o600))
+
+	conf, err := config.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "second.local", conf.SMTP.Hostname)
+
+	// loaded configuration is not used until applied
+	assert.Equal(t, "first.local", config.Get().SMTP.Hostname)
+	assert.NoError(t, config.Apply(conf))
+	assert.Equal(t, "second.local", config.Get().SMTP.Hostname)
+
+	assert.NoError(t, os.WriteFile(configFile, []byte("---\nsmtp:\n  limit:\n    connections: many\n"), 0o600))
+
+	_, err = config.Load()
+	assert.Error(t, err)
+	assert.Equal(t, "second.local", config.Get().SMTP.Hostname)
+
+	assert.NoError(t, os.WriteFile(configFile, []byte("---\nsmtp: [\n"), 0o600))
+
+	_, err = config.Load()
+	assert.Error(t, err)
+	assert.Equal(t, "second.local", config.Get().SMTP.Hostname)
+}
@@ -2,8 +2,6 @@ package config
 
 import (
 	"fmt"
-	"net"
-	"net/url"
 	"reflect"
 	"strconv"
 	"strings"
@@ -26,23 +24,30 @@ type SMTPLimit struct {
 	Recipients  int
 }
 
-type SMTPListen struct {
-	Proto string
-	Host  string
-	Port  string
+// SMTPNormalize toggles fix-ups applied to accepted messages, before they are passed to filters and relayed.
+type SMTPNormalize struct {
+	AddDate         bool
+	AddMessageID    bool
+	FixLineEndings  bool
+	FoldHeaders     bool
+	RejectMalformed bool
 }
 
 type SMTPTimeout struct {
 	Read  time.Duration
 	Write time.Duration
 	Data  time.Duration
+	Drain time.Duration
 }
 
+// SMTPTLS configures listener certificate. With ACME set, certificate obtained from ACME CA is used instead of the
+// configured one.
 type SMTPTLS struct {
 	Key              string
 	Certificate      string
 	KeyFile          string
 	CertificateFile  string
+	ACME             bool
 	ForceForStartTLS bool
 }
 
@@ -51,8 +56,13 @@ type SMTP struct {
 	Hostname  string
 	Limit     SMTPLimit
 	Listen    []SMTPListen
+	Normalize SMTPNormalize
+	Privacy   SMTPPrivacy
+	Proxy     SMTPProxy
+	SMTPUTF8  bool
 	Timeout   SMTPTimeout
 	TLS       SMTPTLS
+	Unix      SMTPUnix
 	Whitelist []string
 }
 
@@ -85,7 +95,17 @@ func SMTPHook(dataType reflect.Type, targetDataType reflect.Type, rawData interf
 		return nil, fmt.Errorf("%w", err)
 	}
 
-	smtpListen, err := buildSMTPListen(data["listen"])
+	smtpNormalize, err := buildSMTPNormalize(data["normalize"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	smtpPrivacy, err := buildSMTPPrivacy(data["privacy"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	smtpProxy, err := buildSMTPProxy(data["proxy"])
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
@@ -100,6 +120,11 @@ func SMTPHook(dataType reflect.Type, targetDataType reflect.Type, rawData interf
 		return nil, fmt.Errorf("%w", err)
 	}
 
+	smtpUnix, err := buildSMTPUnix(data["unix"])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
 	smtpWhitelist, err := buildSMTPWhitelist(data["whitelist"])
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
@@ -108,16 +133,33 @@ func SMTPHook(dataType reflect.Type, targetDataType reflect.Type, rawData interf
 	smtp := SMTP{
 		Auth:      *smtpAuth,
 		Limit:     *smtpLimit,
-		Listen:    smtpListen,
+		Normalize: *smtpNormalize,
+		Privacy:   *smtpPrivacy,
+		Proxy:     *smtpProxy,
 		Timeout:   *smtpTimeout,
 		TLS:       *smtpTLS,
+		Unix:      *smtpUnix,
 		Whitelist: smtpWhitelist,
 	}
 
+	// listeners are built last, as their overrides are applied on top of the settings above
+	if smtp.Listen, err = buildSMTPListen(data, smtp); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
 	if smtp.Hostname, ok = data["hostname"].(string); !ok {
 		return nil, ErrUnserializing
 	}
 
+	switch smtpUTF8 := data["smtputf8"].(type) {
+	case bool:
+		smtp.SMTPUTF8 = smtpUTF8
+	case string:
+		smtp.SMTPUTF8 = parseBoolString(smtpUTF8)
+	default:
+		return nil, ErrUnserializing
+	}
+
 	return smtp, nil
 }
 
@@ -256,56 +298,48 @@ func buildSMTPLimit(limitInterface interface{}) (*SMTPLimit, error) {
 	return smtpLimit, nil
 }
 
-func buildSMTPListen(urisInterface interface{}) ([]SMTPListen, error) {
+func buildSMTPNormalize(normalizeInterface interface{}) (*SMTPNormalize, error) {
 	var (
-		uris []string
-		ok   bool
+		normalize map[string]interface{}
+		ok        bool
+		err       error
 	)
 
-	switch urisDecoded := urisInterface.(type) {
-	case []string:
-		uris = urisDecoded
-	case []interface{}:
-		for _, uriDecoded := range urisDecoded {
-			var uri string
+	if normalize, ok = normalizeInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
 
-			if uri, ok = uriDecoded.(string); !ok {
-				return nil, ErrUnserializing
-			}
+	smtpNormalize := &SMTPNormalize{}
 
-			uris = append(uris, uri)
-		}
-	case string:
-		uris = strings.Split(urisDecoded, " ")
-	default:
-		return nil, ErrUnserializing
+	if smtpNormalize.AddDate, err = parseBool(normalize["add_date"]); err != nil {
+		return nil, err
 	}
 
-	smtpListen := make([]SMTPListen, 0)
+	if smtpNormalize.AddMessageID, err = parseBool(normalize["add_message_id"]); err != nil {
+		return nil, err
+	}
 
-	for _, uri := range uris {
-		url, err := url.Parse(uri)
-		if err != nil {
-			return nil, fmt.Errorf("%w", err)
-		}
+	if smtpNormalize.FixLineEndings, err = parseBool(normalize["fix_line_endings"]); err != nil {
+		return nil, err
+	}
 
-		host, port, err := net.SplitHostPort(url.Host)
-		if err != nil {
-			return nil, fmt.Errorf("%w", err)
-		}
+	if smtpNormalize.FoldHeaders, err = parseBool(normalize["fold_headers"]); err != nil {
+		return nil, err
+	}
 
-		smtpListen = append(smtpListen, SMTPListen{Proto: url.Scheme, Host: host, Port: port})
+	if smtpNormalize.RejectMalformed, err = parseBool(normalize["reject_malformed"]); err != nil {
+		return nil, err
 	}
 
-	return smtpListen, nil
+	return smtpNormalize, nil
 }
 
 func buildSMTPTimeout(timeoutInterface interface{}) (*SMTPTimeout, error) {
 	var (
-		readTimeout, writeTimeout, dataTimeout string
-		ok                                     bool
-		timeout                                map[string]interface{}
-		err                                    error
+		readTimeout, writeTimeout, dataTimeout, drainTimeout string
+		ok                                                   bool
+		timeout                                              map[string]interface{}
+		err                                                  error
 	)
 
 	smtpTimeout := &SMTPTimeout{}
@@ -341,6 +375,15 @@ func buildSMTPTimeout(timeoutInterface interface{}) (*SMTPTimeout, error) {
 		return nil, fmt.Errorf("invalid timeout.data: `%s`: %w", dataTimeout, err)
 	}
 
+	if drainTimeout, ok = timeout["drain"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	smtpTimeout.Drain, err = time.ParseDuration(drainTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout.drain: `%s`: %w", drainTimeout, err)
+	}
+
 	return smtpTimeout, nil
 }
 
@@ -348,6 +391,7 @@ func buildSMTPTLS(tlsInterface interface{}) (*SMTPTLS, error) {
 	var (
 		tls map[string]interface{}
 		ok  bool
+		err error
 	)
 
 	smtpTLS := &SMTPTLS{}
@@ -372,6 +416,10 @@ func buildSMTPTLS(tlsInterface interface{}) (*SMTPTLS, error) {
 		return nil, ErrUnserializing
 	}
 
+	if smtpTLS.ACME, err = parseBool(tls["acme"]); err != nil {
+		return nil, err
+	}
+
 	switch forceStartTLS := tls["force_for_starttls"].(type) {
 	case bool:
 		smtpTLS.ForceForStartTLS = forceStartTLS
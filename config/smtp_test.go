@@ -23,6 +23,12 @@ func TestValidSMTPMarshalFromObjects(t *testing.T) {
 	viperConfig.Set("smtp.limit.message_size", 2048)
 	viperConfig.Set("smtp.limit.recipients", 3072)
 	viperConfig.Set("smtp.listen", []string{"plain://0.0.0.0:25", "tls://192.168.0.0:465"})
+	viperConfig.Set("smtp.normalize.add_date", false)
+	viperConfig.Set("smtp.normalize.add_message_id", true)
+	viperConfig.Set("smtp.normalize.fix_line_endings", false)
+	viperConfig.Set("smtp.normalize.fold_headers", true)
+	viperConfig.Set("smtp.normalize.reject_malformed", false)
+	viperConfig.Set("smtp.smtputf8", false)
 	viperConfig.Set("smtp.timeout.read", "10s")
 	viperConfig.Set("smtp.timeout.write", "20s")
 	viperConfig.Set("smtp.timeout.data", "30s")
@@ -52,6 +58,12 @@ func TestValidSMTPMarshalFromObjects(t *testing.T) {
 	assert.Equal(t, "192.168.0.0", conf.SMTP.Listen[1].Host)
 	assert.Equal(t, "465", conf.SMTP.Listen[1].Port)
 	assert.Equal(t, 2, len(conf.SMTP.Listen))
+	assert.False(t, conf.SMTP.Normalize.AddDate)
+	assert.True(t, conf.SMTP.Normalize.AddMessageID)
+	assert.False(t, conf.SMTP.Normalize.FixLineEndings)
+	assert.True(t, conf.SMTP.Normalize.FoldHeaders)
+	assert.False(t, conf.SMTP.Normalize.RejectMalformed)
+	assert.False(t, conf.SMTP.SMTPUTF8)
 	assert.Equal(t, 10*time.Second, conf.SMTP.Timeout.Read)
 	assert.Equal(t, 20*time.Second, conf.SMTP.Timeout.Write)
 	assert.Equal(t, 30*time.Second, conf.SMTP.Timeout.Data)
@@ -83,10 +95,18 @@ smtp:
   listen:
     - tls://10.0.0.0:1465
     - starttls://172.12.0.0:1587
+  normalize:
+    add_date: true
+    add_message_id: false
+    fix_line_endings: true
+    fold_headers: false
+    reject_malformed: false
+  smtputf8: false
   timeout:
     read: 10m
     write: 20m
     data: 30m
+    drain: 40m
   tls:
     key: yaml-tls-key
     key_file: yaml-tls-keyfile
@@ -120,9 +140,16 @@ smtp:
 	assert.Equal(t, "172.12.0.0", conf.SMTP.Listen[1].Host)
 	assert.Equal(t, "1587", conf.SMTP.Listen[1].Port)
 	assert.Equal(t, 2, len(conf.SMTP.Listen))
+	assert.True(t, conf.SMTP.Normalize.AddDate)
+	assert.False(t, conf.SMTP.Normalize.AddMessageID)
+	assert.True(t, conf.SMTP.Normalize.FixLineEndings)
+	assert.False(t, conf.SMTP.Normalize.FoldHeaders)
+	assert.False(t, conf.SMTP.Normalize.RejectMalformed)
+	assert.False(t, conf.SMTP.SMTPUTF8)
 	assert.Equal(t, 10*time.Minute, conf.SMTP.Timeout.Read)
 	assert.Equal(t, 20*time.Minute, conf.SMTP.Timeout.Write)
 	assert.Equal(t, 30*time.Minute, conf.SMTP.Timeout.Data)
+	assert.Equal(t, 40*time.Minute, conf.SMTP.Timeout.Drain)
 	assert.Equal(t, "yaml-tls-key", conf.SMTP.TLS.Key)
 	assert.Equal(t, "yaml-tls-keyfile", conf.SMTP.TLS.KeyFile)
 	assert.Equal(t, "yaml-tls-certificate", conf.SMTP.TLS.Certificate)
@@ -139,9 +166,16 @@ func TestValidSMTPMarshalFromENV(t *testing.T) {
 	t.Setenv("SMTP_LIMIT_MESSAGE_SIZE", "12")
 	t.Setenv("SMTP_LIMIT_RECIPIENTS", "8")
 	t.Setenv("SMTP_LISTEN", "starttls://192.168.42.0:2587 plain://172.16.0.0:2025")
+	t.Setenv("SMTP_NORMALIZE_ADD_DATE", "false")
+	t.Setenv("SMTP_NORMALIZE_ADD_MESSAGE_ID", "false")
+	t.Setenv("SMTP_NORMALIZE_FIX_LINE_ENDINGS", "0")
+	t.Setenv("SMTP_NORMALIZE_FOLD_HEADERS", "true")
+	t.Setenv("SMTP_NORMALIZE_REJECT_MALFORMED", "1")
+	t.Setenv("SMTP_SMTPUTF8", "false")
 	t.Setenv("SMTP_TIMEOUT_READ", "10h")
 	t.Setenv("SMTP_TIMEOUT_WRITE", "20h")
 	t.Setenv("SMTP_TIMEOUT_DATA", "30h")
+	t.Setenv("SMTP_TIMEOUT_DRAIN", "40h")
 	t.Setenv("SMTP_TLS_KEY", "env-tls-key")
 	t.Setenv("SMTP_TLS_KEY_FILE", "env-tls-keyfile")
 	t.Setenv("SMTP_TLS_CERTIFICATE", "env-tls-certificate")
@@ -170,9 +204,16 @@ func TestValidSMTPMarshalFromENV(t *testing.T) {
 	assert.Equal(t, "172.16.0.0", conf.SMTP.Listen[1].Host)
 	assert.Equal(t, "2025", conf.SMTP.Listen[1].Port)
 	assert.Equal(t, 2, len(conf.SMTP.Listen))
+	assert.False(t, conf.SMTP.Normalize.AddDate)
+	assert.False(t, conf.SMTP.Normalize.AddMessageID)
+	assert.False(t, conf.SMTP.Normalize.FixLineEndings)
+	assert.True(t, conf.SMTP.Normalize.FoldHeaders)
+	assert.True(t, conf.SMTP.Normalize.RejectMalformed)
+	assert.False(t, conf.SMTP.SMTPUTF8)
 	assert.Equal(t, 10*time.Hour, conf.SMTP.Timeout.Read)
 	assert.Equal(t, 20*time.Hour, conf.SMTP.Timeout.Write)
 	assert.Equal(t, 30*time.Hour, conf.SMTP.Timeout.Data)
+	assert.Equal(t, 40*time.Hour, conf.SMTP.Timeout.Drain)
 	assert.Equal(t, "env-tls-key", conf.SMTP.TLS.Key)
 	assert.Equal(t, "env-tls-keyfile", conf.SMTP.TLS.KeyFile)
 	assert.Equal(t, "env-tls-certificate", conf.SMTP.TLS.Certificate)
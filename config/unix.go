@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// SMTPUnix configures unix socket listeners: socket file permissions and owner, and which local users may
+// connect. IP whitelist doesn't apply to them, instead when AllowedUIDs or AllowedGIDs are set, peer credentials
+// must match one of them.
+type SMTPUnix struct {
+	Mode        os.FileMode
+	Owner       string
+	Group       string
+	AllowedUIDs []int
+	AllowedGIDs []int
+}
+
+func buildSMTPUnix(unixInterface interface{}) (*SMTPUnix, error) {
+	var (
+		unix map[string]interface{}
+		ok   bool
+		err  error
+	)
+
+	if unix, ok = unixInterface.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	smtpUnix := &SMTPUnix{}
+
+	if smtpUnix.Mode, err = parseFileMode(unix["mode"]); err != nil {
+		return nil, fmt.Errorf("invalid smtp.unix.mode: %w", err)
+	}
+
+	if smtpUnix.Owner, ok = unix["owner"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if smtpUnix.Group, ok = unix["group"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if smtpUnix.AllowedUIDs, err = parseIntList(unix["allowed_uids"]); err != nil {
+		return nil, fmt.Errorf("invalid smtp.unix.allowed_uids: %w", err)
+	}
+
+	if smtpUnix.AllowedGIDs, err = parseIntList(unix["allowed_gids"]); err != nil {
+		return nil, fmt.Errorf("invalid smtp.unix.allowed_gids: %w", err)
+	}
+
+	return smtpUnix, nil
+}
+
+// parseFileMode accepts octal string (i.e. "0660"), or a number already decoded by YAML (0660).
+func parseFileMode(value interface{}) (os.FileMode, error) {
+	switch modeValue := value.(type) {
+	case int:
+		return os.FileMode(modeValue) & os.ModePerm, nil
+	case string:
+		parsed, err := strconv.ParseUint(modeValue, 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w", err)
+		}
+
+		return os.FileMode(parsed) & os.ModePerm, nil
+	}
+
+	return 0, ErrUnserializing
+}
+
+func parseIntList(value interface{}) ([]int, error) {
+	list := make([]int, 0)
+
+	switch listValue := value.(type) {
+	case []int:
+		list = append(list, listValue...)
+	case []interface{}:
+		for _, itemValue := range listValue {
+			item, err := parseInt(itemValue)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, item)
+		}
+	default:
+		items, err := parseStringList(value)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, itemValue := range items {
+			item, err := parseInt(itemValue)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, item)
+		}
+	}
+
+	return list, nil
+}
@@ -0,0 +1,57 @@
+package config_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidSMTPUnixMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+smtp:
+  listen:
+    - plain://0.0.0.0:25
+    - unix:///run/mailbowl/smtp.sock
+  unix:
+    mode: 0600
+    owner: mailbowl
+    group: mail
+    allowed_uids: [1000, 1001]
+    allowed_gids: [8]
+`
+
+	conf, err := InitConfig(viper.New(), yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "unix", conf.SMTP.Listen[1].Proto)
+	assert.Equal(t, "/run/mailbowl/smtp.sock", conf.SMTP.Listen[1].Path)
+	assert.Equal(t, os.FileMode(0o600), conf.SMTP.Unix.Mode)
+	assert.Equal(t, "mailbowl", conf.SMTP.Unix.Owner)
+	assert.Equal(t, "mail", conf.SMTP.Unix.Group)
+	assert.Equal(t, []int{1000, 1001}, conf.SMTP.Unix.AllowedUIDs)
+	assert.Equal(t, []int{8}, conf.SMTP.Unix.AllowedGIDs)
+}
+
+func TestValidSMTPUnixMarshalFromENV(t *testing.T) {
+	t.Setenv("SMTP_UNIX_MODE", "0640")
+	t.Setenv("SMTP_UNIX_ALLOWED_UIDS", "0 1000")
+
+	conf, err := InitConfig(viper.New())
+	assert.NoError(t, err)
+
+	assert.Equal(t, os.FileMode(0o640), conf.SMTP.Unix.Mode)
+	assert.Equal(t, []int{0, 1000}, conf.SMTP.Unix.AllowedUIDs)
+	assert.Equal(t, []int{}, conf.SMTP.Unix.AllowedGIDs)
+}
+
+func TestInvalidSMTPUnixMode(t *testing.T) {
+	t.Parallel()
+
+	_, err := InitConfig(viper.New(), "---\nsmtp:\n  unix:\n    mode: \"0999\"\n")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid smtp.unix.mode")
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Watch configures reloading on file changes. When enabled, the config file, and files it refers to (policy rules,
+// encryption keys) together with extra Files are watched, and configuration is reloaded the same way as on SIGHUP,
+// once they stop changing for Debounce. TLS keys and certificates are not included, as they are reloaded by SMTP
+// listeners themselves.
+type Watch struct {
+	Enabled  bool
+	Debounce time.Duration
+	Files    []string
+}
+
+func WatchHook(dataType reflect.Type, targetDataType reflect.Type, rawData interface{}) (interface{}, error) {
+	var (
+		data     map[string]interface{}
+		debounce string
+		ok       bool
+		err      error
+	)
+
+	if dataType.Kind() != reflect.Map {
+		return rawData, nil
+	}
+
+	if targetDataType != reflect.TypeOf(Watch{}) {
+		return rawData, nil
+	}
+
+	if data, ok = rawData.(map[string]interface{}); !ok {
+		return nil, ErrUnserializing
+	}
+
+	watch := Watch{}
+
+	if watch.Enabled, err = parseBool(data["enabled"]); err != nil {
+		return nil, err
+	}
+
+	if debounce, ok = data["debounce"].(string); !ok {
+		return nil, ErrUnserializing
+	}
+
+	if watch.Debounce, err = time.ParseDuration(debounce); err != nil {
+		return nil, fmt.Errorf("invalid watch.debounce: `%s`: %w", debounce, err)
+	}
+
+	if watch.Files, err = parseStringList(data["files"]); err != nil {
+		return nil, fmt.Errorf("invalid watch.files: %w", err)
+	}
+
+	return watch, nil
+}
+
+// WatchedFiles returns files referenced by the configuration, which are read again only on reload.
+func (c Config) WatchedFiles() []string {
+	files := make([]string, 0)
+	seen := make(map[string]bool)
+
+	add := func(paths ...string) {
+		for _, path := range paths {
+			if path == "" || seen[path] {
+				continue
+			}
+
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	add(c.Filter.Policy.RulesFile)
+
+	for _, key := range c.Relay.Encryption.Keys {
+		add(key.KeyFile)
+	}
+
+	add(c.Watch.Files...)
+
+	return files
+}
@@ -0,0 +1,89 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidWatchMarshalFromYAML(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+watch:
+  enabled: true
+  debounce: 5s
+  files:
+    - /etc/mailbowl/users.yml
+    - /etc/mailbowl/map.yml
+`
+
+	conf, err := InitConfig(viper.New(), yamlExample)
+	assert.NoError(t, err)
+
+	assert.True(t, conf.Watch.Enabled)
+	assert.Equal(t, 5*time.Second, conf.Watch.Debounce)
+	assert.Equal(t, []string{"/etc/mailbowl/users.yml", "/etc/mailbowl/map.yml"}, conf.Watch.Files)
+}
+
+func TestValidWatchMarshalFromENV(t *testing.T) {
+	t.Setenv("WATCH_ENABLED", "true")
+	t.Setenv("WATCH_DEBOUNCE", "500ms")
+	t.Setenv("WATCH_FILES", "/tmp/users.yml /tmp/map.yml")
+
+	conf, err := InitConfig(viper.New())
+	assert.NoError(t, err)
+
+	assert.True(t, conf.Watch.Enabled)
+	assert.Equal(t, 500*time.Millisecond, conf.Watch.Debounce)
+	assert.Equal(t, []string{"/tmp/users.yml", "/tmp/map.yml"}, conf.Watch.Files)
+}
+
+func TestInvalidWatchDebounce(t *testing.T) {
+	t.Parallel()
+
+	_, err := InitConfig(viper.New(), "---\nwatch:\n  debounce: soon\n")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid watch.debounce: `soon`")
+}
+
+func TestWatchedFiles(t *testing.T) {
+	t.Parallel()
+
+	yamlExample := `---
+filter:
+  policy:
+    rules_file: /etc/mailbowl/policy.yml
+relay:
+  encryption:
+    keys:
+      - email: alice@example.com
+        type: pgp
+        key_file: /etc/mailbowl/keys/alice.asc
+smtp:
+  listen:
+    - uri: tls://0.0.0.0:465
+      tls:
+        key_file: /etc/ssl/submission.key
+        certificate_file: /etc/ssl/submission.crt
+    - starttls://0.0.0.0:587
+  tls:
+    key_file: /etc/ssl/mailbowl.key
+    certificate_file: /etc/ssl/mailbowl.crt
+watch:
+  files:
+    - /etc/mailbowl/users.yml
+    - /etc/mailbowl/policy.yml
+`
+
+	conf, err := InitConfig(viper.New(), yamlExample)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"/etc/mailbowl/policy.yml",
+		"/etc/mailbowl/keys/alice.asc",
+		"/etc/mailbowl/users.yml",
+	}, conf.WatchedFiles())
+}
@@ -0,0 +1,249 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+	"golang.org/x/crypto/openpgp" //nolint:staticcheck
+)
+
+type Method int
+
+const (
+	MethodNone Method = iota
+	MethodPGP
+	MethodSMIME
+)
+
+var ErrEncryptionRequired = errors.New("encryption required, but recipient has no key")
+
+// Batch is a group of recipients, which receive the same copy of the message.
+type Batch struct {
+	Method     Method
+	Recipients []string
+	Keys       []*Key
+}
+
+// Encryptor decides which recipients get encrypted copies of the message, based on the keyring and per domain
+// policies, and encrypts the message into PGP/MIME (RFC 3156) or S/MIME (RFC 8551) form.
+type Encryptor struct {
+	Keyring  *Keyring
+	Policy   config.RelayEncryptionPolicy
+	Domains  map[string]config.RelayEncryptionPolicy
+	Disabled bool
+}
+
+func (m Method) String() string {
+	switch m {
+	case MethodPGP:
+		return "pgp"
+	case MethodSMIME:
+		return "smime"
+	case MethodNone:
+	}
+
+	return "none"
+}
+
+func NewEncryptor(conf config.RelayEncryption) (*Encryptor, error) {
+	keyring, err := NewKeyring(conf.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := make(map[string]config.RelayEncryptionPolicy)
+	disabled := conf.Policy == config.EncryptionNever
+
+	for _, domain := range conf.Domains {
+		domains[domain.Domain] = domain.Policy
+
+		if domain.Policy != config.EncryptionNever {
+			disabled = false
+		}
+	}
+
+	return &Encryptor{Keyring: keyring, Policy: conf.Policy, Domains: domains, Disabled: disabled}, nil
+}
+
+// Split groups recipients by the way their copy of the message has to be encrypted. Recipients without encryption
+// share a single batch, each encrypted one gets its own, so the copy doesn't reveal who else (i.e. Bcc recipients)
+// received it. ErrEncryptionRequired is returned if policy requires encryption for a recipient without a key, so
+// nothing is sent at all.
+func (e *Encryptor) Split(recipients []string) ([]*Batch, error) {
+	if e.Disabled {
+		return []*Batch{{Method: MethodNone, Recipients: recipients}}, nil
+	}
+
+	plain := &Batch{Method: MethodNone, Recipients: make([]string, 0)}
+	encrypted := make([]*Batch, 0)
+
+	for _, recipient := range recipients {
+		policy := e.policyFor(recipient)
+		key := e.Keyring.Get(recipient)
+
+		switch {
+		case policy == config.EncryptionNever || (key == nil && policy == config.EncryptionOpportunistic):
+			plain.Recipients = append(plain.Recipients, recipient)
+		case key == nil:
+			return nil, fmt.Errorf("%w: `%s`", ErrEncryptionRequired, recipient)
+		default:
+			method := MethodPGP
+			if key.Type == config.KeySMIME {
+				method = MethodSMIME
+			}
+
+			encrypted = append(encrypted, &Batch{Method: method, Recipients: []string{recipient}, Keys: []*Key{key}})
+		}
+	}
+
+	result := make([]*Batch, 0, len(encrypted)+1)
+
+	if len(plain.Recipients) > 0 {
+		result = append(result, plain)
+	}
+
+	return append(result, encrypted...), nil
+}
+
+// Encrypt returns copy of the message for given batch. Only content of the message is encrypted, header fields
+// other than Content-* stay in the clear, as they are needed for delivery.
+func (e *Encryptor) Encrypt(batch *Batch, data []byte) ([]byte, error) {
+	if batch.Method == MethodNone {
+		return data, nil
+	}
+
+	parsed, err := message.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing message: %w", err)
+	}
+
+	outer, inner := splitContentHeader(parsed)
+	eol := parsed.EOL()
+
+	if batch.Method == MethodPGP {
+		return encryptPGPMIME(outer, inner, batch.Keys, eol)
+	}
+
+	return encryptSMIMEMessage(outer, inner, batch.Keys, eol)
+}
+
+// policyFor returns policy of the recipient domain, or of the closest parent domain listed in configuration.
+func (e *Encryptor) policyFor(recipient string) config.RelayEncryptionPolicy {
+	domain := strings.ToLower(recipient[strings.LastIndex(recipient, "@")+1:])
+
+	for domain != "" {
+		if policy, ok := e.Domains[domain]; ok {
+			return policy
+		}
+
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			break
+		}
+
+		domain = domain[dot+1:]
+	}
+
+	return e.Policy
+}
+
+// splitContentHeader moves Content-* fields to the entity, which is going to be encrypted, leaving the rest
+// of the header in the outer one. Returned entity is in canonical (CRLF) form.
+func splitContentHeader(parsed *message.Part) (*message.Header, []byte) {
+	outer := &message.Header{Fields: make([]*message.Field, 0), EOL: parsed.Header.EOL}
+	inner := &message.Header{Fields: make([]*message.Field, 0), EOL: parsed.Header.EOL}
+
+	for _, field := range parsed.Header.Fields {
+		name := strings.ToLower(field.Name)
+
+		switch {
+		case strings.HasPrefix(name, "content-"):
+			inner.Fields = append(inner.Fields, field)
+		case name != "mime-version":
+			outer.Fields = append(outer.Fields, field)
+		}
+	}
+
+	if !inner.Has("Content-Type") {
+		if message.Is8Bit(parsed.Body) {
+			inner.Prepend("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			inner.Prepend("Content-Type", "text/plain; charset=us-ascii")
+		}
+	}
+
+	parsed.Header = inner
+
+	return outer, canonicalize(parsed.Bytes())
+}
+
+func encryptPGPMIME(outer *message.Header, content []byte, keys []*Key, eol string) ([]byte, error) {
+	entities := make(openpgp.EntityList, 0, len(keys))
+	for _, key := range keys {
+		entities = append(entities, key.PGP...)
+	}
+
+	encrypted, err := encryptPGP(content, entities)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting PGP message: %w", err)
+	}
+
+	control := message.NewPart("application/pgp-encrypted", []byte("Version: 1"+eol), message.Encoding7Bit, eol)
+	control.Header.Add("Content-Description", "PGP/MIME version identification")
+
+	payload := message.NewPart(
+		`application/octet-stream; name="encrypted.asc"`,
+		bytes.ReplaceAll(encrypted, []byte("\n"), []byte(eol)), message.Encoding7Bit, eol,
+	)
+	payload.Header.Add("Content-Description", "OpenPGP encrypted message")
+	payload.Header.Add("Content-Disposition", `inline; filename="encrypted.asc"`)
+
+	multipart := message.NewMultipart(
+		"multipart/encrypted", map[string]string{"protocol": "application/pgp-encrypted"},
+		[]*message.Part{control, payload}, eol,
+	)
+
+	return withOuterHeader(multipart, outer), nil
+}
+
+func encryptSMIMEMessage(outer *message.Header, content []byte, keys []*Key, eol string) ([]byte, error) {
+	certificates := make([]*x509.Certificate, 0, len(keys))
+	for _, key := range keys {
+		certificates = append(certificates, key.Certificate)
+	}
+
+	encrypted, err := encryptSMIME(content, certificates)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting S/MIME message: %w", err)
+	}
+
+	part := message.NewPart(
+		`application/pkcs7-mime; smime-type=enveloped-data; name="smime.p7m"`, encrypted, message.EncodingBase64, eol,
+	)
+	part.Header.Add("Content-Disposition", `attachment; filename="smime.p7m"`)
+	part.Header.Add("Content-Description", "S/MIME Encrypted Message")
+
+	return withOuterHeader(part, outer), nil
+}
+
+func withOuterHeader(part *message.Part, outer *message.Header) []byte {
+	fields := make([]*message.Field, 0, len(outer.Fields)+len(part.Header.Fields)+1)
+	fields = append(fields, outer.Fields...)
+	fields = append(fields, message.NewField("MIME-Version", "1.0", outer.EOL))
+	fields = append(fields, part.Header.Fields...)
+	part.Header.Fields = fields
+
+	return part.Bytes()
+}
+
+// canonicalize converts line endings to CRLF, as content has to be in canonical form before encryption.
+func canonicalize(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+}
@@ -0,0 +1,244 @@
+package encryption_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/encryption"
+	"github.com/ajgon/mailbowl/message"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/openpgp"       //nolint:staticcheck
+	"golang.org/x/crypto/openpgp/armor" //nolint:staticcheck
+)
+
+const testMessage = "From: sender@example.local\nTo: alice@partner.local\nSubject: secret\n" +
+	"Content-Type: text/plain; charset=utf-8\n\nzażółć gęślą jaźń\n"
+
+func generatePGPKey(t *testing.T, email string) (*openpgp.Entity, string) {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity("Test", "", email, nil)
+	assert.NoError(t, err)
+
+	var buffer bytes.Buffer
+
+	writer, err := armor.Encode(&buffer, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.Serialize(writer))
+	assert.NoError(t, writer.Close())
+
+	return entity, buffer.String()
+}
+
+func generateSMIMECertificate(t *testing.T, email string) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(42),
+		Subject:        pkix.Name{CommonName: email},
+		EmailAddresses: []string{email},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	assert.NoError(t, err)
+
+	return privateKey, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestSplit(t *testing.T) {
+	t.Parallel()
+
+	_, pgpKey := generatePGPKey(t, "alice@partner.local")
+
+	encryptor, err := encryption.NewEncryptor(config.RelayEncryption{
+		Policy: config.EncryptionOpportunistic,
+		Domains: []config.RelayEncryptionDomain{
+			{Domain: "secure.local", Policy: config.EncryptionRequired},
+			{Domain: "plain.local", Policy: config.EncryptionNever},
+		},
+		Keys: []config.RelayEncryptionKey{
+			{Email: "alice@partner.local", Type: config.KeyPGP, Key: pgpKey},
+			{Email: "bob@plain.local", Type: config.KeyPGP, Key: pgpKey},
+			{Email: "carol@mx.secure.local", Type: config.KeyPGP, Key: pgpKey},
+		},
+	})
+	assert.NoError(t, err)
+
+	batches, err := encryptor.Split(
+		[]string{"alice@partner.local", "bob@plain.local", "dave@partner.local", "carol@mx.secure.local"},
+	)
+	assert.NoError(t, err)
+	assert.Len(t, batches, 3)
+	assert.Equal(t, encryption.MethodNone, batches[0].Method)
+	assert.Equal(t, []string{"bob@plain.local", "dave@partner.local"}, batches[0].Recipients)
+
+	// encrypted copies don't reveal other recipients
+	assert.Equal(t, encryption.MethodPGP, batches[1].Method)
+	assert.Equal(t, []string{"alice@partner.local"}, batches[1].Recipients)
+	assert.Len(t, batches[1].Keys, 1)
+	assert.Equal(t, encryption.MethodPGP, batches[2].Method)
+	assert.Equal(t, []string{"carol@mx.secure.local"}, batches[2].Recipients)
+
+	// subdomains inherit the policy of their parent
+	_, err = encryptor.Split([]string{"alice@partner.local", "eve@mx.secure.local"})
+	assert.True(t, errors.Is(err, encryption.ErrEncryptionRequired))
+}
+
+func TestEncryptPGP(t *testing.T) {
+	t.Parallel()
+
+	entity, pgpKey := generatePGPKey(t, "alice@partner.local")
+
+	encryptor, err := encryption.NewEncryptor(config.RelayEncryption{
+		Policy: config.EncryptionOpportunistic,
+		Keys:   []config.RelayEncryptionKey{{Email: "alice@partner.local", Type: config.KeyPGP, Key: pgpKey}},
+	})
+	assert.NoError(t, err)
+
+	batches, err := encryptor.Split([]string{"alice@partner.local"})
+	assert.NoError(t, err)
+
+	encrypted, err := encryptor.Encrypt(batches[0], []byte(testMessage))
+	assert.NoError(t, err)
+
+	parsed, err := message.Parse(encrypted)
+	assert.NoError(t, err)
+
+	mediaType, params := parsed.MediaType()
+	assert.Equal(t, "multipart/encrypted", mediaType)
+	assert.Equal(t, "application/pgp-encrypted", params["protocol"])
+	assert.Equal(t, "secret", parsed.Header.Get("Subject"))
+	assert.Equal(t, "1.0", parsed.Header.Get("MIME-Version"))
+	assert.Len(t, parsed.Parts, 2)
+	assert.NotContains(t, string(encrypted), "gęślą")
+
+	block, err := armor.Decode(bytes.NewReader(parsed.Parts[1].Body))
+	assert.NoError(t, err)
+
+	details, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{entity}, nil, nil)
+	assert.NoError(t, err)
+
+	decrypted, err := io.ReadAll(details.UnverifiedBody)
+	assert.NoError(t, err)
+	assert.Equal(t, "Content-Type: text/plain; charset=utf-8\r\n\r\nzażółć gęślą jaźń\r\n", string(decrypted))
+}
+
+type testContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type testEnvelopedData struct {
+	Version              int
+	RecipientInfos       []testRecipientInfo `asn1:"set"`
+	EncryptedContentInfo testEncryptedContentInfo
+}
+
+type testRecipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type testEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+func decryptSMIME(t *testing.T, der []byte, privateKey *rsa.PrivateKey) []byte {
+	t.Helper()
+
+	var (
+		info      testContentInfo
+		enveloped testEnvelopedData
+		iv        []byte
+	)
+
+	_, err := asn1.Unmarshal(der, &info)
+	assert.NoError(t, err)
+
+	_, err = asn1.Unmarshal(info.Content.Bytes, &enveloped)
+	assert.NoError(t, err)
+	assert.Len(t, enveloped.RecipientInfos, 1)
+
+	key, err := rsa.DecryptPKCS1v15(rand.Reader, privateKey, enveloped.RecipientInfos[0].EncryptedKey)
+	assert.NoError(t, err)
+
+	_, err = asn1.Unmarshal(enveloped.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv)
+	assert.NoError(t, err)
+
+	block, err := aes.NewCipher(key)
+	assert.NoError(t, err)
+
+	content := enveloped.EncryptedContentInfo.EncryptedContent
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(content, content)
+
+	return content[:len(content)-int(content[len(content)-1])]
+}
+
+func TestEncryptSMIME(t *testing.T) {
+	t.Parallel()
+
+	privateKey, certificate := generateSMIMECertificate(t, "alice@partner.local")
+
+	encryptor, err := encryption.NewEncryptor(config.RelayEncryption{
+		Policy: config.EncryptionRequired,
+		Keys:   []config.RelayEncryptionKey{{Email: "alice@partner.local", Type: config.KeySMIME, Key: certificate}},
+	})
+	assert.NoError(t, err)
+
+	batches, err := encryptor.Split([]string{"alice@partner.local"})
+	assert.NoError(t, err)
+	assert.Equal(t, encryption.MethodSMIME, batches[0].Method)
+
+	encrypted, err := encryptor.Encrypt(batches[0], []byte(testMessage))
+	assert.NoError(t, err)
+
+	parsed, err := message.Parse(encrypted)
+	assert.NoError(t, err)
+
+	mediaType, params := parsed.MediaType()
+	assert.Equal(t, "application/pkcs7-mime", mediaType)
+	assert.Equal(t, "enveloped-data", params["smime-type"])
+	assert.Equal(t, "alice@partner.local", parsed.Header.Get("To"))
+
+	der, err := parsed.Content()
+	assert.NoError(t, err)
+	assert.Equal(
+		t, "Content-Type: text/plain; charset=utf-8\r\n\r\nzażółć gęślą jaźń\r\n", string(decryptSMIME(t, der, privateKey)),
+	)
+}
+
+func TestInvalidKeys(t *testing.T) {
+	t.Parallel()
+
+	_, err := encryption.NewEncryptor(config.RelayEncryption{
+		Keys: []config.RelayEncryptionKey{{Email: "alice@partner.local", Type: config.KeyPGP, Key: "garbage"}},
+	})
+	assert.True(t, errors.Is(err, encryption.ErrInvalidPGPKey))
+
+	_, err = encryption.NewEncryptor(config.RelayEncryption{
+		Keys: []config.RelayEncryptionKey{{Email: "alice@partner.local", Type: config.KeySMIME, Key: "garbage"}},
+	})
+	assert.True(t, errors.Is(err, encryption.ErrInvalidSMIMEKey))
+}
@@ -0,0 +1,121 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ajgon/mailbowl/config"
+	"golang.org/x/crypto/openpgp" //nolint:staticcheck
+)
+
+var (
+	ErrInvalidPGPKey       = errors.New("invalid PGP public key")
+	ErrInvalidSMIMEKey     = errors.New("invalid S/MIME certificate")
+	ErrUnsupportedSMIMEKey = errors.New("only RSA S/MIME certificates are supported")
+	ErrDuplicateKey        = errors.New("duplicate key for email")
+)
+
+// Key is a public key (or certificate) of a single recipient.
+type Key struct {
+	Email       string
+	Type        config.RelayEncryptionKeyType
+	PGP         openpgp.EntityList
+	Certificate *x509.Certificate
+}
+
+// Keyring maps recipient addresses to their public keys.
+type Keyring struct {
+	keys map[string]*Key
+}
+
+func NewKeyring(conf []config.RelayEncryptionKey) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string]*Key)}
+
+	for _, keyConf := range conf {
+		if _, ok := keyring.keys[keyConf.Email]; ok {
+			return nil, fmt.Errorf("%w `%s`", ErrDuplicateKey, keyConf.Email)
+		}
+
+		key, err := loadKey(keyConf)
+		if err != nil {
+			return nil, fmt.Errorf("error loading key for `%s`: %w", keyConf.Email, err)
+		}
+
+		keyring.keys[keyConf.Email] = key
+	}
+
+	return keyring, nil
+}
+
+// Get returns key for given recipient, or nil if the recipient has none.
+func (k *Keyring) Get(email string) *Key {
+	return k.keys[strings.ToLower(email)]
+}
+
+func loadKey(conf config.RelayEncryptionKey) (*Key, error) {
+	var (
+		data = []byte(conf.Key)
+		err  error
+	)
+
+	if conf.Key == "" {
+		data, err = os.ReadFile(conf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+	}
+
+	key := &Key{Email: conf.Email, Type: conf.Type}
+
+	if conf.Type == config.KeyPGP {
+		key.PGP, err = parsePGPKey(data)
+	} else {
+		key.Certificate, err = parseSMIMECertificate(data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func parsePGPKey(data []byte) (openpgp.EntityList, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		// not armored, try binary format
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPGPKey, err.Error())
+		}
+	}
+
+	if len(entities) == 0 {
+		return nil, ErrInvalidPGPKey
+	}
+
+	return entities, nil
+}
+
+func parseSMIMECertificate(data []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	certificate, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSMIMEKey, err.Error())
+	}
+
+	if _, ok := certificate.PublicKey.(*rsa.PublicKey); !ok {
+		return nil, ErrUnsupportedSMIMEKey
+	}
+
+	return certificate, nil
+}
@@ -0,0 +1,43 @@
+package encryption
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/crypto/openpgp"       //nolint:staticcheck
+	"golang.org/x/crypto/openpgp/armor" //nolint:staticcheck
+
+	// keys without hash preferences fall back to RIPEMD-160, which has to be registered
+	_ "golang.org/x/crypto/ripemd160" //nolint:staticcheck
+)
+
+// encryptPGP encrypts content to all given entities, returning ASCII armored OpenPGP message.
+func encryptPGP(content []byte, entities openpgp.EntityList) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	armored, err := armor.Encode(&buffer, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	plaintext, err := openpgp.Encrypt(armored, entities, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if _, err = plaintext.Write(content); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if err = plaintext.Close(); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if err = armored.Close(); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	buffer.WriteString("\n")
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,132 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+const aes256KeySize = 32
+
+//nolint:gochecknoglobals
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// PKCS #7 / CMS structures (RFC 5652), only as much as enveloped-data with key transport needs.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []recipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type recipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+// encryptSMIME builds DER encoded enveloped-data, with content encrypted using AES-256-CBC and the content key
+// encrypted with RSA public key of every recipient.
+func encryptSMIME(content []byte, certificates []*x509.Certificate) ([]byte, error) {
+	key := make([]byte, aes256KeySize)
+	iv := make([]byte, aes.BlockSize)
+
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	padded := pkcs7Pad(content, aes.BlockSize)
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+
+	recipients := make([]recipientInfo, 0, len(certificates))
+
+	for _, certificate := range certificates {
+		publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, ErrUnsupportedSMIMEKey
+		}
+
+		encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, key)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		recipients = append(recipients, recipientInfo{
+			IssuerAndSerialNumber: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: certificate.RawIssuer},
+				SerialNumber: certificate.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encryptedKey,
+		})
+	}
+
+	enveloped, err := asn1.Marshal(envelopedData{
+		RecipientInfos: recipients,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType: oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oidAES256CBC,
+				Parameters: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: iv},
+			},
+			EncryptedContent: encrypted,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	der, err := asn1.Marshal(contentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: enveloped},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return der, nil
+}
+
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padding := blockSize - len(data)%blockSize
+
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
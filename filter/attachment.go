@@ -0,0 +1,252 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+)
+
+// Attachment checks attachments against blocked extensions and content types, also inside archives, and against
+// size limits. Offending attachments make the whole message rejected or quarantined, or are replaced with a short
+// notice, depending on configured action.
+type Attachment struct {
+	Action              config.FilterAction
+	BlockedExtensions   map[string]bool
+	BlockedContentTypes []string
+	InspectArchives     bool
+	MaxSize             int
+	MaxTotalSize        int
+}
+
+type attachmentInfo struct {
+	part     *message.Part
+	filename string
+	size     int
+	reason   string
+	code     int
+}
+
+func NewAttachment(conf config.FilterAttachment) *Attachment {
+	extensions := make(map[string]bool)
+	for _, extension := range conf.BlockedExtensions {
+		extensions[extension] = true
+	}
+
+	contentTypes := make([]string, 0, len(conf.BlockedContentTypes))
+	for _, contentType := range conf.BlockedContentTypes {
+		contentTypes = append(contentTypes, strings.ToLower(contentType))
+	}
+
+	return &Attachment{
+		Action:              conf.Action,
+		BlockedExtensions:   extensions,
+		BlockedContentTypes: contentTypes,
+		InspectArchives:     conf.InspectArchives,
+		MaxSize:             conf.MaxSize,
+		MaxTotalSize:        conf.MaxTotalSize,
+	}
+}
+
+func (a *Attachment) GetName() string {
+	return "attachment"
+}
+
+//nolint:cyclop
+func (a *Attachment) Filter(_ context.Context, envelope *Envelope) (*Verdict, error) {
+	parsed, err := message.Parse(envelope.Data)
+	if err != nil {
+		return reject(CodeTransactionFail, "malformed message: %s", err.Error()), nil
+	}
+
+	attachments, err := a.inspect(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	violations := make([]*attachmentInfo, 0)
+	totalSize := 0
+
+	for _, attachment := range attachments {
+		if attachment.reason != "" {
+			violations = append(violations, attachment)
+		} else {
+			totalSize += attachment.size
+		}
+	}
+
+	if a.Action == config.FilterStrip {
+		violations = append(violations, a.overTotalSize(attachments, totalSize)...)
+	} else if a.MaxTotalSize > 0 && totalSize > a.MaxTotalSize {
+		violations = append(violations, &attachmentInfo{
+			reason: fmt.Sprintf("total attachments size %d exceeds limit of %d bytes", totalSize, a.MaxTotalSize),
+			code:   CodeSizeExceeded,
+		})
+	}
+
+	if len(violations) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	first := violations[0]
+	description := first.reason
+
+	if first.filename != "" {
+		description = fmt.Sprintf("attachment %q: %s", first.filename, first.reason)
+	}
+
+	switch a.Action {
+	case config.FilterQuarantine:
+		return &Verdict{Action: ActionQuarantine, Reason: description}, nil
+	case config.FilterStrip:
+		a.strip(envelope, parsed, violations)
+
+		return nil, nil //nolint:nilnil
+	case config.FilterReject:
+	}
+
+	return reject(first.code, "message rejected, %s", description), nil
+}
+
+// inspect returns all attachments of the message, with reason filled for those violating the policy.
+func (a *Attachment) inspect(parsed *message.Part) ([]*attachmentInfo, error) {
+	attachments := make([]*attachmentInfo, 0)
+
+	err := parsed.Walk(func(part *message.Part) error {
+		if !part.IsAttachment() {
+			return nil
+		}
+
+		content, err := part.Content()
+		if err != nil {
+			// undecodable content cannot be inspected, treat it as is
+			content = part.Body
+		}
+
+		attachment := &attachmentInfo{part: part, filename: part.Filename(), size: len(content)}
+		attachment.reason, attachment.code = a.check(attachment.filename, part, content)
+		attachments = append(attachments, attachment)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return attachments, nil
+}
+
+func (a *Attachment) check(filename string, part *message.Part, content []byte) (string, int) {
+	if a.MaxSize > 0 && len(content) > a.MaxSize {
+		return fmt.Sprintf("size %d exceeds limit of %d bytes", len(content), a.MaxSize), CodeSizeExceeded
+	}
+
+	if extension := fileExtension(filename); a.BlockedExtensions[extension] {
+		return fmt.Sprintf("blocked extension .%s", extension), CodeTransactionFail
+	}
+
+	declared, _ := part.MediaType()
+	detected := DetectContentType(content)
+
+	for _, contentType := range []string{detected, declared} {
+		if a.blockedContentType(contentType) {
+			return fmt.Sprintf("blocked content type %s", contentType), CodeTransactionFail
+		}
+	}
+
+	if !a.InspectArchives {
+		return "", 0
+	}
+
+	entries, err := archiveEntries(content, detected)
+	if err != nil {
+		log.Debugw("unable to inspect archive", log.Fields{"filename": filename, "error": err.Error()})
+	}
+
+	for _, entry := range entries {
+		if extension := fileExtension(entry); a.BlockedExtensions[extension] {
+			return fmt.Sprintf("archive contains blocked file %q", entry), CodeTransactionFail
+		}
+	}
+
+	return "", 0
+}
+
+func (a *Attachment) blockedContentType(contentType string) bool {
+	for _, blocked := range a.BlockedContentTypes {
+		if blocked == contentType {
+			return true
+		}
+
+		if strings.HasSuffix(blocked, "/*") && strings.HasPrefix(contentType, blocked[:len(blocked)-1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// overTotalSize picks the largest of allowed attachments for stripping, until the rest fits in the total limit.
+func (a *Attachment) overTotalSize(attachments []*attachmentInfo, totalSize int) []*attachmentInfo {
+	violations := make([]*attachmentInfo, 0)
+
+	if a.MaxTotalSize <= 0 || totalSize <= a.MaxTotalSize {
+		return violations
+	}
+
+	allowed := make([]*attachmentInfo, 0, len(attachments))
+
+	for _, attachment := range attachments {
+		if attachment.reason == "" {
+			allowed = append(allowed, attachment)
+		}
+	}
+
+	sort.SliceStable(allowed, func(i, j int) bool { return allowed[i].size > allowed[j].size })
+
+	for _, attachment := range allowed {
+		if totalSize <= a.MaxTotalSize {
+			break
+		}
+
+		attachment.reason = fmt.Sprintf("total attachments size exceeds limit of %d bytes", a.MaxTotalSize)
+		attachment.code = CodeSizeExceeded
+		totalSize -= attachment.size
+		violations = append(violations, attachment)
+	}
+
+	return violations
+}
+
+func (a *Attachment) strip(envelope *Envelope, parsed *message.Part, violations []*attachmentInfo) {
+	for _, violation := range violations {
+		notice := fmt.Sprintf(
+			"The attachment %q (%d bytes) was removed from this message by mail policy: %s.%s",
+			violation.filename, violation.size, violation.reason, parsed.EOL(),
+		)
+
+		replacement := message.NewPart(
+			"text/plain; charset=utf-8", []byte(notice), message.EncodingQuotedPrintable, parsed.EOL(),
+		)
+		replacement.Header.Add("Content-Disposition", "inline")
+		violation.part.Replace(replacement)
+
+		log.Infow("attachment stripped", log.Fields{
+			"server": envelope.Server, "from": envelope.Sender, "to": envelope.Recipients,
+			"filename": violation.filename, "size": strconv.Itoa(violation.size), "reason": violation.reason,
+		})
+	}
+
+	envelope.Data = parsed.Bytes()
+}
+
+func fileExtension(filename string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(strings.TrimSpace(filename)), "."))
+}
@@ -0,0 +1,214 @@
+package filter_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+func messageWithAttachments(attachments map[string][]byte) []byte {
+	var builder strings.Builder
+
+	builder.WriteString("From: sender@example.local\r\nTo: rcpt@example.local\r\nSubject: test\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"b\"\r\n\r\n")
+	builder.WriteString("--b\r\nContent-Type: text/plain\r\n\r\nHello\r\n")
+
+	for filename, content := range attachments {
+		builder.WriteString("--b\r\nContent-Type: application/octet-stream\r\n")
+		builder.WriteString("Content-Disposition: attachment; filename=\"" + filename + "\"\r\n")
+		builder.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		builder.WriteString(base64.StdEncoding.EncodeToString(content) + "\r\n")
+	}
+
+	builder.WriteString("--b--\r\n")
+
+	return []byte(builder.String())
+}
+
+func zipArchive(t *testing.T, filenames ...string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+
+	writer := zip.NewWriter(&buffer)
+
+	for _, filename := range filenames {
+		file, err := writer.Create(filename)
+		assert.NoError(t, err)
+
+		_, err = file.Write([]byte("content"))
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, writer.Close())
+
+	return buffer.Bytes()
+}
+
+func runAttachment(conf config.FilterAttachment, data []byte) (*filter.Verdict, *filter.Envelope, error) {
+	envelope := &filter.Envelope{Sender: "sender@example.local", Recipients: []string{"rcpt@example.local"}, Data: data}
+	verdict, err := filter.NewAttachment(conf).Filter(context.Background(), envelope)
+
+	return verdict, envelope, err
+}
+
+func TestAttachmentAllowed(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterAttachment{Action: config.FilterReject, BlockedExtensions: []string{"exe"}, InspectArchives: true}
+	data := messageWithAttachments(map[string][]byte{"report.pdf": []byte("%PDF-1.4 report")})
+
+	verdict, envelope, err := runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+	assert.Equal(t, data, envelope.Data)
+}
+
+func TestAttachmentRejectedByExtension(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterAttachment{Action: config.FilterReject, BlockedExtensions: []string{"exe"}}
+	data := messageWithAttachments(map[string][]byte{"Invoice.EXE": []byte("harmless")})
+
+	verdict, _, err := runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Equal(t, filter.ActionReject, verdict.Action)
+	assert.Equal(t, 554, verdict.Code)
+	assert.Equal(t, `message rejected, attachment "Invoice.EXE": blocked extension .exe`, verdict.Message)
+}
+
+func TestAttachmentRejectedByDetectedContentType(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterAttachment{Action: config.FilterReject, BlockedContentTypes: []string{"application/x-msdownload"}}
+	data := messageWithAttachments(map[string][]byte{"photo.jpg": []byte("MZ\x90\x00\x03")})
+
+	verdict, _, err := runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Equal(t, filter.ActionReject, verdict.Action)
+	assert.Equal(t, `message rejected, attachment "photo.jpg": blocked content type application/x-msdownload`,
+		verdict.Message)
+}
+
+func TestAttachmentRejectedByContentTypeWildcard(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterAttachment{Action: config.FilterReject, BlockedContentTypes: []string{"application/*"}}
+	data := messageWithAttachments(map[string][]byte{"data.bin": []byte("plain text really")})
+
+	verdict, _, err := runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Equal(t, `message rejected, attachment "data.bin": blocked content type application/octet-stream`,
+		verdict.Message)
+}
+
+func TestAttachmentRejectedByArchiveContents(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterAttachment{Action: config.FilterReject, BlockedExtensions: []string{"js"}, InspectArchives: true}
+	data := messageWithAttachments(map[string][]byte{"docs.zip": zipArchive(t, "readme.txt", "dir/run.js")})
+
+	verdict, _, err := runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Equal(t, `message rejected, attachment "docs.zip": archive contains blocked file "dir/run.js"`,
+		verdict.Message)
+
+	conf.InspectArchives = false
+
+	verdict, _, err = runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+}
+
+func TestAttachmentRejectedBySize(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterAttachment{Action: config.FilterReject, MaxSize: 10}
+	data := messageWithAttachments(map[string][]byte{"big.txt": bytes.Repeat([]byte("a"), 11)})
+
+	verdict, _, err := runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Equal(t, 552, verdict.Code)
+	assert.Equal(t, `message rejected, attachment "big.txt": size 11 exceeds limit of 10 bytes`, verdict.Message)
+}
+
+func TestAttachmentRejectedByTotalSize(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterAttachment{Action: config.FilterReject, MaxTotalSize: 15}
+	data := messageWithAttachments(map[string][]byte{
+		"a.txt": bytes.Repeat([]byte("a"), 10), "b.txt": bytes.Repeat([]byte("b"), 10),
+	})
+
+	verdict, _, err := runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Equal(t, 552, verdict.Code)
+	assert.Equal(t, "message rejected, total attachments size 20 exceeds limit of 15 bytes", verdict.Message)
+}
+
+func TestAttachmentQuarantined(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterAttachment{Action: config.FilterQuarantine, BlockedExtensions: []string{"exe"}}
+	data := messageWithAttachments(map[string][]byte{"setup.exe": []byte("MZ")})
+
+	verdict, _, err := runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Equal(t, filter.ActionQuarantine, verdict.Action)
+	assert.Equal(t, `attachment "setup.exe": blocked extension .exe`, verdict.Reason)
+}
+
+func TestAttachmentStripped(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterAttachment{Action: config.FilterStrip, BlockedExtensions: []string{"exe"}}
+	data := messageWithAttachments(map[string][]byte{"setup.exe": []byte("MZ payload"), "notes.txt": []byte("notes")})
+
+	verdict, envelope, err := runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+
+	stripped := string(envelope.Data)
+	assert.NotContains(t, stripped, base64.StdEncoding.EncodeToString([]byte("MZ payload")))
+	assert.NotContains(t, stripped, "filename=\"setup.exe\"")
+	assert.Contains(t, stripped, base64.StdEncoding.EncodeToString([]byte("notes")))
+	assert.Contains(t, stripped, "The attachment \"setup.exe\" (10 bytes) was removed from this message")
+	assert.Contains(t, stripped, "Subject: test\r\n")
+}
+
+func TestAttachmentStrippedLargestOverTotalSize(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterAttachment{Action: config.FilterStrip, MaxTotalSize: 25}
+	data := messageWithAttachments(map[string][]byte{
+		"small.txt":  bytes.Repeat([]byte("s"), 5),
+		"medium.txt": bytes.Repeat([]byte("m"), 15),
+		"large.txt":  bytes.Repeat([]byte("l"), 20),
+	})
+
+	verdict, envelope, err := runAttachment(conf, data)
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+
+	stripped := string(envelope.Data)
+	assert.Contains(t, stripped, "The attachment \"large.txt\" (20 bytes) was removed")
+	assert.NotContains(t, stripped, "The attachment \"medium.txt\"")
+	assert.NotContains(t, stripped, "The attachment \"small.txt\"")
+}
+
+func TestDetectContentType(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "application/x-msdownload", filter.DetectContentType([]byte("MZ\x90\x00")))
+	assert.Equal(t, "application/x-executable", filter.DetectContentType([]byte("\x7fELF\x02\x01")))
+	assert.Equal(t, "text/x-shellscript", filter.DetectContentType([]byte("#!/bin/sh\n")))
+	assert.Equal(t, "application/zip", filter.DetectContentType(zipArchive(t, "a.txt")))
+	assert.Equal(t, "text/plain", filter.DetectContentType([]byte("hello")))
+}
@@ -0,0 +1,162 @@
+package filter
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+)
+
+const (
+	clamdChunkSize    = 64 * 1024
+	clamdLengthBytes  = 4
+	clamdMaxReplySize = 4096
+)
+
+var ErrClamd = errors.New("clamd scan failed")
+
+// Clamd streams messages to clamd with INSTREAM command. When clamd can't be reached, or fails to scan the message,
+// message is either accepted (FailOpen) or temporarily rejected.
+type Clamd struct {
+	Network  string
+	Address  string
+	Action   config.FilterAction
+	FailOpen bool
+	Timeout  time.Duration
+}
+
+func NewClamd(conf config.FilterClamd) *Clamd {
+	return &Clamd{
+		Network:  conf.Network,
+		Address:  conf.Address,
+		Action:   conf.Action,
+		FailOpen: conf.FailOpen,
+		Timeout:  conf.Timeout,
+	}
+}
+
+func (c *Clamd) GetName() string {
+	return "clamd"
+}
+
+func (c *Clamd) Filter(ctx context.Context, envelope *Envelope) (*Verdict, error) {
+	virus, err := c.Scan(ctx, envelope.Data)
+	if err != nil {
+		if c.FailOpen {
+			log.Warnw("virus scan failed, accepting message unchecked", log.Fields{
+				"server": envelope.Server, "from": envelope.Sender, "to": envelope.Recipients, "error": err.Error(),
+			})
+
+			return nil, nil //nolint:nilnil
+		}
+
+		return nil, err
+	}
+
+	if virus == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	if c.Action == config.FilterQuarantine {
+		return &Verdict{Action: ActionQuarantine, Reason: fmt.Sprintf("virus found: %s", virus)}, nil
+	}
+
+	return reject(CodeTransactionFail, "message rejected, virus found: %s", virus), nil
+}
+
+// Scan sends data to clamd, returning the name of found virus, or empty string if data is clean.
+func (c *Clamd) Scan(ctx context.Context, data []byte) (string, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+
+	connection, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrClamd, err.Error())
+	}
+	defer connection.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = connection.SetDeadline(deadline)
+	}
+
+	if err = c.stream(connection, data); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrClamd, err.Error())
+	}
+
+	reply, err := bufio.NewReader(connection).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("%w: %s", ErrClamd, err.Error())
+	}
+
+	return parseClamdReply(reply)
+}
+
+func (c *Clamd) stream(connection net.Conn, data []byte) error {
+	writer := bufio.NewWriterSize(connection, clamdChunkSize+clamdLengthBytes)
+	length := make([]byte, clamdLengthBytes)
+
+	if _, err := writer.WriteString("zINSTREAM\x00"); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	for offset := 0; offset < len(data); offset += clamdChunkSize {
+		end := offset + clamdChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		binary.BigEndian.PutUint32(length, uint32(end-offset))
+
+		if _, err := writer.Write(length); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		if _, err := writer.Write(data[offset:end]); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	binary.BigEndian.PutUint32(length, 0)
+
+	if _, err := writer.Write(length); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// parseClamdReply handles replies in `stream: OK`, `stream: <virus> FOUND` and `<error> ERROR` formats.
+func parseClamdReply(reply string) (string, error) {
+	reply = strings.TrimRight(reply, "\x00\r\n")
+	if len(reply) > clamdMaxReplySize {
+		reply = reply[:clamdMaxReplySize]
+	}
+
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrClamd, reply)
+}
@@ -0,0 +1,159 @@
+package filter_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd speaks enough of clamd protocol to answer INSTREAM requests, reporting EICAR signature as a virus.
+func fakeClamd(t *testing.T, network, address string) string {
+	t.Helper()
+
+	listener, err := net.Listen(network, address)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go handleClamdConnection(connection)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func handleClamdConnection(connection net.Conn) {
+	defer connection.Close()
+
+	reader := bufio.NewReader(connection)
+
+	command, err := reader.ReadString(0)
+	if err != nil || command != "zINSTREAM\x00" {
+		_, _ = connection.Write([]byte("UNKNOWN COMMAND\x00"))
+
+		return
+	}
+
+	var data bytes.Buffer
+
+	length := make([]byte, 4)
+
+	for {
+		if _, err = io.ReadFull(reader, length); err != nil {
+			return
+		}
+
+		size := binary.BigEndian.Uint32(length)
+		if size == 0 {
+			break
+		}
+
+		if _, err = io.CopyN(&data, reader, int64(size)); err != nil {
+			return
+		}
+	}
+
+	if bytes.Contains(data.Bytes(), []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+		_, _ = connection.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+	} else {
+		_, _ = connection.Write([]byte("stream: OK\x00"))
+	}
+}
+
+func runClamd(conf config.FilterClamd, data []byte) (*filter.Verdict, error) {
+	envelope := &filter.Envelope{Sender: "sender@example.local", Recipients: []string{"rcpt@example.local"}, Data: data}
+
+	return filter.NewClamd(conf).Filter(context.Background(), envelope)
+}
+
+func TestClamdClean(t *testing.T) {
+	t.Parallel()
+
+	address := fakeClamd(t, "tcp", "127.0.0.1:0")
+	conf := config.FilterClamd{Network: "tcp", Address: address, Timeout: 5 * time.Second}
+
+	verdict, err := runClamd(conf, []byte("Subject: test\r\n\r\nclean message\r\n"))
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+}
+
+func TestClamdInfectedRejected(t *testing.T) {
+	t.Parallel()
+
+	address := fakeClamd(t, "tcp", "127.0.0.1:0")
+	conf := config.FilterClamd{Network: "tcp", Address: address, Action: config.FilterReject, Timeout: 5 * time.Second}
+
+	verdict, err := runClamd(conf, []byte("Subject: test\r\n\r\n"+eicar+"\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, filter.ActionReject, verdict.Action)
+	assert.Equal(t, 554, verdict.Code)
+	assert.Equal(t, "message rejected, virus found: Eicar-Test-Signature", verdict.Message)
+}
+
+func TestClamdInfectedQuarantinedOverUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	address := fakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.sock"))
+	conf := config.FilterClamd{Network: "unix", Address: address, Action: config.FilterQuarantine}
+
+	verdict, err := runClamd(conf, []byte("Subject: test\r\n\r\n"+eicar+"\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, filter.ActionQuarantine, verdict.Action)
+	assert.Equal(t, "virus found: Eicar-Test-Signature", verdict.Reason)
+}
+
+func TestClamdLargeMessageStreamedInChunks(t *testing.T) {
+	t.Parallel()
+
+	address := fakeClamd(t, "tcp", "127.0.0.1:0")
+	conf := config.FilterClamd{Network: "tcp", Address: address, Timeout: 5 * time.Second}
+	data := append(bytes.Repeat([]byte("a"), 200*1024), []byte(eicar)...)
+
+	virus, err := filter.NewClamd(conf).Scan(context.Background(), data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Eicar-Test-Signature", virus)
+}
+
+func TestClamdUnavailableFailClosed(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterClamd{Network: "unix", Address: filepath.Join(t.TempDir(), "missing.sock")}
+
+	verdict, err := runClamd(conf, []byte("Subject: test\r\n\r\nbody\r\n"))
+	assert.ErrorIs(t, err, filter.ErrClamd)
+	assert.Nil(t, verdict)
+
+	chain := &filter.Chain{Filters: []filter.Filter{filter.NewClamd(conf)}}
+	verdict = chain.Run(context.Background(), &filter.Envelope{Data: []byte("Subject: test\r\n\r\nbody\r\n")})
+	assert.Equal(t, filter.ActionTempFail, verdict.Action)
+	assert.Equal(t, 451, verdict.Code)
+}
+
+func TestClamdUnavailableFailOpen(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterClamd{Network: "unix", Address: filepath.Join(t.TempDir(), "missing.sock"), FailOpen: true}
+
+	verdict, err := runClamd(conf, []byte("Subject: test\r\n\r\nbody\r\n"))
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+}
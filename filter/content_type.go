@@ -0,0 +1,114 @@
+package filter
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxArchiveEntries    = 10000
+	maxArchiveReadLength = 100 * 1024 * 1024
+	tarMagicOffset       = 257
+)
+
+//nolint:gochecknoglobals
+var magicNumbers = []struct {
+	offset      int
+	magic       []byte
+	contentType string
+}{
+	{0, []byte("MZ"), "application/x-msdownload"},
+	{0, []byte("\x7fELF"), "application/x-executable"},
+	{0, []byte("\xca\xfe\xba\xbe"), "application/x-mach-binary"},
+	{0, []byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{0, []byte("Rar!\x1a\x07"), "application/vnd.rar"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{0, []byte("#!"), "text/x-shellscript"},
+	{tarMagicOffset, []byte("ustar"), "application/x-tar"},
+}
+
+// DetectContentType guesses content type from the content itself, ignoring what the sender declared. Executables
+// and archives are recognized on top of what net/http sniffing supports.
+func DetectContentType(content []byte) string {
+	for _, magic := range magicNumbers {
+		if len(content) >= magic.offset+len(magic.magic) &&
+			bytes.Equal(content[magic.offset:magic.offset+len(magic.magic)], magic.magic) {
+			return magic.contentType
+		}
+	}
+
+	contentType := http.DetectContentType(content)
+
+	return strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]) //nolint:gomnd
+}
+
+// archiveEntries lists file names inside zip, tar and gzipped tar archives. Other content types return nil.
+func archiveEntries(content []byte, contentType string) ([]string, error) {
+	switch contentType {
+	case "application/zip":
+		return zipEntries(content)
+	case "application/x-tar":
+		return tarEntries(bytes.NewReader(content))
+	case "application/x-gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip archive: %w", err)
+		}
+
+		entries, err := tarEntries(io.LimitReader(reader, maxArchiveReadLength))
+		if err != nil && reader.Name != "" {
+			// not a tarball, just a single compressed file
+			return []string{reader.Name}, nil
+		}
+
+		return entries, err
+	}
+
+	return nil, nil
+}
+
+func zipEntries(content []byte) ([]string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading zip archive: %w", err)
+	}
+
+	entries := make([]string, 0, len(reader.File))
+
+	for i, file := range reader.File {
+		if i >= maxArchiveEntries {
+			break
+		}
+
+		entries = append(entries, file.Name)
+	}
+
+	return entries, nil
+}
+
+func tarEntries(reader io.Reader) ([]string, error) {
+	entries := make([]string, 0)
+	tarReader := tar.NewReader(reader)
+
+	for len(entries) < maxArchiveEntries {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error reading tar archive: %w", err)
+		}
+
+		entries = append(entries, header.Name)
+	}
+
+	return entries, nil
+}
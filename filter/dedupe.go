@@ -0,0 +1,307 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+)
+
+const (
+	dedupeFileMode      = 0o640
+	dedupeDirectoryMode = 0o750
+	// state file is rewritten, when it holds that many times more lines than entries kept in memory
+	dedupeCompactRatio = 2
+)
+
+// Dedupe discards messages already accepted within the window. Messages are identified by Message-ID and the set
+// of recipients, or by hash of the content, when Message-ID is missing. Duplicates are accepted, so the client
+// doesn't retry, but are not relayed again. Messages are reserved, while they are being delivered, so duplicates
+// arriving at the same time are temporarily failed, instead of being relayed twice.
+type Dedupe struct {
+	Window     time.Duration
+	MaxEntries int
+	StateFile  string
+
+	mutex    sync.Mutex
+	seen     map[string]time.Time
+	pending  map[string]*Envelope
+	order    []dedupeEntry
+	appended int
+}
+
+type dedupeEntry struct {
+	fingerprint string
+	accepted    time.Time
+}
+
+func NewDedupe(conf config.FilterDedupe) (*Dedupe, error) {
+	dedupe := &Dedupe{
+		Window:     conf.Window,
+		MaxEntries: conf.MaxEntries,
+		StateFile:  conf.StateFile,
+		seen:       make(map[string]time.Time),
+		pending:    make(map[string]*Envelope),
+		order:      make([]dedupeEntry, 0),
+	}
+
+	if err := dedupe.load(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return dedupe, nil
+}
+
+// Resize changes the window and the number of remembered messages, dropping entries which no longer fit.
+func (d *Dedupe) Resize(window time.Duration, maxEntries int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.Window, d.MaxEntries = window, maxEntries
+
+	for d.MaxEntries > 0 && len(d.order) > d.MaxEntries {
+		d.evict()
+	}
+
+	d.expire(time.Now())
+}
+
+func (d *Dedupe) GetName() string {
+	return "dedupe"
+}
+
+func (d *Dedupe) Filter(_ context.Context, envelope *Envelope) (*Verdict, error) {
+	if envelope.Fingerprint == "" {
+		envelope.Fingerprint = Fingerprint(envelope)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	accepted, ok := d.lookup(envelope.Fingerprint, time.Now())
+	if ok {
+		return &Verdict{
+			Action: ActionDiscard,
+			Reason: fmt.Sprintf("duplicate of message accepted at %s", accepted.UTC().Format(time.RFC3339)),
+		}, nil
+	}
+
+	// the other copy may still fail, so the client has to retry, instead of this one being discarded
+	if _, ok = d.pending[envelope.Fingerprint]; ok {
+		return &Verdict{
+			Action:  ActionTempFail,
+			Code:    CodeTempFail,
+			Message: "duplicate message is being delivered, try again later",
+			Reason:  "duplicate of message being delivered",
+		}, nil
+	}
+
+	d.pending[envelope.Fingerprint] = envelope
+
+	return nil, nil //nolint:nilnil
+}
+
+// Abort drops reservation of the message, which was not accepted, so client retries are not suppressed.
+func (d *Dedupe) Abort(envelope *Envelope) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.pending[envelope.Fingerprint] == envelope {
+		delete(d.pending, envelope.Fingerprint)
+	}
+}
+
+// Commit remembers the message, once it was accepted for good. Messages rejected further down the line, or failing
+// to relay, are not remembered, so client retries are not suppressed.
+func (d *Dedupe) Commit(envelope *Envelope) error {
+	if envelope.Fingerprint == "" {
+		return nil
+	}
+
+	now := time.Now()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.pending[envelope.Fingerprint] == envelope {
+		delete(d.pending, envelope.Fingerprint)
+	}
+
+	if _, ok := d.lookup(envelope.Fingerprint, now); ok {
+		return nil
+	}
+
+	d.add(envelope.Fingerprint, now)
+
+	return d.persist(envelope.Fingerprint, now)
+}
+
+// Fingerprint identifies the message by Message-ID and recipients. Without Message-ID, hash of the header (without
+// trace fields, which differ between submissions) and body is used instead.
+func Fingerprint(envelope *Envelope) string {
+	recipients := make([]string, 0, len(envelope.Recipients))
+	for _, recipient := range envelope.Recipients {
+		recipients = append(recipients, strings.ToLower(recipient))
+	}
+
+	sort.Strings(recipients)
+
+	hash := sha256.New()
+
+	header, body, err := message.ParseHeader(envelope.Data)
+	if err == nil && header.Get("Message-ID") != "" {
+		fmt.Fprintf(hash, "id\x00%s\x00", strings.ToLower(header.Get("Message-ID")))
+	} else {
+		fmt.Fprintf(hash, "content\x00%s\x00", strings.ToLower(envelope.Sender))
+
+		if err == nil {
+			for _, field := range header.Fields {
+				if !strings.EqualFold(field.Name, "Received") && !strings.EqualFold(field.Name, "Return-Path") {
+					hash.Write(field.Raw)
+				}
+			}
+
+			hash.Write(body)
+		} else {
+			hash.Write(envelope.Data)
+		}
+	}
+
+	hash.Write([]byte(strings.Join(recipients, "\x00")))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (d *Dedupe) lookup(fingerprint string, now time.Time) (time.Time, bool) {
+	d.expire(now)
+
+	accepted, ok := d.seen[fingerprint]
+
+	return accepted, ok
+}
+
+func (d *Dedupe) add(fingerprint string, accepted time.Time) {
+	d.seen[fingerprint] = accepted
+	d.order = append(d.order, dedupeEntry{fingerprint: fingerprint, accepted: accepted})
+
+	for d.MaxEntries > 0 && len(d.order) > d.MaxEntries {
+		d.evict()
+	}
+}
+
+// expire drops entries older than the window. Entries are ordered by acceptance time, so only the oldest ones
+// have to be checked.
+func (d *Dedupe) expire(now time.Time) {
+	deadline := now.Add(-d.Window)
+
+	for len(d.order) > 0 && !d.order[0].accepted.After(deadline) {
+		d.evict()
+	}
+}
+
+func (d *Dedupe) evict() {
+	oldest := d.order[0]
+	d.order = d.order[1:]
+
+	if d.seen[oldest.fingerprint].Equal(oldest.accepted) {
+		delete(d.seen, oldest.fingerprint)
+	}
+}
+
+// load reads remembered fingerprints from the state file, one `<unix nanoseconds> <fingerprint>` per line.
+func (d *Dedupe) load(now time.Time) error {
+	if d.StateFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(d.StateFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading dedupe state: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 { //nolint:gomnd
+			continue
+		}
+
+		nanoseconds, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		d.add(fields[1], time.Unix(0, nanoseconds))
+		d.appended++
+	}
+
+	d.expire(now)
+
+	// creates the state file early, so problems with it are reported on start
+	return d.compact()
+}
+
+// persist appends the fingerprint to the state file, rewriting it from time to time, so it doesn't grow forever.
+func (d *Dedupe) persist(fingerprint string, accepted time.Time) error {
+	if d.StateFile == "" {
+		return nil
+	}
+
+	if d.appended >= dedupeCompactRatio*len(d.order) {
+		return d.compact()
+	}
+
+	file, err := os.OpenFile(d.StateFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, dedupeFileMode)
+	if err != nil {
+		return fmt.Errorf("error writing dedupe state: %w", err)
+	}
+	defer file.Close()
+
+	if _, err = fmt.Fprintf(file, "%d %s\n", accepted.UnixNano(), fingerprint); err != nil {
+		return fmt.Errorf("error writing dedupe state: %w", err)
+	}
+
+	d.appended++
+
+	return nil
+}
+
+// compact writes all entries kept in memory to a new state file, replacing the old one.
+func (d *Dedupe) compact() error {
+	var buffer bytes.Buffer
+
+	for _, entry := range d.order {
+		fmt.Fprintf(&buffer, "%d %s\n", entry.accepted.UnixNano(), entry.fingerprint)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.StateFile), dedupeDirectoryMode); err != nil {
+		return fmt.Errorf("error writing dedupe state: %w", err)
+	}
+
+	temporary := d.StateFile + ".tmp"
+	if err := os.WriteFile(temporary, buffer.Bytes(), dedupeFileMode); err != nil {
+		return fmt.Errorf("error writing dedupe state: %w", err)
+	}
+
+	if err := os.Rename(temporary, d.StateFile); err != nil {
+		_ = os.Remove(temporary)
+
+		return fmt.Errorf("error writing dedupe state: %w", err)
+	}
+
+	d.appended = len(d.order)
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package filter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+func dedupeEnvelope(data string, recipients ...string) *filter.Envelope {
+	return &filter.Envelope{Sender: "sender@example.local", Recipients: recipients, Data: []byte(data)}
+}
+
+func runDedupe(t *testing.T, dedupe *filter.Dedupe, envelope *filter.Envelope) *filter.Verdict {
+	t.Helper()
+
+	verdict, err := dedupe.Filter(context.Background(), envelope)
+	assert.NoError(t, err)
+
+	return verdict
+}
+
+func TestDedupeDiscardsCommittedDuplicates(t *testing.T) {
+	t.Parallel()
+
+	dedupe, err := filter.NewDedupe(config.FilterDedupe{Enabled: true, Window: time.Hour, MaxEntries: 10})
+	assert.NoError(t, err)
+
+	data := "Message-ID: <1@example.local>\r\nSubject: test\r\n\r\nbody\r\n"
+	envelope := dedupeEnvelope(data, "a@example.local", "b@example.local")
+
+	failed := dedupeEnvelope(data, "a@example.local", "b@example.local")
+	assert.Nil(t, runDedupe(t, dedupe, failed))
+	assert.NotEmpty(t, failed.Fingerprint)
+
+	// not committed (e.g. relay failed), so retry goes through
+	dedupe.Abort(failed)
+	assert.Nil(t, runDedupe(t, dedupe, envelope))
+
+	assert.NoError(t, dedupe.Commit(envelope))
+
+	verdict := runDedupe(t, dedupe, dedupeEnvelope(data, "B@example.local", "a@example.local"))
+	assert.Equal(t, filter.ActionDiscard, verdict.Action)
+	assert.True(t, strings.HasPrefix(verdict.Reason, "duplicate of message accepted at "))
+
+	// different recipients make a different message
+	assert.Nil(t, runDedupe(t, dedupe, dedupeEnvelope(data, "a@example.local")))
+}
+
+func TestDedupeTempFailsDuplicatesInProgress(t *testing.T) {
+	t.Parallel()
+
+	dedupe, err := filter.NewDedupe(config.FilterDedupe{Enabled: true, Window: time.Hour, MaxEntries: 10})
+	assert.NoError(t, err)
+
+	data := "Subject: test\r\n\r\nbody\r\n"
+	first := dedupeEnvelope(data, "a@example.local")
+	second := dedupeEnvelope(data, "a@example.local")
+
+	assert.Nil(t, runDedupe(t, dedupe, first))
+
+	verdict := runDedupe(t, dedupe, second)
+	assert.Equal(t, filter.ActionTempFail, verdict.Action)
+	assert.Equal(t, filter.CodeTempFail, verdict.Code)
+
+	// rejected duplicate doesn't release reservation of the first one
+	dedupe.Abort(second)
+	assert.Equal(t, filter.ActionTempFail, runDedupe(t, dedupe, dedupeEnvelope(data, "a@example.local")).Action)
+
+	assert.NoError(t, dedupe.Commit(first))
+	assert.Equal(t, filter.ActionDiscard, runDedupe(t, dedupe, second).Action)
+}
+
+func TestDedupeFingerprint(t *testing.T) {
+	t.Parallel()
+
+	withID := filter.Fingerprint(dedupeEnvelope("Message-ID: <1@example.local>\r\n\r\nbody\r\n", "a@example.local"))
+	sameID := filter.Fingerprint(dedupeEnvelope("Message-ID: <1@EXAMPLE.local>\r\n\r\nother\r\n", "a@example.local"))
+	assert.Equal(t, withID, sameID)
+
+	// without Message-ID, content decides, trace fields are ignored
+	first := filter.Fingerprint(dedupeEnvelope("Received: from a\r\nSubject: test\r\n\r\nbody\r\n", "a@example.local"))
+	second := filter.Fingerprint(dedupeEnvelope("Received: from b\r\nSubject: test\r\n\r\nbody\r\n", "a@example.local"))
+	changed := filter.Fingerprint(dedupeEnvelope("Received: from b\r\nSubject: test\r\n\r\nother\r\n", "a@example.local"))
+
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, changed)
+	assert.NotEqual(t, withID, first)
+}
+
+func TestDedupeMaxEntries(t *testing.T) {
+	t.Parallel()
+
+	dedupe, err := filter.NewDedupe(config.FilterDedupe{Enabled: true, Window: time.Hour, MaxEntries: 2})
+	assert.NoError(t, err)
+
+	envelopes := make([]*filter.Envelope, 0, 3)
+
+	for _, id := range []string{"1", "2", "3"} {
+		envelope := dedupeEnvelope("Message-ID: <"+id+"@example.local>\r\n\r\nbody\r\n", "a@example.local")
+		assert.Nil(t, runDedupe(t, dedupe, envelope))
+		assert.NoError(t, dedupe.Commit(envelope))
+
+		envelopes = append(envelopes, envelope)
+	}
+
+	// the oldest one was evicted
+	assert.Nil(t, runDedupe(t, dedupe, envelopes[0]))
+	assert.NotNil(t, runDedupe(t, dedupe, envelopes[1]))
+	assert.NotNil(t, runDedupe(t, dedupe, envelopes[2]))
+}
+
+func TestDedupeStateSurvivesRestart(t *testing.T) {
+	t.Parallel()
+
+	conf := config.FilterDedupe{
+		Enabled: true, Window: time.Hour, MaxEntries: 10, StateFile: filepath.Join(t.TempDir(), "state", "dedupe"),
+	}
+
+	dedupe, err := filter.NewDedupe(conf)
+	assert.NoError(t, err)
+
+	for i := 0; i < 30; i++ {
+		envelope := dedupeEnvelope("Message-ID: <"+strings.Repeat("x", i)+"@example.local>\r\n\r\n", "a@example.local")
+		assert.Nil(t, runDedupe(t, dedupe, envelope))
+		assert.NoError(t, dedupe.Commit(envelope))
+	}
+
+	// state file is compacted, so it doesn't grow beyond the limit
+	state, err := os.ReadFile(conf.StateFile)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, strings.Count(string(state), "\n"), 20)
+
+	restarted, err := filter.NewDedupe(conf)
+	assert.NoError(t, err)
+
+	duplicate := dedupeEnvelope("Message-ID: <"+strings.Repeat("x", 29)+"@example.local>\r\n\r\n", "a@example.local")
+	assert.NotNil(t, runDedupe(t, restarted, duplicate))
+
+	evicted := dedupeEnvelope("Message-ID: <@example.local>\r\n\r\n", "a@example.local")
+	assert.Nil(t, runDedupe(t, restarted, evicted))
+
+	// entries outside of the window are forgotten
+	conf.Window = time.Nanosecond
+	expired, err := filter.NewDedupe(conf)
+	assert.NoError(t, err)
+	assert.Nil(t, runDedupe(t, expired, duplicate))
+}
+
+func TestDedupeInheritedByRebuiltChain(t *testing.T) {
+	t.Parallel()
+
+	conf := config.Filter{Dedupe: config.FilterDedupe{
+		Enabled: true, Window: time.Hour, MaxEntries: 10, StateFile: filepath.Join(t.TempDir(), "dedupe"),
+	}}
+
+	previous, err := filter.NewChain(conf)
+	assert.NoError(t, err)
+
+	envelope := dedupeEnvelope("Message-ID: <1@example.local>\r\n\r\nbody\r\n", "a@example.local")
+	assert.Equal(t, filter.ActionAccept, previous.Run(context.Background(), envelope).Action)
+	previous.Commit(envelope)
+
+	conf.Dedupe.MaxEntries = 5
+	current, err := filter.NewChain(conf)
+	assert.NoError(t, err)
+
+	current.Inherit(previous)
+
+	// both generations share one instance, so there is a single writer of the state file
+	assert.Same(t, previous.Filters[0], current.Filters[0])
+	assert.Equal(t, 5, current.Filters[0].(*filter.Dedupe).MaxEntries)
+
+	duplicate := dedupeEnvelope("Message-ID: <1@example.local>\r\n\r\nbody\r\n", "a@example.local")
+	assert.Equal(t, filter.ActionDiscard, current.Run(context.Background(), duplicate).Action)
+
+	// different state file means a different dedupe
+	conf.Dedupe.StateFile = filepath.Join(t.TempDir(), "dedupe")
+	other, err := filter.NewChain(conf)
+	assert.NoError(t, err)
+
+	other.Inherit(current)
+	assert.NotSame(t, current.Filters[0], other.Filters[0])
+}
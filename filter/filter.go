@@ -0,0 +1,270 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+)
+
+type Action int
+
+const (
+	ActionAccept Action = iota
+	ActionReject
+	ActionTempFail
+	ActionQuarantine
+	ActionDiscard
+)
+
+const (
+	CodeTempFail        = 451
+	CodeSizeExceeded    = 552
+	CodeTransactionFail = 554
+)
+
+// Envelope is the message, as accepted from the client, together with everything known about the session.
+// Filters are free to modify Data. Fingerprint identifies the message as submitted, before it was normalized. It is
+// set by dedupe filter, when the listener didn't set it.
+type Envelope struct {
+	Sender     string
+	Recipients []string
+	Data       []byte
+
+	RemoteIP net.IP
+	Helo     string
+	Username string
+	TLS      bool
+	Server   string
+
+	Fingerprint string
+}
+
+// Verdict is a decision made by one of the filters. Code and Message are returned to the client, when the message
+// is rejected, Rule and Reason are for logs and quarantine only.
+type Verdict struct {
+	Action  Action
+	Code    int
+	Message string
+	Filter  string
+	Rule    string
+	Reason  string
+}
+
+type Filter interface {
+	GetName() string
+	Filter(ctx context.Context, envelope *Envelope) (*Verdict, error)
+}
+
+// Reloader is implemented by filters keeping their configuration outside of the main config file.
+type Reloader interface {
+	Reload() error
+}
+
+// Committer is implemented by filters, which have to know whether the message was eventually accepted.
+type Committer interface {
+	Commit(envelope *Envelope) error
+}
+
+// Aborter is implemented by filters keeping state for messages in progress, which has to be dropped, when the message
+// was not accepted after all.
+type Aborter interface {
+	Abort(envelope *Envelope)
+}
+
+// Chain runs filters one after another, until one of them decides the message should not be relayed.
+type Chain struct {
+	Filters []Filter
+}
+
+func NewChain(conf config.Filter) (*Chain, error) {
+	chain := &Chain{Filters: make([]Filter, 0)}
+
+	// duplicates are dropped before wasting time on scanning them
+	if conf.Dedupe.Enabled {
+		dedupe, err := NewDedupe(conf.Dedupe)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring dedupe: %w", err)
+		}
+
+		chain.Filters = append(chain.Filters, dedupe)
+	}
+
+	if conf.Attachment.Enabled {
+		chain.Filters = append(chain.Filters, NewAttachment(conf.Attachment))
+	}
+
+	if conf.Clamd.Enabled {
+		chain.Filters = append(chain.Filters, NewClamd(conf.Clamd))
+	}
+
+	if conf.Spam.Enabled {
+		chain.Filters = append(chain.Filters, NewSpam(conf.Spam))
+	}
+
+	// policy goes after scanners, so its rules can see headers added by them
+	if conf.Policy.Enabled {
+		policy, err := NewPolicy(conf.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring policy: %w", err)
+		}
+
+		chain.Filters = append(chain.Filters, policy)
+	}
+
+	// footer is added once the message passed all the checks, so it doesn't affect them
+	if conf.Footer.Enabled {
+		footer, err := NewFooter(conf.Footer)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring footer: %w", err)
+		}
+
+		chain.Filters = append(chain.Filters, footer)
+	}
+
+	return chain, nil
+}
+
+// Reload asks filters to read their external configuration again. Failing filters keep the previous one.
+func (c *Chain) Reload() {
+	for _, filter := range c.Filters {
+		reloader, ok := filter.(Reloader)
+		if !ok {
+			continue
+		}
+
+		if err := reloader.Reload(); err != nil {
+			log.Errorw("filter reload failed", log.Fields{"filter": filter.GetName(), "error": err.Error()})
+		}
+	}
+}
+
+// Inherit takes over dedupe state from the previous chain, when both use the same state file. Otherwise both
+// instances would rewrite the file over each other, while the previous chain is still draining sessions.
+func (c *Chain) Inherit(previous *Chain) {
+	current, index := c.dedupe()
+	inherited, _ := previous.dedupe()
+
+	if current == nil || inherited == nil || current.StateFile != inherited.StateFile {
+		return
+	}
+
+	inherited.Resize(current.Window, current.MaxEntries)
+	c.Filters[index] = inherited
+}
+
+func (c *Chain) dedupe() (*Dedupe, int) {
+	if c == nil {
+		return nil, -1
+	}
+
+	for index, filter := range c.Filters {
+		if dedupe, ok := filter.(*Dedupe); ok {
+			return dedupe, index
+		}
+	}
+
+	return nil, -1
+}
+
+func (a Action) String() string {
+	switch a {
+	case ActionReject:
+		return "reject"
+	case ActionTempFail:
+		return "tempfail"
+	case ActionQuarantine:
+		return "quarantine"
+	case ActionDiscard:
+		return "discard"
+	case ActionAccept:
+	}
+
+	return "accept"
+}
+
+// Commit tells filters the message was accepted: relayed, quarantined or discarded.
+func (c *Chain) Commit(envelope *Envelope) {
+	for _, filter := range c.Filters {
+		committer, ok := filter.(Committer)
+		if !ok {
+			continue
+		}
+
+		if err := committer.Commit(envelope); err != nil {
+			log.Errorw("filter commit failed", log.Fields{
+				"server": envelope.Server, "filter": filter.GetName(), "from": envelope.Sender, "error": err.Error(),
+			})
+		}
+	}
+}
+
+// Abort tells filters the message was not accepted: rejected, failed to relay or couldn't be stored. It is safe to
+// call it after Commit, committed messages are left alone.
+func (c *Chain) Abort(envelope *Envelope) {
+	for _, filter := range c.Filters {
+		if aborter, ok := filter.(Aborter); ok {
+			aborter.Abort(envelope)
+		}
+	}
+}
+
+// Finish runs filters, which modify the message on its way out (footer), skipping the checks. It is used for
+// messages released from quarantine, which were held before reaching them.
+func (c *Chain) Finish(ctx context.Context, envelope *Envelope) error {
+	for _, filter := range c.Filters {
+		if _, ok := filter.(*Footer); !ok {
+			continue
+		}
+
+		if _, err := filter.Filter(ctx, envelope); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	return nil
+}
+
+// Run passes the envelope through all filters. Filter errors are turned into temporary failures, so the client
+// retries later, instead of the message being relayed unchecked.
+func (c *Chain) Run(ctx context.Context, envelope *Envelope) *Verdict {
+	for _, filter := range c.Filters {
+		verdict, err := filter.Filter(ctx, envelope)
+		if err != nil {
+			log.Errorw("filter failed", log.Fields{
+				"server": envelope.Server, "filter": filter.GetName(), "from": envelope.Sender, "error": err.Error(),
+			})
+
+			return &Verdict{
+				Action:  ActionTempFail,
+				Code:    CodeTempFail,
+				Message: "message could not be checked, try again later",
+				Filter:  filter.GetName(),
+				Reason:  err.Error(),
+			}
+		}
+
+		if verdict == nil || verdict.Action == ActionAccept {
+			continue
+		}
+
+		verdict.Filter = filter.GetName()
+
+		log.Infow("filter verdict", log.Fields{
+			"server": envelope.Server, "filter": verdict.Filter, "from": envelope.Sender, "to": envelope.Recipients,
+			"action": verdict.Action.String(), "rule": verdict.Rule, "reason": verdict.Reason,
+		})
+
+		return verdict
+	}
+
+	return &Verdict{Action: ActionAccept}
+}
+
+func reject(code int, format string, args ...interface{}) *Verdict {
+	message := fmt.Sprintf(format, args...)
+
+	return &Verdict{Action: ActionReject, Code: code, Message: message, Reason: message}
+}
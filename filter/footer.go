@@ -0,0 +1,262 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+)
+
+// Footer appends footers (i.e. legal disclaimers) to the message body. Text footer goes to text/plain parts, HTML
+// one to text/html parts, both alternatives get it. Signed and encrypted content is left untouched, as modifying
+// it would break signatures.
+type Footer struct {
+	rules []*footerRule
+}
+
+type footerRule struct {
+	config.FilterFooterRule
+
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// FooterData is passed to footer templates.
+type FooterData struct {
+	Sender     string
+	Recipient  string
+	Recipients []string
+	Username   string
+	Date       time.Time
+}
+
+func NewFooter(conf config.FilterFooter) (*Footer, error) {
+	footer := &Footer{rules: make([]*footerRule, 0, len(conf.Rules))}
+
+	for _, ruleConf := range conf.Rules {
+		rule := &footerRule{FilterFooterRule: ruleConf}
+
+		if ruleConf.Text != "" {
+			text, err := texttemplate.New(ruleConf.Name).Parse(ruleConf.Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid text template in rule %s: %w", ruleConf.Name, err)
+			}
+
+			rule.text = text
+		}
+
+		if ruleConf.HTML != "" {
+			html, err := htmltemplate.New(ruleConf.Name).Parse(ruleConf.HTML)
+			if err != nil {
+				return nil, fmt.Errorf("invalid html template in rule %s: %w", ruleConf.Name, err)
+			}
+
+			rule.html = html
+		}
+
+		footer.rules = append(footer.rules, rule)
+	}
+
+	return footer, nil
+}
+
+func (f *Footer) GetName() string {
+	return "footer"
+}
+
+func (f *Footer) Filter(_ context.Context, envelope *Envelope) (*Verdict, error) {
+	rule := f.match(envelope)
+	if rule == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	parsed, err := message.Parse(envelope.Data)
+	if err != nil {
+		log.Debugw("footer skipped, message can't be parsed", log.Fields{"server": envelope.Server, "error": err.Error()})
+
+		return nil, nil //nolint:nilnil
+	}
+
+	text, html, err := rule.render(FooterData{
+		Sender:     envelope.Sender,
+		Recipient:  strings.Join(envelope.Recipients, ", "),
+		Recipients: envelope.Recipients,
+		Username:   envelope.Username,
+		Date:       time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error rendering footer %s: %w", rule.Name, err)
+	}
+
+	if !appendFooter(parsed, text, html) {
+		return nil, nil //nolint:nilnil
+	}
+
+	if parsed.Header.Has("Content-Type") && !parsed.Header.Has("MIME-Version") {
+		parsed.Header.Add("MIME-Version", "1.0")
+	}
+
+	envelope.Data = parsed.Bytes()
+
+	log.Debugw("footer added", log.Fields{"server": envelope.Server, "rule": rule.Name, "from": envelope.Sender})
+
+	return nil, nil //nolint:nilnil
+}
+
+func (f *Footer) match(envelope *Envelope) *footerRule {
+	domain := ""
+	if i := strings.LastIndex(envelope.Sender, "@"); i >= 0 {
+		domain = strings.ToLower(envelope.Sender[i+1:])
+	}
+
+	for _, rule := range f.rules {
+		if len(rule.SenderDomains) == 0 && len(rule.Usernames) == 0 {
+			return rule
+		}
+
+		for _, senderDomain := range rule.SenderDomains {
+			if domain != "" && domain == senderDomain {
+				return rule
+			}
+		}
+
+		for _, username := range rule.Usernames {
+			if envelope.Username != "" && strings.EqualFold(envelope.Username, username) {
+				return rule
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *footerRule) render(data FooterData) (string, string, error) {
+	var text, html bytes.Buffer
+
+	if r.text != nil {
+		if err := r.text.Execute(&text, data); err != nil {
+			return "", "", fmt.Errorf("%w", err)
+		}
+	}
+
+	if r.html != nil {
+		if err := r.html.Execute(&html, data); err != nil {
+			return "", "", fmt.Errorf("%w", err)
+		}
+	}
+
+	return strings.TrimSpace(text.String()), strings.TrimSpace(html.String()), nil
+}
+
+// appendFooter adds footers to the message body: all alternatives of multipart/alternative, the root part of other
+// multiparts (the rest are attachments or related resources). Returns true, if the message was modified.
+func appendFooter(part *message.Part, text, html string) bool {
+	mediaType, _ := part.MediaType()
+
+	switch {
+	case isProtected(mediaType):
+		return false
+	case part.IsMultipart():
+		if mediaType != "multipart/alternative" {
+			return len(part.Parts) > 0 && appendFooter(part.Parts[0], text, html)
+		}
+
+		added := false
+
+		for _, child := range part.Parts {
+			if appendFooter(child, text, html) {
+				added = true
+			}
+		}
+
+		return added
+	case part.IsAttachment():
+		return false
+	case mediaType == "text/plain" && text != "":
+		return appendContent(part, text, appendText)
+	case mediaType == "text/html" && html != "":
+		return appendContent(part, html, appendHTML)
+	}
+
+	return false
+}
+
+func appendContent(part *message.Part, footer string, add func(content, footer, eol string) string) bool {
+	content, err := part.Content()
+	if err != nil || bytes.Contains(content, []byte("-----BEGIN PGP ")) {
+		return false
+	}
+
+	if !message.IsASCII(footer) && !setUTF8(part) {
+		return false
+	}
+
+	eol := part.EOL()
+	footer = strings.ReplaceAll(strings.ReplaceAll(footer, "\r\n", "\n"), "\n", eol)
+	modified := add(string(content), footer, eol)
+
+	encoding := part.TransferEncoding()
+	if encoding == message.Encoding7Bit && !message.IsASCII(modified) {
+		encoding = message.EncodingQuotedPrintable
+	}
+
+	part.SetContent([]byte(modified), encoding)
+
+	return true
+}
+
+func appendText(content, footer, eol string) string {
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += eol
+	}
+
+	return content + eol + footer + eol
+}
+
+// appendHTML puts the footer at the end of the document body.
+func appendHTML(content, footer, eol string) string {
+	lower := strings.ToLower(content)
+
+	for _, tag := range []string{"</body>", "</html>"} {
+		if i := strings.LastIndex(lower, tag); i >= 0 {
+			return content[:i] + footer + eol + content[i:]
+		}
+	}
+
+	return appendText(content, footer, eol)
+}
+
+// setUTF8 switches charset of the part to UTF-8, so non ASCII footer can be added. It is possible only for
+// US-ASCII parts, as it is a subset of UTF-8.
+func setUTF8(part *message.Part) bool {
+	mediaType, params := part.MediaType()
+
+	switch strings.ToLower(params["charset"]) {
+	case "utf-8", "utf8":
+		return true
+	case "", "us-ascii", "ascii":
+		params["charset"] = "utf-8"
+		part.Header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+
+		return true
+	}
+
+	return false
+}
+
+func isProtected(mediaType string) bool {
+	switch mediaType {
+	case "multipart/signed", "multipart/encrypted", "application/pkcs7-mime", "application/x-pkcs7-mime":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package filter_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/ajgon/mailbowl/message"
+	"github.com/stretchr/testify/assert"
+)
+
+const footerAlternativeMessage = "From: sender@corp.local\r\nTo: rcpt@example.local\r\nSubject: test\r\n" +
+	"MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+	"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+	"--inner\r\nContent-Type: text/plain; charset=us-ascii\r\n\r\nHello\r\n" +
+	"--inner\r\nContent-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+	"PGh0bWw+PGJvZHk+SGVsbG88L2JvZHk+PC9odG1sPg==\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=notes.txt\r\n\r\nnotes\r\n" +
+	"--outer--\r\n"
+
+func newFooter(t *testing.T) *filter.Footer {
+	t.Helper()
+
+	footer, err := filter.NewFooter(config.FilterFooter{Enabled: true, Rules: []config.FilterFooterRule{
+		{
+			Name:          "corp",
+			SenderDomains: []string{"corp.local"},
+			Text:          "--\nSent by {{.Sender}} to {{.Recipient}}. Zażółć.",
+			HTML:          "<p>Sent by {{.Sender}} to {{.Recipient}}</p>",
+		},
+		{Name: "app", Usernames: []string{"app@example.local"}, Text: "Sent by app on {{.Date.Year}}"},
+	}})
+	assert.NoError(t, err)
+
+	return footer
+}
+
+func TestFooterAddsToAlternatives(t *testing.T) {
+	t.Parallel()
+
+	envelope := &filter.Envelope{
+		Sender: "sender@corp.local", Recipients: []string{"<b>@example.local"}, Data: []byte(footerAlternativeMessage),
+	}
+
+	verdict, err := newFooter(t).Filter(context.Background(), envelope)
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+
+	parsed, err := message.Parse(envelope.Data)
+	assert.NoError(t, err)
+
+	alternative := parsed.Parts[0]
+
+	text, err := alternative.Parts[0].Content()
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello\r\n\r\n--\r\nSent by sender@corp.local to <b>@example.local. Zażółć.\r\n", string(text))
+	assert.Equal(t, "text/plain; charset=utf-8", alternative.Parts[0].Header.Get("Content-Type"))
+	assert.Equal(t, "quoted-printable", alternative.Parts[0].Header.Get("Content-Transfer-Encoding"))
+
+	html, err := alternative.Parts[1].Content()
+	assert.NoError(t, err)
+	assert.Equal(
+		t, "<html><body>Hello<p>Sent by sender@corp.local to &lt;b&gt;@example.local</p>\r\n</body></html>", string(html),
+	)
+	assert.Equal(t, "base64", alternative.Parts[1].Header.Get("Content-Transfer-Encoding"))
+
+	attachment, err := parsed.Parts[1].Content()
+	assert.NoError(t, err)
+	assert.Equal(t, "notes", string(attachment))
+}
+
+func TestFooterSelectsRuleByUsername(t *testing.T) {
+	t.Parallel()
+
+	envelope := &filter.Envelope{
+		Sender: "noreply@example.local", Username: "APP@example.local",
+		Data: []byte("From: noreply@example.local\nSubject: test\n\nbody"),
+	}
+
+	_, err := newFooter(t).Filter(context.Background(), envelope)
+	assert.NoError(t, err)
+	assert.Regexp(t, "^From: noreply@example.local\nSubject: test\n"+
+		"Content-Transfer-Encoding: 7bit\n\nbody\n\nSent by app on \\d{4}\n$", string(envelope.Data))
+
+	// no matching rule
+	original := []byte("From: other@example.local\nSubject: test\n\nbody\n")
+	envelope = &filter.Envelope{Sender: "other@example.local", Username: "other@example.local", Data: original}
+
+	_, err = newFooter(t).Filter(context.Background(), envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, original, envelope.Data)
+}
+
+func TestFooterSkipsSignedMessages(t *testing.T) {
+	t.Parallel()
+
+	signed := []byte("From: sender@corp.local\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nsigned\r\n" +
+		"--b\r\nContent-Type: application/pgp-signature\r\n\r\nsignature\r\n--b--\r\n")
+	inline := []byte("From: sender@corp.local\r\n\r\n-----BEGIN PGP SIGNED MESSAGE-----\r\nsigned\r\n")
+
+	for _, data := range [][]byte{signed, inline} {
+		envelope := &filter.Envelope{Sender: "sender@corp.local", Data: data}
+
+		_, err := newFooter(t).Filter(context.Background(), envelope)
+		assert.NoError(t, err)
+		assert.Equal(t, data, envelope.Data)
+	}
+}
+
+func TestFooterSkipsIncompatibleCharset(t *testing.T) {
+	t.Parallel()
+
+	original := []byte("From: sender@corp.local\r\nContent-Type: text/plain; charset=iso-8859-2\r\n\r\nbody\r\n")
+	envelope := &filter.Envelope{Sender: "sender@corp.local", Data: original}
+
+	_, err := newFooter(t).Filter(context.Background(), envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, original, envelope.Data)
+}
+
+func TestFooterInvalidTemplate(t *testing.T) {
+	t.Parallel()
+
+	_, err := filter.NewFooter(config.FilterFooter{Rules: []config.FilterFooterRule{{Name: "broken", Text: "{{.Sender"}}})
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "invalid text template in rule broken"))
+}
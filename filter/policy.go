@@ -0,0 +1,371 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+)
+
+// Policy evaluates configured rules against every message, in order. Matching rules can reject or quarantine the
+// message, or modify it and let it through to the next rules.
+type Policy struct {
+	RulesFile string
+
+	inline []config.FilterPolicyRule
+	rules  []config.FilterPolicyRule
+	mutex  sync.RWMutex
+}
+
+// policyMessage is the message being evaluated, parsed lazily, as most of the rules look at the envelope only.
+type policyMessage struct {
+	envelope *Envelope
+	parsed   *message.Part
+	body     *string
+	modified bool
+}
+
+func NewPolicy(conf config.FilterPolicy) (*Policy, error) {
+	policy := &Policy{RulesFile: conf.RulesFile, inline: conf.Rules}
+
+	if err := policy.Reload(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (p *Policy) GetName() string {
+	return "policy"
+}
+
+// Reload reads the rules file again. On error, previously loaded rules are kept.
+func (p *Policy) Reload() error {
+	rules := append([]config.FilterPolicyRule{}, p.inline...)
+
+	if p.RulesFile != "" {
+		fileRules, err := config.LoadPolicyRules(p.RulesFile)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		rules = append(rules, fileRules...)
+	}
+
+	p.mutex.Lock()
+	p.rules = rules
+	p.mutex.Unlock()
+
+	log.Debugw("policy rules loaded", log.Fields{"rules_file": p.RulesFile, "rules": strconv.Itoa(len(rules))})
+
+	return nil
+}
+
+func (p *Policy) Filter(_ context.Context, envelope *Envelope) (*Verdict, error) {
+	p.mutex.RLock()
+	rules := p.rules
+	p.mutex.RUnlock()
+
+	msg := &policyMessage{envelope: envelope}
+
+	for _, rule := range rules {
+		matched, err := msg.matchRule(rule)
+		if err != nil {
+			return reject(CodeTransactionFail, "malformed message: %s", err.Error()), nil
+		}
+
+		if !matched {
+			continue
+		}
+
+		log.Infow("policy rule matched", log.Fields{
+			"server": envelope.Server, "rule": rule.Name, "from": envelope.Sender, "to": envelope.Recipients,
+		})
+
+		verdict, err := msg.apply(rule)
+		if err != nil {
+			return reject(CodeTransactionFail, "malformed message: %s", err.Error()), nil
+		}
+
+		if verdict != nil {
+			return verdict, nil
+		}
+
+		if rule.Stop {
+			break
+		}
+	}
+
+	msg.save()
+
+	return nil, nil //nolint:nilnil
+}
+
+func (m *policyMessage) matchRule(rule config.FilterPolicyRule) (bool, error) {
+	for _, condition := range rule.Conditions {
+		matched, err := m.matchCondition(condition)
+		if err != nil {
+			return false, err
+		}
+
+		if matched == rule.MatchAny {
+			return matched, nil
+		}
+	}
+
+	// rules without conditions always match
+	return !rule.MatchAny || len(rule.Conditions) == 0, nil
+}
+
+func (m *policyMessage) matchCondition(condition config.FilterPolicyCondition) (bool, error) {
+	if condition.Field == config.PolicySize {
+		return m.matchSize(condition) != condition.Negate, nil
+	}
+
+	values, err := m.values(condition)
+	if err != nil {
+		return false, err
+	}
+
+	matched := false
+
+	for _, value := range values {
+		if matchValue(condition, value) {
+			matched = true
+
+			break
+		}
+	}
+
+	return matched != condition.Negate, nil
+}
+
+func (m *policyMessage) matchSize(condition config.FilterPolicyCondition) bool {
+	size := len(m.envelope.Data)
+
+	switch condition.Operator {
+	case config.PolicyGreaterThan:
+		return size > condition.Number
+	case config.PolicyLessThan:
+		return size < condition.Number
+	case config.PolicyEquals:
+		return size == condition.Number
+	case config.PolicyExists:
+		return true
+	case config.PolicyContains, config.PolicyMatches:
+	}
+
+	return false
+}
+
+//nolint:cyclop
+func (m *policyMessage) values(condition config.FilterPolicyCondition) ([]string, error) {
+	envelope := m.envelope
+
+	switch condition.Field {
+	case config.PolicySender:
+		return []string{envelope.Sender}, nil
+	case config.PolicyRecipient:
+		return envelope.Recipients, nil
+	case config.PolicyUsername:
+		return nonEmpty(envelope.Username), nil
+	case config.PolicyListener:
+		return []string{envelope.Server}, nil
+	case config.PolicyRemoteIP:
+		if envelope.RemoteIP == nil {
+			return []string{}, nil
+		}
+
+		return []string{envelope.RemoteIP.String()}, nil
+	case config.PolicyHelo:
+		return nonEmpty(envelope.Helo), nil
+	case config.PolicyHeader:
+		if err := m.parse(); err != nil {
+			return nil, err
+		}
+
+		values := m.parsed.Header.Values(condition.Header)
+		for i, value := range values {
+			values[i] = decodeHeader(value)
+		}
+
+		return values, nil
+	case config.PolicyBody:
+		body, err := m.text()
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{body}, nil
+	case config.PolicySize:
+	}
+
+	return []string{}, nil
+}
+
+func matchValue(condition config.FilterPolicyCondition, value string) bool {
+	switch condition.Operator {
+	case config.PolicyEquals:
+		return strings.EqualFold(value, condition.Value)
+	case config.PolicyContains:
+		return strings.Contains(strings.ToLower(value), strings.ToLower(condition.Value))
+	case config.PolicyMatches:
+		return condition.Regexp.MatchString(value)
+	case config.PolicyExists:
+		return true
+	case config.PolicyGreaterThan, config.PolicyLessThan:
+	}
+
+	return false
+}
+
+//nolint:cyclop
+func (m *policyMessage) apply(rule config.FilterPolicyRule) (*Verdict, error) {
+	reason := fmt.Sprintf("policy rule %s", rule.Name)
+
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case config.PolicyReject, config.PolicyTempFail:
+			m.save()
+
+			verdict := &Verdict{
+				Action: ActionReject, Code: action.Code, Message: action.Message, Rule: rule.Name, Reason: reason,
+			}
+			if action.Type == config.PolicyTempFail {
+				verdict.Action = ActionTempFail
+			}
+
+			return verdict, nil
+		case config.PolicyQuarantine:
+			m.save()
+
+			return &Verdict{Action: ActionQuarantine, Rule: rule.Name, Reason: reason}, nil
+		case config.PolicyRedirect:
+			m.envelope.Recipients = append([]string{}, action.Recipients...)
+		case config.PolicyAddHeader, config.PolicyRemoveHeader, config.PolicyTagSubject:
+			if err := m.parse(); err != nil {
+				return nil, err
+			}
+
+			m.modifyHeader(action)
+		}
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+func (m *policyMessage) modifyHeader(action config.FilterPolicyAction) {
+	header := m.parsed.Header
+
+	switch action.Type {
+	case config.PolicyAddHeader:
+		header.Prepend(action.Name, encodeHeader(action.Value))
+	case config.PolicyRemoveHeader:
+		if header.Del(action.Name) == 0 {
+			return
+		}
+	case config.PolicyTagSubject:
+		subject := header.Get("Subject")
+		if strings.HasPrefix(decodeHeader(subject), action.Value) {
+			return
+		}
+
+		header.Set("Subject", strings.TrimSpace(encodeHeader(action.Value)+" "+subject))
+	case config.PolicyReject, config.PolicyTempFail, config.PolicyQuarantine, config.PolicyRedirect:
+		return
+	}
+
+	m.modified = true
+}
+
+func (m *policyMessage) parse() error {
+	if m.parsed != nil {
+		return nil
+	}
+
+	parsed, err := message.Parse(m.envelope.Data)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	m.parsed = parsed
+
+	return nil
+}
+
+// text returns decoded content of all textual, non attachment parts of the message.
+func (m *policyMessage) text() (string, error) {
+	if m.body != nil {
+		return *m.body, nil
+	}
+
+	if err := m.parse(); err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+
+	err := m.parsed.Walk(func(part *message.Part) error {
+		mediaType, _ := part.MediaType()
+		if part.IsMultipart() || part.IsAttachment() || !strings.HasPrefix(mediaType, "text/") {
+			return nil
+		}
+
+		content, err := part.Content()
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		builder.Write(content)
+		builder.WriteString("\n")
+
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+
+	body := builder.String()
+	m.body = &body
+
+	return body, nil
+}
+
+// save writes modified header back to the envelope.
+func (m *policyMessage) save() {
+	if m.modified {
+		m.envelope.Data = m.parsed.Bytes()
+		m.modified = false
+	}
+}
+
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+
+	return decoded
+}
+
+func encodeHeader(value string) string {
+	if message.IsASCII(value) {
+		return value
+	}
+
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+func nonEmpty(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+
+	return []string{value}
+}
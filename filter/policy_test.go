@@ -0,0 +1,207 @@
+package filter_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+const policyMessage = "From: sender@example.local\r\nTo: rcpt@example.local\r\n" +
+	"Subject: =?utf-8?q?Password_reset?=\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\nClick here to reset your passw=\r\nord\r\n"
+
+func newPolicy(t *testing.T, rules string) *filter.Policy {
+	t.Helper()
+
+	rulesFile := filepath.Join(t.TempDir(), "rules.yml")
+	assert.NoError(t, os.WriteFile(rulesFile, []byte(rules), 0o600))
+
+	policy, err := filter.NewPolicy(config.FilterPolicy{Enabled: true, RulesFile: rulesFile})
+	assert.NoError(t, err)
+
+	return policy
+}
+
+func runPolicy(t *testing.T, policy *filter.Policy, envelope *filter.Envelope) *filter.Verdict {
+	t.Helper()
+
+	if envelope.Data == nil {
+		envelope.Data = []byte(policyMessage)
+	}
+
+	verdict, err := policy.Filter(context.Background(), envelope)
+	assert.NoError(t, err)
+
+	return verdict
+}
+
+func TestPolicyRejectsMatchingMessage(t *testing.T) {
+	t.Parallel()
+
+	policy := newPolicy(t, `
+- name: password-reset
+  conditions:
+    - field: header:Subject
+      operator: matches
+      value: (?i)password reset
+    - field: sender
+      operator: matches
+      value: ^noreply@
+      negate: true
+  actions:
+    - action: reject
+      code: 550
+      message: 5.7.1 password resets are sent from noreply only
+`)
+
+	verdict := runPolicy(t, policy, &filter.Envelope{Sender: "sender@example.local"})
+	assert.Equal(t, &filter.Verdict{
+		Action: filter.ActionReject, Code: 550, Message: "5.7.1 password resets are sent from noreply only",
+		Rule: "password-reset", Reason: "policy rule password-reset",
+	}, verdict)
+
+	assert.Nil(t, runPolicy(t, policy, &filter.Envelope{Sender: "noreply@example.local"}))
+}
+
+func TestPolicyConditions(t *testing.T) {
+	t.Parallel()
+
+	envelope := func() *filter.Envelope {
+		return &filter.Envelope{
+			Sender: "sender@example.local", Recipients: []string{"a@example.local", "b@other.local"},
+			RemoteIP: net.ParseIP("192.0.2.1"), Helo: "client.local", Username: "app", Server: "smtp://0.0.0.0:25",
+		}
+	}
+
+	cases := map[string]bool{
+		"{field: body, operator: contains, value: reset your password}":   true,
+		"{field: body, operator: contains, value: invoice}":               false,
+		"{field: recipient, operator: matches, value: '@other\\.local$'}": true,
+		"{field: size, operator: gt, value: 100}":                         true,
+		"{field: size, operator: lt, value: 100}":                         false,
+		"{field: username, operator: equals, value: APP}":                 true,
+		"{field: username, operator: exists, negate: true}":               false,
+		"{field: listener, operator: contains, value: ':25'}":             true,
+		"{field: remote_ip, operator: matches, value: '^192\\.0\\.2\\.'}": true,
+		"{field: helo, operator: equals, value: other.local}":             false,
+		"{field: header:X-Missing, operator: exists}":                     false,
+		"{field: header:To, operator: exists}":                            true,
+	}
+
+	for condition, expected := range cases {
+		policy := newPolicy(t, "- conditions: ["+condition+"]\n  actions: [{action: quarantine}]\n")
+		verdict := runPolicy(t, policy, envelope())
+
+		if expected {
+			assert.Equal(
+				t, &filter.Verdict{Action: filter.ActionQuarantine, Rule: "rule-1", Reason: "policy rule rule-1"}, verdict,
+				condition,
+			)
+		} else {
+			assert.Nil(t, verdict, condition)
+		}
+	}
+}
+
+func TestPolicyMatchAny(t *testing.T) {
+	t.Parallel()
+
+	policy := newPolicy(t, `
+- name: any
+  match: any
+  conditions:
+    - {field: sender, operator: equals, value: nobody@example.local}
+    - {field: recipient, operator: equals, value: rcpt@example.local}
+  actions:
+    - {action: tempfail}
+`)
+
+	verdict := runPolicy(t, policy, &filter.Envelope{Recipients: []string{"rcpt@example.local"}})
+	assert.Equal(t, &filter.Verdict{
+		Action: filter.ActionTempFail, Code: 451, Message: "message temporarily rejected by policy, try again later",
+		Rule: "any", Reason: "policy rule any",
+	}, verdict)
+
+	assert.Nil(t, runPolicy(t, policy, &filter.Envelope{Recipients: []string{"other@example.local"}}))
+}
+
+func TestPolicyModifiesMessage(t *testing.T) {
+	t.Parallel()
+
+	policy := newPolicy(t, `
+- name: external
+  conditions:
+    - {field: sender, operator: matches, value: '@example\.local$'}
+  actions:
+    - {action: tag_subject, value: "[EXT]"}
+    - {action: add_header, name: X-Policy, value: external}
+    - {action: remove_header, name: To}
+    - {action: redirect, recipients: [review@example.local]}
+- name: tagged-twice
+  actions:
+    - {action: tag_subject, value: "[EXT]"}
+  stop: true
+- name: never-reached
+  actions:
+    - {action: reject}
+`)
+
+	envelope := &filter.Envelope{Sender: "sender@example.local", Recipients: []string{"rcpt@example.local"}}
+	assert.Nil(t, runPolicy(t, policy, envelope))
+
+	data := string(envelope.Data)
+	assert.Equal(t, []string{"review@example.local"}, envelope.Recipients)
+	assert.True(t, strings.HasPrefix(data, "X-Policy: external\r\nFrom: sender@example.local\r\n"))
+	assert.Contains(t, data, "Subject: [EXT] =?utf-8?q?Password_reset?=\r\n")
+	assert.NotContains(t, data, "To: rcpt@example.local")
+	assert.Equal(t, 1, strings.Count(data, "[EXT]"))
+}
+
+func TestPolicyReload(t *testing.T) {
+	t.Parallel()
+
+	rulesFile := filepath.Join(t.TempDir(), "rules.yml")
+	assert.NoError(t, os.WriteFile(rulesFile, []byte("- actions: [{action: quarantine}]\n"), 0o600))
+
+	inline := config.FilterPolicyRule{
+		Name: "inline",
+		Conditions: []config.FilterPolicyCondition{
+			{Field: config.PolicySender, Operator: config.PolicyEquals, Value: "inline@example.local"},
+		},
+		Actions: []config.FilterPolicyAction{{Type: config.PolicyReject, Code: 550, Message: "inline"}},
+	}
+
+	policy, err := filter.NewPolicy(config.FilterPolicy{
+		Enabled: true, RulesFile: rulesFile, Rules: []config.FilterPolicyRule{inline},
+	})
+	assert.NoError(t, err)
+
+	verdict := runPolicy(t, policy, &filter.Envelope{Sender: "inline@example.local"})
+	assert.Equal(t, "policy rule inline", verdict.Reason)
+	verdict = runPolicy(t, policy, &filter.Envelope{Sender: "sender@example.local"})
+	assert.Equal(t, filter.ActionQuarantine, verdict.Action)
+
+	assert.NoError(t, os.WriteFile(rulesFile, []byte("- actions: [{action: tempfail}]\n"), 0o600))
+	assert.NoError(t, policy.Reload())
+
+	verdict = runPolicy(t, policy, &filter.Envelope{Sender: "sender@example.local"})
+	assert.Equal(t, filter.ActionTempFail, verdict.Action)
+
+	// broken file keeps previous rules
+	assert.NoError(t, os.WriteFile(rulesFile, []byte("- actions: [{action: explode}]\n"), 0o600))
+	assert.EqualError(
+		t, policy.Reload(),
+		"invalid policy rules file `"+rulesFile+"`: rule #1: invalid policy action: `explode`",
+	)
+
+	verdict = runPolicy(t, policy, &filter.Envelope{Sender: "sender@example.local"})
+	assert.Equal(t, filter.ActionTempFail, verdict.Action)
+}
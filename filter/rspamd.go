@@ -0,0 +1,83 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+const rspamdMaxReplySize = 1024 * 1024
+
+// Rspamd submits messages to rspamd HTTP `/checkv2` endpoint, together with the envelope, client IP, HELO and
+// authenticated user.
+type Rspamd struct {
+	URL    string
+	Client *http.Client
+}
+
+type rspamdReply struct {
+	Score         float64             `json:"score"`
+	RequiredScore float64             `json:"required_score"`
+	Action        string              `json:"action"`
+	Symbols       map[string]struct{} `json:"symbols"`
+}
+
+func (r *Rspamd) Check(ctx context.Context, envelope *Envelope) (*SpamResult, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL+"/checkv2", bytes.NewReader(envelope.Data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSpamCheck, err.Error())
+	}
+
+	if envelope.RemoteIP != nil {
+		request.Header.Set("IP", envelope.RemoteIP.String())
+	}
+
+	if envelope.Username != "" {
+		request.Header.Set("User", envelope.Username)
+	}
+
+	if envelope.Helo != "" {
+		request.Header.Set("Helo", envelope.Helo)
+	}
+
+	request.Header.Set("From", envelope.Sender)
+
+	for _, recipient := range envelope.Recipients {
+		request.Header.Add("Rcpt", recipient)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSpamCheck, err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: rspamd responded with %s", ErrSpamCheck, response.Status)
+	}
+
+	var reply rspamdReply
+
+	if err = json.NewDecoder(io.LimitReader(response.Body, rspamdMaxReplySize)).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSpamCheck, err.Error())
+	}
+
+	result := &SpamResult{Score: reply.Score, Required: reply.RequiredScore, Symbols: make([]string, 0)}
+
+	for symbol := range reply.Symbols {
+		result.Symbols = append(result.Symbols, symbol)
+	}
+
+	sort.Strings(result.Symbols)
+
+	return result, nil
+}
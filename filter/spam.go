@@ -0,0 +1,144 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+)
+
+var ErrSpamCheck = errors.New("spam check failed")
+
+// SpamResult is a score given to the message by the scanner, together with names of matched rules.
+type SpamResult struct {
+	Score    float64
+	Required float64
+	Symbols  []string
+}
+
+type SpamScanner interface {
+	Check(ctx context.Context, envelope *Envelope) (*SpamResult, error)
+}
+
+// Spam scores messages with spamd or rspamd. Scanner verdicts are ignored, configured thresholds decide whether
+// the message is rejected, quarantined, or relayed with X-Spam-* headers.
+type Spam struct {
+	Scanner         SpamScanner
+	FailOpen        bool
+	Timeout         time.Duration
+	HeaderScore     float64
+	QuarantineScore float64
+	RejectScore     float64
+}
+
+func NewSpam(conf config.FilterSpam) *Spam {
+	var scanner SpamScanner
+
+	switch conf.Engine {
+	case config.SpamEngineRspamd:
+		scanner = &Rspamd{URL: conf.Address}
+	case config.SpamEngineSpamd:
+		scanner = &Spamd{Network: conf.Network, Address: conf.Address}
+	}
+
+	return &Spam{
+		Scanner:         scanner,
+		FailOpen:        conf.FailOpen,
+		Timeout:         conf.Timeout,
+		HeaderScore:     conf.HeaderScore,
+		QuarantineScore: conf.QuarantineScore,
+		RejectScore:     conf.RejectScore,
+	}
+}
+
+func (s *Spam) GetName() string {
+	return "spam"
+}
+
+func (s *Spam) Filter(ctx context.Context, envelope *Envelope) (*Verdict, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	result, err := s.Scanner.Check(ctx, envelope)
+	if err != nil {
+		if s.FailOpen {
+			log.Warnw("spam check failed, accepting message unchecked", log.Fields{
+				"server": envelope.Server, "from": envelope.Sender, "to": envelope.Recipients, "error": err.Error(),
+			})
+
+			return nil, nil //nolint:nilnil
+		}
+
+		return nil, err
+	}
+
+	log.Debugw("spam score", log.Fields{
+		"server": envelope.Server, "from": envelope.Sender, "score": formatScore(result.Score), "symbols": result.Symbols,
+	})
+
+	reason := fmt.Sprintf("spam score %s", formatScore(result.Score))
+
+	if s.RejectScore > 0 && result.Score >= s.RejectScore {
+		verdict := reject(CodeTransactionFail, "message rejected as spam")
+		verdict.Reason = reason
+
+		return verdict, nil
+	}
+
+	if s.QuarantineScore > 0 && result.Score >= s.QuarantineScore {
+		return &Verdict{Action: ActionQuarantine, Reason: reason}, nil
+	}
+
+	if s.HeaderScore > 0 {
+		if err = s.addHeaders(envelope, result); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+// addHeaders replaces X-Spam-* headers set by the client, with the ones describing current score.
+func (s *Spam) addHeaders(envelope *Envelope, result *SpamResult) error {
+	parsed, err := message.Parse(envelope.Data)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	status := "No"
+	if result.Score >= s.HeaderScore {
+		status = "Yes"
+	}
+
+	parsed.Header.Del("X-Spam-Flag")
+	parsed.Header.Del("X-Spam-Score")
+	parsed.Header.Del("X-Spam-Status")
+
+	parsed.Header.Prepend("X-Spam-Status", fmt.Sprintf(
+		"%s, score=%s required=%s tests=%s",
+		status, formatScore(result.Score), formatScore(s.HeaderScore), strings.Join(result.Symbols, ","),
+	))
+	parsed.Header.Prepend("X-Spam-Score", formatScore(result.Score))
+
+	if status == "Yes" {
+		parsed.Header.Prepend("X-Spam-Flag", "YES")
+	}
+
+	envelope.Data = parsed.Bytes()
+
+	return nil
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', 1, 64)
+}
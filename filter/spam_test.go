@@ -0,0 +1,255 @@
+package filter_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+type spamdRequest struct {
+	command string
+	headers map[string]string
+	body    string
+}
+
+// fakeSpamd answers SYMBOLS requests with the score taken from `X-Test-Score` header of the message.
+func fakeSpamd(t *testing.T) (string, chan spamdRequest) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	t.Cleanup(func() { listener.Close() })
+
+	requests := make(chan spamdRequest, 10)
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go handleSpamdConnection(connection, requests)
+		}
+	}()
+
+	return listener.Addr().String(), requests
+}
+
+func handleSpamdConnection(connection net.Conn, requests chan spamdRequest) {
+	defer connection.Close()
+
+	reader := bufio.NewReader(connection)
+	request := spamdRequest{headers: make(map[string]string)}
+
+	command, _ := reader.ReadString('\n')
+	request.command = strings.TrimSpace(command)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil || strings.TrimSpace(line) == "" {
+			break
+		}
+
+		parts := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+		request.headers[parts[0]] = parts[1]
+	}
+
+	length, _ := strconv.Atoi(request.headers["Content-length"])
+	body := make([]byte, length)
+	_, _ = io.ReadFull(reader, body)
+	request.body = string(body)
+	requests <- request
+
+	score := "0.0"
+	if i := strings.Index(request.body, "X-Test-Score: "); i >= 0 {
+		score = strings.Fields(request.body[i+len("X-Test-Score: "):])[0]
+	}
+
+	fmt.Fprintf(connection, "SPAMD/1.1 0 EX_OK\r\nContent-length: 20\r\nSpam: False ; %s / 5.0\r\n\r\nBAYES_99,URIBL_BLACK", score)
+}
+
+func spamMessage(score string) []byte {
+	return []byte("X-Spam-Flag: YES\r\nX-Test-Score: " + score + "\r\nSubject: test\r\n\r\nbody\r\n")
+}
+
+func spamEnvelope(data []byte) *filter.Envelope {
+	return &filter.Envelope{
+		Sender: "sender@example.local", Recipients: []string{"rcpt@example.local", "other@example.local"}, Data: data,
+		RemoteIP: net.ParseIP("192.0.2.10"), Helo: "client.example.local", Username: "app@example.local",
+	}
+}
+
+func spamdConf(address string) config.FilterSpam {
+	return config.FilterSpam{
+		Engine: config.SpamEngineSpamd, Network: "tcp", Address: address, Timeout: 5 * time.Second,
+		HeaderScore: 5, QuarantineScore: 10, RejectScore: 15,
+	}
+}
+
+func TestSpamdHeadersAdded(t *testing.T) {
+	t.Parallel()
+
+	address, requests := fakeSpamd(t)
+	envelope := spamEnvelope(spamMessage("7.5"))
+
+	verdict, err := filter.NewSpam(spamdConf(address)).Filter(context.Background(), envelope)
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+
+	request := <-requests
+	assert.Equal(t, "SYMBOLS SPAMC/1.5", request.command)
+	assert.Equal(t, "app@example.local", request.headers["User"])
+	// client IP is passed in Received field of spamd copy only
+	assert.True(t, strings.HasPrefix(request.body, "Received: from client.example.local ([192.0.2.10]) by mailbowl"))
+	assert.True(t, strings.HasSuffix(request.body, string(spamMessage("7.5"))))
+
+	assert.Equal(
+		t, "X-Spam-Flag: YES\r\nX-Spam-Score: 7.5\r\n"+
+			"X-Spam-Status: Yes, score=7.5 required=5.0 tests=BAYES_99,URIBL_BLACK\r\n"+
+			"X-Test-Score: 7.5\r\nSubject: test\r\n\r\nbody\r\n",
+		string(envelope.Data),
+	)
+}
+
+func TestSpamdKeepsListenerReceived(t *testing.T) {
+	t.Parallel()
+
+	address, requests := fakeSpamd(t)
+	data := "Received: from client.example.local ([192.0.2.10]) by mx.example.local with ESMTP;\r\n" +
+		"\tMon, 02 Jan 2006 15:04:05 -0700\r\n" + string(spamMessage("1.0"))
+
+	_, err := filter.NewSpam(spamdConf(address)).Filter(context.Background(), spamEnvelope([]byte(data)))
+	assert.NoError(t, err)
+
+	assert.Equal(t, data, (<-requests).body)
+}
+
+func TestSpamdBelowHeaderScore(t *testing.T) {
+	t.Parallel()
+
+	address, _ := fakeSpamd(t)
+	envelope := spamEnvelope(spamMessage("1.2"))
+
+	verdict, err := filter.NewSpam(spamdConf(address)).Filter(context.Background(), envelope)
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+
+	assert.True(t, strings.HasPrefix(string(envelope.Data), "X-Spam-Score: 1.2\r\nX-Spam-Status: No, score=1.2"))
+	assert.NotContains(t, string(envelope.Data), "X-Spam-Flag")
+}
+
+func TestSpamdQuarantined(t *testing.T) {
+	t.Parallel()
+
+	address, _ := fakeSpamd(t)
+
+	verdict, err := filter.NewSpam(spamdConf(address)).Filter(context.Background(), spamEnvelope(spamMessage("12")))
+	assert.NoError(t, err)
+	assert.Equal(t, filter.ActionQuarantine, verdict.Action)
+	assert.Equal(t, "spam score 12.0", verdict.Reason)
+}
+
+func TestSpamdRejected(t *testing.T) {
+	t.Parallel()
+
+	address, _ := fakeSpamd(t)
+
+	verdict, err := filter.NewSpam(spamdConf(address)).Filter(context.Background(), spamEnvelope(spamMessage("20.3")))
+	assert.NoError(t, err)
+	assert.Equal(t, filter.ActionReject, verdict.Action)
+	assert.Equal(t, 554, verdict.Code)
+	assert.Equal(t, "message rejected as spam", verdict.Message)
+	assert.Equal(t, "spam score 20.3", verdict.Reason)
+}
+
+func TestSpamdUnavailable(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	address := listener.Addr().String()
+	listener.Close()
+
+	conf := spamdConf(address)
+
+	_, err = filter.NewSpam(conf).Filter(context.Background(), spamEnvelope(spamMessage("1")))
+	assert.ErrorIs(t, err, filter.ErrSpamCheck)
+
+	conf.FailOpen = true
+
+	verdict, err := filter.NewSpam(conf).Filter(context.Background(), spamEnvelope(spamMessage("1")))
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+}
+
+func TestRspamd(t *testing.T) {
+	t.Parallel()
+
+	var request *http.Request
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, r *http.Request) {
+		request = r
+
+		_ = json.NewEncoder(writer).Encode(map[string]interface{}{
+			"score": 11.25, "required_score": 15, "action": "add header",
+			"symbols": map[string]interface{}{
+				"R_SPF_FAIL":  map[string]interface{}{"name": "R_SPF_FAIL", "score": 1},
+				"ABUSE_SURBL": map[string]interface{}{"name": "ABUSE_SURBL", "score": 10.25},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	conf := config.FilterSpam{
+		Engine: config.SpamEngineRspamd, Address: server.URL, Timeout: 5 * time.Second, HeaderScore: 5,
+	}
+	envelope := spamEnvelope(spamMessage("0"))
+
+	verdict, err := filter.NewSpam(conf).Filter(context.Background(), envelope)
+	assert.NoError(t, err)
+	assert.Nil(t, verdict)
+
+	assert.Equal(t, http.MethodPost, request.Method)
+	assert.Equal(t, "/checkv2", request.URL.Path)
+	assert.Equal(t, "192.0.2.10", request.Header.Get("IP"))
+	assert.Equal(t, "app@example.local", request.Header.Get("User"))
+	assert.Equal(t, "client.example.local", request.Header.Get("Helo"))
+	assert.Equal(t, "sender@example.local", request.Header.Get("From"))
+	assert.Equal(t, []string{"rcpt@example.local", "other@example.local"}, request.Header.Values("Rcpt"))
+
+	assert.True(t, strings.HasPrefix(
+		string(envelope.Data),
+		"X-Spam-Flag: YES\r\nX-Spam-Score: 11.2\r\nX-Spam-Status: Yes, score=11.2 required=5.0 "+
+			"tests=ABUSE_SURBL,R_SPF_FAIL\r\n",
+	))
+}
+
+func TestRspamdError(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, r *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	conf := config.FilterSpam{Engine: config.SpamEngineRspamd, Address: server.URL, RejectScore: 15}
+
+	_, err := filter.NewSpam(conf).Filter(context.Background(), spamEnvelope(spamMessage("0")))
+	assert.EqualError(t, err, "spam check failed: rspamd responded with 500 Internal Server Error")
+}
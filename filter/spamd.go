@@ -0,0 +1,169 @@
+package filter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajgon/mailbowl/message"
+)
+
+const (
+	spamcVersion      = "SPAMC/1.5"
+	spamdMaxReplySize = 64 * 1024
+	spamdScoreParts   = 2
+)
+
+// Spamd speaks SPAMC protocol. Authenticated user is passed in User header, so per-user preferences apply,
+// client IP is taken by spamd from the Received line added by the listener (or by spamd copy only, when privacy
+// settings leave it out).
+type Spamd struct {
+	Network string
+	Address string
+}
+
+func (s *Spamd) Check(ctx context.Context, envelope *Envelope) (*SpamResult, error) {
+	var dialer net.Dialer
+
+	connection, err := dialer.DialContext(ctx, s.Network, s.Address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSpamCheck, err.Error())
+	}
+	defer connection.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = connection.SetDeadline(deadline)
+	}
+
+	data := withClientReceived(envelope)
+
+	request := fmt.Sprintf("SYMBOLS %s\r\nContent-length: %d\r\n", spamcVersion, len(data))
+	if envelope.Username != "" {
+		request += fmt.Sprintf("User: %s\r\n", envelope.Username)
+	}
+
+	writer := bufio.NewWriter(connection)
+	_, _ = writer.WriteString(request + "\r\n")
+	_, _ = writer.Write(data)
+
+	if err = writer.Flush(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSpamCheck, err.Error())
+	}
+
+	return parseSpamdReply(bufio.NewReader(io.LimitReader(connection, spamdMaxReplySize)))
+}
+
+// withClientReceived adds Received field with the client IP, when the topmost one doesn't have it (privacy
+// received set to omit or anonymize), so IP based rules (RBL, SPF) still work. Relayed message is not changed.
+func withClientReceived(envelope *Envelope) []byte {
+	if envelope.RemoteIP == nil {
+		return envelope.Data
+	}
+
+	literal := fmt.Sprintf("[%s]", envelope.RemoteIP.String())
+
+	if header, _, err := message.ParseHeader(envelope.Data); err == nil {
+		for _, field := range header.Fields {
+			if !strings.EqualFold(field.Name, "Received") {
+				continue
+			}
+
+			if strings.Contains(field.Value(), literal) {
+				return envelope.Data
+			}
+
+			break
+		}
+	}
+
+	helo := envelope.Helo
+	if helo == "" {
+		helo = "unknown"
+	}
+
+	line := fmt.Sprintf(
+		"Received: from %s (%s) by mailbowl with ESMTP;\r\n\t%s\r\n",
+		helo, literal, time.Now().Format("Mon, 02 Jan 2006 15:04:05 -0700 (MST)"),
+	)
+
+	return append([]byte(line), envelope.Data...)
+}
+
+// parseSpamdReply reads `SPAMD/1.1 0 EX_OK` status line, `Spam: True ; 15.3 / 5.0` header, and comma separated
+// list of symbols in the body.
+func parseSpamdReply(reader *bufio.Reader) (*SpamResult, error) {
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSpamCheck, err.Error())
+	}
+
+	if fields := strings.Fields(status); len(fields) < 3 || !strings.HasPrefix(fields[0], "SPAMD/") || fields[1] != "0" {
+		return nil, fmt.Errorf("%w: %s", ErrSpamCheck, strings.TrimSpace(status))
+	}
+
+	var result *SpamResult
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrSpamCheck, err.Error())
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(strings.ToLower(line), "spam:") {
+			if result, err = parseSpamdScore(line); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("%w: missing Spam header", ErrSpamCheck)
+	}
+
+	body, _ := io.ReadAll(reader)
+
+	for _, symbol := range strings.Split(string(body), ",") {
+		if symbol = strings.TrimSpace(symbol); symbol != "" {
+			result.Symbols = append(result.Symbols, symbol)
+		}
+	}
+
+	sort.Strings(result.Symbols)
+
+	return result, nil
+}
+
+func parseSpamdScore(line string) (*SpamResult, error) {
+	parts := strings.SplitN(line[strings.IndexByte(line, ':')+1:], ";", spamdScoreParts)
+	if len(parts) != spamdScoreParts {
+		return nil, fmt.Errorf("%w: invalid Spam header `%s`", ErrSpamCheck, line)
+	}
+
+	scores := strings.SplitN(parts[1], "/", spamdScoreParts)
+	if len(scores) != spamdScoreParts {
+		return nil, fmt.Errorf("%w: invalid Spam header `%s`", ErrSpamCheck, line)
+	}
+
+	score, err := strconv.ParseFloat(strings.TrimSpace(scores[0]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid Spam header `%s`", ErrSpamCheck, line)
+	}
+
+	required, err := strconv.ParseFloat(strings.TrimSpace(scores[1]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid Spam header `%s`", ErrSpamCheck, line)
+	}
+
+	return &SpamResult{Score: score, Required: required, Symbols: make([]string, 0)}, nil
+}
@@ -0,0 +1,305 @@
+package journal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+)
+
+const (
+	directoryMode = 0o750
+	fileMode      = 0o640
+	idRandomBytes = 6
+
+	pendingExtension = ".pending"
+	readyExtension   = ".eml"
+
+	// pending reports older than that were left behind by a process which died while relaying, they are delivered,
+	// as it's not known whether the message was relayed or not
+	stalePendingAge = time.Hour
+)
+
+var ErrJournalDisabled = errors.New("journal not configured")
+
+// Relayer sends journal reports to the archive address. It is satisfied by relay.Relay.
+type Relayer interface {
+	Handle(from string, recipients []string, message []byte) error
+}
+
+// Report describes the relayed message, it becomes the envelope report sent together with the message.
+type Report struct {
+	Sender     string
+	Recipients []string
+	RemoteIP   string
+	Username   string
+	Listener   string
+	Received   time.Time
+}
+
+// Entry is a journal report waiting in the spool.
+type Entry struct {
+	ID   string
+	Data []byte
+}
+
+// Journal keeps copies of relayed messages. Reports are written to the spool before the message is relayed,
+// delivered after it was relayed, and left in the spool, when delivery fails, so they are retried later.
+type Journal struct {
+	Enabled        bool
+	Address        string
+	Sender         string
+	Directory      string
+	SpoolDirectory string
+	Hostname       string
+	Relayer        Relayer
+}
+
+func NewJournal(conf config.Journal, hostname string, relayer Relayer) *Journal {
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+
+	return &Journal{
+		Enabled:        conf.Enabled,
+		Address:        conf.Address,
+		Sender:         conf.Sender,
+		Directory:      conf.Directory,
+		SpoolDirectory: conf.SpoolDirectory,
+		Hostname:       hostname,
+		Relayer:        relayer,
+	}
+}
+
+// Prepare builds the report and writes it to the spool. Message shouldn't be relayed, if it fails, as it
+// wouldn't be journaled.
+func (j *Journal) Prepare(report *Report, data []byte) (*Entry, error) {
+	if !j.Enabled {
+		return nil, ErrJournalDisabled
+	}
+
+	if err := os.MkdirAll(j.SpoolDirectory, directoryMode); err != nil {
+		return nil, fmt.Errorf("error creating journal spool: %w", err)
+	}
+
+	entry := &Entry{ID: newID(), Data: j.build(report, data)}
+
+	if err := os.WriteFile(j.path(entry.ID, pendingExtension), entry.Data, fileMode); err != nil {
+		return nil, fmt.Errorf("error writing journal spool: %w", err)
+	}
+
+	return entry, nil
+}
+
+// Cancel removes the report from the spool, when the message wasn't relayed after all.
+func (j *Journal) Cancel(entry *Entry) error {
+	if err := os.Remove(j.path(entry.ID, pendingExtension)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing journal spool entry: %w", err)
+	}
+
+	return nil
+}
+
+// Commit delivers the report of relayed message. When delivery fails, report is kept in the spool for retries.
+func (j *Journal) Commit(entry *Entry) error {
+	pending := j.path(entry.ID, pendingExtension)
+
+	if err := j.deliver(entry); err != nil {
+		if renameErr := os.Rename(pending, j.path(entry.ID, readyExtension)); renameErr != nil {
+			return fmt.Errorf("error keeping journal report %s for retry: %w", entry.ID, renameErr)
+		}
+
+		return fmt.Errorf("journal delivery failed, report %s kept for retry: %w", entry.ID, err)
+	}
+
+	if err := os.Remove(pending); err != nil {
+		return fmt.Errorf("error removing journal spool entry: %w", err)
+	}
+
+	return nil
+}
+
+// Flush retries delivery of all reports waiting in the spool. Returns the number of delivered and failed ones.
+func (j *Journal) Flush(now time.Time) (int, int, error) {
+	entries, err := os.ReadDir(j.SpoolDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+
+		return 0, 0, fmt.Errorf("error reading journal spool: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+
+	delivered, failed := 0, 0
+
+	for _, name := range names {
+		path := filepath.Join(j.SpoolDirectory, name)
+		if !j.ready(path, now) {
+			continue
+		}
+
+		entry := &Entry{ID: strings.TrimSuffix(strings.TrimSuffix(name, readyExtension), pendingExtension)}
+		if entry.Data, err = os.ReadFile(path); err != nil {
+			return delivered, failed, fmt.Errorf("error reading journal spool: %w", err)
+		}
+
+		if err = j.deliver(entry); err != nil {
+			log.Warnw("journal delivery failed", log.Fields{"id": entry.ID, "error": err.Error()})
+
+			failed++
+
+			continue
+		}
+
+		if err = os.Remove(path); err != nil {
+			return delivered, failed, fmt.Errorf("error removing journal spool entry: %w", err)
+		}
+
+		delivered++
+	}
+
+	return delivered, failed, nil
+}
+
+// ready checks if the spool file should be delivered: it failed before, or it is a stale pending one.
+func (j *Journal) ready(path string, now time.Time) bool {
+	switch filepath.Ext(path) {
+	case readyExtension:
+		return true
+	case pendingExtension:
+		info, err := os.Stat(path)
+
+		return err == nil && now.Sub(info.ModTime()) > stalePendingAge
+	}
+
+	return false
+}
+
+// deliver sends the report to the archive address and writes it to the archive directory. Both are idempotent
+// for the same entry, so partially failed deliveries can be safely retried.
+func (j *Journal) deliver(entry *Entry) error {
+	if j.Directory != "" {
+		if err := writeFile(filepath.Join(j.Directory, entry.ID+readyExtension), entry.Data); err != nil {
+			return err
+		}
+	}
+
+	if j.Address != "" {
+		if err := j.Relayer.Handle(j.Sender, []string{j.Address}, entry.Data); err != nil {
+			return fmt.Errorf("error sending journal report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// build wraps the message with the envelope report, listing real sender and all the recipients, including the ones
+// not present in message header (BCC).
+func (j *Journal) build(report *Report, data []byte) []byte {
+	const eol = "\r\n"
+
+	var text strings.Builder
+
+	fmt.Fprintf(&text, "Sender: %s%s", report.Sender, eol)
+
+	for _, recipient := range report.Recipients {
+		fmt.Fprintf(&text, "Recipient: %s%s", recipient, eol)
+	}
+
+	fmt.Fprintf(&text, "Remote-IP: %s%s", report.RemoteIP, eol)
+
+	if report.Username != "" {
+		fmt.Fprintf(&text, "Username: %s%s", report.Username, eol)
+	}
+
+	fmt.Fprintf(&text, "Listener: %s%s", report.Listener, eol)
+	fmt.Fprintf(&text, "Received: %s%s", message.FormatDate(report.Received), eol)
+
+	subject, messageID := "", ""
+	if header, _, err := message.ParseHeader(data); err == nil {
+		subject, messageID = header.Get("Subject"), header.Get("Message-ID")
+	}
+
+	if messageID != "" {
+		fmt.Fprintf(&text, "Message-ID: %s%s", messageID, eol)
+	}
+
+	journal := message.NewMultipart("multipart/mixed", nil, []*message.Part{
+		message.NewPart("text/plain; charset=utf-8", []byte(text.String()), transferEncoding(text.String()), eol),
+		message.NewPart("message/rfc822", data, transferEncoding(string(data)), eol),
+	}, eol)
+
+	from := j.Sender
+	if from == "" {
+		from = "postmaster@" + j.Hostname
+	}
+
+	journal.Header.Prepend("MIME-Version", "1.0")
+	journal.Header.Prepend("X-Mailbowl-Journal-Report", "1.0")
+	journal.Header.Prepend("Message-ID", message.NewMessageID(j.Hostname))
+	journal.Header.Prepend("Date", message.FormatDate(time.Now()))
+	journal.Header.Prepend("Subject", strings.TrimSpace("Journal report: "+subject))
+
+	if j.Address != "" {
+		journal.Header.Prepend("To", j.Address)
+	}
+
+	journal.Header.Prepend("From", from)
+
+	return journal.Bytes()
+}
+
+func (j *Journal) path(id, extension string) string {
+	return filepath.Join(j.SpoolDirectory, id+extension)
+}
+
+func transferEncoding(content string) string {
+	if message.IsASCII(content) {
+		return message.Encoding7Bit
+	}
+
+	return message.Encoding8Bit
+}
+
+// writeFile replaces the file atomically, so readers never see partially written reports.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), directoryMode); err != nil {
+		return fmt.Errorf("error creating journal directory: %w", err)
+	}
+
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, data, fileMode); err != nil {
+		return fmt.Errorf("error writing journal report: %w", err)
+	}
+
+	if err := os.Rename(temporary, path); err != nil {
+		_ = os.Remove(temporary)
+
+		return fmt.Errorf("error writing journal report: %w", err)
+	}
+
+	return nil
+}
+
+func newID() string {
+	buffer := make([]byte, idRandomBytes)
+	_, _ = rand.Read(buffer)
+
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(buffer)
+}
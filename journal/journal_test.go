@@ -0,0 +1,176 @@
+package journal_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/journal"
+	"github.com/ajgon/mailbowl/message"
+	"github.com/stretchr/testify/assert"
+)
+
+const journalTestMessage = "From: sender@example.local\r\nTo: rcpt@example.local\r\nSubject: hello\r\n" +
+	"Message-ID: <id@example.local>\r\n\r\nbody\r\n"
+
+type fakeRelayer struct {
+	calls      int
+	sender     string
+	recipients []string
+	data       []byte
+	err        error
+}
+
+func (f *fakeRelayer) Handle(from string, recipients []string, message []byte) error {
+	f.calls++
+	f.sender, f.recipients, f.data = from, recipients, message
+
+	return f.err
+}
+
+func newJournal(t *testing.T, relayer *fakeRelayer) *journal.Journal {
+	t.Helper()
+
+	directory := t.TempDir()
+
+	return journal.NewJournal(config.Journal{
+		Enabled:        true,
+		Address:        "archive@example.local",
+		Directory:      filepath.Join(directory, "archive"),
+		SpoolDirectory: filepath.Join(directory, "spool"),
+	}, "mx.example.local", relayer)
+}
+
+func testReport() *journal.Report {
+	return &journal.Report{
+		Sender:     "sender@example.local",
+		Recipients: []string{"rcpt@example.local", "hidden@example.local"},
+		RemoteIP:   "192.0.2.1",
+		Username:   "app@example.local",
+		Listener:   "tls://0.0.0.0:465",
+		Received:   time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+}
+
+func spoolFiles(t *testing.T, j *journal.Journal) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(j.SpoolDirectory, "*"))
+	assert.NoError(t, err)
+
+	return files
+}
+
+func TestJournalReport(t *testing.T) {
+	t.Parallel()
+
+	relayer := &fakeRelayer{}
+	j := newJournal(t, relayer)
+
+	entry, err := j.Prepare(testReport(), []byte(journalTestMessage))
+	assert.NoError(t, err)
+	assert.Len(t, spoolFiles(t, j), 1)
+
+	assert.NoError(t, j.Commit(entry))
+	assert.Empty(t, spoolFiles(t, j))
+
+	assert.Equal(t, "", relayer.sender)
+	assert.Equal(t, []string{"archive@example.local"}, relayer.recipients)
+
+	archived, err := os.ReadFile(filepath.Join(j.Directory, entry.ID+".eml"))
+	assert.NoError(t, err)
+	assert.Equal(t, relayer.data, archived)
+
+	report, err := message.Parse(relayer.data)
+	assert.NoError(t, err)
+	assert.Equal(t, "postmaster@mx.example.local", report.Header.Get("From"))
+	assert.Equal(t, "archive@example.local", report.Header.Get("To"))
+	assert.Equal(t, "Journal report: hello", report.Header.Get("Subject"))
+	assert.Len(t, report.Parts, 2)
+
+	text, err := report.Parts[0].Content()
+	assert.NoError(t, err)
+	assert.Equal(t, "Sender: sender@example.local\r\nRecipient: rcpt@example.local\r\n"+
+		"Recipient: hidden@example.local\r\nRemote-IP: 192.0.2.1\r\nUsername: app@example.local\r\n"+
+		"Listener: tls://0.0.0.0:465\r\nReceived: Fri, 04 Mar 2022 05:06:07 +0000\r\n"+
+		"Message-ID: <id@example.local>\r\n", string(text))
+
+	mediaType, _ := report.Parts[1].MediaType()
+	assert.Equal(t, "message/rfc822", mediaType)
+	assert.Equal(t, journalTestMessage, string(report.Parts[1].Body))
+}
+
+func TestJournalCancel(t *testing.T) {
+	t.Parallel()
+
+	relayer := &fakeRelayer{}
+	j := newJournal(t, relayer)
+
+	entry, err := j.Prepare(testReport(), []byte(journalTestMessage))
+	assert.NoError(t, err)
+
+	assert.NoError(t, j.Cancel(entry))
+	assert.Empty(t, spoolFiles(t, j))
+	assert.Equal(t, 0, relayer.calls)
+}
+
+func TestJournalRetry(t *testing.T) {
+	t.Parallel()
+
+	relayer := &fakeRelayer{err: errors.New("connection refused")}
+	j := newJournal(t, relayer)
+
+	entry, err := j.Prepare(testReport(), []byte(journalTestMessage))
+	assert.NoError(t, err)
+
+	assert.Error(t, j.Commit(entry))
+	assert.Equal(t, []string{filepath.Join(j.SpoolDirectory, entry.ID+".eml")}, spoolFiles(t, j))
+
+	delivered, failed, err := j.Flush(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+	assert.Equal(t, 1, failed)
+	assert.Len(t, spoolFiles(t, j), 1)
+
+	relayer.err = nil
+
+	delivered, failed, err = j.Flush(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, 0, failed)
+	assert.Empty(t, spoolFiles(t, j))
+	assert.Equal(t, 3, relayer.calls)
+}
+
+func TestJournalFlushesStalePending(t *testing.T) {
+	t.Parallel()
+
+	relayer := &fakeRelayer{}
+	j := newJournal(t, relayer)
+
+	_, err := j.Prepare(testReport(), []byte(journalTestMessage))
+	assert.NoError(t, err)
+
+	// still being relayed
+	delivered, _, err := j.Flush(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+
+	// left behind by a process which died
+	delivered, _, err = j.Flush(time.Now().Add(2 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Empty(t, spoolFiles(t, j))
+}
+
+func TestJournalDisabled(t *testing.T) {
+	t.Parallel()
+
+	j := journal.NewJournal(config.Journal{}, "", &fakeRelayer{})
+
+	_, err := j.Prepare(testReport(), []byte(journalTestMessage))
+	assert.ErrorIs(t, err, journal.ErrJournalDisabled)
+}
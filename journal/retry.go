@@ -0,0 +1,56 @@
+package journal
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Masterminds/log-go"
+)
+
+// Retry periodically delivers journal reports left in the spool. It runs alongside listeners, so it stops together
+// with them.
+type Retry struct {
+	Journal  *Journal
+	Interval time.Duration
+}
+
+func NewRetry(journal *Journal, interval time.Duration) *Retry {
+	return &Retry{Journal: journal, Interval: interval}
+}
+
+func (r *Retry) GetName() string {
+	return "journal retry"
+}
+
+func (r *Retry) Serve(ctx context.Context) error {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.flush()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *Retry) flush() {
+	delivered, failed, err := r.Journal.Flush(time.Now())
+	if err != nil {
+		log.Errorw("journal retry failed", log.Fields{"spool_directory": r.Journal.SpoolDirectory, "error": err.Error()})
+	}
+
+	if delivered > 0 {
+		log.Infow("journal reports delivered", log.Fields{"delivered": strconv.Itoa(delivered)})
+	}
+
+	if failed > 0 {
+		log.Errorw("journal reports still undelivered", log.Fields{
+			"spool_directory": r.Journal.SpoolDirectory, "failed": strconv.Itoa(failed),
+		})
+	}
+}
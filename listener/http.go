@@ -8,28 +8,51 @@ import (
 	"time"
 
 	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/quarantine"
 )
 
-type HTTP struct{}
+// HTTP serves health check and admin endpoints. When ACME is set, it responds to HTTP-01 challenges as well.
+// Quarantined messages are released through Releaser, without it they can't be released.
+type HTTP struct {
+	AdminToken string
+	ACME       http.Handler
+	Quarantine *quarantine.Store
+	Releaser   quarantine.Releaser
+}
 
-func NewHTTP() *HTTP {
-	return new(HTTP)
+func NewHTTP(conf config.Config) (*HTTP, error) {
+	return &HTTP{
+		AdminToken: conf.HTTP.AdminToken,
+		Quarantine: quarantine.NewStore(conf.Quarantine),
+	}, nil
 }
 
-func (h HTTP) GetName() string {
+func (h *HTTP) GetName() string {
 	return "HTTP"
 }
 
-func (h HTTP) Serve(ctx context.Context) error {
+// Handler routes health check and admin endpoints.
+func (h *HTTP) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		log.Debugw("/", log.Fields{"path": "/", "status": http.StatusOK})
 		fmt.Fprintf(w, "OK")
 	})
+	mux.HandleFunc("/quarantine", h.admin(h.quarantineList))
+	mux.HandleFunc("/quarantine/", h.admin(h.quarantineEntry))
+
+	if h.ACME != nil {
+		mux.Handle("/.well-known/acme-challenge/", h.ACME)
+	}
+
+	return mux
+}
 
+func (h *HTTP) Serve(ctx context.Context) error {
 	server := &http.Server{
 		Addr:    ":3000",
-		Handler: mux,
+		Handler: h.Handler(),
 	}
 
 	log.Info("HTTP server started on port 3000")
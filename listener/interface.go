@@ -6,3 +6,9 @@ type Listener interface {
 	GetName() string
 	Serve(context.Context) error
 }
+
+// Closer is implemented by listeners keeping resources between restarts (like listen sockets), which have to be
+// released when the process stops.
+type Closer interface {
+	Close() error
+}
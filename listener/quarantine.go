@@ -0,0 +1,140 @@
+package listener
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/quarantine"
+)
+
+// admin guards admin endpoints with bearer token. Without token configured, they are not available at all.
+func (h *HTTP) admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.AdminToken == "" {
+			writeJSONError(w, r, http.StatusNotFound, "admin endpoints disabled")
+
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, r, http.StatusUnauthorized, "invalid admin token")
+
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// quarantineList handles GET /quarantine.
+func (h *HTTP) quarantineList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	entries, err := h.Quarantine.List()
+	if err != nil {
+		writeQuarantineError(w, r, err)
+
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, entries)
+}
+
+// quarantineEntry handles GET and DELETE /quarantine/<id>, GET /quarantine/<id>/message
+// and POST /quarantine/<id>/release.
+//
+//nolint:cyclop
+func (h *HTTP) quarantineEntry(w http.ResponseWriter, r *http.Request) {
+	id, action := strings.TrimPrefix(r.URL.Path, "/quarantine/"), ""
+	if i := strings.IndexByte(id, '/'); i >= 0 {
+		id, action = id[:i], id[i+1:]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		entry, err := h.Quarantine.Entry(id)
+		if err != nil {
+			writeQuarantineError(w, r, err)
+
+			return
+		}
+
+		writeJSON(w, r, http.StatusOK, entry)
+	case action == "" && r.Method == http.MethodDelete:
+		if err := h.Quarantine.Delete(id); err != nil {
+			writeQuarantineError(w, r, err)
+
+			return
+		}
+
+		log.Infow("quarantined message deleted", log.Fields{"id": id})
+		w.WriteHeader(http.StatusNoContent)
+	case action == "message" && r.Method == http.MethodGet:
+		_, data, err := h.Quarantine.Get(id)
+		if err != nil {
+			writeQuarantineError(w, r, err)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "message/rfc822")
+		_, _ = w.Write(data)
+	case action == "release" && r.Method == http.MethodPost:
+		if h.Releaser == nil {
+			writeQuarantineError(w, r, quarantine.ErrRelayDisabled)
+
+			return
+		}
+
+		entry, err := h.Quarantine.Release(id, h.Releaser)
+		if err != nil {
+			writeQuarantineError(w, r, err)
+
+			return
+		}
+
+		log.Infow("quarantined message released", log.Fields{"id": id, "from": entry.Sender, "to": entry.Recipients})
+		writeJSON(w, r, http.StatusOK, entry)
+	case action == "" || action == "message" || action == "release":
+		writeJSONError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeJSONError(w, r, http.StatusNotFound, "not found")
+	}
+}
+
+func writeQuarantineError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, quarantine.ErrNotFound), errors.Is(err, quarantine.ErrQuarantineDisabled):
+		writeJSONError(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, quarantine.ErrInvalidID):
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, quarantine.ErrRelayDisabled):
+		writeJSONError(w, r, http.StatusServiceUnavailable, err.Error())
+	default:
+		log.Errorw("quarantine request failed", log.Fields{"path": r.URL.Path, "error": err.Error()})
+		writeJSONError(w, r, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeJSON(w, r, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, value interface{}) {
+	log.Debugw(r.URL.Path, log.Fields{"path": r.URL.Path, "method": r.Method, "status": strconv.Itoa(status)})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
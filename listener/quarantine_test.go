@@ -0,0 +1,93 @@
+package listener_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener"
+	"github.com/ajgon/mailbowl/quarantine"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAdminHTTP(t *testing.T, token string) (*listener.HTTP, string) {
+	t.Helper()
+
+	httpServer, err := listener.NewHTTP(config.Config{
+		HTTP:       config.HTTP{AdminToken: token},
+		Quarantine: config.Quarantine{Directory: t.TempDir()},
+	})
+	assert.NoError(t, err)
+
+	id, err := httpServer.Quarantine.Put(&quarantine.Entry{
+		Sender: "sender@example.local", Recipients: []string{"rcpt@example.local"}, Filter: "policy", Reason: "test",
+	}, []byte("Subject: test\r\n\r\nbody\r\n"))
+	assert.NoError(t, err)
+
+	return httpServer, id
+}
+
+func adminRequest(httpServer *listener.HTTP, method, path, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	httpServer.Handler().ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestQuarantineEndpointsRequireToken(t *testing.T) {
+	t.Parallel()
+
+	httpServer, _ := newAdminHTTP(t, "")
+	assert.Equal(t, http.StatusNotFound, adminRequest(httpServer, http.MethodGet, "/quarantine", "secret").Code)
+
+	httpServer, _ = newAdminHTTP(t, "secret")
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(httpServer, http.MethodGet, "/quarantine", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(httpServer, http.MethodGet, "/quarantine", "wrong").Code)
+	assert.Equal(t, http.StatusOK, adminRequest(httpServer, http.MethodGet, "/quarantine", "secret").Code)
+}
+
+func TestQuarantineEndpoints(t *testing.T) {
+	t.Parallel()
+
+	httpServer, id := newAdminHTTP(t, "secret")
+
+	response := adminRequest(httpServer, http.MethodGet, "/quarantine", "secret")
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	var entries []quarantine.Entry
+
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, id, entries[0].ID)
+
+	response = adminRequest(httpServer, http.MethodGet, "/quarantine/"+id, "secret")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"reason":"test"`)
+
+	response = adminRequest(httpServer, http.MethodGet, "/quarantine/"+id+"/message", "secret")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "message/rfc822", response.Header().Get("Content-Type"))
+	assert.Equal(t, "Subject: test\r\n\r\nbody\r\n", response.Body.String())
+
+	response = adminRequest(httpServer, http.MethodPost, "/quarantine/"+id+"/release", "secret")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	response = adminRequest(httpServer, http.MethodGet, "/quarantine/"+id+"/release", "secret")
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+
+	response = adminRequest(httpServer, http.MethodGet, "/quarantine/invalid", "secret")
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = adminRequest(httpServer, http.MethodDelete, "/quarantine/"+id, "secret")
+	assert.Equal(t, http.StatusNoContent, response.Code)
+
+	response = adminRequest(httpServer, http.MethodGet, "/quarantine/"+id, "secret")
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
@@ -0,0 +1,34 @@
+package smtp
+
+import (
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/archive"
+	"github.com/ajgon/mailbowl/filter"
+)
+
+// archive appends relayed message to the tamper-evident archive. Message is already relayed at this point, so
+// failures are only logged.
+func (s *Server) archive(envelope *filter.Envelope) {
+	if !s.Archive.Enabled() {
+		return
+	}
+
+	record := &archive.Record{
+		Sender:     envelope.Sender,
+		Recipients: envelope.Recipients,
+		RemoteIP:   remoteAddress(envelope),
+		Username:   envelope.Username,
+		Listener:   envelope.Server,
+		Message:    envelope.Data,
+	}
+
+	if err := s.Archive.Append(record); err != nil {
+		log.Errorw("archiving failed", log.Fields{
+			"server": s.URI.String(), "from": envelope.Sender, "to": envelope.Recipients, "error": err.Error(),
+		})
+
+		return
+	}
+
+	log.Debugw("message archived", log.Fields{"server": s.URI.String(), "seq": record.Sequence})
+}
@@ -0,0 +1,28 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package smtp
+
+import (
+	"fmt"
+	"net"
+)
+
+// bindUnix creates the socket in place and sets its owner and mode afterwards.
+func (u *Unix) bindUnix(path string) (*net.UnixListener, error) {
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	// socket is unlinked by unixListener
+	listener.SetUnlinkOnClose(false)
+
+	if err = u.setPermissions(path); err != nil {
+		listener.Close()
+
+		return nil, err
+	}
+
+	return listener, nil
+}
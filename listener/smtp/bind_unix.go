@@ -0,0 +1,46 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package smtp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// bindUnix creates the socket in a private directory (0700), sets its owner and mode there, and only then links it
+// into place, so nobody can connect to it before. Process umask is left alone, as other goroutines create files
+// at the same time. Link fails, when the path was taken in the meantime, the socket is never replaced.
+func (u *Unix) bindUnix(path string) (*net.UnixListener, error) {
+	directory, err := os.MkdirTemp(filepath.Dir(path), ".mailbowl-")
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer os.RemoveAll(directory)
+
+	private := filepath.Join(directory, "s")
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: private, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	// socket is unlinked by unixListener, under its final path
+	listener.SetUnlinkOnClose(false)
+
+	if err = u.setPermissions(private); err != nil {
+		listener.Close()
+
+		return nil, err
+	}
+
+	if err = os.Link(private, path); err != nil {
+		listener.Close()
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return listener, nil
+}
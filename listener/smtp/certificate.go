@@ -0,0 +1,128 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/watch"
+)
+
+var ErrCertificateNotLoaded = errors.New("TLS certificate not loaded yet")
+
+// certificateDebounce gives tools renewing certificates time to replace both key and certificate files.
+const certificateDebounce = 2 * time.Second
+
+// Certificate serves TLS certificate through GetCertificate, so renewed certificates are used by new connections
+// without restarting listeners. Key pair loaded from files is loaded again whenever they change, when the new pair is
+// invalid, the previous one is still served.
+type Certificate struct {
+	CertificateFile string
+	KeyFile         string
+
+	mutex   sync.RWMutex
+	current *tls.Certificate
+}
+
+func NewCertificate(certificateFile, keyFile string) (*Certificate, error) {
+	certificate := &Certificate{CertificateFile: certificateFile, KeyFile: keyFile}
+
+	if err := certificate.Reload(); err != nil {
+		return nil, err
+	}
+
+	return certificate, nil
+}
+
+// newInlineCertificate serves key pair given directly in the config, it's never reloaded.
+func newInlineCertificate(cert tls.Certificate) (*Certificate, error) {
+	certificate := new(Certificate)
+
+	if err := certificate.set(cert); err != nil {
+		return nil, err
+	}
+
+	return certificate, nil
+}
+
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.current == nil {
+		return nil, ErrCertificateNotLoaded
+	}
+
+	return c.current, nil
+}
+
+// Reload loads key pair from files again. On error, the previous one is kept.
+func (c *Certificate) Reload() error {
+	if c.CertificateFile == "" && c.KeyFile == "" {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertificateFile, c.KeyFile)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return c.set(cert)
+}
+
+// Watch reloads key pair whenever its files change, until context is cancelled.
+func (c *Certificate) Watch(ctx context.Context) error {
+	if c.CertificateFile == "" && c.KeyFile == "" {
+		return nil
+	}
+
+	return watch.NewWatcher([]string{c.CertificateFile, c.KeyFile}, certificateDebounce, c.reload).Serve(ctx)
+}
+
+func (c *Certificate) reload() {
+	if err := c.Reload(); err != nil {
+		log.Errorw("invalid TLS certificate, keeping the previous one", log.Fields{
+			"certificate": c.source(), "error": err.Error(),
+		})
+	}
+}
+
+func (c *Certificate) set(cert tls.Certificate) error {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	cert.Leaf = leaf
+
+	c.mutex.Lock()
+	c.current = &cert
+	c.mutex.Unlock()
+
+	fields := log.Fields{
+		"certificate": c.source(),
+		"subject":     leaf.Subject.String(),
+		"expires":     leaf.NotAfter.Format(time.RFC3339),
+	}
+
+	log.Infow("TLS certificate loaded", fields)
+
+	if time.Now().After(leaf.NotAfter) {
+		log.Warnw("TLS certificate expired", fields)
+	}
+
+	return nil
+}
+
+func (c *Certificate) source() string {
+	if c.CertificateFile == "" {
+		return "inline"
+	}
+
+	return c.CertificateFile
+}
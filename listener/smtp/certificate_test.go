@@ -0,0 +1,139 @@
+package smtp_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeKeyPair(t *testing.T, directory, commonName string) (string, string) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, public, private)
+	assert.NoError(t, err)
+
+	key, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.NoError(t, err)
+
+	certificateFile := filepath.Join(directory, "tls.crt")
+	keyFile := filepath.Join(directory, "tls.key")
+
+	certificatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+
+	assert.NoError(t, os.WriteFile(certificateFile, certificatePEM, 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+
+	return certificateFile, keyFile
+}
+
+func servedCommonName(t *testing.T, tlsConf *smtp.TLS) string {
+	t.Helper()
+
+	cert, err := tlsConf.Config.GetCertificate(nil)
+	assert.NoError(t, err)
+
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertificateReload(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	certificateFile, keyFile := writeKeyPair(t, directory, "first.example.local")
+
+	tlsConf, err := smtp.NewTLS(config.SMTPTLS{KeyFile: keyFile, CertificateFile: certificateFile})
+	assert.NoError(t, err)
+	assert.Equal(t, "first.example.local", servedCommonName(t, tlsConf))
+
+	// invalid pair is not used
+	assert.NoError(t, os.WriteFile(certificateFile, []byte(tlsCertificateExample), 0o600))
+	assert.Error(t, tlsConf.Certificate.Reload())
+	assert.Equal(t, "first.example.local", servedCommonName(t, tlsConf))
+
+	writeKeyPair(t, directory, "second.example.local")
+	assert.NoError(t, tlsConf.Certificate.Reload())
+	assert.Equal(t, "second.example.local", servedCommonName(t, tlsConf))
+}
+
+func TestCertificateWatch(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	certificateFile, keyFile := writeKeyPair(t, directory, "first.example.local")
+
+	tlsConf, err := smtp.NewTLS(config.SMTPTLS{KeyFile: keyFile, CertificateFile: certificateFile})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		assert.NoError(t, tlsConf.Certificate.Watch(ctx))
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	writeKeyPair(t, directory, "second.example.local")
+
+	assert.Eventually(t, func() bool {
+		return servedCommonName(t, tlsConf) == "second.example.local"
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestInlineCertificateIsServed(t *testing.T) {
+	t.Parallel()
+
+	tlsConf, err := smtp.NewTLS(config.SMTPTLS{Key: tlsKeyExample, Certificate: tlsCertificateExample})
+	assert.NoError(t, err)
+	assert.Equal(t, "example.local", servedCommonName(t, tlsConf))
+	assert.NoError(t, tlsConf.Certificate.Reload())
+}
+
+func TestPendingACMECertificate(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	certificateFile, keyFile := filepath.Join(directory, "tls.crt"), filepath.Join(directory, "tls.key")
+
+	tlsConf, err := smtp.NewTLS(config.SMTPTLS{KeyFile: keyFile, CertificateFile: certificateFile, ACME: true})
+	assert.NoError(t, err)
+
+	_, err = tlsConf.Config.GetCertificate(nil)
+	assert.ErrorIs(t, err, smtp.ErrCertificateNotLoaded)
+
+	writeKeyPair(t, directory, "acme.example.local")
+	assert.NoError(t, tlsConf.Certificate.Reload())
+	assert.Equal(t, "acme.example.local", servedCommonName(t, tlsConf))
+
+	_, err = smtp.NewTLS(config.SMTPTLS{KeyFile: keyFile + ".missing", CertificateFile: certificateFile})
+	assert.Error(t, err)
+}
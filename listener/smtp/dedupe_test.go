@@ -0,0 +1,94 @@
+package smtp_test
+
+import (
+	"fmt"
+	netsmtp "net/smtp"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupeMessagesWithoutMessageID(t *testing.T) {
+	t.Parallel()
+
+	outgoing, delivered := newOutgoingTestServer(t, "")
+	host := fmt.Sprintf("127.0.0.1:%d", randomPort())
+
+	uri, err := smtp.NewURI("plain://" + host)
+	assert.NoError(t, err)
+
+	server, err := smtp.NewServer(config.Config{
+		Filter: config.Filter{Dedupe: config.FilterDedupe{Enabled: true, Window: time.Hour, MaxEntries: 10}},
+		Relay: config.Relay{
+			Encryption: config.RelayEncryption{Policy: config.EncryptionNever},
+			OutgoingServer: config.RelayOutgoingServer{
+				Host: outgoing.IP.String(), Port: outgoing.Port,
+				ConnectionType: config.ConnectionPlain, AuthMethod: config.AuthNone,
+			},
+		},
+		SMTP: config.SMTP{
+			Hostname:  "hostname",
+			Limit:     config.SMTPLimit{Connections: 10, MessageSize: 1024, Recipients: 10},
+			Normalize: config.SMTPNormalize{AddDate: true, AddMessageID: true},
+			Whitelist: []string{"127.0.0.1/32"},
+		},
+	}, uri)
+	assert.NoError(t, err)
+	assert.NoError(t, server.Build())
+
+	go server.Start()
+	defer server.Shutdown() //nolint: errcheck
+
+	// Message-ID and Date are generated for each submission, but the retry is still recognized
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, netsmtp.SendMail(
+			host, nil, "sender@example.local", []string{"receiver@example.local"},
+			[]byte("From: sender@example.local\r\nSubject: Test\r\n\r\nbody\r\n"),
+		))
+	}
+
+	assert.Equal(t, []string{"receiver@example.local"}, *delivered)
+}
+
+func TestDedupeRetryAfterFailedRelay(t *testing.T) {
+	t.Parallel()
+
+	host := fmt.Sprintf("127.0.0.1:%d", randomPort())
+
+	uri, err := smtp.NewURI("plain://" + host)
+	assert.NoError(t, err)
+
+	server, err := smtp.NewServer(config.Config{
+		Filter: config.Filter{Dedupe: config.FilterDedupe{Enabled: true, Window: time.Hour, MaxEntries: 10}},
+		Relay: config.Relay{
+			Encryption: config.RelayEncryption{Policy: config.EncryptionNever},
+			OutgoingServer: config.RelayOutgoingServer{
+				Host: "127.0.0.1", Port: randomPort(),
+				ConnectionType: config.ConnectionPlain, AuthMethod: config.AuthNone,
+			},
+		},
+		SMTP: config.SMTP{
+			Hostname:  "hostname",
+			Limit:     config.SMTPLimit{Connections: 10, MessageSize: 1024, Recipients: 10},
+			Whitelist: []string{"127.0.0.1/32"},
+		},
+	}, uri)
+	assert.NoError(t, err)
+	assert.NoError(t, server.Build())
+
+	go server.Start()
+	defer server.Shutdown() //nolint: errcheck
+
+	// reservation of the failed message is released, so the retry is relayed again, instead of waiting for it
+	for i := 0; i < 2; i++ {
+		err = netsmtp.SendMail(
+			host, nil, "sender@example.local", []string{"receiver@example.local"},
+			[]byte("From: sender@example.local\r\nSubject: Test\r\n\r\nbody\r\n"),
+		)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "forwarding failed")
+	}
+}
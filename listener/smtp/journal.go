@@ -0,0 +1,62 @@
+package smtp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/ajgon/mailbowl/journal"
+)
+
+// journalPrepare spools journal report of the message, before it is relayed. Returns nil entry, when journal is
+// disabled.
+func (s *Server) journalPrepare(envelope *filter.Envelope) (*journal.Entry, error) {
+	if !s.Journal.Enabled {
+		return nil, nil //nolint:nilnil
+	}
+
+	entry, err := s.Journal.Prepare(&journal.Report{
+		Sender:     envelope.Sender,
+		Recipients: envelope.Recipients,
+		RemoteIP:   remoteAddress(envelope),
+		Username:   envelope.Username,
+		Listener:   envelope.Server,
+		Received:   time.Now(),
+	}, envelope.Data)
+	if err != nil {
+		log.Errorw("journal failed", log.Fields{
+			"server": s.URI.String(), "from": envelope.Sender, "to": envelope.Recipients, "error": err.Error(),
+		})
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return entry, nil
+}
+
+// journalCommit delivers the report of relayed message. Failed reports stay in the spool and are retried later.
+func (s *Server) journalCommit(entry *journal.Entry) {
+	if entry == nil {
+		return
+	}
+
+	if err := s.Journal.Commit(entry); err != nil {
+		log.Errorw("journal delivery failed", log.Fields{"server": s.URI.String(), "id": entry.ID, "error": err.Error()})
+
+		return
+	}
+
+	log.Debugw("journal report delivered", log.Fields{"server": s.URI.String(), "id": entry.ID})
+}
+
+// journalCancel drops the report of message, which wasn't relayed.
+func (s *Server) journalCancel(entry *journal.Entry) {
+	if entry == nil {
+		return
+	}
+
+	if err := s.Journal.Cancel(entry); err != nil {
+		log.Errorw("journal cancel failed", log.Fields{"server": s.URI.String(), "id": entry.ID, "error": err.Error()})
+	}
+}
@@ -0,0 +1,77 @@
+package smtp
+
+import (
+	"errors"
+	"net/textproto"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/ajgon/mailbowl/journal"
+	"github.com/chrj/smtpd"
+)
+
+// lmtpHandler reports delivery status of each recipient, as LMTP requires. Recipients removed by filters or milters
+// are reported as delivered, the same way SMTP listeners accept the message for them.
+func (s *Server) lmtpHandler(peer smtpd.Peer, envelope smtpd.Envelope) []error {
+	results := make(map[string]error, len(envelope.Recipients))
+	err := s.process(peer, envelope, results)
+
+	each := make([]error, 0, len(envelope.Recipients))
+
+	for _, recipient := range envelope.Recipients {
+		if err != nil {
+			each = append(each, err)
+
+			continue
+		}
+
+		each = append(each, results[recipient])
+	}
+
+	return each
+}
+
+// relayEach relays the message to each recipient separately, storing their results. Message is journaled and
+// archived, when it was delivered to at least one of them.
+func (s *Server) relayEach(envelope *filter.Envelope, entry *journal.Entry, results map[string]error) {
+	delivered := make([]string, 0, len(envelope.Recipients))
+
+	for i, err := range s.Relay.HandleEach(envelope.Sender, envelope.Recipients, envelope.Data) {
+		recipient := envelope.Recipients[i]
+
+		if err == nil {
+			delivered = append(delivered, recipient)
+
+			continue
+		}
+
+		log.Errorw("forwarding failed", log.Fields{
+			"server": s.URI.String(), "from": envelope.Sender, "to": recipient, "remote_ip": envelope.RemoteIP,
+			"error": err.Error(),
+		})
+
+		// response of the outgoing server is passed on, so temporary failures are retried by the client
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) {
+			results[recipient] = smtpd.Error{Code: protoErr.Code, Message: protoErr.Msg}
+		} else {
+			results[recipient] = relayError(err)
+		}
+	}
+
+	if len(delivered) == 0 {
+		s.journalCancel(entry)
+
+		return
+	}
+
+	log.Infow("forwarding succeeded, mail sent", log.Fields{
+		"server": s.URI.String(), "from": envelope.Sender, "to": delivered, "remote_ip": envelope.RemoteIP,
+	})
+
+	envelope.Recipients = delivered
+
+	s.journalCommit(entry)
+	s.archive(envelope)
+	s.Filters.Commit(envelope)
+}
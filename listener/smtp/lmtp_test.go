@@ -0,0 +1,119 @@
+package smtp_test
+
+import (
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/chrj/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+func newOutgoingTestServer(t *testing.T, refused string) (*net.TCPAddr, *[]string) {
+	t.Helper()
+
+	var (
+		delivered []string
+		mutex     sync.Mutex
+	)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := &smtpd.Server{
+		RecipientChecker: func(_ smtpd.Peer, addr string) error {
+			if addr == refused {
+				return smtpd.Error{Code: 550, Message: "mailbox unavailable"}
+			}
+
+			return nil
+		},
+		Handler: func(_ smtpd.Peer, envelope smtpd.Envelope) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			delivered = append(delivered, envelope.Recipients...)
+
+			return nil
+		},
+	}
+
+	go server.Serve(listener) //nolint:errcheck
+
+	t.Cleanup(func() { server.Shutdown(true) }) //nolint:errcheck
+
+	addr, _ := listener.Addr().(*net.TCPAddr)
+
+	return addr, &delivered
+}
+
+func TestLMTPServerPerRecipientResponses(t *testing.T) {
+	t.Parallel()
+
+	outgoing, delivered := newOutgoingTestServer(t, "refused@example.local")
+	path := filepath.Join(t.TempDir(), "lmtp.sock")
+
+	uri, err := smtp.NewURI("lmtp://" + path)
+	assert.NoError(t, err)
+
+	server, err := smtp.NewServer(config.Config{
+		Relay: config.Relay{
+			Encryption: config.RelayEncryption{Policy: config.EncryptionNever},
+			OutgoingServer: config.RelayOutgoingServer{
+				Host: outgoing.IP.String(), Port: outgoing.Port,
+				ConnectionType: config.ConnectionPlain, AuthMethod: config.AuthNone,
+			},
+		},
+		SMTP: config.SMTP{
+			Hostname: "hostname",
+			Limit:    config.SMTPLimit{Connections: 10, MessageSize: 1024, Recipients: 10},
+		},
+	}, uri)
+	assert.NoError(t, err)
+	assert.NoError(t, server.Build())
+	assert.True(t, server.SMTPD.LMTP)
+
+	go server.Start()
+	defer server.Shutdown() //nolint: errcheck
+
+	conn, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+
+	client := textproto.NewConn(conn)
+	defer client.Close()
+
+	_, greeting, err := client.ReadResponse(220)
+	assert.NoError(t, err)
+	assert.Contains(t, greeting, "LMTP")
+
+	for _, command := range []string{
+		"LHLO localhost", "MAIL FROM:<sender@example.local>",
+		"RCPT TO:<first@example.local>", "RCPT TO:<refused@example.local>",
+	} {
+		assert.NoError(t, client.PrintfLine(command))
+		_, _, err = client.ReadResponse(250)
+		assert.NoError(t, err, command)
+	}
+
+	assert.NoError(t, client.PrintfLine("DATA"))
+	_, _, err = client.ReadResponse(354)
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.PrintfLine("Subject: Test\r\n\r\nThis is the email body\r\n."))
+
+	_, message, err := client.ReadResponse(250)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(message, "first@example.local"))
+
+	code, message, err := client.ReadResponse(550)
+	assert.NoError(t, err)
+	assert.Equal(t, 550, code)
+	assert.Equal(t, "mailbox unavailable", message)
+
+	assert.Equal(t, []string{"first@example.local"}, *delivered)
+}
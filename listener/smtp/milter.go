@@ -0,0 +1,333 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/ajgon/mailbowl/milter"
+	"github.com/chrj/smtpd"
+)
+
+const (
+	milterConnectReject   = 554
+	milterReject          = 550
+	milterConnectTempFail = ServiceNotAvailable
+	milterTempFail        = filter.CodeTempFail
+
+	stageConnect = "connect"
+	stageHelo    = "helo"
+	stageMail    = "mail"
+	stageRcpt    = "rcpt"
+)
+
+// milterConnection keeps milter sessions of a single SMTP connection.
+type milterConnection struct {
+	sessions []*milterSession
+	discard  bool
+}
+
+type milterSession struct {
+	client   *milter.Client
+	session  *milter.Session
+	accepted bool
+}
+
+func (s *Server) milterConnect(peer smtpd.Peer, remoteIP net.IP) error {
+	if len(s.Milters) == 0 {
+		return nil
+	}
+
+	connection := &milterConnection{sessions: make([]*milterSession, 0, len(s.Milters))}
+
+	for _, client := range s.Milters {
+		session, err := client.Open(context.Background())
+		if err != nil {
+			log.Errorw("milter unavailable", log.Fields{
+				"server": s.URI.String(), "milter": client.Name, "remote_ip": remoteIP, "error": err.Error(),
+			})
+
+			if client.FailOpen {
+				continue
+			}
+
+			for _, opened := range connection.sessions {
+				_ = opened.session.Close()
+			}
+
+			return smtpd.Error{Code: milterConnectTempFail, Message: "Service temporarily unavailable"}
+		}
+
+		connection.sessions = append(connection.sessions, &milterSession{client: client, session: session})
+	}
+
+	s.milterMutex.Lock()
+	s.milterConnections[peer.ID] = connection
+	s.milterMutex.Unlock()
+
+	macros := map[string]string{"j": s.Hostname, "{daemon_name}": "mailbowl"}
+	hostname, addr := fmt.Sprintf("[%s]", remoteIP), peer.Addr
+
+	// local clients are reported the way sendmail does it, as localhost connected through the socket (SMFIA_UNIX)
+	if unixAddr, ok := peer.Addr.(*UnixPeerAddr); ok {
+		hostname, addr = "localhost", &net.UnixAddr{Name: unixAddr.Path, Net: "unix"}
+	} else {
+		macros["{client_addr}"] = remoteIP.String()
+	}
+
+	return s.milterStep(peer, stageConnect, func(session *milter.Session) (*milter.Response, error) {
+		return session.Connect(hostname, addr, macros)
+	})
+}
+
+func (s *Server) heloChecker(peer smtpd.Peer, name string) error {
+	macros := map[string]string{}
+
+	if peer.TLS != nil {
+		macros["{tls_version}"] = tlsVersion(peer.TLS.Version)
+		macros["{cipher}"] = tls.CipherSuiteName(peer.TLS.CipherSuite)
+	}
+
+	return s.milterStep(peer, stageHelo, func(session *milter.Session) (*milter.Response, error) {
+		return session.Helo(name, macros)
+	})
+}
+
+func (s *Server) senderChecker(peer smtpd.Peer, addr string) error {
+	connection := s.milterConnection(peer.ID)
+	if connection == nil {
+		return nil
+	}
+
+	connection.discard = false
+	for _, session := range connection.sessions {
+		session.accepted = false
+	}
+
+	macros := map[string]string{"{mail_addr}": addr, "i": peer.ID}
+	if peer.Username != "" {
+		macros["{auth_authen}"] = peer.Username
+	}
+
+	return s.milterStep(peer, stageMail, func(session *milter.Session) (*milter.Response, error) {
+		return session.Mail(addr, macros)
+	})
+}
+
+func (s *Server) recipientChecker(peer smtpd.Peer, addr string) error {
+	macros := map[string]string{"{rcpt_addr}": addr}
+
+	return s.milterStep(peer, stageRcpt, func(session *milter.Session) (*milter.Response, error) {
+		return session.Rcpt(addr, macros)
+	})
+}
+
+func (s *Server) disconnectHandler(peer smtpd.Peer) {
+	s.milterMutex.Lock()
+	connection := s.milterConnections[peer.ID]
+	delete(s.milterConnections, peer.ID)
+	s.milterMutex.Unlock()
+
+	if connection == nil {
+		return
+	}
+
+	for _, session := range connection.sessions {
+		_ = session.session.Close()
+	}
+}
+
+// milterStep calls all milters of the connection, stopping at the first one which doesn't let the client continue.
+func (s *Server) milterStep(
+	peer smtpd.Peer, stage string, call func(session *milter.Session) (*milter.Response, error),
+) error {
+	connection := s.milterConnection(peer.ID)
+	if connection == nil {
+		return nil
+	}
+
+	for _, session := range append([]*milterSession{}, connection.sessions...) {
+		if session.accepted {
+			continue
+		}
+
+		response, err := call(session.session)
+		if err != nil {
+			log.Errorw("milter failed", log.Fields{
+				"server": s.URI.String(), "milter": session.client.Name, "stage": stage, "error": err.Error(),
+			})
+
+			connection.remove(session)
+
+			if session.client.FailOpen {
+				continue
+			}
+
+			return milterError(stage, &milter.Response{Action: milter.ActionTempFail})
+		}
+
+		switch response.Action {
+		case milter.ActionAccept, milter.ActionDiscard:
+			if stage == stageConnect || stage == stageHelo {
+				// milter is not interested in this connection anymore
+				connection.remove(session)
+
+				continue
+			}
+
+			session.accepted = true
+			connection.discard = connection.discard || response.Action == milter.ActionDiscard
+		case milter.ActionReject, milter.ActionTempFail:
+			log.Infow("milter verdict", log.Fields{
+				"server": s.URI.String(), "milter": session.client.Name, "stage": stage, "code": response.Code,
+				"message": response.Message,
+			})
+
+			return milterError(stage, response)
+		case milter.ActionContinue:
+		}
+	}
+
+	return nil
+}
+
+// milterMessage passes the message to milters, applying requested modifications to the envelope.
+//
+//nolint:cyclop,funlen
+func (s *Server) milterMessage(peer smtpd.Peer, envelope *filter.Envelope) *filter.Verdict {
+	connection := s.milterConnection(peer.ID)
+	if connection == nil {
+		return &filter.Verdict{Action: filter.ActionAccept}
+	}
+
+	if connection.discard {
+		return &filter.Verdict{Action: filter.ActionDiscard, Filter: "milter", Reason: "discarded by milter"}
+	}
+
+	modifications := make([]milter.Modification, 0)
+	quarantine := ""
+
+	for _, session := range append([]*milterSession{}, connection.sessions...) {
+		if session.accepted {
+			continue
+		}
+
+		response, err := session.session.Message(envelope.Data, map[string]string{"i": peer.ID})
+		if err != nil {
+			log.Errorw("milter failed", log.Fields{
+				"server": s.URI.String(), "milter": session.client.Name, "stage": "data", "error": err.Error(),
+			})
+
+			connection.remove(session)
+
+			if session.client.FailOpen {
+				continue
+			}
+
+			return &filter.Verdict{
+				Action: filter.ActionTempFail, Code: milterTempFail, Message: "message could not be checked, try again later",
+				Filter: session.client.Name, Reason: err.Error(),
+			}
+		}
+
+		modifications = append(modifications, response.Modifications...)
+
+		if response.Quarantine != "" && quarantine == "" {
+			quarantine = fmt.Sprintf("quarantined by milter %s: %s", session.client.Name, response.Quarantine)
+		}
+
+		switch response.Action {
+		case milter.ActionReject, milter.ActionTempFail:
+			smtpError := milterError("data", response)
+			verdict := &filter.Verdict{
+				Action: filter.ActionReject, Code: smtpError.Code, Message: smtpError.Message,
+				Filter: session.client.Name, Reason: smtpError.Error(),
+			}
+
+			if response.Action == milter.ActionTempFail {
+				verdict.Action = filter.ActionTempFail
+			}
+
+			return verdict
+		case milter.ActionDiscard:
+			return &filter.Verdict{Action: filter.ActionDiscard, Filter: session.client.Name, Reason: "discarded by milter"}
+		case milter.ActionAccept, milter.ActionContinue:
+		}
+	}
+
+	msg := &milter.Message{Sender: envelope.Sender, Recipients: envelope.Recipients, Data: envelope.Data}
+	if err := milter.Apply(msg, modifications); err != nil {
+		return &filter.Verdict{
+			Action: filter.ActionTempFail, Code: milterTempFail, Message: "message could not be checked, try again later",
+			Filter: "milter", Reason: err.Error(),
+		}
+	}
+
+	envelope.Sender, envelope.Recipients, envelope.Data = msg.Sender, msg.Recipients, msg.Data
+
+	if quarantine != "" {
+		return &filter.Verdict{Action: filter.ActionQuarantine, Filter: "milter", Reason: quarantine}
+	}
+
+	return &filter.Verdict{Action: filter.ActionAccept}
+}
+
+func (s *Server) milterConnection(id string) *milterConnection {
+	s.milterMutex.Lock()
+	defer s.milterMutex.Unlock()
+
+	return s.milterConnections[id]
+}
+
+func (c *milterConnection) remove(session *milterSession) {
+	_ = session.session.Close()
+
+	sessions := make([]*milterSession, 0, len(c.sessions))
+
+	for _, other := range c.sessions {
+		if other != session {
+			sessions = append(sessions, other)
+		}
+	}
+
+	c.sessions = sessions
+}
+
+// milterError turns milter response into SMTP error, using milter's own reply, if it sent one.
+func milterError(stage string, response *milter.Response) smtpd.Error {
+	if response.Code != 0 {
+		return smtpd.Error{Code: response.Code, Message: response.Message}
+	}
+
+	if response.Action == milter.ActionTempFail {
+		if stage == stageConnect {
+			return smtpd.Error{Code: milterConnectTempFail, Message: "Service temporarily unavailable"}
+		}
+
+		return smtpd.Error{Code: milterTempFail, Message: "Command temporarily rejected, try again later"}
+	}
+
+	if stage == stageConnect {
+		return smtpd.Error{Code: milterConnectReject, Message: "Connection rejected"}
+	}
+
+	return smtpd.Error{Code: milterReject, Message: "Command rejected"}
+}
+
+func tlsVersion(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+
+	return "unknown"
+}
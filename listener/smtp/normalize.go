@@ -0,0 +1,93 @@
+package smtp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+)
+
+const defaultHostname = "localhost.localdomain"
+
+// Normalize fixes up accepted messages, so they are RFC 5322 compliant before they are filtered and relayed.
+type Normalize struct {
+	AddDate         bool
+	AddMessageID    bool
+	FixLineEndings  bool
+	FoldHeaders     bool
+	RejectMalformed bool
+	Hostname        string
+}
+
+func NewNormalize(conf config.SMTPNormalize, hostname string) *Normalize {
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+
+	if hostname == "" {
+		hostname = defaultHostname
+	}
+
+	return &Normalize{
+		AddDate:         conf.AddDate,
+		AddMessageID:    conf.AddMessageID,
+		FixLineEndings:  conf.FixLineEndings,
+		FoldHeaders:     conf.FoldHeaders,
+		RejectMalformed: conf.RejectMalformed,
+		Hostname:        hostname,
+	}
+}
+
+// Apply returns normalized message. Error is returned only for malformed messages, when they should be rejected,
+// otherwise messages which can't be parsed are passed as they are.
+func (n *Normalize) Apply(data []byte) ([]byte, error) {
+	if n.FixLineEndings {
+		data = message.FixLineEndings(data)
+	}
+
+	if n.RejectMalformed {
+		if err := message.Validate(data); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+	}
+
+	if !n.AddDate && !n.AddMessageID && !n.FoldHeaders {
+		return data, nil
+	}
+
+	header, _, err := message.ParseHeader(data)
+	if err != nil {
+		return data, nil //nolint:nilerr
+	}
+
+	rest := data[len(header.Bytes()):]
+	modified := false
+
+	// header only message, without trailing line ending, new fields can't be appended to the last line
+	if last := len(header.Fields) - 1; last >= 0 && !bytes.HasSuffix(header.Fields[last].Raw, []byte("\n")) {
+		header.Fields[last].Raw = append(header.Fields[last].Raw, header.EOL...)
+	}
+
+	if n.FoldHeaders && header.Fold() {
+		modified = true
+	}
+
+	if n.AddDate && !header.Has("Date") {
+		header.Add("Date", message.FormatDate(time.Now()))
+		modified = true
+	}
+
+	if n.AddMessageID && !header.Has("Message-ID") {
+		header.Add("Message-ID", message.NewMessageID(n.Hostname))
+		modified = true
+	}
+
+	if !modified {
+		return data, nil
+	}
+
+	return append(header.Bytes(), rest...), nil
+}
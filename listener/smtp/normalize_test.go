@@ -0,0 +1,68 @@
+package smtp_test
+
+import (
+	"testing"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/ajgon/mailbowl/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestNormalize() *smtp.Normalize {
+	return smtp.NewNormalize(config.SMTPNormalize{
+		AddDate: true, AddMessageID: true, FixLineEndings: true, FoldHeaders: true, RejectMalformed: true,
+	}, "mx.example.local")
+}
+
+func TestNormalizeAddsMissingFields(t *testing.T) {
+	t.Parallel()
+
+	data, err := newTestNormalize().Apply([]byte("Received: by x\r\nFrom: a@example.local\n\nbody\n"))
+	assert.NoError(t, err)
+
+	header, body, err := message.ParseHeader(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "\r\n", header.EOL)
+	assert.Equal(t, []byte("body\r\n"), body)
+	assert.Equal(t, "Received", header.Fields[0].Name)
+	assert.Regexp(t, `^<\d+\.[0-9a-f]+@mx\.example\.local>$`, header.Get("Message-ID"))
+	assert.NotEmpty(t, header.Get("Date"))
+}
+
+func TestNormalizeKeepsExistingFields(t *testing.T) {
+	t.Parallel()
+
+	original := []byte(
+		"From: a@example.local\r\nDate: Fri, 04 Mar 2022 05:06:07 +0100\r\nMessage-ID: <id@example.local>\r\n\r\nbody\r\n",
+	)
+
+	data, err := newTestNormalize().Apply(original)
+	assert.NoError(t, err)
+	assert.Equal(t, original, data)
+
+	// header only message, without trailing line ending
+	data, err = newTestNormalize().Apply([]byte("From: a@example.local\r\nDate: Fri, 04 Mar 2022 05:06:07 +0100"))
+	assert.NoError(t, err)
+	assert.Regexp(t, "^From: a@example.local\r\nDate: Fri, 04 Mar 2022 05:06:07 \\+0100\r\nMessage-ID: <.+>\r\n$", string(data))
+}
+
+func TestNormalizeRejectsMalformed(t *testing.T) {
+	t.Parallel()
+
+	_, err := newTestNormalize().Apply([]byte("Subject: no sender\r\n\r\nbody\r\n"))
+	assert.ErrorIs(t, err, message.ErrMissingFrom)
+}
+
+func TestNormalizeDisabled(t *testing.T) {
+	t.Parallel()
+
+	normalize := smtp.NewNormalize(config.SMTPNormalize{}, "")
+	assert.NotEmpty(t, normalize.Hostname)
+
+	original := []byte("Subject: no sender\n\nbody\n")
+
+	data, err := normalize.Apply(original)
+	assert.NoError(t, err)
+	assert.Equal(t, original, data)
+}
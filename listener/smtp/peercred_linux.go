@@ -0,0 +1,39 @@
+//go:build linux
+// +build linux
+
+package smtp
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// readPeerCredentials reads credentials of the process on the other end of the socket (SO_PEERCRED).
+func readPeerCredentials(conn *net.UnixConn, addr *UnixPeerAddr) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	var (
+		ucred    *syscall.Ucred
+		ucredErr error
+	)
+
+	err = raw.Control(func(fd uintptr) {
+		ucred, ucredErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if ucredErr != nil {
+		return fmt.Errorf("%w", ucredErr)
+	}
+
+	addr.Credentials = true
+	addr.PID, addr.UID, addr.GID = int(ucred.Pid), int(ucred.Uid), int(ucred.Gid)
+
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package smtp
+
+import (
+	"errors"
+	"net"
+)
+
+var errPeerCredentials = errors.New("peer credentials not supported on this platform")
+
+// readPeerCredentials is not implemented outside of linux, peers restricted by UID/GID are always denied there.
+func readPeerCredentials(conn *net.UnixConn, addr *UnixPeerAddr) error {
+	return errPeerCredentials
+}
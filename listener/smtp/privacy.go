@@ -0,0 +1,131 @@
+package smtp
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+	"github.com/chrj/smtpd"
+)
+
+// receivedAddressPattern matches IP literals in the Received field, either bare IPv4 or in brackets.
+var receivedAddressPattern = regexp.MustCompile( //nolint:gochecknoglobals
+	`\[(?:IPv6:)?([0-9A-Fa-f:.]+)\]|\b(\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3})\b`,
+)
+
+// Privacy removes headers revealing internal hosts and clients, and adds our own Received field, as configured.
+type Privacy struct {
+	Received      config.SMTPReceived
+	StripReceived []*net.IPNet
+	RemoveHeaders []string
+}
+
+func NewPrivacy(conf config.SMTPPrivacy) *Privacy {
+	privacy := &Privacy{
+		Received:      conf.Received,
+		StripReceived: make([]*net.IPNet, 0, len(conf.StripReceived)),
+		RemoveHeaders: conf.RemoveHeaders,
+	}
+
+	// networks are validated, when config is loaded
+	for _, cidr := range conf.StripReceived {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			privacy.StripReceived = append(privacy.StripReceived, network)
+		}
+	}
+
+	return privacy
+}
+
+// Apply cleans up the message header and adds our Received field. Messages with header which can't be parsed,
+// are not cleaned up, they are left for normalization to reject.
+func (p *Privacy) Apply(peer smtpd.Peer, envelope *smtpd.Envelope) {
+	p.clean(envelope)
+
+	switch p.Received {
+	case config.ReceivedFull:
+		envelope.AddReceivedLine(peer)
+	case config.ReceivedAnonymize:
+		line := fmt.Sprintf(
+			"Received: by %s with %s;\r\n\t%s\r\n",
+			peer.ServerName, peer.Protocol, time.Now().Format("Mon, 02 Jan 2006 15:04:05 -0700 (MST)"),
+		)
+
+		envelope.Data = append([]byte(line), envelope.Data...)
+	case config.ReceivedOmit:
+	}
+}
+
+func (p *Privacy) clean(envelope *smtpd.Envelope) {
+	if len(p.StripReceived) == 0 && len(p.RemoveHeaders) == 0 {
+		return
+	}
+
+	header, _, err := message.ParseHeader(envelope.Data)
+	if err != nil {
+		return
+	}
+
+	rest := envelope.Data[len(header.Bytes()):]
+	removed := 0
+
+	for _, name := range p.RemoveHeaders {
+		removed += header.Del(name)
+	}
+
+	fields := make([]*message.Field, 0, len(header.Fields))
+
+	for _, field := range header.Fields {
+		if strings.EqualFold(field.Name, "Received") && p.internalHop(field.Value()) {
+			removed++
+
+			continue
+		}
+
+		fields = append(fields, field)
+	}
+
+	if removed == 0 {
+		return
+	}
+
+	header.Fields = fields
+	envelope.Data = append(header.Bytes(), rest...)
+}
+
+// internalHop checks, if the Received field was added by a host from internal networks. Only the `from` clause
+// is checked, as it names the host which sent the message.
+func (p *Privacy) internalHop(received string) bool {
+	if len(p.StripReceived) == 0 || !strings.HasPrefix(strings.ToLower(received), "from ") {
+		return false
+	}
+
+	from := received
+	if i := strings.Index(strings.ToLower(received), " by "); i >= 0 {
+		from = received[:i]
+	}
+
+	for _, match := range receivedAddressPattern.FindAllStringSubmatch(from, -1) {
+		address := match[1]
+		if address == "" {
+			address = match[2]
+		}
+
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+
+		for _, network := range p.StripReceived {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,173 @@
+package smtp_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	netsmtp "net/smtp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/archive"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/chrj/smtpd"
+	"github.com/stretchr/testify/assert"
+)
+
+const privacyTestMessage = "Received: from app1 (app1.internal [10.1.2.3]) by relay.internal with ESMTP;\n" +
+	"\tMon, 02 Jan 2006 15:04:05 -0700\n" +
+	"Received: from public.example.com ([203.0.113.7]) by 10.1.2.4 with ESMTP;\n" +
+	"\tMon, 02 Jan 2006 15:04:04 -0700\n" +
+	"Received: by 10.1.2.5 with SMTP; Mon, 02 Jan 2006 15:04:03 -0700\n" +
+	"X-Originating-IP: [10.1.2.3]\n" +
+	"User-Agent: Internal App 1.0\n" +
+	"Subject: test\n" +
+	"\n" +
+	"body\n"
+
+func privacyTestPeer() smtpd.Peer {
+	return smtpd.Peer{
+		HeloName:   "app1.internal",
+		Protocol:   smtpd.ESMTP,
+		ServerName: "mx.example.com",
+		Addr:       &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 12345},
+	}
+}
+
+func TestPrivacyFull(t *testing.T) {
+	t.Parallel()
+
+	privacy := smtp.NewPrivacy(config.SMTPPrivacy{Received: config.ReceivedFull})
+	envelope := &smtpd.Envelope{Data: []byte(privacyTestMessage)}
+
+	privacy.Apply(privacyTestPeer(), envelope)
+
+	assert.True(t, strings.HasPrefix(string(envelope.Data), "Received: from app1.internal ([10.1.2.3]) by mx.example.com"))
+	assert.True(t, strings.HasSuffix(string(envelope.Data), privacyTestMessage))
+}
+
+func TestPrivacyAnonymizeAndStrip(t *testing.T) {
+	t.Parallel()
+
+	privacy := smtp.NewPrivacy(config.SMTPPrivacy{
+		Received:      config.ReceivedAnonymize,
+		StripReceived: []string{"10.0.0.0/8"},
+		RemoveHeaders: []string{"x-originating-ip", "User-Agent", "X-Mailer"},
+	})
+	envelope := &smtpd.Envelope{Data: []byte(privacyTestMessage)}
+
+	privacy.Apply(privacyTestPeer(), envelope)
+
+	data := string(envelope.Data)
+	assert.Regexp(t, "^Received: by mx.example.com with ESMTP;\r\n\t.+\r\nReceived: from public.example.com", data)
+	assert.NotContains(t, data, "app1")
+	assert.NotContains(t, data, "10.1.2.3")
+	assert.NotContains(t, data, "User-Agent")
+	assert.Contains(t, data, "Received: by 10.1.2.5 with SMTP")
+	assert.True(t, strings.HasSuffix(data, "Subject: test\n\nbody\n"))
+}
+
+func TestPrivacyOmit(t *testing.T) {
+	t.Parallel()
+
+	privacy := smtp.NewPrivacy(config.SMTPPrivacy{Received: config.ReceivedOmit})
+	envelope := &smtpd.Envelope{Data: []byte(privacyTestMessage)}
+
+	privacy.Apply(privacyTestPeer(), envelope)
+
+	assert.Equal(t, privacyTestMessage, string(envelope.Data))
+}
+
+// fakeSpamd answers a single SYMBOLS request with zero score, passing the message it got.
+func fakeSpamd(t *testing.T) (string, chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 1)
+
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+
+		reader := bufio.NewReader(connection)
+		length := 0
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || strings.TrimSpace(line) == "" {
+				break
+			}
+
+			if strings.HasPrefix(line, "Content-length: ") {
+				length, _ = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-length: ")))
+			}
+		}
+
+		data := make([]byte, length)
+		_, _ = io.ReadFull(reader, data)
+		messages <- string(data)
+
+		fmt.Fprint(connection, "SPAMD/1.1 0 EX_OK\r\nSpam: False ; 0.0 / 5.0\r\n\r\n")
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+func TestPrivacyOmitKeepsClientIPForSpamd(t *testing.T) {
+	t.Parallel()
+
+	address, messages := fakeSpamd(t)
+	directory := t.TempDir()
+	host := fmt.Sprintf("127.0.0.1:%d", randomPort())
+
+	uri, err := smtp.NewURI("plain://" + host)
+	assert.NoError(t, err)
+
+	server, err := smtp.NewServer(config.Config{
+		Archive: config.Archive{Directory: directory},
+		Filter: config.Filter{Spam: config.FilterSpam{
+			Enabled: true, Engine: config.SpamEngineSpamd, Network: "tcp", Address: address, Timeout: 5 * time.Second,
+			HeaderScore: 5, QuarantineScore: 10, RejectScore: 15,
+		}},
+		SMTP: config.SMTP{
+			Hostname:  "hostname",
+			Limit:     config.SMTPLimit{Connections: 10, MessageSize: 1024, Recipients: 10},
+			Privacy:   config.SMTPPrivacy{Received: config.ReceivedOmit},
+			Whitelist: []string{"127.0.0.1/32"},
+		},
+	}, uri)
+	assert.NoError(t, err)
+	assert.NoError(t, server.Build())
+
+	go server.Start()
+	defer server.Shutdown() //nolint: errcheck
+
+	assert.NoError(t, netsmtp.SendMail(
+		host, nil, "sender@example.local", []string{"receiver@example.local"},
+		[]byte("From: sender@example.local\r\nSubject: Test\r\n\r\nbody\r\n"),
+	))
+
+	assert.Regexp(t, "^Received: from localhost \\(\\[127.0.0.1\\]\\)", <-messages)
+
+	// relayed message is left as privacy settings made it
+	records := make([]*archive.Record, 0)
+	assert.NoError(t, archive.Walk(directory, func(_ string, record *archive.Record) error {
+		records = append(records, record)
+
+		return nil
+	}))
+
+	assert.Len(t, records, 1)
+	assert.NotContains(t, string(records[0].Message), "127.0.0.1")
+}
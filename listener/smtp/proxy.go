@@ -0,0 +1,309 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+)
+
+const (
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLength = 107
+
+	proxyV2HeaderLength = 16
+	proxyV2Version      = 0x20
+	proxyV2CommandLocal = 0x00
+	proxyV2CommandProxy = 0x01
+	proxyV2TCP4         = 0x11
+	proxyV2TCP6         = 0x21
+	proxyV2TCP4Length   = 12
+	proxyV2TCP6Length   = 36
+
+	defaultProxyHeaderTimeout = 5 * time.Second
+)
+
+var (
+	errProxyUntrusted = errors.New("connection not from trusted proxy")
+	errProxyMissing   = errors.New("missing PROXY protocol header")
+	errProxyInvalid   = errors.New("invalid PROXY protocol header")
+
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n") //nolint:gochecknoglobals
+)
+
+// Proxy reads PROXY protocol headers, replacing remote address of connections with the one of the client, reported
+// by the load balancer. Access control, Received header and logs use that address then.
+type Proxy struct {
+	Mode           config.SMTPProxyMode
+	TrustedProxies []*net.IPNet
+	HeaderTimeout  time.Duration
+}
+
+func NewProxy(conf config.SMTPProxy) *Proxy {
+	proxy := &Proxy{Mode: conf.Mode, TrustedProxies: make([]*net.IPNet, 0), HeaderTimeout: conf.HeaderTimeout}
+
+	for _, cidr := range conf.TrustedProxies {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			proxy.TrustedProxies = append(proxy.TrustedProxies, network)
+		}
+	}
+
+	if proxy.HeaderTimeout <= 0 {
+		proxy.HeaderTimeout = defaultProxyHeaderTimeout
+	}
+
+	return proxy
+}
+
+// Listener wraps the listener, so headers are read from accepted connections. Headers are read on the first use of
+// the connection, within its session, so slow or broken proxies don't block other connections, and connections in
+// the middle of the handshake are drained on reload, like any other session.
+func (p *Proxy) Listener(listener net.Listener) net.Listener {
+	if p.Mode == config.ProxyNone {
+		return listener
+	}
+
+	return &proxyListener{Listener: listener, proxy: p}
+}
+
+// Handshake reads PROXY header from the connection. Connections from untrusted sources are passed as they are, unless
+// header is required. In accept mode, trusted connections which don't send the header within HeaderTimeout are
+// passed as they are as well.
+func (p *Proxy) Handshake(conn net.Conn) (net.Conn, error) {
+	if !p.trusted(conn.RemoteAddr()) {
+		if p.Mode == config.ProxyRequire {
+			return nil, fmt.Errorf("%w: %s", errProxyUntrusted, conn.RemoteAddr().String())
+		}
+
+		return conn, nil
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(p.HeaderTimeout)); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	proxyConn := &proxyConn{Conn: conn, reader: reader, remote: conn.RemoteAddr()}
+
+	first, err := reader.Peek(1)
+
+	switch {
+	case err != nil && p.Mode == config.ProxyAccept && isTimeout(err):
+		// SMTP clients wait for the greeting, so silence means there is no header
+		err = nil
+	case err != nil:
+		return nil, fmt.Errorf("%w: %s", errProxyMissing, err.Error())
+	case first[0] == proxyV1Prefix[0]:
+		proxyConn.remote, err = readProxyV1(reader, conn.RemoteAddr())
+	case first[0] == proxyV2Signature[0]:
+		proxyConn.remote, err = readProxyV2(reader, conn.RemoteAddr())
+	case p.Mode == config.ProxyRequire:
+		err = errProxyMissing
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return proxyConn, nil
+}
+
+func (p *Proxy) trusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, network := range p.TrustedProxies {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readProxyV1 parses text header: PROXY TCP4|TCP6 <src> <dst> <src port> <dst port>\r\n. For UNKNOWN protocol,
+// original address is kept.
+func readProxyV1(reader *bufio.Reader, original net.Addr) (net.Addr, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, fmt.Errorf("%w: header too long", errProxyInvalid)
+		}
+
+		char, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errProxyInvalid, err.Error())
+		}
+
+		line = append(line, char)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != strings.TrimSpace(proxyV1Prefix) {
+		return nil, fmt.Errorf("%w: `%s`", errProxyInvalid, strings.TrimSpace(string(line)))
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return original, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("%w: unsupported protocol `%s`", errProxyInvalid, fields[1])
+	}
+
+	if len(fields) != 6 { //nolint:gomnd
+		return nil, fmt.Errorf("%w: `%s`", errProxyInvalid, strings.TrimSpace(string(line)))
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("%w: invalid source `%s:%s`", errProxyInvalid, fields[2], fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 parses binary header. LOCAL command (used by health checks) and address families other than TCP keep
+// the original address, TLVs are skipped.
+func readProxyV2(reader *bufio.Reader, original net.Addr) (net.Addr, error) {
+	header := make([]byte, proxyV2HeaderLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("%w: %s", errProxyInvalid, err.Error())
+	}
+
+	if !bytes.Equal(header[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, fmt.Errorf("%w: invalid signature", errProxyInvalid)
+	}
+
+	if header[12]&0xF0 != proxyV2Version {
+		return nil, fmt.Errorf("%w: unsupported version", errProxyInvalid)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, fmt.Errorf("%w: %s", errProxyInvalid, err.Error())
+	}
+
+	switch header[12] & 0x0F {
+	case proxyV2CommandLocal:
+		return original, nil
+	case proxyV2CommandProxy:
+	default:
+		return nil, fmt.Errorf("%w: unsupported command", errProxyInvalid)
+	}
+
+	switch {
+	case header[13] == proxyV2TCP4 && len(payload) >= proxyV2TCP4Length:
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case header[13] == proxyV2TCP6 && len(payload) >= proxyV2TCP6Length:
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	case header[13] == proxyV2TCP4 || header[13] == proxyV2TCP6:
+		return nil, fmt.Errorf("%w: address block too short", errProxyInvalid)
+	}
+
+	return original, nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+type proxyListener struct {
+	net.Listener
+	proxy *Proxy
+}
+
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+}
+
+// lazyProxyConn runs the handshake, when the connection is used for the first time. Failed connections are closed,
+// and keep the address of the proxy.
+type lazyProxyConn struct {
+	net.Conn
+	proxy *Proxy
+
+	once    sync.Once
+	proxied net.Conn
+	err     error
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return &lazyProxyConn{Conn: conn, proxy: l.proxy}, nil
+}
+
+func (c *lazyProxyConn) handshake() error {
+	c.once.Do(func() {
+		if c.proxied, c.err = c.proxy.Handshake(c.Conn); c.err == nil {
+			return
+		}
+
+		log.Infow("PROXY protocol handshake failed, connection refused", log.Fields{
+			"remote_ip": c.Conn.RemoteAddr().String(), "error": c.err.Error(),
+		})
+
+		c.Conn.Close()
+	})
+
+	return c.err
+}
+
+func (c *lazyProxyConn) Read(data []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+
+	return c.proxied.Read(data) //nolint:wrapcheck
+}
+
+func (c *lazyProxyConn) Write(data []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+
+	return c.proxied.Write(data) //nolint:wrapcheck
+}
+
+func (c *lazyProxyConn) RemoteAddr() net.Addr {
+	if c.handshake() != nil {
+		return c.Conn.RemoteAddr()
+	}
+
+	return c.proxied.RemoteAddr()
+}
+
+func (c *proxyConn) Read(data []byte) (int, error) {
+	return c.reader.Read(data) //nolint:wrapcheck
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
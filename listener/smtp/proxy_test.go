@@ -0,0 +1,221 @@
+package smtp_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	netsmtp "net/smtp"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/stretchr/testify/assert"
+)
+
+func proxyHandshake(t *testing.T, conf config.SMTPProxy, header []byte) (net.Conn, error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	_, err = client.Write(append(header, "EHLO client\r\n"...))
+	assert.NoError(t, err)
+
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return smtp.NewProxy(conf).Handshake(conn)
+}
+
+func proxyV2Header(command byte) []byte {
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	header = append(header, 0x20|command, 0x11, 0, 12)
+	header = append(header, 198, 51, 100, 9, 127, 0, 0, 1)
+
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:2], 5353)
+	binary.BigEndian.PutUint16(ports[2:4], 25)
+
+	return append(header, ports...)
+}
+
+func TestProxyHandshake(t *testing.T) {
+	t.Parallel()
+
+	trusted := config.SMTPProxy{Mode: config.ProxyRequire, TrustedProxies: []string{"127.0.0.0/8"}}
+
+	for name, test := range map[string]struct {
+		header []byte
+		remote string
+	}{
+		"v1":         {header: []byte("PROXY TCP4 203.0.113.7 127.0.0.1 4242 25\r\n"), remote: "203.0.113.7:4242"},
+		"v1 ipv6":    {header: []byte("PROXY TCP6 2001:db8::1 ::1 4242 25\r\n"), remote: "[2001:db8::1]:4242"},
+		"v2":         {header: proxyV2Header(0x01), remote: "198.51.100.9:5353"},
+		"v2 local":   {header: proxyV2Header(0x00), remote: "127.0.0.1"},
+		"v1 unknown": {header: []byte("PROXY UNKNOWN\r\n"), remote: "127.0.0.1"},
+	} {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, err := proxyHandshake(t, trusted, test.header)
+			assert.NoError(t, err)
+			assert.Contains(t, conn.RemoteAddr().String(), test.remote)
+
+			// data following the header is intact
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			assert.NoError(t, err)
+			assert.Equal(t, "EHLO client\r\n", line)
+		})
+	}
+}
+
+func TestProxyHandshakeRefused(t *testing.T) {
+	t.Parallel()
+
+	// untrusted source
+	_, err := proxyHandshake(t, config.SMTPProxy{
+		Mode: config.ProxyRequire, TrustedProxies: []string{"10.0.0.0/8"},
+	}, []byte("PROXY TCP4 203.0.113.7 127.0.0.1 4242 25\r\n"))
+	assert.Error(t, err)
+
+	// missing header
+	_, err = proxyHandshake(t, config.SMTPProxy{
+		Mode: config.ProxyRequire, TrustedProxies: []string{"127.0.0.0/8"},
+	}, nil)
+	assert.Error(t, err)
+
+	// malformed header
+	_, err = proxyHandshake(t, config.SMTPProxy{
+		Mode: config.ProxyAccept, TrustedProxies: []string{"127.0.0.0/8"},
+	}, []byte("PROXY TCP4 nowhere\r\n"))
+	assert.Error(t, err)
+}
+
+func TestProxyHandshakeOptional(t *testing.T) {
+	t.Parallel()
+
+	// untrusted source, header is not read, so it can't be spoofed
+	conn, err := proxyHandshake(t, config.SMTPProxy{
+		Mode: config.ProxyAccept, TrustedProxies: []string{"10.0.0.0/8"},
+	}, []byte("PROXY TCP4 203.0.113.7 127.0.0.1 4242 25\r\n"))
+	assert.NoError(t, err)
+	assert.Contains(t, conn.RemoteAddr().String(), "127.0.0.1")
+
+	// trusted source without header
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+
+	defer client.Close()
+
+	conn, err = listener.Accept()
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	proxied, err := smtp.NewProxy(config.SMTPProxy{
+		Mode: config.ProxyAccept, TrustedProxies: []string{"127.0.0.0/8"}, HeaderTimeout: 50 * time.Millisecond,
+	}).Handshake(conn)
+	assert.NoError(t, err)
+	assert.Contains(t, proxied.RemoteAddr().String(), "127.0.0.1")
+}
+
+func TestProxyServerUsesClientAddress(t *testing.T) {
+	t.Parallel()
+
+	host := fmt.Sprintf("127.0.0.1:%d", randomPort())
+	uri, err := smtp.NewURI("plain://" + host)
+	assert.NoError(t, err)
+
+	limit := config.SMTPLimit{Connections: 10, MessageSize: 1024, Recipients: 10}
+	// load balancer itself is not whitelisted, only the client behind it
+	whitelist := []string{"203.0.113.0/24"}
+
+	server, err := smtp.NewServer(config.Config{SMTP: config.SMTP{
+		Hostname: "hostname",
+		Limit:    limit,
+		Listen: []config.SMTPListen{{
+			URI:       "plain://" + host,
+			Limit:     limit,
+			Proxy:     config.SMTPProxy{Mode: config.ProxyRequire, TrustedProxies: []string{"127.0.0.0/8"}},
+			Whitelist: whitelist,
+		}},
+		Whitelist: whitelist,
+	}}, uri)
+	assert.NoError(t, err)
+	assert.NoError(t, server.Build())
+
+	go server.Start()
+	defer server.Shutdown() //nolint: errcheck
+
+	for header, allowed := range map[string]bool{
+		"PROXY TCP4 203.0.113.7 127.0.0.1 4242 25\r\n": true,
+		"PROXY TCP4 192.0.2.7 127.0.0.1 4242 25\r\n":   false,
+	} {
+		conn, err := net.Dial("tcp", host)
+		assert.NoError(t, err)
+
+		_, err = conn.Write([]byte(header))
+		assert.NoError(t, err)
+
+		client, err := netsmtp.NewClient(conn, "localhost")
+		if allowed {
+			assert.NoError(t, err)
+			assert.NoError(t, client.Quit())
+		} else {
+			assert.Error(t, err)
+			conn.Close()
+		}
+	}
+}
+
+func TestProxyHandshakeDrainedOnReload(t *testing.T) {
+	t.Parallel()
+
+	host := fmt.Sprintf("127.0.0.1:%d", randomPort())
+
+	server, err := smtp.NewSMTP(config.Config{SMTP: config.SMTP{
+		Hostname:  "hostname",
+		Limit:     config.SMTPLimit{Connections: 10, MessageSize: 1024, Recipients: 10},
+		Proxy:     config.SMTPProxy{Mode: config.ProxyRequire, TrustedProxies: []string{"127.0.0.0/8"}},
+		Timeout:   config.SMTPTimeout{Read: time.Minute, Write: time.Minute, Data: time.Minute, Drain: time.Minute},
+		Whitelist: []string{"203.0.113.0/24"},
+	}}, []string{"plain://" + host}, nil, nil, nil)
+	assert.NoError(t, err)
+
+	cancel, done := serveSocketsTestSMTP(server)
+
+	conn, err := net.Dial("tcp", host)
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	time.Sleep(100 * time.Millisecond) // allow connection to be accepted
+
+	// generation is stopped, while the proxy didn't send the header yet
+	cancel()
+	assert.NoError(t, <-done)
+
+	_, err = conn.Write([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 4242 25\r\n"))
+	assert.NoError(t, err)
+
+	client, err := netsmtp.NewClient(conn, "localhost")
+	assert.NoError(t, err)
+	assert.NoError(t, client.Quit())
+	assert.NoError(t, server.Close())
+}
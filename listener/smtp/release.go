@@ -0,0 +1,74 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/ajgon/mailbowl/quarantine"
+)
+
+// Release relays the message released from quarantine through the listener which received it, so it takes the same
+// relay route. Messages of listeners removed from the configuration go through the first one.
+func (s *SMTP) Release(entry *quarantine.Entry, data []byte) error {
+	if len(s.Servers) == 0 {
+		return quarantine.ErrRelayDisabled
+	}
+
+	server := s.Servers[0]
+
+	for _, candidate := range s.Servers {
+		if candidate.URI.String() == entry.Listener {
+			server = candidate
+
+			break
+		}
+	}
+
+	if !server.Relay.Enabled() {
+		return quarantine.ErrRelayDisabled
+	}
+
+	envelope := &filter.Envelope{
+		Sender:     entry.Sender,
+		Recipients: entry.Recipients,
+		Data:       data,
+		RemoteIP:   net.ParseIP(entry.RemoteIP),
+		Username:   entry.Username,
+		Server:     server.URI.String(),
+	}
+
+	return server.release(envelope, !entry.Filtered)
+}
+
+// release takes the message through the same steps as accepted ones: footer (unless it was already added), journal,
+// relay and archive.
+func (s *Server) release(envelope *filter.Envelope, finish bool) error {
+	if finish {
+		if err := s.Filters.Finish(context.Background(), envelope); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	entry, err := s.journalPrepare(envelope)
+	if err != nil {
+		return err
+	}
+
+	if err = s.Relay.Handle(envelope.Sender, envelope.Recipients, envelope.Data); err != nil {
+		s.relayFailed(envelope, entry, err)
+
+		return fmt.Errorf("%w", err)
+	}
+
+	log.Infow("released message relayed", log.Fields{
+		"server": s.URI.String(), "from": envelope.Sender, "to": envelope.Recipients,
+	})
+
+	s.journalCommit(entry)
+	s.archive(envelope)
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package smtp_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ajgon/mailbowl/archive"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/ajgon/mailbowl/quarantine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReleaseThroughOriginalListener(t *testing.T) {
+	t.Parallel()
+
+	outgoing, delivered := newOutgoingTestServer(t, "")
+	directory := t.TempDir()
+	plain := fmt.Sprintf("plain://127.0.0.1:%d", randomPort())
+	routed := fmt.Sprintf("plain://127.0.0.1:%d", randomPort())
+
+	filters, err := filter.NewChain(config.Filter{Footer: config.FilterFooter{
+		Enabled: true, Rules: []config.FilterFooterRule{{Name: "all", Text: "-- footer"}},
+	}})
+	assert.NoError(t, err)
+
+	server, err := smtp.NewSMTP(config.Config{
+		Archive: config.Archive{Directory: directory},
+		Relay: config.Relay{
+			Encryption: config.RelayEncryption{Policy: config.EncryptionNever},
+			Routes: map[string]config.RelayOutgoingServer{"routed": {
+				Host: outgoing.IP.String(), Port: outgoing.Port,
+				ConnectionType: config.ConnectionPlain, AuthMethod: config.AuthNone,
+			}},
+		},
+		SMTP: config.SMTP{
+			Hostname: "hostname",
+			Listen:   []config.SMTPListen{{URI: plain}, {URI: routed, Relay: "routed"}},
+		},
+	}, []string{plain, routed}, filters, nil, nil)
+	assert.NoError(t, err)
+
+	entry := &quarantine.Entry{
+		Sender: "sender@example.local", Recipients: []string{"rcpt@example.local"}, RemoteIP: "192.0.2.1",
+		Listener: routed,
+	}
+	data := []byte("From: sender@example.local\r\nContent-Type: text/plain\r\n\r\nbody\r\n")
+
+	assert.NoError(t, server.Release(entry, data))
+	assert.Equal(t, []string{"rcpt@example.local"}, *delivered)
+
+	// released message is archived, with the footer added on the way
+	records := make([]*archive.Record, 0)
+	assert.NoError(t, archive.Walk(directory, func(_ string, record *archive.Record) error {
+		records = append(records, record)
+
+		return nil
+	}))
+
+	assert.Len(t, records, 1)
+	assert.Equal(t, routed, records[0].Listener)
+	assert.Contains(t, string(records[0].Message), "-- footer")
+
+	// listener without outgoing server can't release messages
+	entry.Listener = plain
+	assert.ErrorIs(t, server.Release(entry, data), quarantine.ErrRelayDisabled)
+}
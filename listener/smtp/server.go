@@ -1,14 +1,27 @@
 package smtp
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/archive"
 	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/encryption"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/ajgon/mailbowl/journal"
+	"github.com/ajgon/mailbowl/message"
+	"github.com/ajgon/mailbowl/milter"
+	"github.com/ajgon/mailbowl/quarantine"
 	"github.com/ajgon/mailbowl/relay"
+	"github.com/ajgon/mailbowl/systemd"
 	"github.com/chrj/smtpd"
 )
 
@@ -28,41 +41,107 @@ type Server struct {
 	Auth      *Auth
 	Hostname  string
 	Limit     *Limit
+	Normalize *Normalize
+	Privacy   *Privacy
+	Proxy     *Proxy
+	SMTPUTF8  bool
 	Timeout   *Timeout
 	TLS       *TLS
+	Unix      *Unix
 	Whitelist []string
 
-	URI      *URI
-	Relay    *relay.Relay
-	SMTPD    *smtpd.Server
-	Listener net.Listener
+	URI        *URI
+	Archive    *archive.Archive
+	Filters    *filter.Chain
+	Journal    *journal.Journal
+	Milters    []*milter.Client
+	Quarantine *quarantine.Store
+	Relay      *relay.Relay
+	SMTPD      *smtpd.Server
+	Listener   net.Listener
+	Sockets    *Sockets
+
+	milterConnections map[string]*milterConnection
+	milterMutex       sync.Mutex
 }
 
-func NewServer(smtpConf config.SMTP, relayConf config.Relay, uri *URI) (*Server, error) {
+func NewServer(conf config.Config, uri *URI) (*Server, error) {
+	filters, err := filter.NewChain(conf.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring filters: %w", err)
+	}
+
+	return newServer(conf, uri, filters, archive.NewArchive(conf.Archive), make(map[string]*Certificate))
+}
+
+// newServer builds the server with given filters, archive and TLS certificates, so they can be shared with other
+// servers.
+func newServer(
+	conf config.Config, uri *URI, filters *filter.Chain, store *archive.Archive, certificates map[string]*Certificate,
+) (*Server, error) {
+	// listener overrides are applied on top of the shared settings
+	smtpConf := conf.SMTP.ForListener(uri.String())
+
 	auth := NewAuth(smtpConf.Auth)
 	limit := NewLimit(smtpConf.Limit)
 	timeout := NewTimeout(smtpConf.Timeout)
 
-	tls, err := NewTLS(smtpConf.TLS)
+	if smtpConf.TLS.ACME && !conf.ACME.Enabled {
+		log.Warnw("TLS uses ACME, but it's not enabled", log.Fields{"server": uri.String()})
+	}
+
+	tls, err := newTLS(conf.ACME.ForTLS(smtpConf.TLS), certificates)
 	if err != nil {
+		// fail early, so configuration with broken certificate is rejected on reload, before anything is stopped
+		if uri.TLS() {
+			return nil, fmt.Errorf("error configuring TLS: %w", err)
+		}
+
 		log.Warnw("TLS not configured", log.Fields{"server": uri.String()})
 	}
 
+	relayConf, err := conf.Relay.ForRoute(smtpConf.RelayRoute(uri.String()))
+	if err != nil {
+		return nil, fmt.Errorf("error configuring relay: %w", err)
+	}
+
 	relay, err := relay.NewRelay(relayConf)
 	if err != nil {
 		return nil, fmt.Errorf("error configuring relay: %w", err)
 	}
 
+	unix, err := NewUnix(smtpConf.Unix)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring unix sockets: %w", err)
+	}
+
+	milters := make([]*milter.Client, 0, len(conf.Milter.Servers))
+	for _, milterConf := range conf.Milter.Servers {
+		milters = append(milters, milter.NewClient(milterConf))
+	}
+
 	server := &Server{
 		Auth:      auth,
 		Hostname:  smtpConf.Hostname,
 		Limit:     limit,
+		Normalize: NewNormalize(smtpConf.Normalize, smtpConf.Hostname),
+		Privacy:   NewPrivacy(smtpConf.Privacy),
+		Proxy:     NewProxy(smtpConf.Proxy),
+		SMTPUTF8:  smtpConf.SMTPUTF8,
 		Timeout:   timeout,
 		TLS:       tls,
+		Unix:      unix,
 		Whitelist: smtpConf.Whitelist,
 
-		URI:   uri,
-		Relay: relay,
+		URI:        uri,
+		Archive:    store,
+		Filters:    filters,
+		Journal:    journal.NewJournal(conf.Journal, smtpConf.Hostname, relay),
+		Milters:    milters,
+		Quarantine: quarantine.NewStore(conf.Quarantine),
+		Relay:      relay,
+
+		milterConnections: make(map[string]*milterConnection),
 	}
 
 	return server, nil
@@ -74,16 +153,56 @@ func (s *Server) Start() {
 	}
 }
 
+// Shutdown stops accepting connections, and waits for sessions in progress to finish, up to the drain timeout.
 func (s *Server) Shutdown() error {
+	if err := s.Stop(); err != nil {
+		return err
+	}
+
+	s.Drain()
+
+	return nil
+}
+
+// Stop stops accepting connections. Shared listen socket stays open, so the next generation can take it over.
+func (s *Server) Stop() error {
 	defer s.Listener.Close()
 
-	if err := s.SMTPD.Shutdown(true); err != nil {
-		return fmt.Errorf("%w", s.SMTPD.Shutdown(true))
+	if err := s.SMTPD.Shutdown(false); err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("%w", err)
 	}
 
 	return nil
 }
 
+// Drain waits for sessions of the stopped server to finish. Sessions still running after the drain timeout are
+// disconnected.
+func (s *Server) Drain() {
+	if s.Timeout.Drain <= 0 {
+		_ = s.SMTPD.Wait()
+
+		return
+	}
+
+	drained := make(chan struct{})
+
+	go func() {
+		_ = s.SMTPD.Wait()
+
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(s.Timeout.Drain):
+		log.Warnw("sessions still running after drain timeout, disconnecting", log.Fields{"server": s.URI.String()})
+
+		_ = s.SMTPD.Close()
+
+		<-drained
+	}
+}
+
 func (s *Server) Build() (err error) {
 	s.SMTPD = &smtpd.Server{
 		Hostname: s.Hostname,
@@ -96,17 +215,28 @@ func (s *Server) Build() (err error) {
 		MaxMessageSize: s.Limit.MessageSize,
 		MaxRecipients:  s.Limit.Recipients,
 
+		EnableSMTPUTF8: s.SMTPUTF8,
+		LMTP:           s.URI.LMTP(),
+
 		ConnectionChecker: s.connectionChecker,
 		Handler:           s.handler,
+		LMTPHandler:       s.lmtpHandler,
 	}
 
 	if s.Auth.Enabled {
 		s.SMTPD.Authenticator = s.authenticator
 	}
 
+	if len(s.Milters) > 0 {
+		s.SMTPD.HeloChecker = s.heloChecker
+		s.SMTPD.SenderChecker = s.senderChecker
+		s.SMTPD.RecipientChecker = s.recipientChecker
+		s.SMTPD.DisconnectHandler = s.disconnectHandler
+	}
+
 	switch s.URI.Scheme {
 	case "plain":
-		s.Listener, err = net.Listen("tcp", s.URI.Address)
+		s.Listener, err = s.listen()
 	case "starttls":
 		if s.TLS == nil {
 			return ErrMissingTLSConfig("starttls")
@@ -115,7 +245,7 @@ func (s *Server) Build() (err error) {
 		s.SMTPD.ForceTLS = s.TLS.ForceForStartTLS
 		s.SMTPD.TLSConfig = s.TLS.Config
 
-		s.Listener, err = net.Listen("tcp", s.URI.Address)
+		s.Listener, err = s.listen()
 	case "tls":
 		if s.TLS == nil {
 			return ErrMissingTLSConfig("tls")
@@ -123,7 +253,18 @@ func (s *Server) Build() (err error) {
 
 		s.SMTPD.TLSConfig = s.TLS.Config
 
-		s.Listener, err = tls.Listen("tcp", s.URI.Address, s.SMTPD.TLSConfig)
+		// PROXY header is sent before TLS handshake
+		if s.Listener, err = s.listen(); err == nil {
+			s.Listener = tls.NewListener(s.Listener, s.SMTPD.TLSConfig)
+		}
+	case "lmtp":
+		if s.URI.Socket() {
+			s.Listener, err = s.socket("unix", s.bindUnix)
+		} else {
+			s.Listener, err = s.listen()
+		}
+	case "unix":
+		s.Listener, err = s.socket("unix", s.bindUnix)
 	}
 
 	if err != nil {
@@ -133,9 +274,60 @@ func (s *Server) Build() (err error) {
 	return nil
 }
 
+// listen returns TCP listener of the server. PROXY protocol headers are read from accepted connections, when enabled.
+func (s *Server) listen() (net.Listener, error) {
+	listener, err := s.socket("tcp", s.bindTCP)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Proxy.Listener(listener), nil
+}
+
+// socket takes over the socket of previous server generation, when it was listening on the same address, otherwise
+// binds a new one.
+func (s *Server) socket(network string, bind func() (net.Listener, error)) (net.Listener, error) {
+	if s.Sockets == nil {
+		return bind()
+	}
+
+	return s.Sockets.Listen(network, s.URI.Address, bind)
+}
+
+// bindTCP uses the socket passed by systemd, when there is one bound to the address, so privileged ports can be used
+// without running as root.
+func (s *Server) bindTCP() (net.Listener, error) {
+	listener, inherited, err := systemd.Inherited("tcp", s.URI.Address)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if inherited {
+		log.Infow("using socket passed by systemd", log.Fields{"server": s.URI.String()})
+
+		return listener, nil
+	}
+
+	listener, err = net.Listen("tcp", s.URI.Address)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return listener, nil
+}
+
+func (s *Server) bindUnix() (net.Listener, error) {
+	return s.Unix.Listen(s.URI.Address)
+}
+
 func (s *Server) connectionChecker(peer smtpd.Peer) error {
 	var remoteIP net.IP
 
+	// IP whitelist doesn't apply to local peers, they are checked by credentials
+	if addr, ok := peer.Addr.(*UnixPeerAddr); ok {
+		return s.unixConnectionChecker(peer, addr)
+	}
+
 	if addr, ok := peer.Addr.(*net.TCPAddr); ok {
 		remoteIP = addr.IP
 	}
@@ -151,7 +343,7 @@ func (s *Server) connectionChecker(peer smtpd.Peer) error {
 		_, ipnet, err := net.ParseCIDR(cidr)
 
 		if err == nil && ipnet.Contains(testIP) {
-			return nil
+			return s.milterConnect(peer, remoteIP)
 		}
 	}
 
@@ -182,11 +374,15 @@ func (s *Server) authenticator(peer smtpd.Peer, username string, password string
 }
 
 func (s *Server) handler(peer smtpd.Peer, envelope smtpd.Envelope) error {
-	var remoteIP net.IP
+	return s.process(peer, envelope, nil)
+}
 
-	if s.Relay.OutgoingServer.Host == "" {
-		return nil
-	}
+// process filters and relays the message. For LMTP listeners, results map is given, and the message is relayed to
+// each recipient separately, with results stored in it. Returned error applies to all recipients.
+//
+//nolint:cyclop,funlen
+func (s *Server) process(peer smtpd.Peer, envelope smtpd.Envelope, results map[string]error) error {
+	var remoteIP net.IP
 
 	if addr, ok := peer.Addr.(*net.TCPAddr); ok {
 		remoteIP = addr.IP
@@ -194,23 +390,199 @@ func (s *Server) handler(peer smtpd.Peer, envelope smtpd.Envelope) error {
 
 	log.Infow("processing email", log.Fields{
 		"server": s.URI.String(), "from": envelope.Sender, "to": envelope.Recipients, "remote_ip": remoteIP,
+		"smtputf8": strconv.FormatBool(envelope.SMTPUTF8), "body": envelope.BodyType,
 	})
 
-	envelope.AddReceivedLine(peer)
+	s.Privacy.Apply(peer, &envelope)
+
+	filterEnvelope := &filter.Envelope{
+		Sender:     envelope.Sender,
+		Recipients: envelope.Recipients,
+		Data:       envelope.Data,
+		RemoteIP:   remoteIP,
+		Helo:       peer.HeloName,
+		Username:   peer.Username,
+		TLS:        peer.TLS != nil,
+		Server:     s.URI.String(),
+	}
+
+	// fingerprint is taken before Message-ID and Date are generated, otherwise resubmitted messages without them
+	// would never match
+	filterEnvelope.Fingerprint = filter.Fingerprint(filterEnvelope)
+
+	data, err := s.Normalize.Apply(envelope.Data)
+	if err != nil {
+		log.Infow("malformed message rejected", log.Fields{
+			"server": s.URI.String(), "from": envelope.Sender, "to": envelope.Recipients, "remote_ip": remoteIP,
+			"error": err.Error(),
+		})
+
+		return smtpd.Error{Code: TransactionFailed, Message: fmt.Sprintf("malformed message: %s", err.Error())}
+	}
+
+	envelope.Data = data
+	filterEnvelope.Data = data
+
+	verdict := s.Filters.Run(context.Background(), filterEnvelope)
+	// releases reservations of filters, unless the message was committed on the way
+	defer s.Filters.Abort(filterEnvelope)
+
+	// messages held by milters already passed all filters (and got the footer)
+	filtered := verdict.Action == filter.ActionAccept
+	if filtered {
+		verdict = s.milterMessage(peer, filterEnvelope)
+	}
+
+	switch verdict.Action {
+	case filter.ActionReject, filter.ActionTempFail:
+		return smtpd.Error{Code: verdict.Code, Message: verdict.Message}
+	case filter.ActionQuarantine:
+		if err := s.quarantine(filterEnvelope, verdict, filtered); err != nil {
+			return err
+		}
+
+		s.Filters.Commit(filterEnvelope)
+
+		return nil
+	case filter.ActionDiscard:
+		log.Infow("message discarded", log.Fields{
+			"server": s.URI.String(), "from": envelope.Sender, "to": envelope.Recipients, "filter": verdict.Filter,
+			"reason": verdict.Reason,
+		})
+
+		s.Filters.Commit(filterEnvelope)
+
+		return nil
+	case filter.ActionAccept:
+	}
+
+	// message is not relayed, unless it can be journaled
+	entry, err := s.journalPrepare(filterEnvelope)
+	if err != nil {
+		return smtpd.Error{Code: filter.CodeTempFail, Message: "message could not be journaled, try again later"}
+	}
 
-	err := s.Relay.Handle(envelope.Sender, envelope.Recipients, envelope.Data)
+	if !s.Relay.Enabled() {
+		s.journalCommit(entry)
+		s.archive(filterEnvelope)
+		s.Filters.Commit(filterEnvelope)
+
+		return nil
+	}
+
+	if results != nil {
+		s.relayEach(filterEnvelope, entry, results)
+
+		return nil
+	}
+
+	err = s.Relay.Handle(filterEnvelope.Sender, filterEnvelope.Recipients, filterEnvelope.Data)
 	if err != nil {
-		log.Errorf("forwarding failed", log.Fields{
+		log.Errorw("forwarding failed", log.Fields{
 			"server": s.URI.String(), "from": envelope.Sender, "to": envelope.Recipients, "remote_ip": remoteIP,
 			"error": err.Error(),
 		})
 
-		return smtpd.Error{Code: TransactionFailed, Message: "forwarding failed"}
+		s.relayFailed(filterEnvelope, entry, err)
+
+		return relayError(err)
 	}
 
 	log.Infow("forwarding succeeded, mail sent", log.Fields{
 		"server": s.URI.String(), "from": envelope.Sender, "to": envelope.Recipients, "remote_ip": remoteIP,
 	})
 
+	s.journalCommit(entry)
+	s.archive(filterEnvelope)
+	s.Filters.Commit(filterEnvelope)
+
+	return nil
+}
+
+// relayFailed drops the journal report of message, which wasn't relayed. When it was delivered to some recipients,
+// it is journaled, archived and remembered for them, as the client is told not to retry it.
+func (s *Server) relayFailed(envelope *filter.Envelope, entry *journal.Entry, err error) {
+	s.journalCancel(entry)
+
+	var partial *relay.PartialDeliveryError
+	if !errors.As(err, &partial) {
+		return
+	}
+
+	envelope.Recipients = partial.Delivered
+
+	if delivered, prepareErr := s.journalPrepare(envelope); prepareErr == nil {
+		s.journalCommit(delivered)
+	}
+
+	s.archive(envelope)
+	s.Filters.Commit(envelope)
+}
+
+// remoteAddress returns client IP address stored with the message, empty for clients connected through unix sockets.
+func remoteAddress(envelope *filter.Envelope) string {
+	if envelope.RemoteIP == nil {
+		return ""
+	}
+
+	return envelope.RemoteIP.String()
+}
+
+// relayError converts relay failure to the response sent to the client.
+func relayError(err error) error {
+	// retry would send duplicates to recipients which already got the message
+	var partial *relay.PartialDeliveryError
+	if errors.As(err, &partial) {
+		return smtpd.Error{Code: TransactionFailed, Message: fmt.Sprintf(
+			"message delivered to some recipients only, failed: %s", strings.Join(partial.Failed, ", "),
+		)}
+	}
+
+	if errors.Is(err, message.ErrSMTPUTF8Required) {
+		return smtpd.Error{Code: TransactionFailed, Message: "SMTPUTF8 required, but not supported by outgoing server"}
+	}
+
+	if errors.Is(err, relay.ErrTLSPolicyLookup) {
+		return smtpd.Error{Code: filter.CodeTempFail, Message: "tls policy lookup failed, try again later"}
+	}
+
+	if errors.Is(err, encryption.ErrEncryptionRequired) {
+		return smtpd.Error{Code: TransactionFailed, Message: "encryption required, but no key for recipient"}
+	}
+
+	return smtpd.Error{Code: TransactionFailed, Message: "forwarding failed"}
+}
+
+// quarantine stores the message instead of relaying it. Without quarantine configured, message is rejected, so it
+// doesn't silently disappear.
+func (s *Server) quarantine(envelope *filter.Envelope, verdict *filter.Verdict, filtered bool) error {
+	id, err := s.Quarantine.Put(&quarantine.Entry{
+		Sender:     envelope.Sender,
+		Recipients: envelope.Recipients,
+		RemoteIP:   remoteAddress(envelope),
+		Username:   envelope.Username,
+		Listener:   envelope.Server,
+		Filter:     verdict.Filter,
+		Rule:       verdict.Rule,
+		Reason:     verdict.Reason,
+		Filtered:   filtered,
+	}, envelope.Data)
+	if err != nil {
+		log.Errorw("quarantine failed", log.Fields{
+			"server": s.URI.String(), "from": envelope.Sender, "to": envelope.Recipients, "error": err.Error(),
+		})
+
+		if errors.Is(err, quarantine.ErrQuarantineDisabled) {
+			return smtpd.Error{Code: TransactionFailed, Message: "message rejected by content policy"}
+		}
+
+		return smtpd.Error{Code: filter.CodeTempFail, Message: "message could not be processed, try again later"}
+	}
+
+	log.Infow("message quarantined", log.Fields{
+		"server": s.URI.String(), "from": envelope.Sender, "to": envelope.Recipients, "id": id,
+		"filter": verdict.Filter, "rule": verdict.Rule, "reason": verdict.Reason,
+	})
+
 	return nil
 }
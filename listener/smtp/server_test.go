@@ -36,6 +36,19 @@ func randomPort() int {
 func newTestServer(t *testing.T, proto, cidr string, includeTLSCertificate, authEnabled bool) (*smtp.Server, string) {
 	t.Helper()
 
+	conf, uri, host := newTestServerConfig(t, proto, cidr, includeTLSCertificate, authEnabled)
+
+	server, err := smtp.NewServer(conf, uri)
+	assert.NoError(t, err)
+
+	return server, host
+}
+
+func newTestServerConfig(
+	t *testing.T, proto, cidr string, includeTLSCertificate, authEnabled bool,
+) (config.Config, *smtp.URI, string) {
+	t.Helper()
+
 	var tls config.SMTPTLS
 
 	host := fmt.Sprintf("127.0.0.1:%d", randomPort())
@@ -79,15 +92,13 @@ DQ2hhzbuPuECiTPOUYSO5wI=
 		Auth:      auth,
 		Hostname:  "hostname",
 		Limit:     limit,
+		SMTPUTF8:  true,
 		Timeout:   timeout,
 		TLS:       tls,
 		Whitelist: []string{cidr},
 	}
 
-	server, err := smtp.NewServer(smtpConf, config.Relay{}, uri)
-	assert.NoError(t, err)
-
-	return server, host
+	return config.Config{SMTP: smtpConf}, uri, host
 }
 
 func TestBuildServer(t *testing.T) {
@@ -113,6 +124,8 @@ func TestBuildServer(t *testing.T) {
 	assert.Equal(t, server.SMTPD.DataTimeout, 30*time.Second)
 	assert.Equal(t, server.Timeout.Data, 30*time.Second)
 	assert.Equal(t, server.SMTPD.ReadTimeout, 10*time.Second)
+	assert.True(t, server.SMTPUTF8)
+	assert.True(t, server.SMTPD.EnableSMTPUTF8)
 }
 
 func TestBuildPlainServer(t *testing.T) {
@@ -148,14 +161,11 @@ func TestBuildTlsServer(t *testing.T) {
 func TestBuildTlsServerWithoutCertificates(t *testing.T) {
 	t.Parallel()
 
-	server, _ := newTestServer(t, "tls", "0.0.0.0/0", false, false)
-	err := server.Build()
-	assert.Error(t, err)
+	conf, uri, _ := newTestServerConfig(t, "tls", "0.0.0.0/0", false, false)
 
-	assert.Nil(t, server.TLS)
-	assert.False(t, server.SMTPD.ForceTLS)
-	assert.Nil(t, server.SMTPD.TLSConfig)
-	assert.Nil(t, server.Listener)
+	server, err := smtp.NewServer(conf, uri)
+	assert.ErrorIs(t, err, smtp.ErrTLSNotConfigured)
+	assert.Nil(t, server)
 }
 
 func TestBuildStartTlsServer(t *testing.T) {
@@ -176,14 +186,11 @@ func TestBuildStartTlsServer(t *testing.T) {
 func TestBuildStartTlsServerWithoutCertificates(t *testing.T) {
 	t.Parallel()
 
-	server, _ := newTestServer(t, "starttls", "0.0.0.0/0", false, false)
-	err := server.Build()
-	assert.Error(t, err)
+	conf, uri, _ := newTestServerConfig(t, "starttls", "0.0.0.0/0", false, false)
 
-	assert.Nil(t, server.TLS)
-	assert.False(t, server.SMTPD.ForceTLS)
-	assert.Nil(t, server.SMTPD.TLSConfig)
-	assert.Nil(t, server.Listener)
+	server, err := smtp.NewServer(conf, uri)
+	assert.ErrorIs(t, err, smtp.ErrTLSNotConfigured)
+	assert.Nil(t, server)
 }
 
 func TestIPWhitelistDenied(t *testing.T) {
@@ -2,50 +2,103 @@ package smtp
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/archive"
 	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/filter"
+	"github.com/ajgon/mailbowl/systemd"
 )
 
 type SMTP struct {
-	Servers []*Server
+	Servers      []*Server
+	Filters      *filter.Chain
+	Sockets      *Sockets
+	Certificates map[string]*Certificate
 }
 
-func NewSMTP(smtpConf config.SMTP, relayConf config.Relay, uris []string) *SMTP {
-	smtp := &SMTP{Servers: make([]*Server, 0)}
-	brokenURIs := false
+var errInvalidListeners = errors.New("invalid SMTP listeners")
+
+// NewSMTP builds servers for given listener URIs. Filters and archive are shared by all servers, so state kept by
+// them (like dedupe, or the archive chain) is common. When they are not given, they are built from the
+// configuration. Sockets are shared with the previous generation of servers, so they can be handed over on reload.
+func NewSMTP(
+	conf config.Config, uris []string, filters *filter.Chain, store *archive.Archive, sockets *Sockets,
+) (*SMTP, error) {
+	if filters == nil {
+		var err error
+
+		if filters, err = filter.NewChain(conf.Filter); err != nil {
+			return nil, fmt.Errorf("problem configuring filters: %w", err)
+		}
+	}
+
+	if store == nil {
+		store = archive.NewArchive(conf.Archive)
+	}
+
+	if sockets == nil {
+		sockets = NewSockets()
+	}
+
+	smtp := &SMTP{
+		Servers:      make([]*Server, 0),
+		Filters:      filters,
+		Sockets:      sockets,
+		Certificates: make(map[string]*Certificate),
+	}
+	brokenURIs := make([]string, 0)
 
 	for _, uri := range uris {
 		smtpURI, err := NewURI(uri)
 		if err != nil {
-			brokenURIs = true
+			brokenURIs = append(brokenURIs, uri)
 
-			log.Errorw("invalid SMTP listener URI: %s", log.Fields{"uri": uri})
-		} else {
-			server, err := NewServer(smtpConf, relayConf, smtpURI)
-			if err != nil {
-				log.Fatalw("problem booting SMTP listener: %s", log.Fields{"uri": uri})
-			}
-			smtp.Servers = append(smtp.Servers, server)
+			log.Errorw("invalid SMTP listener URI", log.Fields{"uri": uri, "error": err.Error()})
+
+			continue
 		}
+
+		server, err := newServer(conf, smtpURI, filters, store, smtp.Certificates)
+		if err != nil {
+			return nil, fmt.Errorf("problem booting SMTP listener (%s): %w", uri, err)
+		}
+
+		server.Sockets = sockets
+
+		smtp.Servers = append(smtp.Servers, server)
 	}
 
-	if brokenURIs {
-		log.Fatal("one of SMTP listener uris is invalid, refusing to start")
+	if len(brokenURIs) > 0 {
+		return nil, fmt.Errorf("%w: %s", errInvalidListeners, strings.Join(brokenURIs, ", "))
 	}
 
-	return smtp
+	return smtp, nil
 }
 
 func (s *SMTP) GetName() string {
 	return "SMTP"
 }
 
+// Serve starts the servers, taking over listen sockets of the previous generation. When context is cancelled, servers
+// stop accepting connections, and their sessions are drained in the background, so the next generation can start
+// right away.
 func (s *SMTP) Serve(ctx context.Context) (err error) {
-	for _, server := range s.Servers {
+	s.Sockets.Begin()
+
+	for index, server := range s.Servers {
 		if err = server.Build(); err != nil {
-			log.Fatalf("error starting SMTP server (%s): %s", server.URI.String(), err.Error())
+			// servers started so far are stopped, the error stops the whole generation anyway
+			for _, started := range s.Servers[:index] {
+				if stopErr := s.stop(started); stopErr != nil {
+					log.Warnw(stopErr.Error(), log.Fields{"server": started.URI.String()})
+				}
+			}
+
+			return fmt.Errorf("error starting SMTP server (%s): %w", server.URI.String(), err)
 		}
 
 		go server.Start()
@@ -53,17 +106,56 @@ func (s *SMTP) Serve(ctx context.Context) (err error) {
 		log.Infow("SMTP server started", log.Fields{"server": server.URI.String()})
 	}
 
+	// sockets of listeners removed from config are not needed anymore
+	s.Sockets.Release()
+
+	for _, certificate := range s.Certificates {
+		go s.watchCertificate(ctx, certificate)
+	}
+
+	// all listeners are bound at this point, so connections queued by systemd can be accepted
+	if err := systemd.Notify(systemd.StateReady); err != nil {
+		log.Warnw("systemd notification failed", log.Fields{"error": err.Error()})
+	}
+
 	<-ctx.Done()
 
 	for _, server := range s.Servers {
-		log.Debugw("stopping SMTP server", log.Fields{"server": server.URI.String()})
-
-		if err := server.Shutdown(); err != nil {
-			return fmt.Errorf("error stopping SMTP server (%s): %w", server.URI.String(), err)
+		if err := s.stop(server); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		log.Debugw("SMTP server shut down", log.Fields{"server": server.URI.String()})
+// stop stops accepting connections, and drains sessions of the server in the background.
+func (s *SMTP) stop(server *Server) error {
+	log.Debugw("stopping SMTP server", log.Fields{"server": server.URI.String()})
+
+	if err := server.Stop(); err != nil {
+		return fmt.Errorf("error stopping SMTP server (%s): %w", server.URI.String(), err)
+	}
+
+	s.Sockets.Drain(server)
+
+	return nil
+}
+
+// watchCertificate reloads TLS certificate when its files change, without restarting servers using it.
+func (s *SMTP) watchCertificate(ctx context.Context, certificate *Certificate) {
+	if err := certificate.Watch(ctx); err != nil {
+		log.Errorw("can't watch TLS certificate for changes", log.Fields{
+			"certificate": certificate.CertificateFile, "error": err.Error(),
+		})
 	}
+}
+
+// Close closes listen sockets, and waits for sessions of all server generations to finish.
+func (s *SMTP) Close() error {
+	log.Debug("closing SMTP listen sockets and draining sessions")
+
+	s.Sockets.Close()
 
 	return nil
 }
@@ -0,0 +1,185 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/Masterminds/log-go"
+)
+
+// Sockets keeps listen sockets open between server generations, so connections aren't refused during reload. Each
+// generation accepts connections through its own view of the socket, closing the view only detaches the generation,
+// and connections waiting in the meantime are accepted by the next one. Sockets not used by the current generation
+// are closed by Release.
+type Sockets struct {
+	mutex      sync.Mutex
+	sockets    map[string]*socket
+	generation int
+	draining   sync.WaitGroup
+}
+
+type socket struct {
+	net.Listener
+	generation int
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type socketView struct {
+	socket    *socket
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewSockets() *Sockets {
+	return &Sockets{sockets: make(map[string]*socket)}
+}
+
+// Begin starts a new server generation. Sockets are marked as used by it, when listened on.
+func (s *Sockets) Begin() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.generation++
+}
+
+// Listen returns the socket bound to the address by previous generation, or binds a new one. Socket mode, owner and
+// bound address are kept, when it's handed over.
+func (s *Sockets) Listen(network, address string, bind func() (net.Listener, error)) (net.Listener, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := fmt.Sprintf("%s://%s", network, address)
+
+	shared, ok := s.sockets[key]
+	if !ok {
+		listener, err := bind()
+		if err != nil {
+			return nil, err
+		}
+
+		shared = &socket{
+			Listener: listener,
+			conns:    make(chan net.Conn),
+			errs:     make(chan error),
+			done:     make(chan struct{}),
+		}
+
+		go shared.acceptLoop()
+
+		s.sockets[key] = shared
+	} else {
+		log.Debugw("listen socket handed over", log.Fields{"socket": key})
+	}
+
+	shared.generation = s.generation
+
+	return &socketView{socket: shared, done: make(chan struct{})}, nil
+}
+
+// Release closes sockets, which are not used by the current generation (i.e. listeners removed from config).
+func (s *Sockets) Release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, shared := range s.sockets {
+		if shared.generation == s.generation {
+			continue
+		}
+
+		log.Infow("closing listen socket removed from config", log.Fields{"socket": key})
+
+		if err := shared.Close(); err != nil {
+			log.Warnw("error closing listen socket", log.Fields{"socket": key, "error": err.Error()})
+		}
+
+		delete(s.sockets, key)
+	}
+}
+
+// Drain waits in the background for sessions of the stopped server to finish.
+func (s *Sockets) Drain(server *Server) {
+	s.draining.Add(1)
+
+	go func() {
+		defer s.draining.Done()
+
+		server.Drain()
+	}()
+}
+
+// Close closes all sockets, and waits for draining servers.
+func (s *Sockets) Close() {
+	s.mutex.Lock()
+	s.generation++
+	s.mutex.Unlock()
+
+	s.Release()
+	s.draining.Wait()
+}
+
+func (s *socket) acceptLoop() {
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			select {
+			case s.errs <- err:
+			case <-s.done:
+				return
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			continue
+		}
+
+		select {
+		case s.conns <- conn:
+		case <-s.done:
+			conn.Close()
+
+			return
+		}
+	}
+}
+
+func (s *socket) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+
+	if err := s.Listener.Close(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+func (v *socketView) Accept() (net.Conn, error) {
+	select {
+	case conn := <-v.socket.conns:
+		return conn, nil
+	case err := <-v.socket.errs:
+		return nil, err
+	case <-v.done:
+		return nil, net.ErrClosed
+	case <-v.socket.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close detaches the view, the socket stays open for the next generation.
+func (v *socketView) Close() error {
+	v.closeOnce.Do(func() { close(v.done) })
+
+	return nil
+}
+
+func (v *socketView) Addr() net.Addr {
+	return v.socket.Addr()
+}
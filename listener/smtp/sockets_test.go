@@ -0,0 +1,89 @@
+package smtp_test
+
+import (
+	"context"
+	"fmt"
+	netsmtp "net/smtp"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSocketsTestSMTP(t *testing.T, sockets *smtp.Sockets, uris ...string) *smtp.SMTP {
+	t.Helper()
+
+	server, err := smtp.NewSMTP(config.Config{SMTP: config.SMTP{
+		Hostname:  "hostname",
+		Limit:     config.SMTPLimit{Connections: 10, MessageSize: 1024, Recipients: 10},
+		Timeout:   config.SMTPTimeout{Read: time.Minute, Write: time.Minute, Data: time.Minute, Drain: time.Second},
+		Whitelist: []string{"127.0.0.1/32"},
+	}}, uris, nil, nil, sockets)
+	assert.NoError(t, err)
+
+	return server
+}
+
+func serveSocketsTestSMTP(server *smtp.SMTP) (func(), chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() { done <- server.Serve(ctx) }()
+
+	time.Sleep(100 * time.Millisecond) // allow servers to start
+
+	return cancel, done
+}
+
+func TestSocketsHandover(t *testing.T) {
+	t.Parallel()
+
+	kept := fmt.Sprintf("127.0.0.1:%d", randomPort())
+	removed := fmt.Sprintf("127.0.0.1:%d", randomPort())
+	sockets := smtp.NewSockets()
+
+	first := newSocketsTestSMTP(t, sockets, "plain://"+kept, "plain://"+removed)
+	cancel, done := serveSocketsTestSMTP(first)
+
+	// session in progress during reload
+	client, err := netsmtp.Dial(kept)
+	assert.NoError(t, err)
+	assert.NoError(t, client.Hello("localhost"))
+
+	cancel()
+	assert.NoError(t, <-done)
+
+	// socket is still open, connection waits for the next generation
+	dialed := make(chan error)
+
+	go func() {
+		next, err := netsmtp.Dial(kept)
+		if err == nil {
+			err = next.Quit()
+		}
+		dialed <- err
+	}()
+
+	second := newSocketsTestSMTP(t, sockets, "plain://"+kept)
+	cancel, done = serveSocketsTestSMTP(second)
+
+	assert.NoError(t, <-dialed)
+
+	// previous session is still served, until the drain timeout
+	assert.NoError(t, client.Noop())
+
+	_, err = netsmtp.Dial(removed)
+	assert.Error(t, err)
+
+	time.Sleep(1200 * time.Millisecond)
+	assert.Error(t, client.Noop())
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.NoError(t, second.Close())
+
+	_, err = netsmtp.Dial(kept)
+	assert.Error(t, err)
+}
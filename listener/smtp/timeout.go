@@ -10,6 +10,7 @@ type Timeout struct {
 	Read  time.Duration
 	Write time.Duration
 	Data  time.Duration
+	Drain time.Duration
 }
 
 func NewTimeout(conf config.SMTPTimeout) *Timeout {
@@ -17,5 +18,6 @@ func NewTimeout(conf config.SMTPTimeout) *Timeout {
 		Read:  conf.Read,
 		Write: conf.Write,
 		Data:  conf.Data,
+		Drain: conf.Drain,
 	}
 }
@@ -14,8 +14,10 @@ func TestValidTimeoutArgs(t *testing.T) {
 
 	var gotTimeout, wantTimeout *smtp.Timeout
 
-	gotTimeout = smtp.NewTimeout(config.SMTPTimeout{Read: 30 * time.Second, Write: 10 * time.Minute, Data: 2 * time.Hour})
-	wantTimeout = &smtp.Timeout{Read: 30 * time.Second, Write: 10 * time.Minute, Data: 2 * time.Hour}
+	gotTimeout = smtp.NewTimeout(config.SMTPTimeout{
+		Read: 30 * time.Second, Write: 10 * time.Minute, Data: 2 * time.Hour, Drain: time.Minute,
+	})
+	wantTimeout = &smtp.Timeout{Read: 30 * time.Second, Write: 10 * time.Minute, Data: 2 * time.Hour, Drain: time.Minute}
 
 	assert.Equal(t, gotTimeout, wantTimeout)
 }
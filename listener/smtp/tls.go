@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/Masterminds/log-go"
 	"github.com/ajgon/mailbowl/config"
 )
 
@@ -12,15 +13,16 @@ var ErrTLSNotConfigured = errors.New("TLS not configured")
 
 type TLS struct {
 	Config           *tls.Config
+	Certificate      *Certificate
 	ForceForStartTLS bool
 }
 
 func NewTLS(conf config.SMTPTLS) (*TLS, error) {
-	var (
-		cert tls.Certificate
-		err  error
-	)
+	return newTLS(conf, make(map[string]*Certificate))
+}
 
+// newTLS reuses certificates loaded from the same files, so they are watched and reloaded once for all servers.
+func newTLS(conf config.SMTPTLS, certificates map[string]*Certificate) (*TLS, error) {
 	if conf.Key == "" && conf.Certificate == "" && conf.KeyFile == "" && conf.CertificateFile == "" {
 		return nil, ErrTLSNotConfigured
 	}
@@ -39,15 +41,9 @@ func NewTLS(conf config.SMTPTLS) (*TLS, error) {
 		tls.TLS_RSA_WITH_AES_256_GCM_SHA384, // does not provide PFS
 	}
 
-	// first try load direct TLS (it takes precedence)
-	cert, err = tls.X509KeyPair([]byte(conf.Certificate), []byte(conf.Key))
+	certificate, err := loadCertificate(conf, certificates)
 	if err != nil {
-		// okay, let's try file then
-		cert, err = tls.LoadX509KeyPair(conf.CertificateFile, conf.KeyFile)
-		if err != nil {
-			// still no luck? then fail
-			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
-		}
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
 	}
 
 	return &TLS{
@@ -55,8 +51,42 @@ func NewTLS(conf config.SMTPTLS) (*TLS, error) {
 			PreferServerCipherSuites: true,
 			MinVersion:               tls.VersionTLS12,
 			CipherSuites:             tlsCipherSuites,
-			Certificates:             []tls.Certificate{cert},
+			GetCertificate:           certificate.GetCertificate,
 		},
+		Certificate:      certificate,
 		ForceForStartTLS: conf.ForceForStartTLS,
 	}, nil
 }
+
+func loadCertificate(conf config.SMTPTLS, certificates map[string]*Certificate) (*Certificate, error) {
+	// first try load direct TLS (it takes precedence)
+	if cert, err := tls.X509KeyPair([]byte(conf.Certificate), []byte(conf.Key)); err == nil {
+		return newInlineCertificate(cert)
+	}
+
+	// okay, let's try file then
+	key := conf.CertificateFile + ":" + conf.KeyFile
+
+	if certificate, ok := certificates[key]; ok {
+		return certificate, nil
+	}
+
+	certificate, err := NewCertificate(conf.CertificateFile, conf.KeyFile)
+
+	switch {
+	case err != nil && conf.ACME:
+		// not obtained yet, it's loaded as soon as it's written to the cache
+		log.Warnw("ACME certificate not available yet", log.Fields{
+			"certificate": conf.CertificateFile, "error": err.Error(),
+		})
+
+		certificate = &Certificate{CertificateFile: conf.CertificateFile, KeyFile: conf.KeyFile}
+	case err != nil:
+		// still no luck? then fail
+		return nil, err
+	}
+
+	certificates[key] = certificate
+
+	return certificate, nil
+}
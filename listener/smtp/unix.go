@@ -0,0 +1,285 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/systemd"
+	"github.com/chrj/smtpd"
+)
+
+var (
+	errSocketInUse = errors.New("unix socket already in use")
+	errNotSocket   = errors.New("path exists and is not a unix socket")
+)
+
+// Unix holds unix socket listener settings: socket file permissions and owner, and the local users allowed to
+// connect. Mode is 0 and UID, GID are -1, when they are not changed.
+type Unix struct {
+	Mode        os.FileMode
+	UID         int
+	GID         int
+	AllowedUIDs []int
+	AllowedGIDs []int
+}
+
+// UnixPeerAddr is the remote address of connections accepted on unix sockets, carrying peer credentials.
+type UnixPeerAddr struct {
+	Path        string
+	Credentials bool
+	PID         int
+	UID         int
+	GID         int
+}
+
+func (a *UnixPeerAddr) Network() string {
+	return "unix"
+}
+
+func (a *UnixPeerAddr) String() string {
+	if !a.Credentials {
+		return a.Path
+	}
+
+	return fmt.Sprintf("%s (pid=%d uid=%d gid=%d)", a.Path, a.PID, a.UID, a.GID)
+}
+
+func NewUnix(conf config.SMTPUnix) (*Unix, error) {
+	unix := &Unix{Mode: conf.Mode, UID: -1, GID: -1, AllowedUIDs: conf.AllowedUIDs, AllowedGIDs: conf.AllowedGIDs}
+
+	if conf.Owner != "" {
+		owner, err := lookupUser(conf.Owner)
+		if err != nil {
+			return nil, fmt.Errorf("invalid smtp.unix.owner: %w", err)
+		}
+
+		if unix.UID, err = strconv.Atoi(owner.Uid); err != nil {
+			return nil, fmt.Errorf("invalid smtp.unix.owner: %w", err)
+		}
+	}
+
+	if conf.Group != "" {
+		group, err := lookupGroup(conf.Group)
+		if err != nil {
+			return nil, fmt.Errorf("invalid smtp.unix.group: %w", err)
+		}
+
+		if unix.GID, err = strconv.Atoi(group.Gid); err != nil {
+			return nil, fmt.Errorf("invalid smtp.unix.group: %w", err)
+		}
+	}
+
+	return unix, nil
+}
+
+// Listen creates the socket, removing the stale one left by previous process. Socket which still accepts
+// connections is never removed, as it belongs to another running instance.
+func (u *Unix) Listen(path string) (net.Listener, error) {
+	// socket passed by systemd already has its mode and owner set (SocketMode, SocketUser), and it's not removed
+	// when closed
+	listener, inherited, err := systemd.Inherited("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if inherited {
+		log.Infow("using socket passed by systemd", log.Fields{"socket": path})
+
+		return &unixListener{Listener: listener, path: path}, nil
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	bound, err := u.bindUnix(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unixListener{Listener: bound, path: path, unlink: true}, nil
+}
+
+// setPermissions sets owner and mode of the socket. Mode given by the process umask is kept, when it's not
+// configured.
+func (u *Unix) setPermissions(path string) error {
+	// owner goes first, socket is accessible by nobody else until its mode is set
+	if u.UID != -1 || u.GID != -1 {
+		if err := os.Chown(path, u.UID, u.GID); err != nil {
+			return fmt.Errorf("error setting unix socket owner: %w", err)
+		}
+	}
+
+	if u.Mode != 0 {
+		if err := os.Chmod(path, u.Mode); err != nil {
+			return fmt.Errorf("error setting unix socket mode: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Allowed checks if the peer may connect. Without allowed UIDs and GIDs, access is controlled by socket file
+// permissions only.
+func (u *Unix) Allowed(addr *UnixPeerAddr) bool {
+	if len(u.AllowedUIDs) == 0 && len(u.AllowedGIDs) == 0 {
+		return true
+	}
+
+	if !addr.Credentials {
+		return false
+	}
+
+	for _, uid := range u.AllowedUIDs {
+		if uid == addr.UID {
+			return true
+		}
+	}
+
+	for _, gid := range u.AllowedGIDs {
+		if gid == addr.GID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unixListener replaces remote address of accepted connections with UnixPeerAddr, so credentials are available
+// in smtpd.Peer. Socket bound by mailbowl is removed when closed, the one passed by systemd is left in place.
+type unixListener struct {
+	net.Listener
+	path   string
+	unlink bool
+}
+
+type unixConn struct {
+	net.Conn
+	addr *UnixPeerAddr
+}
+
+func (l *unixListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	addr := &UnixPeerAddr{Path: l.path}
+
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		if err := readPeerCredentials(unixConn, addr); err != nil {
+			log.Debugw("peer credentials unavailable", log.Fields{"socket": l.path, "error": err.Error()})
+		}
+	}
+
+	return &unixConn{Conn: conn, addr: addr}, nil
+}
+
+// Addr returns the socket path, the socket might have been bound under a different one.
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+
+	if l.unlink {
+		if removeErr := os.Remove(l.path); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+			err = removeErr
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+func (c *unixConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%w: %s", errNotSocket, path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+
+		return fmt.Errorf("%w: %s", errSocketInUse, path)
+	}
+
+	log.Infow("removing stale unix socket", log.Fields{"socket": path})
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("error removing stale unix socket: %w", err)
+	}
+
+	return nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		owner, err := user.LookupId(name)
+		if err == nil {
+			return owner, nil
+		}
+
+		// numeric UID without passwd entry is still valid
+		return &user.User{Uid: name}, nil //nolint:nilerr
+	}
+
+	owner, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return owner, nil
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		group, err := user.LookupGroupId(name)
+		if err == nil {
+			return group, nil
+		}
+
+		return &user.Group{Gid: name}, nil //nolint:nilerr
+	}
+
+	group, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return group, nil
+}
+
+func (s *Server) unixConnectionChecker(peer smtpd.Peer, addr *UnixPeerAddr) error {
+	log.Debugw("new SMTP connection", log.Fields{"server": s.URI.String(), "peer": addr.String()})
+
+	if s.Unix.Allowed(addr) {
+		return s.milterConnect(peer, nil)
+	}
+
+	log.Infow("unix peer not allowed, access denied", log.Fields{"server": s.URI.String(), "peer": addr.String()})
+
+	return smtpd.Error{Code: ServiceNotAvailable, Message: "Denied"}
+}
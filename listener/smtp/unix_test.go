@@ -0,0 +1,222 @@
+package smtp_test
+
+import (
+	"net"
+	netsmtp "net/smtp"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ajgon/mailbowl/archive"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/stretchr/testify/assert"
+)
+
+func newUnixTestServer(t *testing.T, unix config.SMTPUnix) (*smtp.Server, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "smtp.sock")
+
+	uri, err := smtp.NewURI("unix://" + path)
+	assert.NoError(t, err)
+
+	server, err := smtp.NewServer(config.Config{SMTP: config.SMTP{
+		Hostname: "hostname",
+		Limit:    config.SMTPLimit{Connections: 10, MessageSize: 1024, Recipients: 10},
+		Unix:     unix,
+		// IP whitelist doesn't apply to unix sockets
+		Whitelist: []string{"10.0.0.0/8"},
+	}}, uri)
+	assert.NoError(t, err)
+
+	return server, path
+}
+
+func sendUnixMail(path string) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return err
+	}
+
+	client, err := netsmtp.NewClient(conn, "localhost")
+	if err != nil {
+		conn.Close()
+
+		return err
+	}
+	defer client.Close()
+
+	return client.Noop()
+}
+
+func TestUnixSocketServer(t *testing.T) {
+	t.Parallel()
+
+	server, path := newUnixTestServer(t, config.SMTPUnix{Mode: 0o600})
+	assert.NoError(t, server.Build())
+
+	go server.Start()
+	defer server.Shutdown() //nolint: errcheck
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeSocket, info.Mode()&os.ModeSocket)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	assert.NoError(t, sendUnixMail(path))
+}
+
+func TestUnixSocketMode(t *testing.T) {
+	t.Parallel()
+
+	// mode given by the process umask is kept, when it's not configured
+	plain, err := net.Listen("unix", filepath.Join(t.TempDir(), "plain.sock"))
+	assert.NoError(t, err)
+
+	defer plain.Close()
+
+	expected, err := os.Stat(plain.Addr().String())
+	assert.NoError(t, err)
+
+	for mode, unix := range map[os.FileMode]config.SMTPUnix{
+		expected.Mode().Perm(): {},
+		0o666:                  {Mode: 0o666},
+	} {
+		server, path := newUnixTestServer(t, unix)
+		assert.NoError(t, server.Build())
+
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, mode, info.Mode().Perm())
+
+		server.Listener.Close()
+	}
+}
+
+func TestUnixSocketBoundInPlace(t *testing.T) {
+	t.Parallel()
+
+	server, path := newUnixTestServer(t, config.SMTPUnix{Mode: 0o660})
+	assert.NoError(t, server.Build())
+
+	// private directory, the socket was bound in, is gone
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "smtp.sock", entries[0].Name())
+	assert.Equal(t, path, server.Listener.Addr().String())
+
+	server.Listener.Close()
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUnixSocketPeerCredentials(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are supported on linux only")
+	}
+
+	allowed, allowedPath := newUnixTestServer(t, config.SMTPUnix{AllowedUIDs: []int{os.Getuid()}})
+	assert.NoError(t, allowed.Build())
+
+	go allowed.Start()
+	defer allowed.Shutdown() //nolint: errcheck
+
+	assert.NoError(t, sendUnixMail(allowedPath))
+
+	denied, deniedPath := newUnixTestServer(t, config.SMTPUnix{AllowedUIDs: []int{os.Getuid() + 1}})
+	assert.NoError(t, denied.Build())
+
+	go denied.Start()
+	defer denied.Shutdown() //nolint: errcheck
+
+	err := sendUnixMail(deniedPath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "421")
+}
+
+func TestUnixSocketStaleCleanup(t *testing.T) {
+	t.Parallel()
+
+	server, path := newUnixTestServer(t, config.SMTPUnix{})
+
+	// socket left behind by a process which died
+	stale, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	assert.NoError(t, server.Build())
+	server.Listener.Close()
+
+	// socket still in use
+	active, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+
+	defer active.Close()
+
+	server, _ = newUnixTestServer(t, config.SMTPUnix{})
+	server.URI.Address = path
+	assert.Error(t, server.Build())
+
+	// not a socket at all
+	regular := filepath.Join(t.TempDir(), "regular")
+	assert.NoError(t, os.WriteFile(regular, nil, 0o600))
+
+	server.URI.Address = regular
+	assert.Error(t, server.Build())
+}
+
+func TestUnixSocketArchivedWithoutRemoteIP(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	path := filepath.Join(t.TempDir(), "smtp.sock")
+
+	uri, err := smtp.NewURI("unix://" + path)
+	assert.NoError(t, err)
+
+	server, err := smtp.NewServer(config.Config{
+		Archive: config.Archive{Directory: directory},
+		SMTP: config.SMTP{
+			Hostname: "hostname",
+			Limit:    config.SMTPLimit{Connections: 10, MessageSize: 1024, Recipients: 10},
+		},
+	}, uri)
+	assert.NoError(t, err)
+	assert.NoError(t, server.Build())
+
+	go server.Start()
+	defer server.Shutdown() //nolint: errcheck
+
+	conn, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+
+	client, err := netsmtp.NewClient(conn, "localhost")
+	assert.NoError(t, err)
+	assert.NoError(t, client.Mail("sender@example.local"))
+	assert.NoError(t, client.Rcpt("receiver@example.local"))
+
+	writer, err := client.Data()
+	assert.NoError(t, err)
+
+	_, err = writer.Write([]byte("From: sender@example.local\r\nSubject: Test\r\n\r\nbody\r\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	assert.NoError(t, client.Quit())
+
+	records := make([]*archive.Record, 0)
+	assert.NoError(t, archive.Walk(directory, func(_ string, record *archive.Record) error {
+		records = append(records, record)
+
+		return nil
+	}))
+
+	assert.Len(t, records, 1)
+	assert.Equal(t, "", records[0].RemoteIP)
+}
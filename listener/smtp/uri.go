@@ -4,12 +4,20 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 )
 
-var errInvalidScheme = errors.New("invalid smtp server scheme")
+var (
+	errInvalidScheme     = errors.New("invalid smtp server scheme")
+	errMissingSocketPath = errors.New("missing unix socket path in")
+)
 
 func ErrInvalidScheme(scheme string) error {
-	return fmt.Errorf("%w `%s`, must be one of `plain`, `tls` or `starttls`", errInvalidScheme, scheme)
+	return fmt.Errorf("%w `%s`, must be one of `plain`, `tls`, `starttls`, `lmtp` or `unix`", errInvalidScheme, scheme)
+}
+
+func ErrMissingSocketPath(uri string) error {
+	return fmt.Errorf("%w `%s`", errMissingSocketPath, uri)
 }
 
 type URI struct {
@@ -23,7 +31,21 @@ func NewURI(uri string) (*URI, error) {
 		return nil, fmt.Errorf("error parsing `%s` uri: %w", uri, err)
 	}
 
-	if url.Scheme != "plain" && url.Scheme != "tls" && url.Scheme != "starttls" {
+	switch url.Scheme {
+	case "plain", "tls", "starttls":
+	case "lmtp":
+		// lmtp://127.0.0.1:24 or lmtp:///run/mailbowl/lmtp.sock
+		if url.Host == "" && url.Path != "" {
+			return &URI{Scheme: url.Scheme, Address: url.Path}, nil
+		}
+	case "unix":
+		// unix:///run/mailbowl/smtp.sock - address is the socket path
+		if url.Path == "" {
+			return nil, ErrMissingSocketPath(uri)
+		}
+
+		return &URI{Scheme: url.Scheme, Address: url.Path}, nil
+	default:
 		return nil, ErrInvalidScheme(url.Scheme)
 	}
 
@@ -36,3 +58,18 @@ func NewURI(uri string) (*URI, error) {
 func (u *URI) String() string {
 	return fmt.Sprintf("%s://%s", u.Scheme, u.Address)
 }
+
+// LMTP tells whether the listener speaks LMTP instead of SMTP.
+func (u *URI) LMTP() bool {
+	return u.Scheme == "lmtp"
+}
+
+// TLS tells whether the listener can't be served without TLS certificate.
+func (u *URI) TLS() bool {
+	return u.Scheme == "tls" || u.Scheme == "starttls"
+}
+
+// Socket tells whether the listener is bound to unix socket.
+func (u *URI) Socket() bool {
+	return u.Scheme == "unix" || (u.Scheme == "lmtp" && strings.HasPrefix(u.Address, "/"))
+}
@@ -29,7 +29,13 @@ func TestInvalidURIArgs(t *testing.T) {
 	gotURI, gotErr = smtp.NewURI("http://example.local/")
 	assert.Nil(t, gotURI)
 
-	wantErr = "invalid smtp server scheme `http`, must be one of `plain`, `tls` or `starttls`"
+	wantErr = "invalid smtp server scheme `http`, must be one of `plain`, `tls`, `starttls`, `lmtp` or `unix`"
+	assert.Equal(t, gotErr.Error(), wantErr)
+
+	gotURI, gotErr = smtp.NewURI("unix://smtp.sock")
+	assert.Nil(t, gotURI)
+
+	wantErr = "missing unix socket path in `unix://smtp.sock`"
 	assert.Equal(t, gotErr.Error(), wantErr)
 }
 
@@ -65,4 +71,33 @@ func TestValidURIArgs(t *testing.T) {
 	assert.Nil(t, gotErr)
 	assert.Equal(t, gotURI, wantURI)
 	assert.Equal(t, gotURI.String(), uri)
+
+	uri = "unix:///run/mailbowl/smtp.sock"
+	gotURI, gotErr = smtp.NewURI(uri)
+	wantURI = &smtp.URI{Scheme: "unix", Address: "/run/mailbowl/smtp.sock"}
+
+	assert.Nil(t, gotErr)
+	assert.Equal(t, gotURI, wantURI)
+	assert.Equal(t, gotURI.String(), uri)
+	assert.True(t, gotURI.Socket())
+
+	uri = "lmtp://example.local:24"
+	gotURI, gotErr = smtp.NewURI(uri)
+	wantURI = &smtp.URI{Scheme: "lmtp", Address: "example.local:24"}
+
+	assert.Nil(t, gotErr)
+	assert.Equal(t, gotURI, wantURI)
+	assert.Equal(t, gotURI.String(), uri)
+	assert.True(t, gotURI.LMTP())
+	assert.False(t, gotURI.Socket())
+
+	uri = "lmtp:///run/mailbowl/lmtp.sock"
+	gotURI, gotErr = smtp.NewURI(uri)
+	wantURI = &smtp.URI{Scheme: "lmtp", Address: "/run/mailbowl/lmtp.sock"}
+
+	assert.Nil(t, gotErr)
+	assert.Equal(t, gotURI, wantURI)
+	assert.Equal(t, gotURI.String(), uri)
+	assert.True(t, gotURI.LMTP())
+	assert.True(t, gotURI.Socket())
 }
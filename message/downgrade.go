@@ -0,0 +1,179 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrSMTPUTF8Required = errors.New("message cannot be delivered without SMTPUTF8")
+
+//nolint:gochecknoglobals
+var addressFields = map[string]bool{
+	"from":                        true,
+	"sender":                      true,
+	"reply-to":                    true,
+	"to":                          true,
+	"cc":                          true,
+	"bcc":                         true,
+	"resent-from":                 true,
+	"resent-sender":               true,
+	"resent-to":                   true,
+	"resent-cc":                   true,
+	"resent-bcc":                  true,
+	"return-path":                 true,
+	"disposition-notification-to": true,
+}
+
+func Is8Bit(data []byte) bool {
+	for _, c := range data {
+		if c >= utf8.RuneSelf {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasNonASCIIHeaders returns true if any header field, in the message or any of its parts, contains raw UTF-8
+// (RFC 6532), which is only allowed when message is transferred with SMTPUTF8.
+func (p *Part) HasNonASCIIHeaders() bool {
+	found := false
+
+	_ = p.Walk(func(part *Part) error {
+		if Is8Bit(part.Header.Bytes()) {
+			found = true
+		}
+
+		return nil
+	})
+
+	return found
+}
+
+// Downgrade8Bit re-encodes 8-bit parts with quoted-printable (text parts) or base64 (everything else), so
+// the message can be transferred to a server without 8BITMIME support.
+func (p *Part) Downgrade8Bit() error {
+	if p.IsMultipart() {
+		for _, child := range p.Parts {
+			if err := child.Downgrade8Bit(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	mediaType, _ := p.MediaType()
+
+	if mediaType == "message/rfc822" || mediaType == "message/global" {
+		return p.downgradeEmbedded()
+	}
+
+	encoding := p.TransferEncoding()
+	if encoding == EncodingBase64 || encoding == EncodingQuotedPrintable {
+		return nil
+	}
+
+	if !Is8Bit(p.Body) {
+		if encoding != Encoding7Bit {
+			p.Header.Set("Content-Transfer-Encoding", Encoding7Bit)
+		}
+
+		return nil
+	}
+
+	if !p.Header.Has("Content-Type") {
+		if utf8.Valid(p.Body) {
+			p.Header.Add("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			p.Header.Add("Content-Type", "text/plain; charset=unknown-8bit")
+		}
+
+		if !p.Header.Has("MIME-Version") {
+			p.Header.Add("MIME-Version", "1.0")
+		}
+
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "text/") {
+		p.SetContent(p.Body, EncodingQuotedPrintable)
+	} else {
+		p.SetContent(p.Body, EncodingBase64)
+	}
+
+	return nil
+}
+
+// DowngradeHeaders makes header fields of the message and all its parts ASCII only. Non-ASCII domains in address
+// fields are converted to A-labels, display names and unstructured fields are encoded as RFC 2047 encoded-words.
+// When an address with non-ASCII local part is found, ErrSMTPUTF8Required is returned.
+func (p *Part) DowngradeHeaders() error {
+	return p.Walk(func(part *Part) error {
+		for i, field := range part.Header.Fields {
+			if !Is8Bit(field.Raw) {
+				continue
+			}
+
+			value, err := downgradeFieldValue(field.Name, field.Value())
+			if err != nil {
+				return err
+			}
+
+			part.Header.Fields[i] = NewField(field.Name, value, part.Header.EOL)
+		}
+
+		return nil
+	})
+}
+
+func (p *Part) downgradeEmbedded() error {
+	embedded, err := Parse(p.Body)
+	if err != nil {
+		return fmt.Errorf("error parsing embedded message: %w", err)
+	}
+
+	if err := embedded.DowngradeHeaders(); err != nil {
+		return err
+	}
+
+	if err := embedded.Downgrade8Bit(); err != nil {
+		return err
+	}
+
+	p.Body = embedded.Bytes()
+
+	if p.TransferEncoding() != Encoding7Bit {
+		p.Header.Set("Content-Transfer-Encoding", Encoding7Bit)
+	}
+
+	return nil
+}
+
+func downgradeFieldValue(name, value string) (string, error) {
+	if !addressFields[strings.ToLower(name)] {
+		return mime.QEncoding.Encode("utf-8", value), nil
+	}
+
+	addresses, err := mail.ParseAddressList(value)
+	if err != nil {
+		return "", fmt.Errorf("error parsing `%s` header: %w", name, err)
+	}
+
+	encoded := make([]string, 0, len(addresses))
+
+	for _, address := range addresses {
+		if address.Address, err = AddressToASCII(address.Address); err != nil {
+			return "", err
+		}
+
+		// mail.Address encodes non-ASCII display names on its own
+		encoded = append(encoded, address.String())
+	}
+
+	return strings.Join(encoded, ", "), nil
+}
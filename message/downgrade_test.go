@@ -0,0 +1,64 @@
+package message_test
+
+import (
+	"testing"
+
+	"github.com/ajgon/mailbowl/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDowngrade8Bit(t *testing.T) {
+	t.Parallel()
+
+	msg, err := message.Parse([]byte(
+		"Content-Type: multipart/mixed; boundary=b\n\n" +
+			"--b\nContent-Type: text/plain; charset=utf-8\nContent-Transfer-Encoding: 8bit\n\nzażółć\n" +
+			"--b\nContent-Type: application/octet-stream\n\n\xff\xfe\n" +
+			"--b\nContent-Type: text/plain\nContent-Transfer-Encoding: 8bit\n\nascii\n" +
+			"--b--\n",
+	))
+	assert.NoError(t, err)
+	assert.NoError(t, msg.Downgrade8Bit())
+
+	assert.Equal(t, "quoted-printable", msg.Parts[0].TransferEncoding())
+	assert.Equal(t, "za=C5=BC=C3=B3=C5=82=C4=87", string(msg.Parts[0].Body))
+	assert.Equal(t, "base64", msg.Parts[1].TransferEncoding())
+	assert.Equal(t, "//4=\n", string(msg.Parts[1].Body))
+	assert.Equal(t, "7bit", msg.Parts[2].TransferEncoding())
+	assert.False(t, message.Is8Bit(msg.Bytes()))
+}
+
+func TestDowngrade8BitWithoutMIMEHeaders(t *testing.T) {
+	t.Parallel()
+
+	msg, err := message.Parse([]byte("Subject: test\n\nżółć\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, msg.Downgrade8Bit())
+
+	assert.Equal(
+		t,
+		"Subject: test\nContent-Type: text/plain; charset=utf-8\nMIME-Version: 1.0\n"+
+			"Content-Transfer-Encoding: quoted-printable\n\n=C5=BC=C3=B3=C5=82=C4=87\n",
+		string(msg.Bytes()),
+	)
+}
+
+func TestDowngradeHeaders(t *testing.T) {
+	t.Parallel()
+
+	msg, err := message.Parse([]byte(
+		"From: Zażółć <jose@bücher.de>\nTo: plain@example.local\nSubject: Grüße\n\nbody\n",
+	))
+	assert.NoError(t, err)
+	assert.True(t, msg.HasNonASCIIHeaders())
+	assert.NoError(t, msg.DowngradeHeaders())
+	assert.False(t, msg.HasNonASCIIHeaders())
+
+	assert.Equal(t, "=?utf-8?q?Za=C5=BC=C3=B3=C5=82=C4=87?= <jose@xn--bcher-kva.de>", msg.Header.Get("From"))
+	assert.Equal(t, "plain@example.local", msg.Header.Get("To"))
+	assert.Equal(t, "=?utf-8?q?Gr=C3=BC=C3=9Fe?=", msg.Header.Get("Subject"))
+
+	msg, err = message.Parse([]byte("From: 用户@例子.广告\n\nbody\n"))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, msg.DowngradeHeaders(), message.ErrSMTPUTF8Required)
+}
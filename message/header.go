@@ -0,0 +1,203 @@
+package message
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Field is a single header field. Raw keeps the original (possibly folded) representation, including the
+// trailing line ending, so untouched fields are written back byte for byte.
+type Field struct {
+	Name string
+	Raw  []byte
+}
+
+type Header struct {
+	Fields []*Field
+	EOL    string
+}
+
+func NewField(name, value, eol string) *Field {
+	return &Field{Name: name, Raw: []byte(name + ": " + value + eol)}
+}
+
+// Value returns unfolded field value, with leading and trailing whitespace removed.
+func (f *Field) Value() string {
+	raw := string(f.Raw)
+
+	if i := strings.IndexByte(raw, ':'); i >= 0 {
+		raw = raw[i+1:]
+	}
+
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	raw = strings.ReplaceAll(raw, "\n ", " ")
+	raw = strings.ReplaceAll(raw, "\n\t", "\t")
+
+	return strings.TrimSpace(raw)
+}
+
+func (h *Header) Get(name string) string {
+	for _, field := range h.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return field.Value()
+		}
+	}
+
+	return ""
+}
+
+func (h *Header) Values(name string) []string {
+	values := make([]string, 0)
+
+	for _, field := range h.Fields {
+		if strings.EqualFold(field.Name, name) {
+			values = append(values, field.Value())
+		}
+	}
+
+	return values
+}
+
+func (h *Header) Has(name string) bool {
+	for _, field := range h.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add appends a new field at the bottom of the header.
+func (h *Header) Add(name, value string) {
+	h.Fields = append(h.Fields, NewField(name, value, h.EOL))
+}
+
+// Prepend inserts a new field at the top of the header, where trace fields belong.
+func (h *Header) Prepend(name, value string) {
+	h.Fields = append([]*Field{NewField(name, value, h.EOL)}, h.Fields...)
+}
+
+// Set replaces the first occurrence of the field and removes all the others, or adds a new one if it was missing.
+func (h *Header) Set(name, value string) {
+	fields := make([]*Field, 0, len(h.Fields))
+	replaced := false
+
+	for _, field := range h.Fields {
+		if !strings.EqualFold(field.Name, name) {
+			fields = append(fields, field)
+
+			continue
+		}
+
+		if !replaced {
+			fields = append(fields, NewField(field.Name, value, h.EOL))
+			replaced = true
+		}
+	}
+
+	h.Fields = fields
+
+	if !replaced {
+		h.Add(name, value)
+	}
+}
+
+// Del removes all occurrences of the field, returning the number of removed fields.
+func (h *Header) Del(name string) int {
+	fields := make([]*Field, 0, len(h.Fields))
+
+	for _, field := range h.Fields {
+		if !strings.EqualFold(field.Name, name) {
+			fields = append(fields, field)
+		}
+	}
+
+	removed := len(h.Fields) - len(fields)
+	h.Fields = fields
+
+	return removed
+}
+
+func (h *Header) Bytes() []byte {
+	var buffer bytes.Buffer
+
+	for _, field := range h.Fields {
+		buffer.Write(field.Raw)
+	}
+
+	return buffer.Bytes()
+}
+
+// ParseHeader splits the message into its header and body, without looking into the MIME structure.
+func ParseHeader(data []byte) (*Header, []byte, error) {
+	header, body, err := parseHeader(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+
+	return header, body, nil
+}
+
+func parseHeader(data []byte) (*Header, []byte, error) {
+	header := &Header{Fields: make([]*Field, 0), EOL: detectEOL(data)}
+	rest := data
+
+	for len(rest) > 0 {
+		line, next := splitLine(rest)
+
+		if isBlankLine(line) {
+			return header, next, nil
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(header.Fields) == 0 {
+				return nil, nil, ErrMalformedHeader
+			}
+
+			last := header.Fields[len(header.Fields)-1]
+			last.Raw = append(last.Raw, line...)
+			rest = next
+
+			continue
+		}
+
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 {
+			return nil, nil, ErrMalformedHeader
+		}
+
+		name := string(bytes.TrimRight(line[:colon], " \t"))
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return nil, nil, ErrMalformedHeader
+		}
+
+		header.Fields = append(header.Fields, &Field{Name: name, Raw: append([]byte{}, line...)})
+		rest = next
+	}
+
+	// header only message, without body
+	return header, nil, nil
+}
+
+func detectEOL(data []byte) string {
+	if i := bytes.IndexByte(data, '\n'); i > 0 && data[i-1] == '\r' {
+		return "\r\n"
+	}
+
+	return "\n"
+}
+
+// splitLine returns first line of data (including line ending) and the rest.
+func splitLine(data []byte) ([]byte, []byte) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[:i+1], data[i+1:]
+	}
+
+	return data, nil
+}
+
+func isBlankLine(line []byte) bool {
+	return len(bytes.TrimRight(line, "\r\n")) == 0 && len(line) > 0 && line[len(line)-1] == '\n'
+}
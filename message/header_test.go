@@ -0,0 +1,43 @@
+package message_test
+
+import (
+	"testing"
+
+	"github.com/ajgon/mailbowl/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderManipulation(t *testing.T) {
+	t.Parallel()
+
+	msg, err := message.Parse([]byte("Subject: Hello\n World\nX-Test: one\nx-test: two\n\nbody\n"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Hello World", msg.Header.Get("subject"))
+	assert.Equal(t, []string{"one", "two"}, msg.Header.Values("X-Test"))
+	assert.True(t, msg.Header.Has("X-TEST"))
+	assert.False(t, msg.Header.Has("Date"))
+
+	msg.Header.Set("X-Test", "three")
+	assert.Equal(t, []string{"three"}, msg.Header.Values("X-Test"))
+
+	msg.Header.Prepend("Received", "from localhost")
+	msg.Header.Add("Date", "Mon, 02 Jan 2006 15:04:05 -0700")
+
+	assert.Equal(t, 1, msg.Header.Del("subject"))
+	assert.Equal(t, 0, msg.Header.Del("subject"))
+
+	assert.Equal(
+		t, "Received: from localhost\nX-Test: three\nDate: Mon, 02 Jan 2006 15:04:05 -0700\n\nbody\n", string(msg.Bytes()),
+	)
+}
+
+func TestMalformedHeader(t *testing.T) {
+	t.Parallel()
+
+	_, err := message.Parse([]byte(" continuation without field\n\nbody"))
+	assert.ErrorIs(t, err, message.ErrMalformedHeader)
+
+	_, err = message.Parse([]byte("this is not a header\n\nbody"))
+	assert.ErrorIs(t, err, message.ErrMalformedHeader)
+}
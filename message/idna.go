@@ -0,0 +1,186 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Punycode parameters, as defined in RFC 3492 section 5.
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+
+	acePrefix      = "xn--"
+	maxLabelLength = 63
+)
+
+var ErrInvalidDomain = errors.New("invalid internationalized domain")
+
+func IsASCII(data string) bool {
+	for i := 0; i < len(data); i++ {
+		if data[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DomainToASCII converts internationalized domain name into its ASCII compatible form (A-labels). Labels are
+// lowercased, but no further UTS #46 mapping is applied, so domains should already be in their canonical form.
+func DomainToASCII(domain string) (string, error) {
+	if IsASCII(domain) {
+		return domain, nil
+	}
+
+	if !utf8.ValidString(domain) {
+		return "", fmt.Errorf("%w `%s`: not valid UTF-8", ErrInvalidDomain, domain)
+	}
+
+	labels := strings.Split(domain, ".")
+
+	for i, label := range labels {
+		if IsASCII(label) {
+			continue
+		}
+
+		labels[i] = acePrefix + punycodeEncode(strings.ToLower(label))
+
+		if len(labels[i]) > maxLabelLength {
+			return "", fmt.Errorf("%w `%s`: label too long", ErrInvalidDomain, domain)
+		}
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// AddressToASCII converts domain part of the address to A-labels. Addresses with non-ASCII local part cannot
+// be represented without SMTPUTF8, so ErrSMTPUTF8Required is returned for them.
+func AddressToASCII(address string) (string, error) {
+	if IsASCII(address) {
+		return address, nil
+	}
+
+	at := strings.LastIndex(address, "@")
+	if at < 0 || !IsASCII(address[:at]) {
+		return "", fmt.Errorf("%w: `%s`", ErrSMTPUTF8Required, address)
+	}
+
+	domain, err := DomainToASCII(address[at+1:])
+	if err != nil {
+		return "", err
+	}
+
+	return address[:at+1] + domain, nil
+}
+
+func punycodeEncode(label string) string {
+	var (
+		runes  = []rune(label)
+		output = make([]byte, 0, len(label))
+	)
+
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			output = append(output, byte(r))
+		}
+	}
+
+	basicLength := len(output)
+	handled := basicLength
+
+	if basicLength > 0 {
+		output = append(output, '-')
+	}
+
+	n, delta, bias := rune(punycodeInitialN), 0, punycodeInitialBias
+
+	for handled < len(runes) {
+		next := rune(utf8.MaxRune)
+
+		for _, r := range runes {
+			if r >= n && r < next {
+				next = r
+			}
+		}
+
+		delta += int(next-n) * (handled + 1)
+		n = next
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+
+			if r != n {
+				continue
+			}
+
+			q := delta
+
+			for k := punycodeBase; ; k += punycodeBase {
+				t := punycodeThreshold(k, bias)
+				if q < t {
+					break
+				}
+
+				output = append(output, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+
+			output = append(output, punycodeDigit(q))
+			bias = punycodeAdapt(delta, handled+1, handled == basicLength)
+			delta = 0
+			handled++
+		}
+
+		delta++
+		n++
+	}
+
+	return string(output)
+}
+
+func punycodeThreshold(k, bias int) int {
+	switch {
+	case k <= bias:
+		return punycodeTMin
+	case k >= bias+punycodeTMax:
+		return punycodeTMax
+	}
+
+	return k - bias
+}
+
+func punycodeAdapt(delta, numPoints int, firstTime bool) int {
+	if firstTime {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+
+	delta += delta / numPoints
+	k := 0
+
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
+
+func punycodeDigit(digit int) byte {
+	if digit < 26 { //nolint:gomnd
+		return byte('a' + digit)
+	}
+
+	return byte('0' + digit - 26) //nolint:gomnd
+}
@@ -0,0 +1,34 @@
+package message_test
+
+import (
+	"testing"
+
+	"github.com/ajgon/mailbowl/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainToASCII(t *testing.T) {
+	t.Parallel()
+
+	for domain, want := range map[string]string{
+		"example.local": "example.local",
+		"bücher.de":     "xn--bcher-kva.de",
+		"MÜNCHEN.de":    "xn--mnchen-3ya.de",
+		"例子.广告":         "xn--fsqu00a.xn--4rr70v",
+	} {
+		got, err := message.DomainToASCII(domain)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestAddressToASCII(t *testing.T) {
+	t.Parallel()
+
+	got, err := message.AddressToASCII("jose@bücher.de")
+	assert.NoError(t, err)
+	assert.Equal(t, "jose@xn--bcher-kva.de", got)
+
+	_, err = message.AddressToASCII("用户@例子.广告")
+	assert.ErrorIs(t, err, message.ErrSMTPUTF8Required)
+}
@@ -0,0 +1,190 @@
+package message
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxLineLength is the hard limit of RFC 5322 line length, excluding the line ending.
+	MaxLineLength = 998
+
+	foldLength = 78
+)
+
+var (
+	ErrNULCharacter   = errors.New("message contains NUL characters")
+	ErrMissingFrom    = errors.New("missing From header field")
+	ErrDuplicateField = errors.New("duplicate header field")
+)
+
+// singularFields may occur at most once in the message header (RFC 5322 section 3.6).
+var singularFields = []string{ //nolint:gochecknoglobals
+	"Date", "From", "Sender", "Reply-To", "To", "Cc", "Bcc", "Message-ID", "In-Reply-To", "References", "Subject",
+}
+
+// FixLineEndings converts bare CR and bare LF line endings to CRLF.
+func FixLineEndings(data []byte) []byte {
+	if !hasBareLineEndings(data) {
+		return data
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, len(data)+len(data)/40)) //nolint:gomnd
+
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\r':
+			buffer.WriteString("\r\n")
+
+			if i+1 < len(data) && data[i+1] == '\n' {
+				i++
+			}
+		case '\n':
+			buffer.WriteString("\r\n")
+		default:
+			buffer.WriteByte(data[i])
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+// Validate checks if the message is structurally sound: header and MIME structure can be parsed, From is present,
+// and fields allowed once are not repeated.
+func Validate(data []byte) error {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return ErrNULCharacter
+	}
+
+	part, err := Parse(data)
+	if err != nil {
+		return err
+	}
+
+	if !part.Header.Has("From") {
+		return ErrMissingFrom
+	}
+
+	for _, name := range singularFields {
+		if len(part.Header.Values(name)) > 1 {
+			return fmt.Errorf("%w: %s", ErrDuplicateField, name)
+		}
+	}
+
+	return nil
+}
+
+// NewMessageID generates a unique Message-ID for given host, including angle brackets.
+func NewMessageID(hostname string) string {
+	buffer := make([]byte, 8) //nolint:gomnd
+	_, _ = rand.Read(buffer)
+
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(buffer), hostname)
+}
+
+// FormatDate formats the time as RFC 5322 date-time.
+func FormatDate(date time.Time) string {
+	return date.Format(time.RFC1123Z)
+}
+
+// Fold breaks header lines longer than MaxLineLength at whitespace, into lines of about 78 characters. Lines without
+// whitespace can't be folded and are left untouched. Returns true, if any field was changed.
+func (h *Header) Fold() bool {
+	folded := false
+
+	for _, field := range h.Fields {
+		raw, changed := foldField(string(field.Raw), h.EOL)
+		if changed {
+			field.Raw = []byte(raw)
+			folded = true
+		}
+	}
+
+	return folded
+}
+
+func foldField(raw, eol string) (string, bool) {
+	lines := strings.SplitAfter(raw, "\n")
+	changed := false
+
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		if len(content) <= MaxLineLength {
+			continue
+		}
+
+		if folded := foldLine(content, eol); folded != content {
+			lines[i] = folded + line[len(content):]
+			changed = true
+		}
+	}
+
+	return strings.Join(lines, ""), changed
+}
+
+func foldLine(line, eol string) string {
+	var builder strings.Builder
+
+	for len(line) > foldLength {
+		point := foldPoint(line)
+		if point < 0 {
+			break
+		}
+
+		builder.WriteString(line[:point])
+		builder.WriteString(eol)
+		line = line[point:]
+	}
+
+	builder.WriteString(line)
+
+	return builder.String()
+}
+
+// foldPoint returns position of the whitespace, where the line should be broken: the last one within fold length,
+// or the first one after it. Lines are never broken into whitespace only lines. Returns -1 if there is none.
+func foldPoint(line string) int {
+	first := strings.IndexFunc(line, isNotWhitespace)
+	last := strings.LastIndexFunc(line, isNotWhitespace)
+	point := -1
+
+	for i := first + 1; i < last; i++ {
+		if line[i] != ' ' && line[i] != '\t' {
+			continue
+		}
+
+		if i > foldLength && point > 0 {
+			break
+		}
+
+		point = i
+
+		if i > foldLength {
+			break
+		}
+	}
+
+	return point
+}
+
+func isNotWhitespace(r rune) bool {
+	return r != ' ' && r != '\t'
+}
+
+func hasBareLineEndings(data []byte) bool {
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] == '\n' && (i == 0 || data[i-1] != '\r'):
+			return true
+		case data[i] == '\r' && (i+1 == len(data) || data[i+1] != '\n'):
+			return true
+		}
+	}
+
+	return false
+}
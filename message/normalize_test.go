@@ -0,0 +1,89 @@
+package message_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixLineEndings(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(
+		t, "Received: x\r\nSubject: a\r\nFrom: b\r\n\r\nline\r\nline\r\n",
+		string(message.FixLineEndings([]byte("Received: x\r\nSubject: a\nFrom: b\r\n\nline\rline\n"))),
+	)
+
+	data := []byte("Subject: a\r\n\r\nbody\r\n")
+	assert.Equal(t, data, message.FixLineEndings(data))
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, message.Validate([]byte("From: a@example.local\r\nSubject: test\r\n\r\nbody\r\n")))
+
+	err := message.Validate([]byte("Subject: test\r\n\r\nbody\r\n"))
+	assert.ErrorIs(t, err, message.ErrMissingFrom)
+
+	err = message.Validate([]byte("From: a@example.local\r\nSubject: one\r\nsubject: two\r\n\r\nbody\r\n"))
+	assert.ErrorIs(t, err, message.ErrDuplicateField)
+	assert.EqualError(t, err, "duplicate header field: Subject")
+
+	err = message.Validate([]byte("From: a@example.local\r\n\r\nbo\x00dy\r\n"))
+	assert.ErrorIs(t, err, message.ErrNULCharacter)
+
+	err = message.Validate([]byte("this is not a header\r\n\r\nbody\r\n"))
+	assert.ErrorIs(t, err, message.ErrMalformedHeader)
+
+	err = message.Validate([]byte(
+		"From: a@example.local\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\nno delimiter\r\n",
+	))
+	assert.ErrorIs(t, err, message.ErrMalformedMultipart)
+}
+
+func TestFold(t *testing.T) {
+	t.Parallel()
+
+	long := "Subject:" + strings.Repeat(" word", 250)
+	header, _, err := message.ParseHeader([]byte(long + "\r\nX-Short: short\r\n\r\nbody\r\n"))
+	assert.NoError(t, err)
+
+	assert.True(t, header.Fold())
+
+	lines := strings.Split(strings.TrimSuffix(string(header.Fields[0].Raw), "\r\n"), "\r\n")
+	assert.Greater(t, len(lines), 10)
+
+	for i, line := range lines {
+		assert.LessOrEqual(t, len(line), 78)
+		assert.NotEmpty(t, strings.TrimSpace(line))
+
+		if i > 0 {
+			assert.Equal(t, " ", line[:1])
+		}
+	}
+
+	assert.Equal(t, strings.TrimPrefix(long, "Subject: "), header.Get("Subject"))
+	assert.Equal(t, "X-Short: short\r\n", string(header.Fields[1].Raw))
+	assert.False(t, header.Fold())
+
+	// no whitespace to fold at
+	unfoldable := "X-Token:" + strings.Repeat("a", 1200) + "\r\n"
+	header, _, err = message.ParseHeader([]byte(unfoldable + "\r\n"))
+	assert.NoError(t, err)
+	assert.False(t, header.Fold())
+	assert.Equal(t, unfoldable, string(header.Fields[0].Raw))
+}
+
+func TestNewMessageIDAndDate(t *testing.T) {
+	t.Parallel()
+
+	assert.Regexp(t, `^<\d+\.[0-9a-f]{16}@example\.local>$`, message.NewMessageID("example.local"))
+	assert.NotEqual(t, message.NewMessageID("example.local"), message.NewMessageID("example.local"))
+
+	date := time.Date(2022, 3, 4, 5, 6, 7, 0, time.FixedZone("", 3600))
+	assert.Equal(t, "Fri, 04 Mar 2022 05:06:07 +0100", message.FormatDate(date))
+}
@@ -0,0 +1,426 @@
+package message
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+)
+
+const (
+	EncodingBase64          = "base64"
+	EncodingQuotedPrintable = "quoted-printable"
+	Encoding7Bit            = "7bit"
+	Encoding8Bit            = "8bit"
+	EncodingBinary          = "binary"
+
+	base64LineLength = 76
+)
+
+var (
+	ErrMalformedHeader    = errors.New("malformed message header")
+	ErrMalformedMultipart = errors.New("malformed multipart body")
+)
+
+// Part is a single MIME entity. The whole message is a Part as well. For multipart entities Body is nil and the
+// content is kept in Parts, together with Preamble and Epilogue.
+type Part struct {
+	Header *Header
+	Body   []byte
+
+	Boundary string
+	Preamble []byte
+	Parts    []*Part
+	Epilogue []byte
+
+	separator []byte
+	eol       string
+}
+
+func Parse(data []byte) (*Part, error) {
+	header, body, err := parseHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	part := &Part{Header: header, Body: body, eol: header.EOL}
+
+	if body != nil {
+		headerLength := len(header.Bytes())
+		part.separator = data[headerLength : len(data)-len(body)]
+	}
+
+	mediaType, params := part.MediaType()
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		if err := part.parseMultipart(params["boundary"]); err != nil {
+			return nil, err
+		}
+	}
+
+	return part, nil
+}
+
+// NewPart builds a new leaf part with given content type, encoding the content with given transfer encoding.
+func NewPart(contentType string, content []byte, encoding, eol string) *Part {
+	part := &Part{Header: &Header{Fields: make([]*Field, 0), EOL: eol}, eol: eol}
+	part.Header.Add("Content-Type", contentType)
+	part.SetContent(content, encoding)
+
+	return part
+}
+
+// NewMultipart builds a new multipart entity of given media type (i.e. multipart/mixed), with a random boundary.
+func NewMultipart(mediaType string, params map[string]string, parts []*Part, eol string) *Part {
+	boundary := randomBoundary()
+
+	if params == nil {
+		params = make(map[string]string)
+	}
+
+	params["boundary"] = boundary
+
+	part := &Part{
+		Header:    &Header{Fields: make([]*Field, 0), EOL: eol},
+		Boundary:  boundary,
+		Preamble:  []byte{},
+		Parts:     parts,
+		separator: []byte(eol),
+		eol:       eol,
+	}
+	part.Header.Add("Content-Type", mime.FormatMediaType(mediaType, params))
+
+	return part
+}
+
+func (p *Part) IsMultipart() bool {
+	return p.Boundary != ""
+}
+
+// MediaType returns lowercased media type of the part and its parameters. Parts without (or with broken)
+// Content-Type are treated as text/plain, as RFC 2045 says.
+func (p *Part) MediaType() (string, map[string]string) {
+	contentType := p.Header.Get("Content-Type")
+	if contentType == "" {
+		return "text/plain", map[string]string{"charset": "us-ascii"}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])) //nolint:gomnd
+
+		return mediaType, map[string]string{}
+	}
+
+	return mediaType, params
+}
+
+// Filename returns name of the attached file, taken from Content-Disposition or Content-Type name parameter.
+func (p *Part) Filename() string {
+	var filename string
+
+	if disposition := p.Header.Get("Content-Disposition"); disposition != "" {
+		if _, params, err := mime.ParseMediaType(disposition); err == nil {
+			filename = params["filename"]
+		}
+	}
+
+	if filename == "" {
+		_, params := p.MediaType()
+		filename = params["name"]
+	}
+
+	decoder := new(mime.WordDecoder)
+	if decoded, err := decoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	return filename
+}
+
+// IsAttachment returns true for parts explicitly marked as attachments, or carrying a file name.
+func (p *Part) IsAttachment() bool {
+	if p.IsMultipart() {
+		return false
+	}
+
+	disposition, _, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+
+	return disposition == "attachment" || p.Filename() != ""
+}
+
+func (p *Part) TransferEncoding() string {
+	encoding := strings.ToLower(p.Header.Get("Content-Transfer-Encoding"))
+	if encoding == "" {
+		return Encoding7Bit
+	}
+
+	return encoding
+}
+
+// Content returns body of the part with transfer encoding removed.
+func (p *Part) Content() ([]byte, error) {
+	switch p.TransferEncoding() {
+	case EncodingBase64:
+		content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, &whitespaceStripper{data: p.Body}))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding base64 content: %w", err)
+		}
+
+		return content, nil
+	case EncodingQuotedPrintable:
+		content, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(p.Body)))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding quoted-printable content: %w", err)
+		}
+
+		return content, nil
+	}
+
+	return p.Body, nil
+}
+
+// SetContent replaces body of the part with content encoded using given transfer encoding.
+func (p *Part) SetContent(content []byte, encoding string) {
+	switch encoding {
+	case EncodingBase64:
+		p.Body = encodeBase64(content, p.eol)
+	case EncodingQuotedPrintable:
+		p.Body = encodeQuotedPrintable(content, p.eol)
+	default:
+		p.Body = content
+	}
+
+	p.Header.Set("Content-Transfer-Encoding", encoding)
+
+	if p.separator == nil {
+		p.separator = []byte(p.eol)
+	}
+}
+
+// Replace swaps content of the part (Content-* header fields and the body) with content of another part, leaving
+// remaining header fields intact.
+func (p *Part) Replace(other *Part) {
+	fields := make([]*Field, 0, len(p.Header.Fields)+len(other.Header.Fields))
+
+	for _, field := range p.Header.Fields {
+		if !strings.HasPrefix(strings.ToLower(field.Name), "content-") {
+			fields = append(fields, field)
+		}
+	}
+
+	p.Header.Fields = append(fields, other.Header.Fields...)
+	p.Body = other.Body
+	p.Boundary = other.Boundary
+	p.Preamble = other.Preamble
+	p.Parts = other.Parts
+	p.Epilogue = other.Epilogue
+
+	if p.separator == nil {
+		p.separator = other.separator
+	}
+}
+
+// Walk calls fn for the part and all its descendants, depth first.
+func (p *Part) Walk(fn func(part *Part) error) error {
+	if err := fn(p); err != nil {
+		return err
+	}
+
+	for _, child := range p.Parts {
+		if err := child.Walk(fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Part) EOL() string {
+	return p.eol
+}
+
+func (p *Part) Bytes() []byte {
+	var buffer bytes.Buffer
+
+	buffer.Write(p.Header.Bytes())
+
+	if !p.IsMultipart() {
+		if p.Body != nil {
+			buffer.Write(p.separator)
+			buffer.Write(p.Body)
+		}
+
+		return buffer.Bytes()
+	}
+
+	buffer.Write(p.separator)
+	buffer.Write(p.Preamble)
+
+	for _, child := range p.Parts {
+		buffer.WriteString("--" + p.Boundary + p.eol)
+		buffer.Write(child.Bytes())
+		buffer.WriteString(p.eol)
+	}
+
+	buffer.WriteString("--" + p.Boundary + "--")
+
+	if p.Epilogue == nil {
+		buffer.WriteString(p.eol)
+	} else {
+		buffer.Write(p.Epilogue)
+	}
+
+	return buffer.Bytes()
+}
+
+func (p *Part) parseMultipart(boundary string) error {
+	var (
+		delimiter    = "--" + boundary
+		offset       = 0
+		contentStart = -1
+		found        = false
+	)
+
+	if p.separator == nil {
+		p.separator = []byte(p.eol)
+	}
+
+	p.Boundary = boundary
+	p.Parts = make([]*Part, 0)
+
+	for offset < len(p.Body) {
+		line, _ := splitLine(p.Body[offset:])
+		trimmed := string(bytes.TrimRight(line, " \t\r\n"))
+		lineStart := offset
+		offset += len(line)
+
+		if trimmed != delimiter && trimmed != delimiter+"--" {
+			continue
+		}
+
+		if !found {
+			p.Preamble = p.Body[:lineStart]
+			found = true
+		} else if err := p.appendPart(p.Body[contentStart:trimEOL(p.Body, lineStart)]); err != nil {
+			return err
+		}
+
+		if trimmed == delimiter+"--" {
+			p.Epilogue = p.Body[lineStart+len(trimmed):]
+			p.Body = nil
+
+			return nil
+		}
+
+		contentStart = offset
+	}
+
+	if !found {
+		p.Boundary = ""
+		p.Parts = nil
+
+		return ErrMalformedMultipart
+	}
+
+	// missing closing delimiter, treat the rest of the body as the last part
+	if err := p.appendPart(p.Body[contentStart:]); err != nil {
+		return err
+	}
+
+	p.Body = nil
+
+	return nil
+}
+
+func (p *Part) appendPart(data []byte) error {
+	child, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("error parsing body part: %w", err)
+	}
+
+	if len(child.Header.Fields) == 0 {
+		child.Header.EOL = p.eol
+		child.eol = p.eol
+	}
+
+	p.Parts = append(p.Parts, child)
+
+	return nil
+}
+
+// trimEOL returns position of the line ending preceding offset, as the line ending before delimiter belongs
+// to the delimiter, not to the part content.
+func trimEOL(data []byte, offset int) int {
+	if offset > 0 && data[offset-1] == '\n' {
+		offset--
+
+		if offset > 0 && data[offset-1] == '\r' {
+			offset--
+		}
+	}
+
+	return offset
+}
+
+func randomBoundary() string {
+	buffer := make([]byte, 24) //nolint:gomnd
+	_, _ = rand.Read(buffer)
+
+	return hex.EncodeToString(buffer)
+}
+
+func encodeBase64(content []byte, eol string) []byte {
+	var buffer bytes.Buffer
+
+	encoded := base64.StdEncoding.EncodeToString(content)
+
+	for len(encoded) > base64LineLength {
+		buffer.WriteString(encoded[:base64LineLength] + eol)
+		encoded = encoded[base64LineLength:]
+	}
+
+	if encoded != "" {
+		buffer.WriteString(encoded + eol)
+	}
+
+	return buffer.Bytes()
+}
+
+func encodeQuotedPrintable(content []byte, eol string) []byte {
+	var buffer bytes.Buffer
+
+	writer := quotedprintable.NewWriter(&buffer)
+	_, _ = writer.Write(content)
+	_ = writer.Close()
+
+	return bytes.ReplaceAll(buffer.Bytes(), []byte("\r\n"), []byte(eol))
+}
+
+type whitespaceStripper struct {
+	data []byte
+}
+
+func (ws *whitespaceStripper) Read(buffer []byte) (int, error) {
+	written := 0
+
+	for written < len(buffer) && len(ws.data) > 0 {
+		if c := ws.data[0]; c != '\r' && c != '\n' && c != ' ' && c != '\t' {
+			buffer[written] = c
+			written++
+		}
+
+		ws.data = ws.data[1:]
+	}
+
+	if written == 0 && len(ws.data) == 0 {
+		return 0, io.EOF
+	}
+
+	return written, nil
+}
@@ -0,0 +1,146 @@
+package message_test
+
+import (
+	"testing"
+
+	"github.com/ajgon/mailbowl/message"
+	"github.com/stretchr/testify/assert"
+)
+
+const multipartExample = "From: sender@example.local\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"This is a preamble\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"plain text\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>html</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"invoice.pdf\"\r\n" +
+	"Content-Disposition: attachment\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"aGVsbG8g\r\n" +
+	"d29ybGQ=\r\n" +
+	"--outer--\r\n" +
+	"epilogue\r\n"
+
+func TestParseMultipart(t *testing.T) {
+	t.Parallel()
+
+	msg, err := message.Parse([]byte(multipartExample))
+	assert.NoError(t, err)
+
+	assert.True(t, msg.IsMultipart())
+	assert.Equal(t, "outer", msg.Boundary)
+	assert.Equal(t, "This is a preamble\r\n", string(msg.Preamble))
+	assert.Len(t, msg.Parts, 2)
+	assert.Len(t, msg.Parts[0].Parts, 2)
+	assert.Equal(t, "plain text", string(msg.Parts[0].Parts[0].Body))
+	assert.Equal(t, "<p>html</p>", string(msg.Parts[0].Parts[1].Body))
+
+	attachment := msg.Parts[1]
+	mediaType, _ := attachment.MediaType()
+
+	assert.Equal(t, "application/pdf", mediaType)
+	assert.Equal(t, "invoice.pdf", attachment.Filename())
+	assert.True(t, attachment.IsAttachment())
+	assert.False(t, msg.Parts[0].Parts[0].IsAttachment())
+
+	content, err := attachment.Content()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+
+	assert.Equal(t, multipartExample, string(msg.Bytes()))
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	msg, err := message.Parse([]byte(multipartExample))
+	assert.NoError(t, err)
+
+	mediaTypes := make([]string, 0)
+	err = msg.Walk(func(part *message.Part) error {
+		mediaType, _ := part.MediaType()
+		mediaTypes = append(mediaTypes, mediaType)
+
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(
+		t, []string{"multipart/mixed", "multipart/alternative", "text/plain", "text/html", "application/pdf"}, mediaTypes,
+	)
+}
+
+func TestSetContent(t *testing.T) {
+	t.Parallel()
+
+	msg, err := message.Parse([]byte("Subject: test\n\nżółć\n"))
+	assert.NoError(t, err)
+
+	msg.SetContent([]byte("żółć\n"), message.EncodingBase64)
+	assert.Equal(t, "Subject: test\nContent-Transfer-Encoding: base64\n\nxbzDs8WCxIcK\n", string(msg.Bytes()))
+
+	content, err := msg.Content()
+	assert.NoError(t, err)
+	assert.Equal(t, "żółć\n", string(content))
+
+	part := message.NewPart("text/plain; charset=utf-8", []byte("zażółć gęślą jaźń"), message.EncodingQuotedPrintable, "\n")
+	assert.Equal(
+		t,
+		"Content-Type: text/plain; charset=utf-8\nContent-Transfer-Encoding: quoted-printable\n\n"+
+			"za=C5=BC=C3=B3=C5=82=C4=87 g=C4=99=C5=9Bl=C4=85 ja=C5=BA=C5=84",
+		string(part.Bytes()),
+	)
+}
+
+func TestMissingBoundary(t *testing.T) {
+	t.Parallel()
+
+	_, err := message.Parse([]byte("Content-Type: multipart/mixed; boundary=missing\n\nno parts here\n"))
+	assert.ErrorIs(t, err, message.ErrMalformedMultipart)
+}
+
+func TestNewMultipart(t *testing.T) {
+	t.Parallel()
+
+	multipart := message.NewMultipart("multipart/mixed", nil, []*message.Part{
+		message.NewPart("text/plain", []byte("first\n"), message.Encoding7Bit, "\r\n"),
+		message.NewPart("text/plain", []byte("second\n"), message.Encoding7Bit, "\r\n"),
+	}, "\r\n")
+
+	parsed, err := message.Parse(multipart.Bytes())
+	assert.NoError(t, err)
+
+	mediaType, params := parsed.MediaType()
+	assert.Equal(t, "multipart/mixed", mediaType)
+	assert.Equal(t, multipart.Boundary, params["boundary"])
+	assert.Len(t, parsed.Parts, 2)
+	assert.Equal(t, "second\n", string(parsed.Parts[1].Body))
+	assert.Equal(t, multipart.Bytes(), parsed.Bytes())
+}
+
+func TestReplace(t *testing.T) {
+	t.Parallel()
+
+	msg, err := message.Parse([]byte(
+		"Subject: test\nContent-Type: application/pdf\nContent-Transfer-Encoding: base64\n\nJVBERi0=\n",
+	))
+	assert.NoError(t, err)
+
+	msg.Replace(message.NewPart("text/plain", []byte("removed\n"), message.Encoding7Bit, "\n"))
+	assert.Equal(
+		t, "Subject: test\nContent-Type: text/plain\nContent-Transfer-Encoding: 7bit\n\nremoved\n", string(msg.Bytes()),
+	)
+}
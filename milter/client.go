@@ -0,0 +1,461 @@
+package milter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+)
+
+type Action int
+
+const (
+	ActionContinue Action = iota
+	ActionAccept
+	ActionReject
+	ActionTempFail
+	ActionDiscard
+)
+
+const (
+	replyCodeLength = 3
+	tempFailPrefix  = '4'
+)
+
+// Response is the decision of a milter after one step of the session. Code and Message are set only, when milter
+// sent its own SMTP reply. Modifications and Quarantine are set only after end of message.
+type Response struct {
+	Action        Action
+	Code          int
+	Message       string
+	Modifications []Modification
+	Quarantine    string
+}
+
+// Client connects to a single milter. A new Session has to be opened for each SMTP connection.
+type Client struct {
+	Name     string
+	Network  string
+	Address  string
+	Timeout  time.Duration
+	FailOpen bool
+}
+
+// Session is a single milter conversation, following one SMTP connection.
+type Session struct {
+	Name string
+
+	connection    net.Conn
+	timeout       time.Duration
+	actions       uint32
+	protocol      uint32
+	inTransaction bool
+}
+
+func NewClient(conf config.MilterServer) *Client {
+	return &Client{
+		Name:     conf.Name,
+		Network:  conf.Network,
+		Address:  conf.Address,
+		Timeout:  conf.Timeout,
+		FailOpen: conf.FailOpen,
+	}
+}
+
+// Open connects to the milter and negotiates protocol options.
+func (c *Client) Open(ctx context.Context) (*Session, error) {
+	dialer := net.Dialer{Timeout: c.Timeout}
+
+	connection, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	session := &Session{Name: c.Name, connection: connection, timeout: c.Timeout}
+
+	if err = session.negotiate(); err != nil {
+		connection.Close()
+
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (s *Session) negotiate() error {
+	s.deadline()
+
+	err := writePacket(
+		s.connection, cmdOptNeg,
+		uint32Bytes(protocolVersion), uint32Bytes(supportedActions), uint32Bytes(supportedProtocol),
+	)
+	if err != nil {
+		return err
+	}
+
+	reply, err := readPacket(s.connection)
+	if err != nil {
+		return err
+	}
+
+	if reply.code != respOptNeg || len(reply.data) < optNegLength {
+		return fmt.Errorf("%w: unexpected option negotiation reply `%c`", ErrProtocol, reply.code)
+	}
+
+	version := binary.BigEndian.Uint32(reply.data[0:4])
+	if version < 2 { //nolint:gomnd
+		return fmt.Errorf("%w: unsupported version %d", ErrProtocol, version)
+	}
+
+	s.actions = binary.BigEndian.Uint32(reply.data[4:8]) & supportedActions
+	s.protocol = binary.BigEndian.Uint32(reply.data[8:12]) & supportedProtocol
+
+	return nil
+}
+
+// Connect passes client hostname and address.
+func (s *Session) Connect(hostname string, addr net.Addr, macros map[string]string) (*Response, error) {
+	var data []byte
+
+	switch address := addr.(type) {
+	case *net.TCPAddr:
+		family := byte('4')
+		if address.IP.To4() == nil {
+			family = '6'
+		}
+
+		port := make([]byte, 2) //nolint:gomnd
+		binary.BigEndian.PutUint16(port, uint16(address.Port))
+
+		data = append(append(append(cString(hostname), family), port...), cString(address.IP.String())...)
+	case *net.UnixAddr:
+		data = append(append(cString(hostname), 'L', 0, 0), cString(address.Name)...)
+	default:
+		data = append(cString(hostname), 'U')
+	}
+
+	return s.call(cmdConnect, protocolNoConnect, protocolNoReplyConn, macros, data)
+}
+
+func (s *Session) Helo(name string, macros map[string]string) (*Response, error) {
+	return s.call(cmdHelo, protocolNoHelo, protocolNoReplyHelo, macros, cString(name))
+}
+
+// Mail starts a new transaction, aborting previous one, if it wasn't finished.
+func (s *Session) Mail(sender string, macros map[string]string) (*Response, error) {
+	if s.inTransaction {
+		if err := s.Abort(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.inTransaction = true
+
+	return s.call(cmdMail, protocolNoMail, protocolNoReplyMail, macros, cString("<"+sender+">"))
+}
+
+func (s *Session) Rcpt(recipient string, macros map[string]string) (*Response, error) {
+	return s.call(cmdRcpt, protocolNoRcpt, protocolNoReplyRcpt, macros, cString("<"+recipient+">"))
+}
+
+// Message passes message header and body, finishing with end of message. Returned response carries all
+// modifications requested by the milter.
+func (s *Session) Message(data []byte, macros map[string]string) (*Response, error) {
+	header, body, err := message.ParseHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	response, err := s.call(cmdData, protocolNoData, protocolNoReplyData, nil)
+	if err != nil || response.Action != ActionContinue {
+		return response, err
+	}
+
+	for _, field := range header.Fields {
+		value := cString(field.Name, s.headerValue(field))
+
+		response, err = s.call(cmdHeader, protocolNoHeaders, protocolNoReplyHeader, nil, value)
+		if err != nil || response.Action != ActionContinue {
+			return response, err
+		}
+	}
+
+	response, err = s.call(cmdEOH, protocolNoEOH, protocolNoReplyEOH, nil)
+	if err != nil || response.Action != ActionContinue {
+		return response, err
+	}
+
+	if response, err = s.body(canonicalBody(body)); err != nil || response.Action != ActionContinue {
+		return response, err
+	}
+
+	return s.endOfMessage(macros)
+}
+
+// Abort ends current transaction, milter stays connected for the next one.
+func (s *Session) Abort() error {
+	s.inTransaction = false
+	s.deadline()
+
+	return writePacket(s.connection, cmdAbort)
+}
+
+// Close ends the session, closing the connection.
+func (s *Session) Close() error {
+	s.deadline()
+
+	_ = writePacket(s.connection, cmdQuit)
+
+	if err := s.connection.Close(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+func (s *Session) body(body []byte) (*Response, error) {
+	for offset := 0; offset < len(body); offset += maxBodyChunk {
+		end := offset + maxBodyChunk
+		if end > len(body) {
+			end = len(body)
+		}
+
+		response, err := s.step(cmdBody, protocolNoBody, protocolNoReplyBody, nil, body[offset:end])
+		if err != nil {
+			return nil, err
+		}
+
+		if response.skip {
+			break
+		}
+
+		if response.Action != ActionContinue {
+			return &response.Response, nil
+		}
+	}
+
+	return &Response{Action: ActionContinue}, nil
+}
+
+func (s *Session) endOfMessage(macros map[string]string) (*Response, error) {
+	if err := s.send(cmdBodyEOB, macros); err != nil {
+		return nil, err
+	}
+
+	s.inTransaction = false
+	modifications := make([]Modification, 0)
+	quarantine := ""
+
+	for {
+		reply, err := s.read()
+		if err != nil {
+			return nil, err
+		}
+
+		if reply.code == respQuarantine {
+			if s.actions&actionQuarantine == 0 {
+				return nil, fmt.Errorf("%w: `%c`", ErrUnsupportedStep, reply.code)
+			}
+
+			quarantine = strings.Join(splitCStrings(reply.data), " ")
+
+			continue
+		}
+
+		if modification, ok, err := s.parseModification(reply); ok || err != nil {
+			if err != nil {
+				return nil, err
+			}
+
+			modifications = appendModification(modifications, modification)
+
+			continue
+		}
+
+		response, err := parseResponse(reply)
+		if err != nil {
+			return nil, err
+		}
+
+		response.Modifications = modifications
+		response.Quarantine = quarantine
+
+		return &response.Response, nil
+	}
+}
+
+// appendModification adds modification to the list, joining body replacements, as long bodies are sent in chunks.
+func appendModification(modifications []Modification, modification *Modification) []Modification {
+	if modification.Type == ReplaceBody {
+		for i := range modifications {
+			if modifications[i].Type == ReplaceBody {
+				modifications[i].Body = append(modifications[i].Body, modification.Body...)
+
+				return modifications
+			}
+		}
+	}
+
+	return append(modifications, *modification)
+}
+
+// stepResponse is a response, which can also ask for skipping the rest of the body.
+type stepResponse struct {
+	Response
+	skip bool
+}
+
+func (s *Session) call(code byte, noSend, noReply uint32, macros map[string]string, data ...[]byte) (*Response, error) {
+	response, err := s.step(code, noSend, noReply, macros, data...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response.Response, nil
+}
+
+// step sends a single command, unless milter asked to skip it, and waits for the reply, unless milter asked not
+// to send one.
+func (s *Session) step(
+	code byte, noSend, noReply uint32, macros map[string]string, data ...[]byte,
+) (*stepResponse, error) {
+	if s.protocol&noSend != 0 {
+		return &stepResponse{Response: Response{Action: ActionContinue}}, nil
+	}
+
+	if err := s.send(code, macros, data...); err != nil {
+		return nil, err
+	}
+
+	if s.protocol&noReply != 0 {
+		return &stepResponse{Response: Response{Action: ActionContinue}}, nil
+	}
+
+	reply, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseResponse(reply)
+}
+
+func (s *Session) send(code byte, macros map[string]string, data ...[]byte) error {
+	s.deadline()
+
+	if len(macros) > 0 {
+		names := make([]string, 0, len(macros))
+		for name := range macros {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		values := make([]string, 0, len(macros)*2) //nolint:gomnd
+		for _, name := range names {
+			values = append(values, name, macros[name])
+		}
+
+		if err := writePacket(s.connection, cmdMacro, []byte{code}, cString(values...)); err != nil {
+			return err
+		}
+	}
+
+	return writePacket(s.connection, code, data...)
+}
+
+// read returns next reply, skipping progress notifications.
+func (s *Session) read() (*packet, error) {
+	for {
+		reply, err := readPacket(s.connection)
+		if err != nil {
+			return nil, err
+		}
+
+		if reply.code != respProgress {
+			return reply, nil
+		}
+
+		s.deadline()
+	}
+}
+
+func (s *Session) deadline() {
+	if s.timeout > 0 {
+		_ = s.connection.SetDeadline(time.Now().Add(s.timeout))
+	}
+}
+
+// headerValue returns value as it was sent by the client, with folding preserved, and leading space only if milter
+// asked for it.
+func (s *Session) headerValue(field *message.Field) string {
+	raw := field.Raw[bytes.IndexByte(field.Raw, ':')+1:]
+	value := strings.TrimRight(strings.ReplaceAll(string(raw), "\r\n", "\n"), "\n")
+
+	if s.protocol&protocolHeaderLeadSpc == 0 {
+		value = strings.TrimLeft(value, " \t")
+	}
+
+	return value
+}
+
+func parseResponse(reply *packet) (*stepResponse, error) {
+	switch reply.code {
+	case respContinue:
+		return &stepResponse{Response: Response{Action: ActionContinue}}, nil
+	case respAccept:
+		return &stepResponse{Response: Response{Action: ActionAccept}}, nil
+	case respReject:
+		return &stepResponse{Response: Response{Action: ActionReject}}, nil
+	case respTempFail:
+		return &stepResponse{Response: Response{Action: ActionTempFail}}, nil
+	case respDiscard:
+		return &stepResponse{Response: Response{Action: ActionDiscard}}, nil
+	case respSkip:
+		return &stepResponse{Response: Response{Action: ActionContinue}, skip: true}, nil
+	case respReplyCode:
+		return parseReplyCode(string(bytes.TrimRight(reply.data, "\x00")))
+	}
+
+	return nil, fmt.Errorf("%w: unexpected reply `%c`", ErrProtocol, reply.code)
+}
+
+// parseReplyCode handles custom SMTP replies, like `550 5.7.1 Message rejected`. Multiline replies are joined.
+func parseReplyCode(reply string) (*stepResponse, error) {
+	if len(reply) < replyCodeLength {
+		return nil, fmt.Errorf("%w: invalid reply code `%s`", ErrProtocol, reply)
+	}
+
+	code, err := strconv.Atoi(reply[:replyCodeLength])
+	if err != nil || (reply[0] != '4' && reply[0] != '5') {
+		return nil, fmt.Errorf("%w: invalid reply code `%s`", ErrProtocol, reply)
+	}
+
+	lines := strings.Split(reply, "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimLeft(strings.TrimPrefix(line, reply[:replyCodeLength]), " -")
+	}
+
+	action := ActionReject
+	if reply[0] == tempFailPrefix {
+		action = ActionTempFail
+	}
+
+	return &stepResponse{Response: Response{Action: action, Code: code, Message: strings.Join(lines, " ")}}, nil
+}
+
+// canonicalBody converts body line endings to CRLF, as expected by milters.
+func canonicalBody(body []byte) []byte {
+	if bytes.Contains(body, []byte("\r\n")) || !bytes.Contains(body, []byte("\n")) {
+		return body
+	}
+
+	return bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n"))
+}
@@ -0,0 +1,308 @@
+package milter_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/milter"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePacket struct {
+	code byte
+	data []byte
+}
+
+// fakeMilter speaks milter protocol, recording all commands and replying with whatever respond returns.
+type fakeMilter struct {
+	protocol uint32
+	respond  func(code byte, data []byte) []fakePacket
+
+	mutex    sync.Mutex
+	commands []fakePacket
+}
+
+func (f *fakeMilter) start(t *testing.T) *milter.Client {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go f.handle(connection)
+		}
+	}()
+
+	return milter.NewClient(config.MilterServer{
+		Name: "fake", Network: "tcp", Address: listener.Addr().String(), Timeout: 5 * time.Second,
+	})
+}
+
+func (f *fakeMilter) handle(connection net.Conn) {
+	defer connection.Close()
+
+	for {
+		var length uint32
+
+		if err := binary.Read(connection, binary.BigEndian, &length); err != nil {
+			return
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(connection, data); err != nil {
+			return
+		}
+
+		f.mutex.Lock()
+		f.commands = append(f.commands, fakePacket{code: data[0], data: data[1:]})
+		f.mutex.Unlock()
+
+		switch data[0] {
+		case 'O':
+			options := make([]byte, 12)
+			binary.BigEndian.PutUint32(options[0:4], 6)
+			binary.BigEndian.PutUint32(options[4:8], 0x1ff)
+			binary.BigEndian.PutUint32(options[8:12], f.protocol)
+			writeFakePacket(connection, 'O', options)
+		case 'D', 'A':
+		case 'Q':
+			return
+		default:
+			replies := []fakePacket{{code: 'c'}}
+			if f.respond != nil {
+				replies = f.respond(data[0], data[1:])
+			}
+
+			for _, reply := range replies {
+				writeFakePacket(connection, reply.code, reply.data)
+			}
+		}
+	}
+}
+
+func (f *fakeMilter) received() []fakePacket {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]fakePacket{}, f.commands...)
+}
+
+func (f *fakeMilter) codes() string {
+	codes := ""
+	for _, command := range f.received() {
+		codes += string(command.code)
+	}
+
+	return codes
+}
+
+func writeFakePacket(writer io.Writer, code byte, data []byte) {
+	packet := make([]byte, 5)
+	binary.BigEndian.PutUint32(packet, uint32(len(data)+1))
+	packet[4] = code
+
+	_, _ = writer.Write(append(packet, data...))
+}
+
+func indexed(index uint32, values ...string) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, index)
+
+	for _, value := range values {
+		data = append(append(data, value...), 0)
+	}
+
+	return data
+}
+
+func TestSessionFullTransaction(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeMilter{}
+	client := fake.start(t)
+
+	session, err := client.Open(context.Background())
+	assert.NoError(t, err)
+
+	response, err := session.Connect("[192.0.2.1]", &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2525}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, milter.ActionContinue, response.Action)
+
+	_, err = session.Helo("client.local", nil)
+	assert.NoError(t, err)
+
+	_, err = session.Mail("sender@example.local", map[string]string{"{auth_authen}": "app@example.local"})
+	assert.NoError(t, err)
+
+	_, err = session.Rcpt("rcpt@example.local", nil)
+	assert.NoError(t, err)
+
+	response, err = session.Message([]byte("Subject:  test\r\nX-Folded: a\r\n b\r\n\r\nbody\r\n"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, milter.ActionContinue, response.Action)
+	assert.Empty(t, response.Modifications)
+
+	assert.NoError(t, session.Close())
+
+	time.Sleep(50 * time.Millisecond)
+
+	commands := fake.received()
+	assert.Equal(t, "OCHDMRTLLNBEQ", fake.codes())
+	assert.Equal(t, []byte("[192.0.2.1]\x004\x09\xdd192.0.2.1\x00"), commands[1].data)
+	assert.Equal(t, []byte("M{auth_authen}\x00app@example.local\x00"), commands[3].data)
+	assert.Equal(t, []byte("<sender@example.local>\x00"), commands[4].data)
+	assert.Equal(t, []byte("Subject\x00test\x00"), commands[7].data)
+	assert.Equal(t, []byte("X-Folded\x00a\n b\x00"), commands[8].data)
+	assert.Equal(t, []byte("body\r\n"), commands[10].data)
+}
+
+func TestSessionSkipsSteps(t *testing.T) {
+	t.Parallel()
+
+	// no connect, no helo, no reply to headers, no body
+	fake := &fakeMilter{protocol: 0x01 | 0x02 | 0x80 | 0x10}
+	client := fake.start(t)
+
+	session, err := client.Open(context.Background())
+	assert.NoError(t, err)
+
+	_, err = session.Connect("[127.0.0.1]", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1}, nil)
+	assert.NoError(t, err)
+	_, err = session.Helo("client.local", nil)
+	assert.NoError(t, err)
+	_, err = session.Mail("sender@example.local", nil)
+	assert.NoError(t, err)
+	_, err = session.Message([]byte("Subject: test\n\nbody\n"), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, session.Close())
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "OMTLNEQ", fake.codes())
+}
+
+func TestSessionRejects(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeMilter{respond: func(code byte, data []byte) []fakePacket {
+		switch {
+		case code == 'R' && bytes.Contains(data, []byte("blocked")):
+			return []fakePacket{{code: 'y', data: []byte("550 5.7.1 Recipient blocked\x00")}}
+		case code == 'R' && bytes.Contains(data, []byte("later")):
+			return []fakePacket{{code: 't'}}
+		case code == 'R':
+			return []fakePacket{{code: 'p'}, {code: 'c'}}
+		}
+
+		return []fakePacket{{code: 'c'}}
+	}}
+	client := fake.start(t)
+
+	session, err := client.Open(context.Background())
+	assert.NoError(t, err)
+
+	_, err = session.Mail("sender@example.local", nil)
+	assert.NoError(t, err)
+
+	response, err := session.Rcpt("blocked@example.local", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &milter.Response{Action: milter.ActionReject, Code: 550, Message: "5.7.1 Recipient blocked"}, response)
+
+	response, err = session.Rcpt("later@example.local", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, milter.ActionTempFail, response.Action)
+
+	response, err = session.Rcpt("ok@example.local", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, milter.ActionContinue, response.Action)
+
+	// new transaction aborts the previous one
+	_, err = session.Mail("other@example.local", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, session.Close())
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "OMRRRAMQ", fake.codes())
+}
+
+func TestSessionModifications(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeMilter{respond: func(code byte, data []byte) []fakePacket {
+		if code != 'E' {
+			return []fakePacket{{code: 'c'}}
+		}
+
+		return []fakePacket{
+			{code: 'h', data: []byte("X-Added\x00yes\x00")},
+			{code: 'i', data: indexed(0, "DKIM-Signature", "v=1; a=rsa-sha256;\n\tb=abc")},
+			{code: 'm', data: indexed(1, "Subject", "[ext] test")},
+			{code: 'm', data: indexed(1, "X-Remove", "")},
+			{code: '+', data: []byte("<added@example.local>\x00")},
+			{code: '-', data: []byte("<rcpt@example.local>\x00")},
+			{code: 'e', data: []byte("<bounce@example.local>\x00")},
+			{code: 'b', data: []byte("new ")},
+			{code: 'b', data: []byte("body\r\n")},
+			{code: 'q', data: []byte("looks suspicious\x00")},
+			{code: 'a'},
+		}
+	}}
+	client := fake.start(t)
+
+	session, err := client.Open(context.Background())
+	assert.NoError(t, err)
+
+	_, err = session.Mail("sender@example.local", nil)
+	assert.NoError(t, err)
+
+	response, err := session.Message([]byte("Subject: test\nX-Remove: me\n\nold body\n"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, milter.ActionAccept, response.Action)
+	assert.Equal(t, "looks suspicious", response.Quarantine)
+	assert.Len(t, response.Modifications, 8)
+
+	msg := &milter.Message{
+		Sender:     "sender@example.local",
+		Recipients: []string{"rcpt@example.local", "other@example.local"},
+		Data:       []byte("Subject: test\nX-Remove: me\n\nold body\n"),
+	}
+	assert.NoError(t, milter.Apply(msg, response.Modifications))
+
+	assert.Equal(t, "bounce@example.local", msg.Sender)
+	assert.Equal(t, []string{"other@example.local", "added@example.local"}, msg.Recipients)
+	assert.Equal(
+		t, "DKIM-Signature: v=1; a=rsa-sha256;\n\tb=abc\nSubject: [ext] test\nX-Added: yes\n\nnew body\n",
+		string(msg.Data),
+	)
+
+	assert.NoError(t, session.Close())
+}
+
+func TestOpenUnavailable(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	address := listener.Addr().String()
+	listener.Close()
+
+	client := milter.NewClient(config.MilterServer{Network: "tcp", Address: address, Timeout: time.Second})
+
+	_, err = client.Open(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,214 @@
+package milter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/ajgon/mailbowl/message"
+)
+
+type ModificationType int
+
+const (
+	AddHeader ModificationType = iota
+	InsertHeader
+	ChangeHeader
+	ReplaceBody
+	AddRecipient
+	DeleteRecipient
+	ChangeFrom
+)
+
+// Modification is a change of the message or its envelope, requested by milter at the end of message. Index is
+// a position in the header for InsertHeader, and 1-based occurrence of the named field for ChangeHeader.
+type Modification struct {
+	Type  ModificationType
+	Index int
+	Name  string
+	Value string
+	Body  []byte
+}
+
+// Message is what gets modified: the envelope and the message itself.
+type Message struct {
+	Sender     string
+	Recipients []string
+	Data       []byte
+}
+
+//nolint:cyclop
+func (s *Session) parseModification(reply *packet) (*Modification, bool, error) {
+	var (
+		modification *Modification
+		action       uint32
+	)
+
+	values := splitCStrings(reply.data)
+
+	switch reply.code {
+	case respAddHeader:
+		action = actionAddHeaders
+		modification = &Modification{Type: AddHeader}
+	case respInsHeader, respChgHeader:
+		if len(reply.data) < uint32Length {
+			return nil, true, fmt.Errorf("%w: truncated `%c` reply", ErrProtocol, reply.code)
+		}
+
+		action = actionAddHeaders
+		modification = &Modification{Type: InsertHeader, Index: int(binary.BigEndian.Uint32(reply.data))}
+
+		if reply.code == respChgHeader {
+			action = actionChgHeaders
+			modification.Type = ChangeHeader
+		}
+
+		values = splitCStrings(reply.data[uint32Length:])
+	case respReplBody:
+		return &Modification{Type: ReplaceBody, Body: reply.data}, true, s.allowed(actionChangeBody, reply.code)
+	case respAddRcpt, respAddRcptPar:
+		action = actionAddRcpt | actionAddRcptPar
+		modification = &Modification{Type: AddRecipient}
+	case respDelRcpt:
+		action = actionDelRcpt
+		modification = &Modification{Type: DeleteRecipient}
+	case respChgFrom:
+		action = actionChangeFrom
+		modification = &Modification{Type: ChangeFrom}
+	default:
+		return nil, false, nil
+	}
+
+	if err := s.allowed(action, reply.code); err != nil {
+		return nil, true, err
+	}
+
+	if len(values) == 0 {
+		return nil, true, fmt.Errorf("%w: empty `%c` reply", ErrProtocol, reply.code)
+	}
+
+	switch modification.Type {
+	case AddHeader, InsertHeader, ChangeHeader:
+		if len(values) < 2 { //nolint:gomnd
+			values = append(values, "")
+		}
+
+		modification.Name, modification.Value = values[0], values[1]
+	case AddRecipient, DeleteRecipient, ChangeFrom:
+		modification.Value = strings.Trim(values[0], "<>")
+	case ReplaceBody:
+	}
+
+	return modification, true, nil
+}
+
+func (s *Session) allowed(action uint32, code byte) error {
+	if s.actions&action == 0 {
+		return fmt.Errorf("%w: `%c`", ErrUnsupportedStep, code)
+	}
+
+	return nil
+}
+
+// Apply changes the message according to modifications requested by milter.
+//
+//nolint:cyclop
+func Apply(msg *Message, modifications []Modification) error {
+	if len(modifications) == 0 {
+		return nil
+	}
+
+	header, body, err := message.ParseHeader(msg.Data)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	for _, modification := range modifications {
+		switch modification.Type {
+		case AddHeader:
+			header.Add(modification.Name, eolValue(modification.Value, header.EOL))
+		case InsertHeader:
+			insertHeader(header, modification.Index, modification.Name, eolValue(modification.Value, header.EOL))
+		case ChangeHeader:
+			changeHeader(header, modification.Index, modification.Name, eolValue(modification.Value, header.EOL))
+		case ReplaceBody:
+			body = []byte(eolValue(string(modification.Body), header.EOL))
+		case AddRecipient:
+			if !containsAddress(msg.Recipients, modification.Value) {
+				msg.Recipients = append(msg.Recipients, modification.Value)
+			}
+		case DeleteRecipient:
+			recipients := make([]string, 0, len(msg.Recipients))
+
+			for _, recipient := range msg.Recipients {
+				if !strings.EqualFold(recipient, modification.Value) {
+					recipients = append(recipients, recipient)
+				}
+			}
+
+			msg.Recipients = recipients
+		case ChangeFrom:
+			msg.Sender = modification.Value
+		}
+	}
+
+	data := header.Bytes()
+	if body != nil {
+		data = append(append(data, []byte(header.EOL)...), body...)
+	}
+
+	msg.Data = data
+
+	return nil
+}
+
+func insertHeader(header *message.Header, index int, name, value string) {
+	if index > len(header.Fields) {
+		index = len(header.Fields)
+	}
+
+	field := message.NewField(name, value, header.EOL)
+	header.Fields = append(header.Fields[:index], append([]*message.Field{field}, header.Fields[index:]...)...)
+}
+
+// changeHeader replaces index-th occurrence of the field, empty value removes it. Missing fields are added.
+func changeHeader(header *message.Header, index int, name, value string) {
+	occurrence := 0
+
+	for i, field := range header.Fields {
+		if !strings.EqualFold(field.Name, name) {
+			continue
+		}
+
+		occurrence++
+
+		if occurrence == index || index == 0 {
+			if value == "" {
+				header.Fields = append(header.Fields[:i], header.Fields[i+1:]...)
+			} else {
+				header.Fields[i] = message.NewField(field.Name, value, header.EOL)
+			}
+
+			return
+		}
+	}
+
+	if value != "" {
+		header.Add(name, value)
+	}
+}
+
+// eolValue converts line endings of values sent by milters to the ones used in the message.
+func eolValue(value, eol string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(value, "\r\n", "\n"), "\n", eol)
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, other := range addresses {
+		if strings.EqualFold(other, address) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,191 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Commands sent by the MTA.
+const (
+	cmdAbort   = 'A'
+	cmdBody    = 'B'
+	cmdConnect = 'C'
+	cmdMacro   = 'D'
+	cmdBodyEOB = 'E'
+	cmdHelo    = 'H'
+	cmdHeader  = 'L'
+	cmdMail    = 'M'
+	cmdEOH     = 'N'
+	cmdOptNeg  = 'O'
+	cmdQuit    = 'Q'
+	cmdRcpt    = 'R'
+	cmdData    = 'T'
+)
+
+// Responses and modification requests sent by milters.
+const (
+	respAddRcpt    = '+'
+	respDelRcpt    = '-'
+	respAddRcptPar = '2'
+	respAccept     = 'a'
+	respReplBody   = 'b'
+	respContinue   = 'c'
+	respDiscard    = 'd'
+	respChgFrom    = 'e'
+	respAddHeader  = 'h'
+	respInsHeader  = 'i'
+	respChgHeader  = 'm'
+	respOptNeg     = 'O'
+	respProgress   = 'p'
+	respQuarantine = 'q'
+	respReject     = 'r'
+	respSkip       = 's'
+	respTempFail   = 't'
+	respReplyCode  = 'y'
+)
+
+// Actions milter may request, SMFIF_* flags.
+const (
+	actionAddHeaders = 0x01
+	actionChangeBody = 0x02
+	actionAddRcpt    = 0x04
+	actionDelRcpt    = 0x08
+	actionChgHeaders = 0x10
+	actionQuarantine = 0x20
+	actionChangeFrom = 0x40
+	actionAddRcptPar = 0x80
+	supportedActions = actionAddHeaders | actionChangeBody | actionAddRcpt | actionDelRcpt | actionChgHeaders |
+		actionQuarantine | actionChangeFrom | actionAddRcptPar
+)
+
+// Protocol steps milter may skip or not reply to, SMFIP_* flags.
+const (
+	protocolNoConnect     = 0x01
+	protocolNoHelo        = 0x02
+	protocolNoMail        = 0x04
+	protocolNoRcpt        = 0x08
+	protocolNoBody        = 0x10
+	protocolNoHeaders     = 0x20
+	protocolNoEOH         = 0x40
+	protocolNoReplyHeader = 0x80
+	protocolNoUnknown     = 0x100
+	protocolNoData        = 0x200
+	protocolSkip          = 0x400
+	protocolNoReplyConn   = 0x1000
+	protocolNoReplyHelo   = 0x2000
+	protocolNoReplyMail   = 0x4000
+	protocolNoReplyRcpt   = 0x8000
+	protocolNoReplyData   = 0x10000
+	protocolNoReplyEOH    = 0x40000
+	protocolNoReplyBody   = 0x80000
+	protocolHeaderLeadSpc = 0x100000
+	supportedProtocol     = protocolNoConnect | protocolNoHelo | protocolNoMail | protocolNoRcpt | protocolNoBody |
+		protocolNoHeaders | protocolNoEOH | protocolNoReplyHeader | protocolNoUnknown | protocolNoData |
+		protocolSkip | protocolNoReplyConn | protocolNoReplyHelo | protocolNoReplyMail | protocolNoReplyRcpt |
+		protocolNoReplyData | protocolNoReplyEOH | protocolNoReplyBody | protocolHeaderLeadSpc
+)
+
+const (
+	protocolVersion = 6
+	maxPacketSize   = 64 * 1024 * 1024
+	maxBodyChunk    = 65535
+	optNegLength    = 12
+	uint32Length    = 4
+)
+
+var (
+	ErrProtocol        = errors.New("milter protocol error")
+	ErrPacketTooLarge  = errors.New("milter packet too large")
+	ErrUnsupportedStep = errors.New("milter requested unsupported action")
+)
+
+type packet struct {
+	code byte
+	data []byte
+}
+
+func writePacket(writer io.Writer, code byte, data ...[]byte) error {
+	var buffer bytes.Buffer
+
+	length := 1
+	for _, chunk := range data {
+		length += len(chunk)
+	}
+
+	_ = binary.Write(&buffer, binary.BigEndian, uint32(length))
+	buffer.WriteByte(code)
+
+	for _, chunk := range data {
+		buffer.Write(chunk)
+	}
+
+	if _, err := writer.Write(buffer.Bytes()); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+func readPacket(reader io.Reader) (*packet, error) {
+	var length uint32
+
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if length == 0 {
+		return nil, fmt.Errorf("%w: empty packet", ErrProtocol)
+	}
+
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPacketTooLarge, length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return &packet{code: data[0], data: data[1:]}, nil
+}
+
+// cString encodes strings as NUL terminated ones.
+func cString(values ...string) []byte {
+	var buffer bytes.Buffer
+
+	for _, value := range values {
+		buffer.WriteString(value)
+		buffer.WriteByte(0)
+	}
+
+	return buffer.Bytes()
+}
+
+// splitCStrings decodes NUL terminated strings.
+func splitCStrings(data []byte) []string {
+	data = bytes.TrimSuffix(data, []byte{0})
+	if len(data) == 0 {
+		return []string{}
+	}
+
+	return toStrings(bytes.Split(data, []byte{0}))
+}
+
+func toStrings(values [][]byte) []string {
+	result := make([]string, len(values))
+	for i, value := range values {
+		result[i] = string(value)
+	}
+
+	return result
+}
+
+func uint32Bytes(value uint32) []byte {
+	data := make([]byte, uint32Length)
+	binary.BigEndian.PutUint32(data, value)
+
+	return data
+}
@@ -2,6 +2,7 @@ package process
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"sync"
@@ -10,6 +11,7 @@ import (
 	"github.com/Masterminds/log-go"
 	"github.com/ajgon/mailbowl/config"
 	"github.com/ajgon/mailbowl/listener"
+	"github.com/ajgon/mailbowl/systemd"
 )
 
 const (
@@ -17,16 +19,26 @@ const (
 	ExitCodeTerminated = 130
 )
 
+// Builder creates listeners from the configuration. It's called again on reload, so listeners are rebuilt from the
+// new configuration.
+type Builder func(conf config.Config) ([]listener.Listener, error)
+
 type Manager struct {
+	builder   Builder
 	listeners []listener.Listener
 
 	interruptChan chan os.Signal
 	reloadChan    chan os.Signal
-	restarting    bool
+
+	// guards restarting and stopping, set by signal handlers
+	mutex      sync.Mutex
+	restarting bool
+	stopping   bool
 }
 
-func NewManager() *Manager {
+func NewManager(builder Builder) *Manager {
 	manager := new(Manager)
+	manager.builder = builder
 	manager.listeners = make([]listener.Listener, 0)
 	manager.interruptChan = make(chan os.Signal, 1)
 	manager.reloadChan = make(chan os.Signal, 1)
@@ -35,34 +47,92 @@ func NewManager() *Manager {
 	return manager
 }
 
-func (m *Manager) AddListener(listener listener.Listener) {
-	m.listeners = append(m.listeners, listener)
+// Build replaces listeners with the ones built from given configuration. On error, current listeners are kept.
+func (m *Manager) Build(conf config.Config) error {
+	listeners, err := m.builder(conf)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	m.listeners = listeners
+
+	return nil
 }
 
 func (m *Manager) Start() {
-	for m.restarting {
-		m.restarting = false
+	for m.nextGeneration() {
 		m.startAllListeners()
 	}
 }
 
+// nextGeneration tells whether listeners should be started (again), clearing the restart request.
+func (m *Manager) nextGeneration() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	restarting := m.restarting
+	m.restarting = false
+
+	return restarting
+}
+
+func (m *Manager) closeListeners() {
+	for _, l := range m.listeners {
+		closer, ok := l.(listener.Closer)
+		if !ok {
+			continue
+		}
+
+		if err := closer.Close(); err != nil {
+			log.Errorf("error closing %s: %s", l.GetName(), err.Error())
+		}
+	}
+}
+
+// Reload requests configuration reload, the same way SIGHUP does. Requests made while one is pending are merged.
+func (m *Manager) Reload() {
+	select {
+	case m.reloadChan <- syscall.SIGHUP:
+	default:
+	}
+}
+
+// Restart stops current listeners, so the new ones are started. Shutdown requested in the meantime wins.
 func (m *Manager) Restart(cancelCtx func()) {
-	m.restarting = true
+	m.mutex.Lock()
+	m.restarting = !m.stopping
+	m.mutex.Unlock()
+
+	cancelCtx()
+}
+
+func (m *Manager) stop(cancelCtx func()) {
+	m.mutex.Lock()
+	m.restarting, m.stopping = false, true
+	m.mutex.Unlock()
 
 	cancelCtx()
 }
 
+func (m *Manager) isRestarting() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.restarting
+}
+
 func (m *Manager) startAllListeners() {
 	var waitGroup sync.WaitGroup
 
 	ctx, cancelCtx := context.WithCancel(context.Background())
+	done := make(chan struct{})
 
 	m.attachSignals()
 
 	defer m.cleanup(cancelCtx)
 
 	go m.handleReload(ctx, cancelCtx)
-	go m.handleInterrupt(ctx, cancelCtx)
+	go m.handleInterrupt(cancelCtx, done)
 
 	for _, listener := range m.listeners {
 		waitGroup.Add(1)
@@ -71,11 +141,18 @@ func (m *Manager) startAllListeners() {
 	}
 
 	waitGroup.Wait()
+
+	// interrupts are handled until here, so a second one stops draining sessions
+	if !m.isRestarting() {
+		m.closeListeners()
+	}
+
+	close(done)
 }
 
 func (m *Manager) attachSignals() {
 	signal.Notify(m.reloadChan, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
-	signal.Notify(m.interruptChan, syscall.SIGINT, syscall.SIGQUIT)
+	signal.Notify(m.interruptChan, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
 }
 
 func (m *Manager) cleanup(cancelCtx func()) {
@@ -89,7 +166,15 @@ func (m *Manager) handleReload(ctx context.Context, cancelCtx func()) {
 		select {
 		case <-m.reloadChan:
 			log.Info("reloading config")
-			config.Reload()
+			notify(systemd.StateReloading)
+
+			if err := m.reload(); err != nil {
+				log.Errorf("problem reloading configuration, keeping the current one: %s", err.Error())
+				notify(systemd.StateReady)
+
+				continue
+			}
+
 			m.Restart(cancelCtx)
 		case <-ctx.Done():
 			return
@@ -97,17 +182,40 @@ func (m *Manager) handleReload(ctx context.Context, cancelCtx func()) {
 	}
 }
 
-func (m *Manager) handleInterrupt(ctx context.Context, cancelCtx func()) {
+// reload reads the configuration again and builds new listeners from it. They replace the current ones, once those
+// are stopped by Restart. Nothing is changed, unless both configuration and listeners are valid.
+func (m *Manager) reload() error {
+	conf, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err = m.Build(conf); err != nil {
+		return err
+	}
+
+	return config.Apply(conf)
+}
+
+// handleInterrupt shuts down gracefully on the first signal, and quits at once on the second one. It runs until
+// listeners of the generation are stopped and drained, also when they were stopped by reload or error, so sessions
+// kept open by clients can't delay shutdown forever.
+func (m *Manager) handleInterrupt(cancelCtx func(), done chan struct{}) {
 	select {
 	case <-m.interruptChan:
 		log.Info("gracefully shutting down")
-		cancelCtx()
-	case <-ctx.Done():
+		notify(systemd.StateStopping)
+		m.stop(cancelCtx)
+	case <-done:
 		return
 	}
-	<-m.interruptChan
-	log.Warn("forcing quit")
-	os.Exit(ExitCodeTerminated)
+
+	select {
+	case <-m.interruptChan:
+		log.Warn("forcing quit")
+		os.Exit(ExitCodeTerminated)
+	case <-done:
+	}
 }
 
 func (m *Manager) startListener(ctx context.Context, cancelCtx func(), wg *sync.WaitGroup, listener listener.Listener) {
@@ -118,3 +226,9 @@ func (m *Manager) startListener(ctx context.Context, cancelCtx func(), wg *sync.
 		cancelCtx()
 	}
 }
+
+func notify(state string) {
+	if err := systemd.Notify(state); err != nil {
+		log.Warnw("systemd notification failed", log.Fields{"state": state, "error": err.Error()})
+	}
+}
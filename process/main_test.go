@@ -0,0 +1,82 @@
+package process_test
+
+import (
+	"fmt"
+	"net"
+	netsmtp "net/smtp"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/listener"
+	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/ajgon/mailbowl/process"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	defer socket.Close()
+
+	return socket.Addr().String()
+}
+
+func TestReloadWithInvalidConfigKeepsListeners(t *testing.T) {
+	current, added := freeAddress(t), freeAddress(t)
+	configFile := filepath.Join(t.TempDir(), "mailbowl.yml")
+	configYAML := "---\nsmtp:\n  hostname: mailbowl.local\n  whitelist: [127.0.0.1/32]\n  listen:\n    - plain://%s\n"
+
+	assert.NoError(t, os.WriteFile(configFile, []byte(fmt.Sprintf(configYAML, current)), 0o600))
+
+	config.CobraInitialize(configFile)
+	defer viper.Reset()
+
+	sockets := smtp.NewSockets()
+	manager := process.NewManager(func(conf config.Config) ([]listener.Listener, error) {
+		server, err := smtp.NewSMTP(conf, conf.SMTP.ListenURIs(), nil, nil, sockets)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		return []listener.Listener{server}, nil
+	})
+	assert.NoError(t, manager.Build(config.Get()))
+
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		manager.Start()
+	}()
+
+	time.Sleep(100 * time.Millisecond) // allow listeners to start
+
+	// certificate of the added listener doesn't exist
+	assert.NoError(t, os.WriteFile(configFile, []byte(fmt.Sprintf(
+		configYAML+"    - starttls://%s\n  tls:\n    key_file: /nonexistent/tls.key\n"+
+			"    certificate_file: /nonexistent/tls.crt\n", current, added,
+	)), 0o600))
+
+	manager.Reload()
+	time.Sleep(200 * time.Millisecond) // allow reload to finish
+
+	client, err := netsmtp.Dial(current)
+	assert.NoError(t, err)
+	assert.NoError(t, client.Hello("localhost"))
+	assert.NoError(t, client.Quit())
+
+	_, err = net.Dial("tcp", added)
+	assert.Error(t, err)
+
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	<-stopped
+}
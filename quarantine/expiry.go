@@ -0,0 +1,52 @@
+package quarantine
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Masterminds/log-go"
+)
+
+const defaultExpiryInterval = time.Hour
+
+// Expiry periodically removes messages kept in quarantine longer than its retention period. It runs alongside
+// listeners, so it stops together with them.
+type Expiry struct {
+	Store    *Store
+	Interval time.Duration
+}
+
+func NewExpiry(store *Store) *Expiry {
+	return &Expiry{Store: store, Interval: defaultExpiryInterval}
+}
+
+func (e *Expiry) GetName() string {
+	return "quarantine expiry"
+}
+
+func (e *Expiry) Serve(ctx context.Context) error {
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	for {
+		e.expire()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (e *Expiry) expire() {
+	expired, err := e.Store.Expire(time.Now())
+	if err != nil {
+		log.Errorw("quarantine expiry failed", log.Fields{"directory": e.Store.Directory, "error": err.Error()})
+	}
+
+	if expired > 0 {
+		log.Infow("quarantined messages expired", log.Fields{"directory": e.Store.Directory, "expired": strconv.Itoa(expired)})
+	}
+}
@@ -0,0 +1,290 @@
+package quarantine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+)
+
+const (
+	directoryMode = 0o750
+	fileMode      = 0o640
+	idRandomBytes = 6
+
+	messageExtension  = ".eml"
+	metadataExtension = ".json"
+)
+
+var (
+	ErrQuarantineDisabled = errors.New("quarantine directory not configured")
+	ErrNotFound           = errors.New("quarantined message not found")
+	ErrInvalidID          = errors.New("invalid quarantine id")
+	ErrRelayDisabled      = errors.New("outgoing server not configured, can't release messages")
+
+	idPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}-[0-9a-f]+$`)
+)
+
+// Releaser sends released messages on. It is satisfied by smtp.SMTP, which takes them through the same steps as
+// accepted messages.
+type Releaser interface {
+	Release(entry *Entry, data []byte) error
+}
+
+// Entry describes a quarantined message. It is stored as JSON next to the message itself. Listener is the one which
+// received the message, Filtered is set, when the message passed all filters and was held by a milter.
+type Entry struct {
+	ID         string    `json:"id"`
+	Sender     string    `json:"sender"`
+	Recipients []string  `json:"recipients"`
+	RemoteIP   string    `json:"remote_ip"`
+	Username   string    `json:"username,omitempty"`
+	Listener   string    `json:"listener,omitempty"`
+	Filter     string    `json:"filter"`
+	Rule       string    `json:"rule,omitempty"`
+	Reason     string    `json:"reason"`
+	Filtered   bool      `json:"filtered,omitempty"`
+	Received   time.Time `json:"received"`
+}
+
+// Store keeps quarantined messages in a directory, each as a pair of files: `<id>.eml` with the message
+// and `<id>.json` with its metadata. Entries older than Retention are removed by Expire, 0 keeps them forever.
+type Store struct {
+	Directory string
+	Retention time.Duration
+}
+
+func NewStore(conf config.Quarantine) *Store {
+	return &Store{Directory: conf.Directory, Retention: conf.Retention}
+}
+
+func (s *Store) Enabled() bool {
+	return s != nil && s.Directory != ""
+}
+
+// Put stores the message, filling entry ID and receive time. Returns the ID of the stored entry.
+func (s *Store) Put(entry *Entry, data []byte) (string, error) {
+	if !s.Enabled() {
+		return "", ErrQuarantineDisabled
+	}
+
+	if err := os.MkdirAll(s.Directory, directoryMode); err != nil {
+		return "", fmt.Errorf("error creating quarantine directory: %w", err)
+	}
+
+	entry.ID = newID()
+	entry.Received = time.Now().UTC()
+
+	metadata, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+
+	if err = writeFile(s.path(entry.ID, messageExtension), data); err != nil {
+		return "", err
+	}
+
+	// metadata is written last, entries without it are not complete yet
+	if err = writeFile(s.path(entry.ID, metadataExtension), metadata); err != nil {
+		_ = os.Remove(s.path(entry.ID, messageExtension))
+
+		return "", err
+	}
+
+	return entry.ID, nil
+}
+
+// List returns all complete entries, oldest first.
+func (s *Store) List() ([]*Entry, error) {
+	if !s.Enabled() {
+		return nil, ErrQuarantineDisabled
+	}
+
+	paths, err := filepath.Glob(filepath.Join(s.Directory, "*"+metadataExtension))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	entries := make([]*Entry, 0, len(paths))
+
+	for _, path := range paths {
+		entry, err := s.Entry(strings.TrimSuffix(filepath.Base(path), metadataExtension))
+		if err != nil {
+			// removed in the meantime
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Received.Equal(entries[j].Received) {
+			return entries[i].ID < entries[j].ID
+		}
+
+		return entries[i].Received.Before(entries[j].Received)
+	})
+
+	return entries, nil
+}
+
+// Entry returns metadata of a single entry.
+func (s *Store) Entry(id string) (*Entry, error) {
+	if err := s.check(id); err != nil {
+		return nil, err
+	}
+
+	metadata, err := os.ReadFile(s.path(id, metadataExtension))
+	if err != nil {
+		return nil, notFound(id, err)
+	}
+
+	entry := &Entry{}
+	if err = json.Unmarshal(metadata, entry); err != nil {
+		return nil, fmt.Errorf("error reading quarantine entry `%s`: %w", id, err)
+	}
+
+	return entry, nil
+}
+
+// Get returns the entry together with the quarantined message.
+func (s *Store) Get(id string) (*Entry, []byte, error) {
+	entry, err := s.Entry(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(s.path(id, messageExtension))
+	if err != nil {
+		return nil, nil, notFound(id, err)
+	}
+
+	return entry, data, nil
+}
+
+// Delete removes the entry. Metadata goes first, so the entry disappears from the list even if removing
+// the message fails.
+func (s *Store) Delete(id string) error {
+	if err := s.check(id); err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.path(id, metadataExtension)); err != nil {
+		return notFound(id, err)
+	}
+
+	if err := os.Remove(s.path(id, messageExtension)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing quarantined message `%s`: %w", id, err)
+	}
+
+	return nil
+}
+
+// Release passes the message on, to original recipients, and removes it from quarantine.
+func (s *Store) Release(id string, releaser Releaser) (*Entry, error) {
+	entry, data, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = releaser.Release(entry, data); err != nil {
+		return nil, fmt.Errorf("error releasing quarantined message `%s`: %w", id, err)
+	}
+
+	if err = s.Delete(id); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Expire removes entries received before the retention period, returning the number of removed entries.
+func (s *Store) Expire(now time.Time) (int, error) {
+	if !s.Enabled() || s.Retention <= 0 {
+		return 0, nil
+	}
+
+	entries, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	deadline := now.Add(-s.Retention)
+
+	for _, entry := range entries {
+		if !entry.Received.Before(deadline) {
+			break
+		}
+
+		if err = s.Delete(entry.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			return expired, err
+		}
+
+		expired++
+	}
+
+	return expired, nil
+}
+
+func (s *Store) check(id string) error {
+	if !s.Enabled() {
+		return ErrQuarantineDisabled
+	}
+
+	// ids end up in file paths
+	if !idPattern.MatchString(id) {
+		return fmt.Errorf("%w: `%s`", ErrInvalidID, id)
+	}
+
+	return nil
+}
+
+func (s *Store) path(id, extension string) string {
+	return filepath.Join(s.Directory, id+extension)
+}
+
+// writeFile writes data to a temporary file first, so readers never see partially written files.
+func writeFile(path string, data []byte) error {
+	temporary := path + ".tmp"
+
+	if err := os.WriteFile(temporary, data, fileMode); err != nil {
+		return fmt.Errorf("error writing quarantine file: %w", err)
+	}
+
+	if err := os.Rename(temporary, path); err != nil {
+		_ = os.Remove(temporary)
+
+		return fmt.Errorf("error writing quarantine file: %w", err)
+	}
+
+	return nil
+}
+
+func notFound(id string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: `%s`", ErrNotFound, id)
+	}
+
+	return fmt.Errorf("%w", err)
+}
+
+func newID() string {
+	random := make([]byte, idRandomBytes)
+	_, _ = rand.Read(random)
+
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(random)
+}
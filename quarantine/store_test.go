@@ -0,0 +1,178 @@
+package quarantine_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/quarantine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPut(t *testing.T) {
+	t.Parallel()
+
+	directory := filepath.Join(t.TempDir(), "quarantine")
+	store := quarantine.NewStore(config.Quarantine{Directory: directory})
+
+	entry := &quarantine.Entry{Sender: "sender@example.local", Recipients: []string{"rcpt@example.local"}, Filter: "test"}
+	id, err := store.Put(entry, []byte("Subject: test\r\n\r\nbody\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, id, entry.ID)
+	assert.False(t, entry.Received.IsZero())
+
+	data, err := os.ReadFile(filepath.Join(directory, id+".eml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Subject: test\r\n\r\nbody\r\n", string(data))
+
+	metadata, err := os.ReadFile(filepath.Join(directory, id+".json"))
+	assert.NoError(t, err)
+
+	var stored quarantine.Entry
+
+	assert.NoError(t, json.Unmarshal(metadata, &stored))
+	assert.Equal(t, "sender@example.local", stored.Sender)
+	assert.Equal(t, []string{"rcpt@example.local"}, stored.Recipients)
+	assert.Equal(t, "test", stored.Filter)
+}
+
+func TestPutDisabled(t *testing.T) {
+	t.Parallel()
+
+	store := quarantine.NewStore(config.Quarantine{})
+
+	_, err := store.Put(&quarantine.Entry{}, []byte("data"))
+	assert.ErrorIs(t, err, quarantine.ErrQuarantineDisabled)
+}
+
+type fakeReleaser struct {
+	sender     string
+	recipients []string
+	data       []byte
+	err        error
+}
+
+func (f *fakeReleaser) Release(entry *quarantine.Entry, data []byte) error {
+	f.sender, f.recipients, f.data = entry.Sender, entry.Recipients, data
+
+	return f.err
+}
+
+func putEntries(t *testing.T, store *quarantine.Store, senders ...string) []string {
+	t.Helper()
+
+	ids := make([]string, 0, len(senders))
+
+	for _, sender := range senders {
+		id, err := store.Put(
+			&quarantine.Entry{Sender: sender, Recipients: []string{"rcpt@example.local"}, Filter: "policy", Rule: "test"},
+			[]byte("Subject: "+sender+"\r\n\r\nbody\r\n"),
+		)
+		assert.NoError(t, err)
+
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func TestListAndGet(t *testing.T) {
+	t.Parallel()
+
+	store := quarantine.NewStore(config.Quarantine{Directory: t.TempDir()})
+
+	entries, err := store.List()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	ids := putEntries(t, store, "first@example.local", "second@example.local")
+
+	entries, err = store.List()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.ElementsMatch(t, ids, []string{entries[0].ID, entries[1].ID})
+	assert.Equal(t, "test", entries[0].Rule)
+
+	entry, data, err := store.Get(ids[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "second@example.local", entry.Sender)
+	assert.Equal(t, "Subject: second@example.local\r\n\r\nbody\r\n", string(data))
+
+	_, _, err = store.Get("20220101T000000-abcdef")
+	assert.ErrorIs(t, err, quarantine.ErrNotFound)
+
+	_, err = store.Entry("../../etc/passwd")
+	assert.ErrorIs(t, err, quarantine.ErrInvalidID)
+}
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	store := quarantine.NewStore(config.Quarantine{Directory: directory})
+	ids := putEntries(t, store, "sender@example.local")
+
+	assert.NoError(t, store.Delete(ids[0]))
+	assert.ErrorIs(t, store.Delete(ids[0]), quarantine.ErrNotFound)
+
+	files, err := os.ReadDir(directory)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestRelease(t *testing.T) {
+	t.Parallel()
+
+	store := quarantine.NewStore(config.Quarantine{Directory: t.TempDir()})
+	ids := putEntries(t, store, "sender@example.local", "failing@example.local")
+
+	releaser := &fakeReleaser{}
+	entry, err := store.Release(ids[0], releaser)
+	assert.NoError(t, err)
+	assert.Equal(t, "sender@example.local", entry.Sender)
+	assert.Equal(t, "sender@example.local", releaser.sender)
+	assert.Equal(t, []string{"rcpt@example.local"}, releaser.recipients)
+	assert.Equal(t, "Subject: sender@example.local\r\n\r\nbody\r\n", string(releaser.data))
+
+	_, err = store.Entry(ids[0])
+	assert.ErrorIs(t, err, quarantine.ErrNotFound)
+
+	// failed relay keeps the message in quarantine
+	relayErr := errors.New("connection refused")
+	_, err = store.Release(ids[1], &fakeReleaser{err: relayErr})
+	assert.ErrorIs(t, err, relayErr)
+
+	_, err = store.Entry(ids[1])
+	assert.NoError(t, err)
+}
+
+func TestExpire(t *testing.T) {
+	t.Parallel()
+
+	store := quarantine.NewStore(config.Quarantine{Directory: t.TempDir(), Retention: time.Hour})
+	putEntries(t, store, "old@example.local", "new@example.local")
+
+	expired, err := store.Expire(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+
+	expired, err = store.Expire(time.Now().Add(2 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, expired)
+
+	entries, err := store.List()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// no retention, keeps everything
+	store.Retention = 0
+	putEntries(t, store, "sender@example.local")
+
+	expired, err = store.Expire(time.Now().Add(24 * 365 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+}
@@ -0,0 +1,111 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"errors"
+)
+
+// TLSA certificate usages, selectors and matching types (RFC 6698 section 2.1). Only DANE-TA and DANE-EE usages
+// are used for SMTP (RFC 7672 section 3.1.3).
+const (
+	TLSAUsageDANETA = 2
+	TLSAUsageDANEEE = 3
+
+	TLSASelectorCertificate = 0
+	TLSASelectorSPKI        = 1
+
+	TLSAMatchingFull   = 0
+	TLSAMatchingSHA256 = 1
+	TLSAMatchingSHA512 = 2
+)
+
+var ErrTLSANoMatch = errors.New("no TLSA record matches the peer certificate chain")
+
+// verifyDANE checks peer certificate chain against TLSA records. DANE-EE records are matched against the leaf
+// certificate only, with no name or expiry checks. DANE-TA records pin a trust anchor, which has to be a part of
+// the presented chain, and the leaf has to be valid for host when verified against it.
+func verifyDANE(certificates []*x509.Certificate, records []TLSARecord, host string) error {
+	for _, record := range records {
+		if record.Usage == TLSAUsageDANEEE && matchTLSA(certificates[0], record) {
+			return nil
+		}
+	}
+
+	var lastErr error
+
+	for _, record := range records {
+		if record.Usage != TLSAUsageDANETA {
+			continue
+		}
+
+		for _, anchor := range certificates[1:] {
+			if !matchTLSA(anchor, record) {
+				continue
+			}
+
+			roots := x509.NewCertPool()
+			roots.AddCert(anchor)
+
+			if lastErr = verifyPKIX(certificates, host, roots); lastErr == nil {
+				return nil
+			}
+		}
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+
+	return &tlsPolicyError{ResultType: ResultValidationFailure, Err: ErrTLSANoMatch}
+}
+
+func matchTLSA(certificate *x509.Certificate, record TLSARecord) bool {
+	var data []byte
+
+	switch record.Selector {
+	case TLSASelectorCertificate:
+		data = certificate.Raw
+	case TLSASelectorSPKI:
+		data = certificate.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+
+	switch record.MatchingType {
+	case TLSAMatchingFull:
+		return bytes.Equal(data, record.Data)
+	case TLSAMatchingSHA256:
+		sum := sha256.Sum256(data)
+
+		return bytes.Equal(sum[:], record.Data)
+	case TLSAMatchingSHA512:
+		sum := sha512.Sum512(data)
+
+		return bytes.Equal(sum[:], record.Data)
+	}
+
+	return false
+}
+
+// usableTLSARecords drops records with usages, selectors or matching types not supported for SMTP. When no usable
+// record is left, the server is treated as if it had no TLSA records at all (RFC 7672 section 2.2).
+func usableTLSARecords(records []TLSARecord) []TLSARecord {
+	usable := make([]TLSARecord, 0, len(records))
+
+	for _, record := range records {
+		if record.Usage != TLSAUsageDANETA && record.Usage != TLSAUsageDANEEE {
+			continue
+		}
+
+		if record.Selector > TLSASelectorSPKI || record.MatchingType > TLSAMatchingSHA512 {
+			continue
+		}
+
+		usable = append(usable, record)
+	}
+
+	return usable
+}
@@ -0,0 +1,264 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	dnsTypeTLSA = 52
+	dnsTypeOPT  = 41
+	dnsClassIN  = 1
+
+	dnsFlagRD = 1 << 8
+	dnsFlagAD = 1 << 5
+	dnsFlagTC = 1 << 9
+	// EDNS0 DO flag (RFC 3225), kept in the TTL field of OPT record
+	dnsFlagDO = 1 << 15
+
+	dnsRcodeNoError  = 0
+	dnsRcodeNXDomain = 3
+
+	dnsHeaderLength = 12
+	dnsMaxUDPSize   = 4096
+	dnsDefaultPort  = "53"
+	resolvConfPath  = "/etc/resolv.conf"
+)
+
+var (
+	ErrDNSLookup        = errors.New("dns lookup failed")
+	ErrDNSMalformed     = errors.New("malformed dns response")
+	ErrNoDNSNameservers = errors.New("no nameservers configured")
+)
+
+type TLSARecord struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte
+}
+
+// TLSAResolver looks up TLSA records. Secure must be true only when the answer was DNSSEC validated, DANE
+// ignores insecure records.
+type TLSAResolver interface {
+	LookupTLSA(ctx context.Context, name string) (records []TLSARecord, secure bool, err error)
+}
+
+// DNSResolver is a minimal stub resolver, asking a validating recursive nameserver for TLSA records. DNSSEC
+// validation itself is left to the nameserver, authenticated answers are recognized by the AD bit. The AD bit is
+// only as trustworthy as the path to the nameserver, so it should be a local one (i.e. unbound on loopback).
+type DNSResolver struct {
+	Server string
+}
+
+func NewDNSResolver(server string) *DNSResolver {
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, dnsDefaultPort)
+		}
+	}
+
+	return &DNSResolver{Server: server}
+}
+
+func (dr *DNSResolver) LookupTLSA(ctx context.Context, name string) ([]TLSARecord, bool, error) {
+	server, err := dr.nameserver()
+	if err != nil {
+		return nil, false, err
+	}
+
+	query, id := buildDNSQuery(name, dnsTypeTLSA)
+
+	response, err := dnsExchange(ctx, "udp", server, query)
+	if err == nil && len(response) >= dnsHeaderLength && binary.BigEndian.Uint16(response[2:])&dnsFlagTC != 0 {
+		response, err = dnsExchange(ctx, "tcp", server, query)
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %s", ErrDNSLookup, err.Error())
+	}
+
+	return parseTLSAResponse(response, id)
+}
+
+func (dr *DNSResolver) nameserver() (string, error) {
+	if dr.Server != "" {
+		return dr.Server, nil
+	}
+
+	file, err := os.Open(resolvConfPath)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrNoDNSNameservers, err.Error())
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], dnsDefaultPort), nil
+		}
+	}
+
+	return "", ErrNoDNSNameservers
+}
+
+func buildDNSQuery(name string, qtype uint16) ([]byte, uint16) {
+	idBytes := make([]byte, 2) //nolint:gomnd
+	_, _ = rand.Read(idBytes)
+	id := binary.BigEndian.Uint16(idBytes)
+
+	query := make([]byte, dnsHeaderLength)
+	binary.BigEndian.PutUint16(query[0:], id)
+	binary.BigEndian.PutUint16(query[2:], dnsFlagRD|dnsFlagAD)
+	binary.BigEndian.PutUint16(query[4:], 1)  // QDCOUNT
+	binary.BigEndian.PutUint16(query[10:], 1) // ARCOUNT
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+
+	query = append(query, 0, byte(qtype>>8), byte(qtype), 0, dnsClassIN) //nolint:gomnd
+
+	// OPT record asks for DNSSEC records (DO), validating nameservers set AD only when DO or AD is set
+	opt := make([]byte, 11) //nolint:gomnd
+	binary.BigEndian.PutUint16(opt[1:], dnsTypeOPT)
+	binary.BigEndian.PutUint16(opt[3:], dnsMaxUDPSize)
+	binary.BigEndian.PutUint32(opt[5:], dnsFlagDO)
+
+	return append(query, opt...), id
+}
+
+func dnsExchange(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		query = append([]byte{byte(len(query) >> 8), byte(len(query))}, query...) //nolint:gomnd
+	}
+
+	if _, err = conn.Write(query); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if network == "tcp" {
+		length := make([]byte, 2) //nolint:gomnd
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		response := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err = io.ReadFull(conn, response); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		return response, nil
+	}
+
+	response := make([]byte, dnsMaxUDPSize)
+
+	read, err := conn.Read(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return response[:read], nil
+}
+
+//nolint:cyclop
+func parseTLSAResponse(response []byte, id uint16) ([]TLSARecord, bool, error) {
+	if len(response) < dnsHeaderLength || binary.BigEndian.Uint16(response[0:]) != id {
+		return nil, false, ErrDNSMalformed
+	}
+
+	flags := binary.BigEndian.Uint16(response[2:])
+	secure := flags&dnsFlagAD != 0
+
+	switch rcode := flags & 0xf; rcode { //nolint:gomnd
+	case dnsRcodeNoError:
+	case dnsRcodeNXDomain:
+		return []TLSARecord{}, secure, nil
+	default:
+		return nil, false, fmt.Errorf("%w: rcode %d", ErrDNSLookup, rcode)
+	}
+
+	questions := int(binary.BigEndian.Uint16(response[4:]))
+	answers := int(binary.BigEndian.Uint16(response[6:]))
+	offset := dnsHeaderLength
+
+	var err error
+
+	for i := 0; i < questions; i++ {
+		if offset, err = skipDNSName(response, offset); err != nil {
+			return nil, false, err
+		}
+
+		offset += 4 // QTYPE + QCLASS
+	}
+
+	records := make([]TLSARecord, 0, answers)
+
+	for i := 0; i < answers; i++ {
+		if offset, err = skipDNSName(response, offset); err != nil {
+			return nil, false, err
+		}
+
+		if offset+10 > len(response) { //nolint:gomnd
+			return nil, false, ErrDNSMalformed
+		}
+
+		rrtype := binary.BigEndian.Uint16(response[offset:])
+		rdlength := int(binary.BigEndian.Uint16(response[offset+8:]))
+		offset += 10
+
+		if offset+rdlength > len(response) {
+			return nil, false, ErrDNSMalformed
+		}
+
+		if rrtype == dnsTypeTLSA && rdlength > 3 {
+			rdata := response[offset : offset+rdlength]
+			records = append(records, TLSARecord{
+				Usage: rdata[0], Selector: rdata[1], MatchingType: rdata[2], Data: append([]byte{}, rdata[3:]...),
+			})
+		}
+
+		offset += rdlength
+	}
+
+	return records, secure, nil
+}
+
+func skipDNSName(message []byte, offset int) (int, error) {
+	for offset < len(message) {
+		length := int(message[offset])
+
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0: // compression pointer
+			return offset + 2, nil //nolint:gomnd
+		default:
+			offset += length + 1
+		}
+	}
+
+	return 0, ErrDNSMalformed
+}
@@ -0,0 +1,81 @@
+package relay_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/relay"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveDNS answers every query with a single TLSA record. Like validating nameservers, it sets the AD flag only for
+// queries asking for DNSSEC records with EDNS0 DO flag, when secure.
+func serveDNS(t *testing.T, secure bool) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 512)
+
+		for {
+			read, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+
+			query := buffer[:read]
+			question := 12 + bytes.IndexByte(query[12:], 0) + 5 // name, QTYPE and QCLASS
+			opt := query[question:]
+
+			flags := byte(0x80) // RA
+			if secure && len(opt) == 11 && opt[2] == 41 && opt[7]&0x80 != 0 {
+				flags |= 0x20 // AD
+			}
+
+			// id, QR + RD, one question and one answer
+			response := append([]byte{}, query[:2]...)
+			response = append(response, 0x81, flags, 0, 1, 0, 1, 0, 0, 0, 0)
+			response = append(response, query[12:question]...)
+			response = append(response, 0xc0, 12, 0, 52, 0, 1, 0, 0, 0, 60, 0, 7, 3, 1, 1, 0xde, 0xad, 0xbe, 0xef)
+
+			_, _ = conn.WriteTo(response, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestDNSResolverLookupTLSA(t *testing.T) {
+	t.Parallel()
+
+	for _, secure := range []bool{true, false} {
+		resolver := relay.NewDNSResolver(serveDNS(t, secure))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		records, authenticated, err := resolver.LookupTLSA(ctx, "_25._tcp.mail.example.com")
+
+		cancel()
+
+		assert.NoError(t, err, fmt.Sprintf("secure: %v", secure))
+		assert.Equal(t, secure, authenticated)
+		assert.Equal(t, []relay.TLSARecord{
+			{Usage: 3, Selector: 1, MatchingType: 1, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		}, records)
+	}
+}
+
+func TestNewDNSResolverDefaultPort(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "10.0.0.53:53", relay.NewDNSResolver("10.0.0.53").Server)
+	assert.Equal(t, "10.0.0.53:5353", relay.NewDNSResolver("10.0.0.53:5353").Server)
+	assert.Equal(t, "", relay.NewDNSResolver("").Server)
+}
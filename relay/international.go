@@ -0,0 +1,76 @@
+package relay
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/message"
+)
+
+type extensionChecker interface {
+	Extension(ext string) (bool, string)
+}
+
+// prepareInternational checks if outgoing server supports everything the message needs. When SMTPUTF8 is missing,
+// internationalized domains are converted to punycode and non-ASCII headers are encoded, when 8BITMIME is missing,
+// 8-bit parts are re-encoded with quoted-printable or base64. If none of this is possible (i.e. one of addresses has
+// non-ASCII local part), message.ErrSMTPUTF8Required is returned.
+func prepareInternational(
+	server extensionChecker, from string, recipients []string, data []byte,
+) (string, []string, []byte, error) {
+	var err error
+
+	supportsSMTPUTF8, _ := server.Extension("SMTPUTF8")
+	supports8BitMIME, _ := server.Extension("8BITMIME")
+
+	if !supportsSMTPUTF8 {
+		if from, err = message.AddressToASCII(from); err != nil {
+			return "", nil, nil, fmt.Errorf("invalid sender: %w", err)
+		}
+
+		asciiRecipients := make([]string, 0, len(recipients))
+
+		for _, recipient := range recipients {
+			asciiRecipient, err := message.AddressToASCII(recipient)
+			if err != nil {
+				return "", nil, nil, fmt.Errorf("invalid recipient: %w", err)
+			}
+
+			asciiRecipients = append(asciiRecipients, asciiRecipient)
+		}
+
+		recipients = asciiRecipients
+	}
+
+	if !message.Is8Bit(data) || (supportsSMTPUTF8 && supports8BitMIME) {
+		return from, recipients, data, nil
+	}
+
+	parsed, err := message.Parse(data)
+	if err != nil {
+		if supports8BitMIME {
+			// nothing in the header can be identified for conversion, let the outgoing server decide
+			return from, recipients, data, nil
+		}
+
+		return "", nil, nil, fmt.Errorf("unable to downgrade 8-bit message: %w", err)
+	}
+
+	if !supportsSMTPUTF8 && parsed.HasNonASCIIHeaders() {
+		if err = parsed.DowngradeHeaders(); err != nil {
+			return "", nil, nil, fmt.Errorf("unable to downgrade message headers: %w", err)
+		}
+
+		log.Debugw("non-ASCII headers encoded for outgoing server without SMTPUTF8", log.Fields{"from": from})
+	}
+
+	if !supports8BitMIME {
+		if err = parsed.Downgrade8Bit(); err != nil {
+			return "", nil, nil, fmt.Errorf("unable to downgrade message body: %w", err)
+		}
+
+		log.Debugw("8-bit body re-encoded for outgoing server without 8BITMIME", log.Fields{"from": from})
+	}
+
+	return from, recipients, parsed.Bytes(), nil
+}
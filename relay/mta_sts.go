@@ -0,0 +1,248 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MTASTSModeEnforce = "enforce"
+	MTASTSModeTesting = "testing"
+	MTASTSModeNone    = "none"
+
+	mtaSTSMaxPolicySize = 64 * 1024
+	mtaSTSMaxAge        = 31557600
+)
+
+var (
+	ErrMTASTSPolicyInvalid = errors.New("invalid MTA-STS policy")
+	ErrMTASTSPolicyFetch   = errors.New("error fetching MTA-STS policy")
+)
+
+// TXTResolver looks up TXT records, *net.Resolver satisfies it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// PolicyFetcher downloads MTA-STS policy file for given policy domain.
+type PolicyFetcher interface {
+	FetchPolicy(ctx context.Context, domain string) ([]byte, error)
+}
+
+type HTTPSPolicyFetcher struct {
+	Client *http.Client
+}
+
+type MTASTSPolicy struct {
+	ID      string
+	Mode    string
+	MX      []string
+	MaxAge  time.Duration
+	Expires time.Time
+}
+
+type mtaSTSCache struct {
+	mutex    sync.Mutex
+	policies map[string]*MTASTSPolicy
+}
+
+func NewHTTPSPolicyFetcher(timeout time.Duration) *HTTPSPolicyFetcher {
+	return &HTTPSPolicyFetcher{
+		Client: &http.Client{
+			Timeout: timeout,
+			// RFC 8461 section 3.3: HTTP 3xx redirects MUST NOT be followed
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (hpf *HTTPSPolicyFetcher) FetchPolicy(ctx context.Context, domain string) ([]byte, error) {
+	url := fmt.Sprintf("https://mta-sts.%s/.well-known/mta-sts.txt", domain)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMTASTSPolicyFetch, err.Error())
+	}
+
+	response, err := hpf.Client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMTASTSPolicyFetch, err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrMTASTSPolicyFetch, response.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, mtaSTSMaxPolicySize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMTASTSPolicyFetch, err.Error())
+	}
+
+	return body, nil
+}
+
+// ParseMTASTSPolicy parses policy file body, as described in RFC 8461 section 3.2.
+func ParseMTASTSPolicy(body []byte) (*MTASTSPolicy, error) {
+	var version string
+
+	policy := &MTASTSPolicy{MX: make([]string, 0)}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+
+	for scanner.Scan() {
+		key, value, found := cutString(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "version":
+			version = value
+		case "mode":
+			policy.Mode = value
+		case "mx":
+			policy.MX = append(policy.MX, strings.ToLower(value))
+		case "max_age":
+			maxAge, err := strconv.Atoi(value)
+			if err != nil || maxAge < 0 || maxAge > mtaSTSMaxAge {
+				return nil, fmt.Errorf("%w: invalid max_age `%s`", ErrMTASTSPolicyInvalid, value)
+			}
+
+			policy.MaxAge = time.Duration(maxAge) * time.Second
+		}
+	}
+
+	if version != "STSv1" {
+		return nil, fmt.Errorf("%w: unsupported version `%s`", ErrMTASTSPolicyInvalid, version)
+	}
+
+	if policy.Mode != MTASTSModeEnforce && policy.Mode != MTASTSModeTesting && policy.Mode != MTASTSModeNone {
+		return nil, fmt.Errorf("%w: invalid mode `%s`", ErrMTASTSPolicyInvalid, policy.Mode)
+	}
+
+	if policy.Mode != MTASTSModeNone && len(policy.MX) == 0 {
+		return nil, fmt.Errorf("%w: missing mx", ErrMTASTSPolicyInvalid)
+	}
+
+	return policy, nil
+}
+
+// MatchesMX checks if host is covered by one of policy mx patterns. Wildcard matches only the leftmost label.
+func (p *MTASTSPolicy) MatchesMX(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, pattern := range p.MX {
+		if pattern == host {
+			return true
+		}
+
+		if strings.HasPrefix(pattern, "*.") {
+			if dot := strings.IndexByte(host, '.'); dot > 0 && host[dot:] == pattern[1:] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// lookupMTASTS returns MTA-STS policy for the domain, using the cached one while the policy id in DNS is unchanged.
+// When DNS or HTTPS lookup fails, a not yet expired cached policy is used, as RFC 8461 section 5.1 requires.
+// Domains without any policy return nil.
+func (tp *TLSPolicy) lookupMTASTS(ctx context.Context, domain string) (*MTASTSPolicy, error) {
+	cached := tp.stsCache.get(domain)
+
+	id, err := tp.lookupMTASTSID(ctx, domain)
+	if err != nil || id == "" {
+		return cached, nil
+	}
+
+	if cached != nil && cached.ID == id {
+		return cached, nil
+	}
+
+	body, err := tp.PolicyFetcher.FetchPolicy(ctx, domain)
+	if err != nil {
+		if cached != nil {
+			return cached, nil
+		}
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	policy, err := ParseMTASTSPolicy(body)
+	if err != nil {
+		if cached != nil {
+			return cached, nil
+		}
+
+		return nil, err
+	}
+
+	policy.ID = id
+	policy.Expires = time.Now().Add(policy.MaxAge)
+	tp.stsCache.set(domain, policy)
+
+	return policy, nil
+}
+
+func (tp *TLSPolicy) lookupMTASTSID(ctx context.Context, domain string) (string, error) {
+	records, err := tp.TXTResolver.LookupTXT(ctx, "_mta-sts."+domain)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+
+	for _, record := range records {
+		if !strings.HasPrefix(record, "v=STSv1;") {
+			continue
+		}
+
+		for _, field := range strings.Split(record, ";") {
+			if key, value, found := cutString(strings.TrimSpace(field), "="); found && key == "id" {
+				return value, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func (msc *mtaSTSCache) get(domain string) *MTASTSPolicy {
+	msc.mutex.Lock()
+	defer msc.mutex.Unlock()
+
+	policy, ok := msc.policies[domain]
+	if !ok || time.Now().After(policy.Expires) {
+		return nil
+	}
+
+	return policy
+}
+
+func (msc *mtaSTSCache) set(domain string, policy *MTASTSPolicy) {
+	msc.mutex.Lock()
+	defer msc.mutex.Unlock()
+
+	msc.policies[domain] = policy
+}
+
+func cutString(data, separator string) (string, string, bool) {
+	if i := strings.Index(data, separator); i >= 0 {
+		return data[:i], data[i+len(separator):], true
+	}
+
+	return data, "", false
+}
@@ -0,0 +1,53 @@
+package relay_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/relay"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMTASTSPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := relay.ParseMTASTSPolicy([]byte(
+		"version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmx: *.Example.NET\r\nmax_age: 604800\r\n",
+	))
+	assert.NoError(t, err)
+
+	assert.Equal(t, relay.MTASTSModeEnforce, policy.Mode)
+	assert.Equal(t, []string{"mail.example.com", "*.example.net"}, policy.MX)
+	assert.Equal(t, 7*24*time.Hour, policy.MaxAge)
+}
+
+func TestParseInvalidMTASTSPolicy(t *testing.T) {
+	t.Parallel()
+
+	invalidPolicies := []string{
+		"mode: enforce\nmx: mail.example.com\nmax_age: 86400\n",
+		"version: STSv2\nmode: enforce\nmx: mail.example.com\nmax_age: 86400\n",
+		"version: STSv1\nmode: strict\nmx: mail.example.com\nmax_age: 86400\n",
+		"version: STSv1\nmode: enforce\nmax_age: 86400\n",
+		"version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: forever\n",
+	}
+
+	for _, body := range invalidPolicies {
+		_, err := relay.ParseMTASTSPolicy([]byte(body))
+		assert.True(t, errors.Is(err, relay.ErrMTASTSPolicyInvalid), body)
+	}
+}
+
+func TestMTASTSPolicyMatchesMX(t *testing.T) {
+	t.Parallel()
+
+	policy := &relay.MTASTSPolicy{MX: []string{"mail.example.com", "*.example.net"}}
+
+	assert.True(t, policy.MatchesMX("mail.example.com"))
+	assert.True(t, policy.MatchesMX("MAIL.example.com."))
+	assert.True(t, policy.MatchesMX("mx1.example.net"))
+	assert.False(t, policy.MatchesMX("example.net"))
+	assert.False(t, policy.MatchesMX("a.mx1.example.net"))
+	assert.False(t, policy.MatchesMX("mail.example.org"))
+}
@@ -2,6 +2,7 @@ package relay
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/smtp"
 
@@ -23,6 +24,7 @@ type OutgoingServer struct {
 	Port           int
 	Username       string
 	VerifyTLS      bool
+	TLSPolicy      *TLSPolicy
 }
 
 func NewOutgoingServer(conf config.RelayOutgoingServer) (*OutgoingServer, error) {
@@ -35,84 +37,178 @@ func NewOutgoingServer(conf config.RelayOutgoingServer) (*OutgoingServer, error)
 		Port:           conf.Port,
 		Username:       conf.Username,
 		VerifyTLS:      conf.VerifyTLS,
+		TLSPolicy:      NewTLSPolicy(conf.TLSPolicy),
 	}, nil
 }
 
+// Send delivers the message, failing when any of recipients is refused by the outgoing server.
 func (ros *OutgoingServer) Send(from string, recipients []string, message []byte) error {
+	_, err := ros.deliver(from, recipients, message, false)
+
+	return err
+}
+
+// SendEach delivers the message to recipients accepted by the outgoing server, returning the result for each
+// recipient (nil when delivered). Error is returned when the message could not be sent at all.
+func (ros *OutgoingServer) SendEach(from string, recipients []string, message []byte) ([]error, error) {
+	return ros.deliver(from, recipients, message, true)
+}
+
+func (ros *OutgoingServer) deliver(from string, recipients []string, message []byte, partial bool) ([]error, error) {
 	if ros.FromEmail != "" {
 		from = ros.FromEmail
 	}
 
-	if ros.ConnectionType == config.ConnectionPlain {
-		return ros.sendPlain(from, recipients, message)
+	requirements, err := ros.TLSPolicy.Evaluate(ros.Host, ros.Port, recipients)
+	if err != nil {
+		return nil, fmt.Errorf("outgoing smtp error: %w", err)
 	}
 
-	return ros.sendTLS(from, recipients, message)
-}
+	client, err := ros.buildClient(requirements)
+	if err != nil {
+		var policyErr *tlsPolicyError
+		if errors.As(err, &policyErr) {
+			ros.TLSPolicy.report.failure(ros.Host, policyErr.ResultType, requirements.Policies, policyErr.Err)
+		}
 
-func (ros *OutgoingServer) sendTLS(from string, recipients []string, message []byte) error { // nolint:cyclop
-	var (
-		client *smtp.Client
-		err    error
-	)
+		return nil, err
+	}
 
-	auth := ros.buildAuth()
+	defer client.Close()
 
-	client, err = ros.buildTLSClient()
-	if err != nil {
-		return fmt.Errorf("outgoing smtp error: %w", err)
+	if requirements.active() {
+		ros.TLSPolicy.report.success(ros.Host, requirements.Policies)
 	}
 
+	return ros.send(client, from, recipients, message, partial)
+}
+
+// send performs the transaction. With partial, refused recipients are reported in results, and the message is still
+// sent to the accepted ones, otherwise the first refused recipient fails the whole transaction.
+//
+//nolint:cyclop
+func (ros *OutgoingServer) send(
+	client *smtp.Client, from string, recipients []string, message []byte, partial bool,
+) ([]error, error) {
+	var err error
+
 	// Auth
-	if auth != nil {
+	if auth := ros.buildAuth(); auth != nil {
 		if err = client.Auth(auth); err != nil {
-			return fmt.Errorf("outgoing smtp error: %w", err)
+			return nil, fmt.Errorf("outgoing smtp error: %w", err)
 		}
 	}
 
+	from, recipients, message, err = prepareInternational(client, from, recipients, message)
+	if err != nil {
+		return nil, fmt.Errorf("outgoing smtp error: %w", err)
+	}
+
 	// To && From
 	if err = client.Mail(from); err != nil {
-		return fmt.Errorf("outgoing smtp error: %w", err)
+		return nil, fmt.Errorf("outgoing smtp error: %w", err)
 	}
 
-	for _, rcpt := range recipients {
+	results := make([]error, len(recipients))
+	accepted := 0
+
+	for i, rcpt := range recipients {
 		if err = client.Rcpt(rcpt); err != nil {
-			return fmt.Errorf("outgoing smtp error: %w", err)
+			if !partial {
+				return nil, fmt.Errorf("outgoing smtp error: %w", err)
+			}
+
+			results[i] = fmt.Errorf("outgoing smtp error: %w", err)
+
+			continue
 		}
+
+		accepted++
+	}
+
+	// nothing to send, connection is closed anyway
+	if accepted == 0 {
+		_ = client.Quit()
+
+		return results, nil
 	}
 
 	// Data
 	writer, err := client.Data()
 	if err != nil {
-		return fmt.Errorf("outgoing smtp error: %w", err)
+		return nil, fmt.Errorf("outgoing smtp error: %w", err)
 	}
 
 	_, err = writer.Write(message)
 	if err != nil {
-		return fmt.Errorf("outgoing smtp error: %w", err)
+		return nil, fmt.Errorf("outgoing smtp error: %w", err)
 	}
 
 	err = writer.Close()
 	if err != nil {
-		return fmt.Errorf("outgoing smtp error: %w", err)
+		return nil, fmt.Errorf("outgoing smtp error: %w", err)
 	}
 
 	err = client.Quit()
 	if err != nil {
-		return fmt.Errorf("outgoing smtp error: %w", err)
+		return nil, fmt.Errorf("outgoing smtp error: %w", err)
 	}
 
-	return nil
+	return results, nil
 }
 
-func (ros *OutgoingServer) buildTLSClient() (client *smtp.Client, err error) {
-	var conn *tls.Conn
+func (ros *OutgoingServer) buildClient(requirements *tlsRequirements) (*smtp.Client, error) {
+	if ros.ConnectionType != config.ConnectionPlain {
+		return ros.buildTLSClient(requirements)
+	}
+
+	client, err := smtp.Dial(fmt.Sprintf("%s:%d", ros.Host, ros.Port))
+	if err != nil {
+		return nil, fmt.Errorf("outgoing smtp error: %w", err)
+	}
+
+	// opportunistic STARTTLS, the same way smtp.SendMail does it
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(ros.buildTLSConfig(requirements)); err != nil {
+			client.Close()
+
+			return nil, fmt.Errorf("outgoing starttls error: %w", err)
+		}
+	} else if requirements.RequireTLS {
+		// policy says no TLS means no delivery, never fall back to plain text
+		client.Close()
+
+		return nil, fmt.Errorf(
+			"outgoing starttls error: %w", &tlsPolicyError{ResultType: ResultSTARTTLSNotSupported, Err: ErrTLSRequired},
+		)
+	}
+
+	return client, nil
+}
 
-	tlsconfig := &tls.Config{
+// buildTLSConfig verifies certificates according to verify_tls. When a TLS policy applies, the verification
+// is done by the policy instead, regardless of verify_tls.
+func (ros *OutgoingServer) buildTLSConfig(requirements *tlsRequirements) *tls.Config {
+	tlsConfig := &tls.Config{
 		InsecureSkipVerify: !ros.VerifyTLS, //nolint:gosec
 		ServerName:         ros.Host,
 	}
 
+	if requirements.active() {
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			return requirements.verifyConnection(state, ros.Host, ros.TLSPolicy.RootCAs, ros.VerifyTLS)
+		}
+	}
+
+	return tlsConfig
+}
+
+func (ros *OutgoingServer) buildTLSClient(requirements *tlsRequirements) (client *smtp.Client, err error) {
+	var conn *tls.Conn
+
+	tlsconfig := ros.buildTLSConfig(requirements)
+
 	if ros.ConnectionType == config.ConnectionStartTLS {
 		client, err = smtp.Dial(fmt.Sprintf("%s:%d", ros.Host, ros.Port))
 		if err != nil {
@@ -140,17 +236,6 @@ func (ros *OutgoingServer) buildTLSClient() (client *smtp.Client, err error) {
 	return client, nil
 }
 
-func (ros *OutgoingServer) sendPlain(from string, recipients []string, message []byte) error {
-	auth := ros.buildAuth()
-
-	err := smtp.SendMail(fmt.Sprintf("%s:%d", ros.Host, ros.Port), auth, from, recipients, message)
-	if err != nil {
-		return fmt.Errorf("error sending email via outgoing server: %w", err)
-	}
-
-	return nil
-}
-
 func (ros *OutgoingServer) buildAuth() smtp.Auth {
 	if ros.AuthMethod == config.AuthPlain {
 		return smtp.PlainAuth("", ros.Username, ros.Password, ros.Host)
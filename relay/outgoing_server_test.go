@@ -3,6 +3,7 @@ package relay_test
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/ajgon/mailbowl/config"
 	"github.com/ajgon/mailbowl/listener/smtp"
+	"github.com/ajgon/mailbowl/message"
 	"github.com/ajgon/mailbowl/relay"
 	"github.com/chrj/smtpd"
 	"github.com/stretchr/testify/assert"
@@ -42,6 +44,7 @@ type SMTPTestServer struct {
 	Recipients []string
 	Message    string
 	TLS        *tls.Config
+	SMTPUTF8   bool
 }
 
 func NewSMTPTestServer() *SMTPTestServer {
@@ -75,7 +78,8 @@ func (sts *SMTPTestServer) Serve(ctx context.Context, connectionType string) {
 	var listener net.Listener
 
 	smtpdServer := &smtpd.Server{
-		Hostname: "127.0.0.1",
+		Hostname:       "127.0.0.1",
+		EnableSMTPUTF8: sts.SMTPUTF8,
 
 		Handler: func(_peer smtpd.Peer, envelope smtpd.Envelope) error {
 			sts.Sender = envelope.Sender
@@ -209,3 +213,69 @@ func TestSendTLSWithCustomFrom(t *testing.T) {
 	assert.Equal(t, []string{"to@example.local"}, testSMTPServer.Recipients)
 	assert.Equal(t, "test message\n", testSMTPServer.Message)
 }
+
+func TestSendInternationalWithSMTPUTF8(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	testSMTPServer := NewSMTPTestServer()
+	testSMTPServer.SMTPUTF8 = true
+	go testSMTPServer.Serve(ctx, "plain")
+
+	outgoingServer, err := relay.NewOutgoingServer(config.RelayOutgoingServer{
+		AuthMethod:     config.AuthNone,
+		ConnectionType: config.ConnectionPlain,
+		Host:           "127.0.0.1",
+		Port:           testSMTPServer.Port,
+	})
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond) // allow server to start
+
+	err = outgoingServer.Send(
+		"用户@例子.广告", []string{"jose@bücher.de"}, []byte("Subject: Grüße\n\nżółć"),
+	)
+	assert.NoError(t, err)
+
+	cancel()
+
+	assert.Equal(t, "用户@例子.广告", testSMTPServer.Sender)
+	assert.Equal(t, []string{"jose@bücher.de"}, testSMTPServer.Recipients)
+	assert.Equal(t, "Subject: Grüße\n\nżółć\n", testSMTPServer.Message)
+}
+
+func TestSendInternationalWithoutSMTPUTF8(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	testSMTPServer := NewSMTPTestServer()
+	go testSMTPServer.Serve(ctx, "plain")
+
+	outgoingServer, err := relay.NewOutgoingServer(config.RelayOutgoingServer{
+		AuthMethod:     config.AuthNone,
+		ConnectionType: config.ConnectionPlain,
+		Host:           "127.0.0.1",
+		Port:           testSMTPServer.Port,
+	})
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond) // allow server to start
+
+	err = outgoingServer.Send(
+		"from@example.local", []string{"jose@bücher.de"}, []byte("To: Józef <jose@bücher.de>\nSubject: Grüße\n\nżółć"),
+	)
+	assert.NoError(t, err)
+
+	err = outgoingServer.Send("用户@例子.广告", []string{"to@example.local"}, []byte("Subject: test\n\ntest"))
+	assert.True(t, errors.Is(err, message.ErrSMTPUTF8Required))
+
+	cancel()
+
+	assert.Equal(t, "from@example.local", testSMTPServer.Sender)
+	assert.Equal(t, []string{"jose@xn--bcher-kva.de"}, testSMTPServer.Recipients)
+	assert.Equal(
+		t,
+		"To: =?utf-8?q?J=C3=B3zef?= <jose@xn--bcher-kva.de>\nSubject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\n\nżółć\n",
+		testSMTPServer.Message,
+	)
+}
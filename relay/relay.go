@@ -2,11 +2,30 @@ package relay
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/Masterminds/log-go"
 	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/encryption"
 )
 
+// PartialDeliveryError is returned by Handle, when the message was delivered to some of the recipients only.
+type PartialDeliveryError struct {
+	Delivered []string
+	Failed    []string
+	Err       error
+}
+
+func (e *PartialDeliveryError) Error() string {
+	return fmt.Sprintf("delivered to %s only: %s", strings.Join(e.Delivered, ", "), e.Err.Error())
+}
+
+func (e *PartialDeliveryError) Unwrap() error {
+	return e.Err
+}
+
 type Relay struct {
+	Encryptor      *encryption.Encryptor
 	OutgoingServer *OutgoingServer
 }
 
@@ -16,16 +35,138 @@ func NewRelay(conf config.Relay) (*Relay, error) {
 		return nil, fmt.Errorf("error configuring outgoing server: %w", err)
 	}
 
+	encryptor, err := encryption.NewEncryptor(conf.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring encryption: %w", err)
+	}
+
 	return &Relay{
+		Encryptor:      encryptor,
 		OutgoingServer: outgoingServer,
 	}, nil
 }
 
+// Enabled tells whether outgoing server is configured. Without it, accepted messages are dropped.
+func (r *Relay) Enabled() bool {
+	return r != nil && r.OutgoingServer.Host != ""
+}
+
+// Handle sends the message to all recipients. Recipients with known keys get encrypted copies, each sent in
+// a separate transaction. All copies are encrypted before anything is sent, when sending fails after some of them
+// were delivered, PartialDeliveryError tells which recipients got the message.
 func (r *Relay) Handle(from string, recipients []string, message []byte) error {
-	err := r.OutgoingServer.Send(from, recipients, message)
+	batches, err := r.Encryptor.Split(recipients)
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
+	copies := make([][]byte, 0, len(batches))
+
+	for _, batch := range batches {
+		data, err := r.Encryptor.Encrypt(batch, message)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		if batch.Method != encryption.MethodNone {
+			log.Debugw("message encrypted", log.Fields{"to": batch.Recipients, "method": batch.Method})
+		}
+
+		copies = append(copies, data)
+	}
+
+	delivered := make([]string, 0, len(recipients))
+
+	for i, batch := range batches {
+		if err = r.OutgoingServer.Send(from, batch.Recipients, copies[i]); err == nil {
+			delivered = append(delivered, batch.Recipients...)
+
+			continue
+		}
+
+		if len(delivered) == 0 {
+			return fmt.Errorf("%w", err)
+		}
+
+		failed := make([]string, 0, len(recipients)-len(delivered))
+		for _, remaining := range batches[i:] {
+			failed = append(failed, remaining.Recipients...)
+		}
+
+		return &PartialDeliveryError{Delivered: delivered, Failed: failed, Err: err}
+	}
+
 	return nil
 }
+
+// HandleEach works like Handle, but recipients which can't be delivered to (i.e. refused by the outgoing server, or
+// without a required encryption key) don't fail the others. Result is returned for each recipient (nil when
+// delivered), in the order of recipients.
+func (r *Relay) HandleEach(from string, recipients []string, message []byte) []error {
+	results := make(map[string]error, len(recipients))
+	deliverable := make([]string, 0, len(recipients))
+
+	for _, recipient := range recipients {
+		if _, err := r.Encryptor.Split([]string{recipient}); err != nil {
+			results[recipient] = fmt.Errorf("%w", err)
+
+			continue
+		}
+
+		deliverable = append(deliverable, recipient)
+	}
+
+	if len(deliverable) > 0 {
+		batches, err := r.Encryptor.Split(deliverable)
+		if err != nil {
+			batches = nil
+
+			for _, recipient := range deliverable {
+				results[recipient] = fmt.Errorf("%w", err)
+			}
+		}
+
+		for _, batch := range batches {
+			for recipient, result := range r.handleBatch(from, batch, message) {
+				results[recipient] = result
+			}
+		}
+	}
+
+	each := make([]error, 0, len(recipients))
+	for _, recipient := range recipients {
+		each = append(each, results[recipient])
+	}
+
+	return each
+}
+
+func (r *Relay) handleBatch(from string, batch *encryption.Batch, message []byte) map[string]error {
+	results := make(map[string]error, len(batch.Recipients))
+
+	data, err := r.Encryptor.Encrypt(batch, message)
+	if err != nil {
+		for _, recipient := range batch.Recipients {
+			results[recipient] = fmt.Errorf("%w", err)
+		}
+
+		return results
+	}
+
+	if batch.Method != encryption.MethodNone {
+		log.Debugw("message encrypted", log.Fields{"to": batch.Recipients, "method": batch.Method})
+	}
+
+	sent, err := r.OutgoingServer.SendEach(from, batch.Recipients, data)
+
+	for i, recipient := range batch.Recipients {
+		switch {
+		case err != nil:
+			results[recipient] = fmt.Errorf("%w", err)
+		case i < len(sent):
+			results[recipient] = sent[i]
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,86 @@
+package relay_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/relay"
+	"github.com/chrj/smtpd"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/openpgp"       //nolint:staticcheck
+	"golang.org/x/crypto/openpgp/armor" //nolint:staticcheck
+)
+
+func pgpPublicKey(t *testing.T, email string) string {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity("Test", "", email, nil)
+	assert.NoError(t, err)
+
+	var buffer bytes.Buffer
+
+	writer, err := armor.Encode(&buffer, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.Serialize(writer))
+	assert.NoError(t, writer.Close())
+
+	return buffer.String()
+}
+
+func TestHandleReportsPartialDelivery(t *testing.T) {
+	t.Parallel()
+
+	port := randomPort()
+	delivered := make([]string, 0)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.NoError(t, err)
+
+	server := &smtpd.Server{
+		Hostname: "127.0.0.1",
+		Handler: func(_ smtpd.Peer, envelope smtpd.Envelope) error {
+			if envelope.Recipients[0] == "bob@example.local" {
+				return smtpd.Error{Code: 550, Message: "mailbox unavailable"}
+			}
+
+			delivered = append(delivered, envelope.Recipients...)
+
+			return nil
+		},
+	}
+
+	go func() { _ = server.Serve(listener) }()
+	defer server.Shutdown(true) //nolint: errcheck
+
+	time.Sleep(100 * time.Millisecond) // allow server to start
+
+	handler, err := relay.NewRelay(config.Relay{
+		Encryption: config.RelayEncryption{
+			Policy: config.EncryptionOpportunistic,
+			Keys: []config.RelayEncryptionKey{
+				{Email: "bob@example.local", Type: config.KeyPGP, Key: pgpPublicKey(t, "bob@example.local")},
+			},
+		},
+		OutgoingServer: config.RelayOutgoingServer{
+			Host: "127.0.0.1", Port: port, ConnectionType: config.ConnectionPlain, AuthMethod: config.AuthNone,
+		},
+	})
+	assert.NoError(t, err)
+
+	err = handler.Handle(
+		"sender@example.local", []string{"alice@example.local", "bob@example.local"},
+		[]byte("From: sender@example.local\r\nSubject: test\r\n\r\nbody\r\n"),
+	)
+
+	var partial *relay.PartialDeliveryError
+
+	assert.True(t, errors.As(err, &partial))
+	assert.Equal(t, []string{"alice@example.local"}, partial.Delivered)
+	assert.Equal(t, []string{"bob@example.local"}, partial.Failed)
+	assert.Equal(t, []string{"alice@example.local"}, delivered)
+}
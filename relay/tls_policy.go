@@ -0,0 +1,388 @@
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/message"
+)
+
+// Policy and result types, as used in TLS-RPT reports (RFC 8460 section 4.3).
+const (
+	PolicyTypeSTS  = "sts"
+	PolicyTypeTLSA = "tlsa"
+
+	ResultSTARTTLSNotSupported    = "starttls-not-supported"
+	ResultCertificateHostMismatch = "certificate-host-mismatch"
+	ResultCertificateExpired      = "certificate-expired"
+	ResultCertificateNotTrusted   = "certificate-not-trusted"
+	ResultValidationFailure       = "validation-failure"
+	ResultDNSSECInvalid           = "dnssec-invalid"
+	ResultSTSPolicyFetchError     = "sts-policy-fetch-error"
+	ResultSTSPolicyInvalid        = "sts-policy-invalid"
+)
+
+var (
+	ErrTLSPolicy         = errors.New("tls policy violation")
+	ErrTLSPolicyLookup   = errors.New("tls policy lookup failed")
+	ErrTLSRequired       = errors.New("tls required by policy, but not supported by outgoing server")
+	ErrMXNotAllowed      = errors.New("outgoing server is not allowed by MTA-STS policy")
+	ErrCertificateNoPeer = errors.New("no peer certificates")
+)
+
+// MXResolver looks up MX records, *net.Resolver satisfies it.
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// TLSPolicy decides, per delivery, how the TLS connection to outgoing server has to be secured. MTA-STS policies
+// are looked up for every recipient domain, for which the outgoing server is one of MX hosts (direct delivery).
+// Policies of recipient domains don't apply to smarthosts, which are verified by their own TLSA records (DANE)
+// and verify_tls. When none of them applies, the connection is verified according to verify_tls, as before.
+type TLSPolicy struct {
+	MTASTS  bool
+	DANE    bool
+	Timeout time.Duration
+
+	MXResolver    MXResolver
+	TXTResolver   TXTResolver
+	TLSAResolver  TLSAResolver
+	PolicyFetcher PolicyFetcher
+	RootCAs       *x509.CertPool
+
+	stsCache *mtaSTSCache
+	report   *tlsReport
+}
+
+type appliedPolicy struct {
+	Type   string
+	Domain string
+}
+
+// tlsRequirements is the outcome of policy evaluation for a single delivery.
+type tlsRequirements struct {
+	RequireTLS  bool
+	RequirePKIX bool
+	TLSA        []TLSARecord
+	Policies    []appliedPolicy
+}
+
+// tlsPolicyError carries TLS-RPT result type of the failure.
+type tlsPolicyError struct {
+	ResultType string
+	Err        error
+}
+
+func NewTLSPolicy(conf config.RelayTLSPolicy) *TLSPolicy {
+	return &TLSPolicy{
+		MTASTS:        conf.MTASTS,
+		DANE:          conf.DANE,
+		Timeout:       conf.Timeout,
+		MXResolver:    net.DefaultResolver,
+		TXTResolver:   net.DefaultResolver,
+		TLSAResolver:  NewDNSResolver(conf.DNSServer),
+		PolicyFetcher: NewHTTPSPolicyFetcher(conf.Timeout),
+		stsCache:      &mtaSTSCache{policies: make(map[string]*MTASTSPolicy)},
+		report:        &tlsReport{counts: make(map[string]*tlsReportCounts)},
+	}
+}
+
+func (e *tlsPolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.ResultType, e.Err.Error())
+}
+
+func (e *tlsPolicyError) Unwrap() error {
+	return e.Err
+}
+
+func (tp *TLSPolicy) Enabled() bool {
+	return tp != nil && (tp.MTASTS || tp.DANE)
+}
+
+// Evaluate gathers MTA-STS policies of recipient domains delivered directly and TLSA records of the outgoing server.
+// An error is returned when a policy in enforce mode forbids delivery through this server at all.
+//
+//nolint:cyclop
+func (tp *TLSPolicy) Evaluate(host string, port int, recipients []string) (*tlsRequirements, error) {
+	requirements := &tlsRequirements{Policies: make([]appliedPolicy, 0)}
+
+	if !tp.Enabled() {
+		return requirements, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tp.Timeout)
+	defer cancel()
+
+	if tp.MTASTS {
+		for _, domain := range recipientDomains(recipients) {
+			direct, err := tp.directDelivery(ctx, host, domain)
+			if err != nil {
+				return nil, err
+			}
+
+			if !direct {
+				continue
+			}
+
+			policy, err := tp.lookupMTASTS(ctx, domain)
+			if err != nil {
+				resultType := ResultSTSPolicyFetchError
+				if errors.Is(err, ErrMTASTSPolicyInvalid) {
+					resultType = ResultSTSPolicyInvalid
+				}
+
+				// without a cached policy, the domain is treated as having none (RFC 8461 section 5.1)
+				tp.report.failure(host, resultType, []appliedPolicy{{Type: PolicyTypeSTS, Domain: domain}}, err)
+
+				continue
+			}
+
+			if policy == nil || policy.Mode == MTASTSModeNone {
+				continue
+			}
+
+			applied := appliedPolicy{Type: PolicyTypeSTS, Domain: domain}
+
+			if !policy.MatchesMX(host) {
+				tp.report.failure(host, ResultValidationFailure, []appliedPolicy{applied}, ErrMXNotAllowed)
+
+				if policy.Mode == MTASTSModeEnforce {
+					return nil, fmt.Errorf("%w: %s (%s)", ErrTLSPolicy, ErrMXNotAllowed.Error(), domain)
+				}
+
+				continue
+			}
+
+			if policy.Mode == MTASTSModeEnforce {
+				requirements.RequireTLS = true
+				requirements.RequirePKIX = true
+				requirements.Policies = append(requirements.Policies, applied)
+			}
+		}
+	}
+
+	if tp.DANE {
+		records, err := tp.lookupTLSA(ctx, host, port)
+		if err != nil {
+			tp.report.failure(host, ResultDNSSECInvalid, []appliedPolicy{{Type: PolicyTypeTLSA, Domain: host}}, err)
+
+			// RFC 7672 section 2.2: a failed TLSA lookup must not be treated as absence of records, delivery is deferred
+			return nil, fmt.Errorf("%w: TLSA of %s: %s", ErrTLSPolicyLookup, host, err.Error())
+		}
+
+		if len(records) > 0 {
+			requirements.RequireTLS = true
+			requirements.TLSA = records
+			requirements.Policies = append(requirements.Policies, appliedPolicy{Type: PolicyTypeTLSA, Domain: host})
+		}
+	}
+
+	return requirements, nil
+}
+
+// active returns true when at least one policy applies to the delivery, otherwise verify_tls alone decides.
+func (tr *tlsRequirements) active() bool {
+	return len(tr.Policies) > 0
+}
+
+// verifyConnection checks peer certificates against the requirements. DANE, when present, takes precedence over
+// PKIX validation. Falls back to regular verification when verifyTLS is set.
+func (tr *tlsRequirements) verifyConnection(
+	state tls.ConnectionState, host string, roots *x509.CertPool, verifyTLS bool,
+) error {
+	if len(state.PeerCertificates) == 0 {
+		return &tlsPolicyError{ResultType: ResultValidationFailure, Err: ErrCertificateNoPeer}
+	}
+
+	if len(tr.TLSA) > 0 {
+		return verifyDANE(state.PeerCertificates, tr.TLSA, host)
+	}
+
+	if !tr.RequirePKIX && !verifyTLS {
+		return nil
+	}
+
+	return verifyPKIX(state.PeerCertificates, host, roots)
+}
+
+func verifyPKIX(certificates []*x509.Certificate, host string, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+
+	_, err := certificates[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err == nil {
+		return nil
+	}
+
+	var (
+		hostnameErr x509.HostnameError
+		invalidErr  x509.CertificateInvalidError
+		unknownErr  x509.UnknownAuthorityError
+	)
+
+	switch {
+	case errors.As(err, &hostnameErr):
+		return &tlsPolicyError{ResultType: ResultCertificateHostMismatch, Err: err}
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return &tlsPolicyError{ResultType: ResultCertificateExpired, Err: err}
+	case errors.As(err, &unknownErr):
+		return &tlsPolicyError{ResultType: ResultCertificateNotTrusted, Err: err}
+	}
+
+	return &tlsPolicyError{ResultType: ResultValidationFailure, Err: err}
+}
+
+// directDelivery checks if host is one of MX hosts of the domain. Otherwise it is a smarthost, which relays
+// the message further, and the policy of the domain applies to that hop, not this one. Domain without MX records
+// is its own MX (RFC 5321 section 5.1). When MX hosts can't be looked up, it's unknown which policy applies,
+// so an error is returned and delivery has to be retried later.
+func (tp *TLSPolicy) directDelivery(ctx context.Context, host, domain string) (bool, error) {
+	records, err := tp.MXResolver.LookupMX(ctx, domain)
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		records, err = []*net.MX{}, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("%w: MX of %s: %s", ErrTLSPolicyLookup, domain, err.Error())
+	}
+
+	if len(records) == 0 {
+		records = []*net.MX{{Host: domain}}
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	for _, record := range records {
+		if strings.TrimSuffix(strings.ToLower(record.Host), ".") == host {
+			return true, nil
+		}
+	}
+
+	log.Debugw("outgoing server is not an MX of recipient domain, MTA-STS policy skipped", log.Fields{
+		"domain": domain, "host": host,
+	})
+
+	return false, nil
+}
+
+func (tp *TLSPolicy) lookupTLSA(ctx context.Context, host string, port int) ([]TLSARecord, error) {
+	// TLSA records for IP literals make no sense
+	if net.ParseIP(host) != nil {
+		return []TLSARecord{}, nil
+	}
+
+	records, secure, err := tp.TLSAResolver.LookupTLSA(ctx, fmt.Sprintf("_%d._tcp.%s", port, host))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	// records not validated with DNSSEC are ignored, as if they were never published
+	if !secure {
+		return []TLSARecord{}, nil
+	}
+
+	return usableTLSARecords(records), nil
+}
+
+func recipientDomains(recipients []string) []string {
+	seen := make(map[string]bool)
+	domains := make([]string, 0, len(recipients))
+
+	for _, recipient := range recipients {
+		at := strings.LastIndex(recipient, "@")
+		if at < 0 {
+			continue
+		}
+
+		domain, err := message.DomainToASCII(strings.ToLower(recipient[at+1:]))
+		if err != nil || seen[domain] {
+			continue
+		}
+
+		seen[domain] = true
+		domains = append(domains, domain)
+	}
+
+	return domains
+}
+
+// tlsReport keeps per policy session counters and logs TLS-RPT style summaries, so failures can be correlated with
+// reports received from other parties.
+type tlsReport struct {
+	mutex  sync.Mutex
+	counts map[string]*tlsReportCounts
+}
+
+type tlsReportCounts struct {
+	successful int
+	failed     int
+}
+
+func (tr *tlsReport) success(host string, policies []appliedPolicy) {
+	for _, policy := range policies {
+		counts := tr.count(policy, true)
+
+		log.Debugw("tls policy satisfied", log.Fields{
+			"policy_type":                    policy.Type,
+			"policy_domain":                  policy.Domain,
+			"receiving_mx_hostname":          host,
+			"total_successful_session_count": strconv.Itoa(counts.successful),
+			"total_failure_session_count":    strconv.Itoa(counts.failed),
+		})
+	}
+}
+
+func (tr *tlsReport) failure(host, resultType string, policies []appliedPolicy, err error) {
+	for _, policy := range policies {
+		counts := tr.count(policy, false)
+
+		log.Warnw("tls policy failure", log.Fields{
+			"policy_type":                    policy.Type,
+			"policy_domain":                  policy.Domain,
+			"result_type":                    resultType,
+			"receiving_mx_hostname":          host,
+			"failure_reason_code":            err.Error(),
+			"total_successful_session_count": strconv.Itoa(counts.successful),
+			"total_failure_session_count":    strconv.Itoa(counts.failed),
+		})
+	}
+}
+
+func (tr *tlsReport) count(policy appliedPolicy, successful bool) tlsReportCounts {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	key := policy.Type + ":" + policy.Domain
+
+	counts, ok := tr.counts[key]
+	if !ok {
+		counts = &tlsReportCounts{}
+		tr.counts[key] = counts
+	}
+
+	if successful {
+		counts.successful++
+	} else {
+		counts.failed++
+	}
+
+	return *counts
+}
@@ -0,0 +1,374 @@
+package relay_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/config"
+	"github.com/ajgon/mailbowl/relay"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMXResolver struct {
+	records map[string][]string
+	err     error
+}
+
+func (fmr *fakeMXResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	if fmr.err != nil {
+		return nil, fmr.err
+	}
+
+	records := make([]*net.MX, 0)
+	for _, host := range fmr.records[name] {
+		records = append(records, &net.MX{Host: host, Pref: 10})
+	}
+
+	return records, nil
+}
+
+type fakeTXTResolver struct {
+	records map[string][]string
+}
+
+func (ftr *fakeTXTResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	return ftr.records[name], nil
+}
+
+type fakePolicyFetcher struct {
+	policies map[string]string
+	fetches  int
+}
+
+func (fpf *fakePolicyFetcher) FetchPolicy(_ context.Context, domain string) ([]byte, error) {
+	fpf.fetches++
+
+	policy, ok := fpf.policies[domain]
+	if !ok {
+		return nil, relay.ErrMTASTSPolicyFetch
+	}
+
+	return []byte(policy), nil
+}
+
+type fakeTLSAResolver struct {
+	records map[string][]relay.TLSARecord
+	secure  bool
+	err     error
+}
+
+func (ftr *fakeTLSAResolver) LookupTLSA(_ context.Context, name string) ([]relay.TLSARecord, bool, error) {
+	return ftr.records[name], ftr.secure, ftr.err
+}
+
+func newPolicyOutgoingServer(
+	t *testing.T, connectionType config.RelayConnectionType, host string, port int, tlsPolicy config.RelayTLSPolicy,
+) *relay.OutgoingServer {
+	t.Helper()
+
+	outgoingServer, err := relay.NewOutgoingServer(config.RelayOutgoingServer{
+		AuthMethod:     config.AuthNone,
+		ConnectionType: connectionType,
+		Host:           host,
+		Port:           port,
+		VerifyTLS:      false,
+		TLSPolicy:      tlsPolicy,
+	})
+	assert.NoError(t, err)
+
+	return outgoingServer
+}
+
+func mtaSTSPolicy(mode string) *fakePolicyFetcher {
+	return &fakePolicyFetcher{policies: map[string]string{
+		"example.local": "version: STSv1\nmode: " + mode + "\nmx: 127.0.0.1\nmax_age: 86400\n",
+		"other.local":   "version: STSv1\nmode: " + mode + "\nmx: *.other.local\nmax_age: 86400\n",
+	}}
+}
+
+// mxRecords make the outgoing server (127.0.0.1) an MX of both domains, so their policies apply.
+func mxRecords() *fakeMXResolver {
+	return &fakeMXResolver{records: map[string][]string{
+		"example.local": {"127.0.0.1."},
+		"other.local":   {"mx.other.local.", "127.0.0.1."},
+	}}
+}
+
+func mtaSTSRecords() *fakeTXTResolver {
+	return &fakeTXTResolver{records: map[string][]string{
+		"_mta-sts.example.local": {"v=STSv1; id=20220101000000Z;"},
+		"_mta-sts.other.local":   {"v=STSv1; id=20220101000000Z;"},
+	}}
+}
+
+func TestSendWithMTASTSEnforceTrustedCertificate(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testSMTPServer := NewSMTPTestServer()
+	go testSMTPServer.Serve(ctx, "starttls")
+
+	served, err := testSMTPServer.TLS.GetCertificate(nil)
+	assert.NoError(t, err)
+
+	certificate, err := x509.ParseCertificate(served.Certificate[0])
+	assert.NoError(t, err)
+
+	outgoingServer := newPolicyOutgoingServer(
+		t, config.ConnectionPlain, "127.0.0.1", testSMTPServer.Port,
+		config.RelayTLSPolicy{MTASTS: true, Timeout: time.Second},
+	)
+	fetcher := mtaSTSPolicy(relay.MTASTSModeEnforce)
+	outgoingServer.TLSPolicy.MXResolver = mxRecords()
+	outgoingServer.TLSPolicy.TXTResolver = mtaSTSRecords()
+	outgoingServer.TLSPolicy.PolicyFetcher = fetcher
+	outgoingServer.TLSPolicy.RootCAs = x509.NewCertPool()
+	outgoingServer.TLSPolicy.RootCAs.AddCert(certificate)
+	time.Sleep(100 * time.Millisecond) // allow server to start
+
+	err = outgoingServer.Send("from@example.local", []string{"to@example.local"}, []byte("test message"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"to@example.local"}, testSMTPServer.Recipients)
+
+	// policy with unchanged id is taken from cache
+	err = outgoingServer.Send("from@example.local", []string{"to@example.local"}, []byte("test message"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fetcher.fetches)
+}
+
+func TestSendWithMTASTSEnforceUntrustedCertificate(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testSMTPServer := NewSMTPTestServer()
+	go testSMTPServer.Serve(ctx, "tls")
+
+	outgoingServer := newPolicyOutgoingServer(
+		t, config.ConnectionTLS, "127.0.0.1", testSMTPServer.Port,
+		config.RelayTLSPolicy{MTASTS: true, Timeout: time.Second},
+	)
+	outgoingServer.TLSPolicy.MXResolver = mxRecords()
+	outgoingServer.TLSPolicy.TXTResolver = mtaSTSRecords()
+	outgoingServer.TLSPolicy.PolicyFetcher = mtaSTSPolicy(relay.MTASTSModeEnforce)
+	outgoingServer.TLSPolicy.RootCAs = x509.NewCertPool()
+	time.Sleep(100 * time.Millisecond) // allow server to start
+
+	// verify_tls is off, but the policy still requires valid certificate
+	err := outgoingServer.Send("from@example.local", []string{"to@example.local"}, []byte("test message"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), relay.ResultCertificateNotTrusted)
+	assert.Nil(t, testSMTPServer.Recipients)
+}
+
+func TestSendWithMTASTSTestingUntrustedCertificate(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testSMTPServer := NewSMTPTestServer()
+	go testSMTPServer.Serve(ctx, "tls")
+
+	outgoingServer := newPolicyOutgoingServer(
+		t, config.ConnectionTLS, "127.0.0.1", testSMTPServer.Port,
+		config.RelayTLSPolicy{MTASTS: true, Timeout: time.Second},
+	)
+	outgoingServer.TLSPolicy.MXResolver = mxRecords()
+	outgoingServer.TLSPolicy.TXTResolver = mtaSTSRecords()
+	outgoingServer.TLSPolicy.PolicyFetcher = mtaSTSPolicy(relay.MTASTSModeTesting)
+	outgoingServer.TLSPolicy.RootCAs = x509.NewCertPool()
+	time.Sleep(100 * time.Millisecond) // allow server to start
+
+	err := outgoingServer.Send("from@example.local", []string{"to@example.local"}, []byte("test message"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"to@example.local"}, testSMTPServer.Recipients)
+}
+
+func TestSendWithMTASTSEnforceMXMismatch(t *testing.T) {
+	t.Parallel()
+
+	outgoingServer := newPolicyOutgoingServer(
+		t, config.ConnectionTLS, "127.0.0.1", randomPort(),
+		config.RelayTLSPolicy{MTASTS: true, Timeout: time.Second},
+	)
+	outgoingServer.TLSPolicy.MXResolver = mxRecords()
+	outgoingServer.TLSPolicy.TXTResolver = mtaSTSRecords()
+	outgoingServer.TLSPolicy.PolicyFetcher = mtaSTSPolicy(relay.MTASTSModeEnforce)
+
+	err := outgoingServer.Send(
+		"from@example.local", []string{"to@example.local", "to@other.local"}, []byte("test message"),
+	)
+	assert.True(t, errors.Is(err, relay.ErrTLSPolicy))
+	assert.Contains(t, err.Error(), "other.local")
+}
+
+func TestSendWithMTASTSEnforceThroughSmarthost(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testSMTPServer := NewSMTPTestServer()
+	go testSMTPServer.Serve(ctx, "tls")
+
+	outgoingServer := newPolicyOutgoingServer(
+		t, config.ConnectionTLS, "127.0.0.1", testSMTPServer.Port,
+		config.RelayTLSPolicy{MTASTS: true, Timeout: time.Second},
+	)
+	fetcher := mtaSTSPolicy(relay.MTASTSModeEnforce)
+	outgoingServer.TLSPolicy.MXResolver = &fakeMXResolver{records: map[string][]string{"other.local": {"mx.other.local."}}}
+	outgoingServer.TLSPolicy.TXTResolver = mtaSTSRecords()
+	outgoingServer.TLSPolicy.PolicyFetcher = fetcher
+	outgoingServer.TLSPolicy.RootCAs = x509.NewCertPool()
+	time.Sleep(100 * time.Millisecond) // allow server to start
+
+	// outgoing server is not an MX of the recipient domain, so its policy applies to the next hop only
+	err := outgoingServer.Send("from@example.local", []string{"to@other.local"}, []byte("test message"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"to@other.local"}, testSMTPServer.Recipients)
+	assert.Equal(t, 0, fetcher.fetches)
+}
+
+func TestSendWithMTASTSMXLookupFailure(t *testing.T) {
+	t.Parallel()
+
+	outgoingServer := newPolicyOutgoingServer(
+		t, config.ConnectionTLS, "127.0.0.1", randomPort(),
+		config.RelayTLSPolicy{MTASTS: true, Timeout: time.Second},
+	)
+	fetcher := mtaSTSPolicy(relay.MTASTSModeEnforce)
+	outgoingServer.TLSPolicy.MXResolver = &fakeMXResolver{err: &net.DNSError{Err: "server misbehaving", IsTemporary: true}}
+	outgoingServer.TLSPolicy.TXTResolver = mtaSTSRecords()
+	outgoingServer.TLSPolicy.PolicyFetcher = fetcher
+
+	// it's unknown whether the policy applies, so delivery is deferred instead of rejected
+	err := outgoingServer.Send("from@example.local", []string{"to@other.local"}, []byte("test message"))
+	assert.True(t, errors.Is(err, relay.ErrTLSPolicyLookup))
+	assert.False(t, errors.Is(err, relay.ErrTLSPolicy))
+	assert.Equal(t, 0, fetcher.fetches)
+}
+
+func TestSendWithMTASTSWithoutMXRecords(t *testing.T) {
+	t.Parallel()
+
+	outgoingServer := newPolicyOutgoingServer(
+		t, config.ConnectionTLS, "other.local", randomPort(),
+		config.RelayTLSPolicy{MTASTS: true, Timeout: time.Second},
+	)
+	outgoingServer.TLSPolicy.MXResolver = &fakeMXResolver{err: &net.DNSError{Err: "no such host", IsNotFound: true}}
+	outgoingServer.TLSPolicy.TXTResolver = mtaSTSRecords()
+	outgoingServer.TLSPolicy.PolicyFetcher = mtaSTSPolicy(relay.MTASTSModeEnforce)
+
+	// domain without MX records is its own MX, so delivery is direct and the policy (*.other.local) applies
+	err := outgoingServer.Send("from@example.local", []string{"to@other.local"}, []byte("test message"))
+	assert.True(t, errors.Is(err, relay.ErrTLSPolicy))
+	assert.Contains(t, err.Error(), "other.local")
+}
+
+func TestSendWithMTASTSEnforceWithoutSTARTTLS(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testSMTPServer := NewSMTPTestServer()
+	go testSMTPServer.Serve(ctx, "plain")
+
+	outgoingServer := newPolicyOutgoingServer(
+		t, config.ConnectionPlain, "127.0.0.1", testSMTPServer.Port,
+		config.RelayTLSPolicy{MTASTS: true, Timeout: time.Second},
+	)
+	outgoingServer.TLSPolicy.MXResolver = mxRecords()
+	outgoingServer.TLSPolicy.TXTResolver = mtaSTSRecords()
+	outgoingServer.TLSPolicy.PolicyFetcher = mtaSTSPolicy(relay.MTASTSModeEnforce)
+	time.Sleep(100 * time.Millisecond) // allow server to start
+
+	err := outgoingServer.Send("from@example.local", []string{"to@example.local"}, []byte("test message"))
+	assert.True(t, errors.Is(err, relay.ErrTLSRequired))
+	assert.Nil(t, testSMTPServer.Recipients)
+}
+
+func TestSendWithDANE(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testSMTPServer := NewSMTPTestServer()
+	go testSMTPServer.Serve(ctx, "tls")
+
+	served, err := testSMTPServer.TLS.GetCertificate(nil)
+	assert.NoError(t, err)
+
+	certificate, err := x509.ParseCertificate(served.Certificate[0])
+	assert.NoError(t, err)
+
+	spkiHash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	tlsaName := "_" + strconv.Itoa(testSMTPServer.Port) + "._tcp.localhost"
+
+	tests := []struct {
+		name    string
+		records []relay.TLSARecord
+		secure  bool
+		valid   bool
+	}{
+		{name: "DANE-EE SPKI SHA-256", secure: true, valid: true, records: []relay.TLSARecord{
+			{Usage: relay.TLSAUsageDANEEE, Selector: relay.TLSASelectorSPKI, MatchingType: relay.TLSAMatchingSHA256, Data: spkiHash[:]},
+		}},
+		{name: "DANE-EE full certificate", secure: true, valid: true, records: []relay.TLSARecord{
+			{Usage: relay.TLSAUsageDANEEE, Selector: relay.TLSASelectorCertificate, MatchingType: relay.TLSAMatchingFull, Data: certificate.Raw},
+		}},
+		{name: "mismatch", secure: true, valid: false, records: []relay.TLSARecord{
+			{Usage: relay.TLSAUsageDANEEE, Selector: relay.TLSASelectorSPKI, MatchingType: relay.TLSAMatchingSHA256, Data: make([]byte, 32)},
+		}},
+		{name: "insecure mismatch is ignored", secure: false, valid: true, records: []relay.TLSARecord{
+			{Usage: relay.TLSAUsageDANEEE, Selector: relay.TLSASelectorSPKI, MatchingType: relay.TLSAMatchingSHA256, Data: make([]byte, 32)},
+		}},
+		{name: "unusable PKIX-EE mismatch is ignored", secure: true, valid: true, records: []relay.TLSARecord{
+			{Usage: 1, Selector: relay.TLSASelectorSPKI, MatchingType: relay.TLSAMatchingSHA256, Data: make([]byte, 32)},
+		}},
+	}
+
+	time.Sleep(100 * time.Millisecond) // allow server to start
+
+	for _, test := range tests {
+		outgoingServer := newPolicyOutgoingServer(
+			t, config.ConnectionTLS, "localhost", testSMTPServer.Port,
+			config.RelayTLSPolicy{DANE: true, Timeout: time.Second},
+		)
+		outgoingServer.TLSPolicy.TLSAResolver = &fakeTLSAResolver{
+			records: map[string][]relay.TLSARecord{tlsaName: test.records}, secure: test.secure,
+		}
+
+		err := outgoingServer.Send("from@example.local", []string{"to@example.local"}, []byte("test message"))
+		if test.valid {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.True(t, errors.Is(err, relay.ErrTLSANoMatch), test.name)
+		}
+	}
+}
+
+func TestSendWithDANELookupFailure(t *testing.T) {
+	t.Parallel()
+
+	outgoingServer := newPolicyOutgoingServer(
+		t, config.ConnectionTLS, "localhost", randomPort(),
+		config.RelayTLSPolicy{DANE: true, Timeout: time.Second},
+	)
+	outgoingServer.TLSPolicy.TLSAResolver = &fakeTLSAResolver{err: relay.ErrDNSLookup}
+
+	err := outgoingServer.Send("from@example.local", []string{"to@example.local"}, []byte("test message"))
+	assert.True(t, errors.Is(err, relay.ErrTLSPolicyLookup))
+	assert.False(t, errors.Is(err, relay.ErrTLSPolicy))
+}
@@ -0,0 +1,154 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Masterminds/log-go"
+)
+
+// ListenFDsStart is the first file descriptor passed by systemd (SD_LISTEN_FDS_START).
+const ListenFDsStart = 3
+
+var ErrInvalidEnvironment = errors.New("invalid socket activation environment")
+
+//nolint:gochecknoglobals
+var (
+	activation     *Activation
+	activationErr  error
+	activationOnce sync.Once
+)
+
+// Socket is a listening socket passed by systemd, with its FileDescriptorName and the address it is bound to.
+type Socket struct {
+	Name string
+	File *os.File
+	Addr net.Addr
+}
+
+// Activation holds sockets passed by systemd (LISTEN_FDS, LISTEN_FDNAMES). Sockets are kept open for the lifetime of
+// the process, listeners built from them are duplicates, so they can be closed and rebuilt on config reload.
+type Activation struct {
+	Sockets []*Socket
+}
+
+// NewActivation reads sockets passed to this process, starting at given descriptor. Environment is cleared, so it's
+// not inherited by child processes. Returns empty activation, when process wasn't socket activated.
+func NewActivation(start int) (*Activation, error) {
+	inherited := &Activation{Sockets: make([]*Socket, 0)}
+
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || fds == "" {
+		return inherited, nil
+	}
+
+	// sockets were passed to other process (i.e. the parent), which didn't clear the environment
+	if pid != strconv.Itoa(os.Getpid()) {
+		return inherited, nil
+	}
+
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("%w: LISTEN_FDS=%s", ErrInvalidEnvironment, fds)
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0; i < count; i++ {
+		fd := start + i
+		name := "unknown"
+
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		syscall.CloseOnExec(fd)
+
+		socket := &Socket{Name: name, File: os.NewFile(uintptr(fd), name)}
+
+		listener, err := net.FileListener(socket.File)
+		if err != nil {
+			log.Warnw("ignoring inherited socket", log.Fields{"fd": fd, "name": name, "error": err.Error()})
+
+			continue
+		}
+
+		socket.Addr = listener.Addr()
+		listener.Close()
+
+		log.Debugw("inherited socket", log.Fields{"fd": fd, "name": name, "address": socket.Addr.String()})
+
+		inherited.Sockets = append(inherited.Sockets, socket)
+	}
+
+	return inherited, nil
+}
+
+// Listener builds listener from the inherited socket bound to given address. Returns false, when there is no such
+// socket.
+func (a *Activation) Listener(network, address string) (net.Listener, bool, error) {
+	for _, socket := range a.Sockets {
+		if !matches(socket.Addr, network, address) {
+			continue
+		}
+
+		listener, err := net.FileListener(socket.File)
+		if err != nil {
+			return nil, true, fmt.Errorf("error using inherited socket %s: %w", socket.Name, err)
+		}
+
+		return listener, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// Inherited returns listener from the socket passed by systemd to this process, if there is one bound to given
+// address.
+func Inherited(network, address string) (net.Listener, bool, error) {
+	activationOnce.Do(func() {
+		activation, activationErr = NewActivation(ListenFDsStart)
+	})
+
+	if activationErr != nil {
+		return nil, false, activationErr
+	}
+
+	return activation.Listener(network, address)
+}
+
+func matches(addr net.Addr, network, address string) bool {
+	switch socketAddr := addr.(type) {
+	case *net.TCPAddr:
+		if network != "tcp" {
+			return false
+		}
+
+		wantAddr, err := net.ResolveTCPAddr(network, address)
+		if err != nil || wantAddr.Port != socketAddr.Port {
+			return false
+		}
+
+		if len(wantAddr.IP) == 0 || wantAddr.IP.IsUnspecified() {
+			return len(socketAddr.IP) == 0 || socketAddr.IP.IsUnspecified()
+		}
+
+		return wantAddr.IP.Equal(socketAddr.IP)
+	case *net.UnixAddr:
+		return network == "unix" && socketAddr.Name == address
+	}
+
+	return false
+}
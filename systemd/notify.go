@@ -0,0 +1,39 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+const (
+	StateReady     = "READY=1"
+	StateReloading = "RELOADING=1"
+	StateStopping  = "STOPPING=1"
+)
+
+// Notify sends service state to systemd (sd_notify). It does nothing, when process is not run by systemd with
+// Type=notify.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// abstract namespace socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("error connecting to systemd notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("error notifying systemd: %w", err)
+	}
+
+	return nil
+}
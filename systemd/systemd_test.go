@@ -0,0 +1,127 @@
+package systemd_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ajgon/mailbowl/systemd"
+	"github.com/stretchr/testify/assert"
+)
+
+func passSocket(t *testing.T, network, address string) (net.Listener, int) {
+	t.Helper()
+
+	listener, err := net.Listen(network, address)
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var file *os.File
+
+	switch typed := listener.(type) {
+	case *net.TCPListener:
+		file, err = typed.File()
+	case *net.UnixListener:
+		file, err = typed.File()
+	}
+
+	assert.NoError(t, err)
+
+	return listener, int(file.Fd())
+}
+
+func TestActivation(t *testing.T) {
+	listener, fd := passSocket(t, "tcp", "127.0.0.1:0")
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "smtp")
+
+	activation, err := systemd.NewActivation(fd)
+	assert.NoError(t, err)
+	assert.Len(t, activation.Sockets, 1)
+	assert.Equal(t, "smtp", activation.Sockets[0].Name)
+
+	// environment is not passed to child processes
+	assert.Equal(t, "", os.Getenv("LISTEN_FDS"))
+
+	inherited, ok, err := activation.Listener("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, listener.Addr().String(), inherited.Addr().String())
+
+	// listener is a duplicate, closing it keeps the socket for the next one
+	assert.NoError(t, inherited.Close())
+
+	inherited, ok, err = activation.Listener("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	conn.Close()
+	inherited.Close()
+
+	_, ok, err = activation.Listener("tcp", "127.0.0.1:1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = activation.Listener("unix", listener.Addr().String())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestActivationUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtp.sock")
+	_, fd := passSocket(t, "unix", path)
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+
+	activation, err := systemd.NewActivation(fd)
+	assert.NoError(t, err)
+	assert.Equal(t, "unknown", activation.Sockets[0].Name)
+
+	_, ok, err := activation.Listener("unix", path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestActivationForOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	activation, err := systemd.NewActivation(systemd.ListenFDsStart)
+	assert.NoError(t, err)
+	assert.Empty(t, activation.Sockets)
+}
+
+func TestActivationInvalidEnvironment(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "many")
+
+	_, err := systemd.NewActivation(systemd.ListenFDsStart)
+	assert.ErrorIs(t, err, systemd.ErrInvalidEnvironment)
+}
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	assert.NoError(t, systemd.Notify(systemd.StateReady))
+
+	buffer := make([]byte, 64)
+	n, err := conn.Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buffer[:n]))
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.NoError(t, systemd.Notify(systemd.StateStopping))
+}
@@ -0,0 +1,3 @@
+module github.com/chrj/smtpd
+
+go 1.14
@@ -0,0 +1,149 @@
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher reloads configuration when any of watched files change. Directories containing the files are watched
+// instead of the files themselves, so files replaced by rename or symlink swap (like in volumes mounted from
+// Kubernetes ConfigMaps and Secrets) are noticed as well. Reload is triggered once files stop changing for Debounce,
+// and only when content of any of them is different.
+type Watcher struct {
+	Files    []string
+	Debounce time.Duration
+	Reload   func()
+
+	directories map[string]bool
+}
+
+func NewWatcher(files []string, debounce time.Duration, reload func()) *Watcher {
+	return &Watcher{Files: files, Debounce: debounce, Reload: reload}
+}
+
+func (w *Watcher) GetName() string {
+	return "config watcher"
+}
+
+func (w *Watcher) Serve(ctx context.Context) error {
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer notifier.Close()
+
+	w.directories = make(map[string]bool)
+	state := w.snapshot()
+	w.watchDirectories(notifier)
+
+	log.Debugw("watching files for changes", log.Fields{"files": w.Files})
+
+	var (
+		debounce *time.Timer
+		settled  <-chan time.Time
+	)
+
+	for {
+		select {
+		case <-notifier.Events:
+			if debounce == nil {
+				debounce = time.NewTimer(w.Debounce)
+				settled = debounce.C
+			} else {
+				if !debounce.Stop() {
+					<-debounce.C
+				}
+
+				debounce.Reset(w.Debounce)
+			}
+		case err := <-notifier.Errors:
+			log.Warnw("file watcher error", log.Fields{"error": err.Error()})
+		case <-settled:
+			debounce, settled = nil, nil
+
+			current := w.snapshot()
+			w.watchDirectories(notifier)
+
+			if changed := state.changed(current); len(changed) > 0 {
+				log.Infow("watched files changed", log.Fields{"files": changed})
+				w.Reload()
+			}
+
+			state = current
+		case <-ctx.Done():
+			if debounce != nil {
+				debounce.Stop()
+			}
+
+			return nil
+		}
+	}
+}
+
+// watchDirectories adds directories of watched files, and of files they link to, as symlinks can be changed
+// to point somewhere else.
+func (w *Watcher) watchDirectories(notifier *fsnotify.Watcher) {
+	for _, file := range w.Files {
+		directories := []string{filepath.Dir(file)}
+
+		if resolved, err := filepath.EvalSymlinks(file); err == nil {
+			directories = append(directories, filepath.Dir(resolved))
+		}
+
+		for _, directory := range directories {
+			if w.directories[directory] {
+				continue
+			}
+
+			if err := notifier.Add(directory); err != nil {
+				log.Warnw("can't watch directory", log.Fields{"directory": directory, "error": err.Error()})
+
+				continue
+			}
+
+			w.directories[directory] = true
+		}
+	}
+}
+
+// snapshot contains checksums of watched files, missing ones are stored as empty.
+type snapshot map[string]string
+
+func (w *Watcher) snapshot() snapshot {
+	state := make(snapshot, len(w.Files))
+
+	for _, file := range w.Files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			state[file] = ""
+
+			continue
+		}
+
+		state[file] = fmt.Sprintf("%x", sha256.Sum256(content))
+	}
+
+	return state
+}
+
+func (s snapshot) changed(current snapshot) []string {
+	changed := make([]string, 0)
+
+	for file, checksum := range current {
+		if s[file] != checksum {
+			changed = append(changed, file)
+		}
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
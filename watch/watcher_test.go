@@ -0,0 +1,107 @@
+package watch_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ajgon/mailbowl/watch"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDebounce = 100 * time.Millisecond
+
+func startWatcher(t *testing.T, files ...string) chan struct{} {
+	t.Helper()
+
+	reloads := make(chan struct{}, 10)
+	watcher := watch.NewWatcher(files, testDebounce, func() { reloads <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		assert.NoError(t, watcher.Serve(ctx))
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// give the watcher time to add its directories
+	time.Sleep(testDebounce)
+
+	return reloads
+}
+
+func reloadCount(reloads chan struct{}) int {
+	count := 0
+	timeout := time.After(10 * testDebounce)
+
+	for {
+		select {
+		case <-reloads:
+			count++
+		case <-timeout:
+			return count
+		}
+	}
+}
+
+func TestReloadDebouncedOnWrites(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "mailbowl.yml")
+	assert.NoError(t, os.WriteFile(file, []byte("log:\n  level: warn\n"), 0o600))
+
+	reloads := startWatcher(t, file)
+
+	for _, level := range []string{"info", "debug", "error"} {
+		assert.NoError(t, os.WriteFile(file, []byte("log:\n  level: "+level+"\n"), 0o600))
+	}
+
+	assert.Equal(t, 1, reloadCount(reloads))
+}
+
+func TestNoReloadWhenContentIsTheSame(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "mailbowl.yml")
+	assert.NoError(t, os.WriteFile(file, []byte("log:\n  level: warn\n"), 0o600))
+
+	reloads := startWatcher(t, file)
+
+	assert.NoError(t, os.WriteFile(file, []byte("log:\n  level: warn\n"), 0o600))
+
+	assert.Equal(t, 0, reloadCount(reloads))
+}
+
+// Kubernetes mounts ConfigMaps and Secrets as symlinks to `..data`, which links to timestamped directory. Update
+// writes new directory, and atomically replaces `..data` symlink.
+func TestReloadOnSymlinkSwap(t *testing.T) {
+	t.Parallel()
+
+	mount := t.TempDir()
+	writeVersion := func(version, content string) {
+		assert.NoError(t, os.Mkdir(filepath.Join(mount, version), 0o700))
+		assert.NoError(t, os.WriteFile(filepath.Join(mount, version, "tls.crt"), []byte(content), 0o600))
+	}
+
+	writeVersion("..2022_03_01_10_00_00.1", "first")
+	assert.NoError(t, os.Symlink("..2022_03_01_10_00_00.1", filepath.Join(mount, "..data")))
+	assert.NoError(t, os.Symlink(filepath.Join("..data", "tls.crt"), filepath.Join(mount, "tls.crt")))
+
+	reloads := startWatcher(t, filepath.Join(mount, "tls.crt"))
+
+	writeVersion("..2022_03_01_11_00_00.2", "second")
+	assert.NoError(t, os.Symlink("..2022_03_01_11_00_00.2", filepath.Join(mount, "..data_tmp")))
+	assert.NoError(t, os.Rename(filepath.Join(mount, "..data_tmp"), filepath.Join(mount, "..data")))
+	assert.NoError(t, os.RemoveAll(filepath.Join(mount, "..2022_03_01_10_00_00.1")))
+
+	assert.Equal(t, 1, reloadCount(reloads))
+}